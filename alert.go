@@ -0,0 +1,121 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"time"
+)
+
+var alertWebhook = flag.String("alert-webhook", "",
+	"POST each alert-p99 alert, and its resolution, as JSON to this URL.")
+
+const defaultAlertIntervals = 3
+
+/*
+ * Watches the rolling p99 latency of a job over its last alert-intervals
+ * stats intervals, alerting when it first exceeds the alert-p99 goal and
+ * again when it recovers.
+ */
+type latencyAlert struct {
+	goal     time.Duration
+	window   []*LatencyHistogram
+	next     int
+	alerting bool
+}
+
+func newLatencyAlert(goal time.Duration, intervals int) *latencyAlert {
+	return &latencyAlert{goal: goal, window: make([]*LatencyHistogram, intervals)}
+}
+
+/*
+ * Adds the latencies of an interval, returning the rolling p99 and whether
+ * it crossed the goal (in either direction).
+ */
+func (la *latencyAlert) Add(interval *LatencyHistogram) (time.Duration, bool) {
+	la.window[la.next] = interval
+	la.next = (la.next + 1) % len(la.window)
+
+	var rolling LatencyHistogram
+	for _, lh := range la.window {
+		if lh != nil {
+			rolling.Merge(lh)
+		}
+	}
+	if rolling.Count() == 0 {
+		return 0, false
+	}
+	p99 := rolling.Percentiles(99)[0]
+	if alerting := p99 > la.goal; alerting != la.alerting {
+		la.alerting = alerting
+		return p99, true
+	}
+	return p99, false
+}
+
+// An alert-p99 alert, as a json output-format record and webhook payload.
+type alertJSON struct {
+	Type            string    `json:"type"`
+	Time            time.Time `json:"time"`
+	Job             string    `json:"job"`
+	State           string    `json:"state"` // firing or resolved
+	P99Micros       float64   `json:"p99_micros"`
+	AlertP99Micros  float64   `json:"alert_p99_micros"`
+	WindowIntervals int       `json:"window_intervals"`
+}
+
+func (la *latencyAlert) report(name string, p99 time.Duration, now time.Time) {
+	record := &alertJSON{"alert", now, name, "resolved", jsonMicros(p99),
+		jsonMicros(la.goal), len(la.window)}
+	if la.alerting {
+		record.State = "firing"
+		log.Printf("ALERT %s: p99 latency %v over the last %d intervals exceeds alert-p99 %v",
+			name, p99, len(la.window), la.goal)
+	} else {
+		log.Printf("RESOLVED %s: p99 latency %v over the last %d intervals is within alert-p99 %v",
+			name, p99, len(la.window), la.goal)
+	}
+	if jsonOutput() {
+		writeJSONRecord(record, false)
+	}
+	if *alertWebhook != "" {
+		go postAlert(*alertWebhook, record)
+	}
+}
+
+var alertClient = &http.Client{Timeout: 10 * time.Second}
+
+func postAlert(url string, record *alertJSON) {
+	b, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("encoding alert: %v", err)
+		return
+	}
+	resp, err := alertClient.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		log.Printf("posting alert to alert-webhook: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		log.Printf("posting alert to alert-webhook: %s", resp.Status)
+	}
+}
@@ -0,0 +1,50 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyAlert(t *testing.T) {
+	interval := func(latencies ...time.Duration) *LatencyHistogram {
+		var lh LatencyHistogram
+		for _, l := range latencies {
+			lh.Add(l)
+		}
+		return &lh
+	}
+	alert := newLatencyAlert(50*time.Millisecond, 2)
+	for i, c := range []struct {
+		interval *LatencyHistogram
+		p99      time.Duration
+		crossed  bool
+	}{
+		{interval(), 0, false},
+		{interval(10 * time.Millisecond), 10 * time.Millisecond, false},
+		{interval(100 * time.Millisecond), 100 * time.Millisecond, true},
+		{interval(10 * time.Millisecond), 100 * time.Millisecond, false},
+		{interval(20 * time.Millisecond), 20 * time.Millisecond, true},
+	} {
+		p99, crossed := alert.Add(c.interval)
+		if roundDuration(p99, 2) != c.p99 || crossed != c.crossed {
+			t.Errorf("For interval %d\n\texpected p99 %v (crossed %t)\n\tbut got %v (crossed %t)",
+				i, c.p99, c.crossed, p99, crossed)
+		}
+	}
+}
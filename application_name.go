@@ -0,0 +1,86 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+)
+
+/*
+ * Identifies the run in the connections of dbbench to the server (so that
+ * DBAs can tell dbbench traffic apart in a shared environment) and in the
+ * results database. With -watch, the connections are kept for the later runs,
+ * so keep the ID of the first.
+ */
+var runID = newRunID()
+
+func newRunID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		log.Fatalf("generating run id: %v", err)
+	}
+	return hex.EncodeToString(id)
+}
+
+// The name dbbench connections identify themselves by, e.g. in pg_stat_activity.
+func applicationName(job string) string {
+	name := "dbbench " + runID
+	if job != "" {
+		name += " " + job
+	}
+	return name
+}
+
+/*
+ * Adds key=value to the sep separated params, unless they already set key
+ * (so that the user can override it).
+ */
+func withParam(params, sep, key, value string) string {
+	for _, param := range strings.Split(params, sep) {
+		if strings.EqualFold(strings.TrimSpace(strings.SplitN(param, "=", 2)[0]), key) {
+			return params
+		}
+	}
+	if params != "" && !strings.HasSuffix(params, sep) {
+		params += sep
+	}
+	return params + key + "=" + value
+}
+
+/*
+ * Database flavor -> query naming the session after the job, for the drivers
+ * that allow a connection to be renamed.
+ */
+var sessionNameQueries = map[string]string{
+	"postgres": "SET application_name = '%s'",
+}
+
+/*
+ * The query naming a connection reserved by a worker of the job after it, or
+ * "" if the flavor does not support it.
+ */
+func sessionNameQuery(df DatabaseFlavor, job string) string {
+	sq, ok := df.(*sqlDatabaseFlavor)
+	if !ok || sessionNameQueries[sq.name] == "" {
+		return ""
+	}
+	return fmt.Sprintf(sessionNameQueries[sq.name], strings.ReplaceAll(applicationName(job), "'", "''"))
+}
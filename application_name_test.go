@@ -0,0 +1,56 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestWithParam(t *testing.T) {
+	for _, c := range []struct {
+		params, sep, expected string
+	}{
+		{"", "&", "application_name=x"},
+		{"sslmode=disable", "&", "sslmode=disable&application_name=x"},
+		{"sslmode=disable&application_name=mine", "&", "sslmode=disable&application_name=mine"},
+		{"encrypt=true;", ";", "encrypt=true;application_name=x"},
+		{"encrypt=true; Application_Name = mine", ";", "encrypt=true; Application_Name = mine"},
+	} {
+		if actual := withParam(c.params, c.sep, "application_name", "x"); actual != c.expected {
+			t.Errorf("For %q expected %q, got %q", c.params, c.expected, actual)
+		}
+	}
+}
+
+func TestConnectionsNamedAfterRun(t *testing.T) {
+	cc := &ConnectionConfig{Database: "test"}
+	expected := "postgres://root:@localhost:5432/test?sslmode=disable&application_name=" +
+		url.QueryEscape("dbbench "+runID)
+	if dsn := postgresDataSourceName(cc); dsn != expected {
+		t.Errorf("Expected Postgres DSN %s, got %s", expected, dsn)
+	}
+	if dsn := sqlServerDataSourceName(cc); dsn != "user id=root;password=;server=localhost;port=1433;database=test;app name=dbbench "+runID {
+		t.Errorf("Unexpected SQL Server DSN %s", dsn)
+	}
+
+	if q := sessionNameQuery(supportedDatabaseFlavors["postgres"], "bob's job"); q != "SET application_name = 'dbbench "+runID+" bob''s job'" {
+		t.Errorf("Unexpected session name query %s", q)
+	} else if q := sessionNameQuery(supportedDatabaseFlavors["mysql"], "test"); q != "" {
+		t.Errorf("Expected no session name query for mysql, got %s", q)
+	}
+}
@@ -0,0 +1,195 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+ * Runs the query and returns the first column of the first row it returns as
+ * a number, e.g. to sample a server metric such as Threads_running.
+ */
+func sampleMetric(db Database, query string) (float64, error) {
+	var buf bytes.Buffer
+	if _, err := db.RunQuery(context.Background(), NewSafeCSVWriterTo(&buf), query, nil); err != nil {
+		return 0, err
+	}
+
+	record, err := csv.NewReader(&buf).Read()
+	if err != nil {
+		return 0, err
+	} else if len(record) == 0 {
+		return 0, errors.New("metric query returned no columns")
+	}
+	return strconv.ParseFloat(record[0], 64)
+}
+
+/*
+ * Adjusts the rate of a job to hold a server utilization metric, or the p99
+ * latency of the job, at a target value.
+ */
+type rateAutoscaler struct {
+	m           sync.Mutex
+	rate        float64
+	utilization float64
+
+	// The latencies of the job since the last adjustment, and the highest
+	// throughput (invocations per second) whose p99 latency was within the
+	// latency-target.
+	latencies      LatencyHistogram
+	windowStart    time.Time
+	bestThroughput float64
+}
+
+// The most the rate is changed by in a single adjustment.
+const maxRateAdjustment = 2
+
+func newRateAutoscaler(rate float64) *rateAutoscaler {
+	return &rateAutoscaler{rate: rate, windowStart: clock.Now()}
+}
+
+func (ra *rateAutoscaler) Interval() time.Duration {
+	ra.m.Lock()
+	defer ra.m.Unlock()
+
+	return time.Duration(float64(time.Second) / ra.rate)
+}
+
+// The most recently sampled utilization.
+func (ra *rateAutoscaler) Utilization() float64 {
+	ra.m.Lock()
+	defer ra.m.Unlock()
+
+	return ra.utilization
+}
+
+// The factor to change the rate by to bring the measured value to the target.
+func rateAdjustment(measured, target float64) float64 {
+	factor := float64(maxRateAdjustment)
+	if measured > 0 {
+		factor = target / measured
+	}
+	if factor > maxRateAdjustment {
+		factor = maxRateAdjustment
+	} else if factor < 1.0/maxRateAdjustment {
+		factor = 1.0 / maxRateAdjustment
+	}
+	return factor
+}
+
+func (ra *rateAutoscaler) adjust(utilization, target float64) float64 {
+	ra.m.Lock()
+	defer ra.m.Unlock()
+
+	ra.utilization = utilization
+	ra.rate *= rateAdjustment(utilization, target)
+	return ra.rate
+}
+
+// Records the latency of a successful invocation of the job.
+func (ra *rateAutoscaler) Observe(latency time.Duration) {
+	ra.m.Lock()
+	defer ra.m.Unlock()
+
+	ra.latencies.Add(latency)
+}
+
+/*
+ * Adjusts the rate by the p99 latency since the last adjustment, and returns
+ * the p99 and the new rate. The rate is left alone if nothing completed.
+ */
+func (ra *rateAutoscaler) adjustForLatency(target time.Duration) (time.Duration, float64) {
+	ra.m.Lock()
+	defer ra.m.Unlock()
+
+	if ra.latencies.Count() == 0 {
+		return 0, ra.rate
+	}
+	p99 := ra.latencies.Percentiles(99)[0]
+	throughput := float64(ra.latencies.Count()) / clockSince(ra.windowStart).Seconds()
+	ra.latencies, ra.windowStart = LatencyHistogram{}, clock.Now()
+	if p99 <= target && throughput > ra.bestThroughput {
+		ra.bestThroughput = throughput
+	}
+	ra.rate *= rateAdjustment(float64(p99), float64(target))
+	return p99, ra.rate
+}
+
+/*
+ * The highest throughput (successful invocations per second) over a stats
+ * interval whose p99 latency was within the latency-target, or 0.
+ */
+func (ra *rateAutoscaler) BestThroughput() float64 {
+	ra.m.Lock()
+	defer ra.m.Unlock()
+
+	return ra.bestThroughput
+}
+
+/*
+ * Samples the utilization query of the job every stats interval and adjusts
+ * the rate until the context is done.
+ */
+func (ra *rateAutoscaler) Run(ctx context.Context, db Database, job *Job) {
+	ticker := clock.NewTicker(*updateInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.Chan():
+			utilization, err := sampleMetric(db, job.UtilizationQuery)
+			if err != nil {
+				job.logf("%s: error sampling utilization: %v", job.Name, err)
+				continue
+			}
+			rate := ra.adjust(utilization, job.TargetUtilization)
+			job.logf("%s: utilization %.3f, adjusted rate to %.3f",
+				job.Name, utilization, rate)
+		}
+	}
+}
+
+/*
+ * Adjusts the rate every stats interval to hold the p99 latency of the job at
+ * its latency-target until the context is done, e.g. to find the highest
+ * throughput the database sustains within it.
+ */
+func (ra *rateAutoscaler) RunLatencyTarget(ctx context.Context, job *Job) {
+	ticker := clock.NewTicker(*updateInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.Chan():
+			if p99, rate := ra.adjustForLatency(job.LatencyTarget); p99 > 0 {
+				job.logf("%s: p99 latency %v, adjusted rate to %.3f",
+					job.Name, p99, rate)
+			}
+		}
+	}
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAdjustForLatency(t *testing.T) {
+	ra := newRateAutoscaler(100)
+	if p99, rate := ra.adjustForLatency(20 * time.Millisecond); p99 != 0 || rate != 100 {
+		t.Errorf("Expected no adjustment without latencies, got p99 %v and rate %v", p99, rate)
+	}
+
+	// Well within the target, so the rate goes up as far as it can.
+	for i := 0; i < 100; i++ {
+		ra.Observe(time.Millisecond)
+	}
+	if _, rate := ra.adjustForLatency(20 * time.Millisecond); rate != 200 {
+		t.Errorf("Expected rate 200, got %v", rate)
+	}
+	best := ra.BestThroughput()
+	if best <= 0 {
+		t.Errorf("Expected a throughput within the target, got %v", best)
+	}
+
+	// Over the target, so the rate comes down in proportion.
+	for i := 0; i < 100; i++ {
+		ra.Observe(40 * time.Millisecond)
+	}
+	if _, rate := ra.adjustForLatency(20 * time.Millisecond); rate < 99 || rate > 101 {
+		t.Errorf("Expected rate about 100, got %v", rate)
+	}
+	if ra.BestThroughput() != best {
+		t.Errorf("Expected the best throughput to stay %v, got %v", best, ra.BestThroughput())
+	}
+}
+
+func TestTickQueryChannelFollowsAutoscaler(t *testing.T) {
+	job := &Job{Name: "test", Queries: []string{"select 1"}, Rate: 1, Count: 5, BatchSize: 1}
+
+	// At the 1/s rate of the job, 5 invocations would take 5s.
+	start := time.Now()
+	n := 0
+	for range job.startTickQueryChannel(context.Background(), newRateAutoscaler(1000)) {
+		n++
+	}
+	if n != 5 {
+		t.Errorf("Expected 5 invocations, got %d", n)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the invocations at the rate of the autoscaler, took %v", elapsed)
+	}
+}
@@ -0,0 +1,255 @@
+//go:build cassandra
+// +build cassandra
+
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+/*
+ * A Cassandra (or ScyllaDB) cluster, queried in CQL with gocql. Only built
+ * with the cassandra build tag, so that other builds do not depend on gocql.
+ */
+type cassandraDatabaseFlavor struct{}
+
+func init() {
+	supportedDatabaseFlavors["cassandra"] = &cassandraDatabaseFlavor{}
+}
+
+type cassandraDb struct {
+	session *gocql.Session
+}
+
+/*
+ * Connects to the comma separated contact points of host, using database as
+ * the keyspace. The params may set consistency=<level> (default quorum),
+ * timeout=<duration>, and num-conns=<connections per host>.
+ */
+func (cf *cassandraDatabaseFlavor) Connect(cc *ConnectionConfig) (Database, error) {
+	params, err := url.ParseQuery(cc.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := strings.Split(firstString(cc.Host, "localhost"), ",")
+	cluster := gocql.NewCluster(hosts...)
+	cluster.Port = firstInt(cc.Port, 9042)
+	cluster.Keyspace = cc.Database
+	if cc.Username != "" {
+		cluster.Authenticator = gocql.PasswordAuthenticator{
+			Username: cc.Username,
+			Password: cc.Password,
+		}
+	}
+	consistency := firstString(params.Get("consistency"), "quorum")
+	if cluster.Consistency, err = gocql.ParseConsistencyWrapper(strings.ToUpper(consistency)); err != nil {
+		return nil, err
+	}
+	if v := params.Get("timeout"); v != "" {
+		if cluster.Timeout, err = time.ParseDuration(v); err != nil {
+			return nil, err
+		}
+	}
+	if v := params.Get("num-conns"); v != "" {
+		if cluster.NumConns, err = strconv.Atoi(v); err != nil {
+			return nil, err
+		}
+	}
+	if cc.TLS.Enabled() {
+		if err := cc.TLS.Check("cassandra"); err != nil {
+			return nil, err
+		}
+		config, err := cc.TLS.Config()
+		if err != nil {
+			return nil, err
+		}
+		cluster.SslOpts = &gocql.SslOptions{Config: config, EnableHostVerification: !cc.TLS.SkipVerify}
+	}
+
+	log.Printf("Connecting to cassandra %s port %d keyspace %s consistency %v",
+		strings.Join(hosts, ","), cluster.Port, strconv.Quote(cc.Database), cluster.Consistency)
+	session, err := cluster.CreateSession()
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Connected")
+	return &cassandraDb{session}, nil
+}
+
+/*
+ * A CQL statement may not change the keyspace of the session, since the
+ * session is shared by every job.
+ */
+func checkCQLQuery(q string) error {
+	query := strings.TrimSpace(stripQuotedSQL(q))
+	if len(query) == 0 {
+		return EmptyQueryError
+	}
+	if strings.Contains(query, ";") {
+		return errors.New("cannot have a semicolon")
+	}
+	if sqlAction(query) == "use" {
+		return errors.New("cannot change keyspace")
+	}
+	return nil
+}
+
+func (cf *cassandraDatabaseFlavor) CheckQuery(q string) error {
+	return checkCQLQuery(q)
+}
+
+func (cf *cassandraDatabaseFlavor) SplitQueries(contents string) []string {
+	return splitOnUnquotedSemicolons(contents)
+}
+
+/*
+ * The code of an error returned by the cluster, in hex as in the CQL
+ * protocol (e.g. 0x1200 for a read timeout), or timeout if no response was
+ * received.
+ */
+func (cf *cassandraDatabaseFlavor) ErrorCode(e error) (string, error) {
+	if err, ok := e.(gocql.RequestError); ok {
+		return fmt.Sprintf("%#x", err.Code()), nil
+	}
+	if e == gocql.ErrTimeoutNoResponse {
+		return "timeout", nil
+	}
+	return "", fmt.Errorf("Unrecognized Cassandra error: %v", e)
+}
+
+func (cf *cassandraDatabaseFlavor) SupportsNamedArgs() bool {
+	return false
+}
+
+func (cf *cassandraDatabaseFlavor) CostModel(rates LinearCostModel) CostModel {
+	return defaultCostModel(rates)
+}
+
+func (cf *cassandraDatabaseFlavor) HasErrorCodes() bool {
+	return true
+}
+
+func (cf *cassandraDatabaseFlavor) RetryableErrorCodes() []string {
+	return nil
+}
+
+func (cf *cassandraDatabaseFlavor) Describe() string {
+	return "gocql driver, default contact point localhost:9042, configured by " +
+		"params consistency=<level>&timeout=<duration>&num-conns=<count>"
+}
+
+func (c *cassandraDb) RunQuery(ctx context.Context, w *SafeCSVWriter, q string, args []interface{}) (int64, error) {
+	return c.RunQueryWithOptions(ctx, w, q, args, QueryOptions{})
+}
+
+/*
+ * Returns the number of rows returned by the statement; CQL does not report
+ * the rows affected by a write.
+ */
+func (c *cassandraDb) RunQueryWithOptions(ctx context.Context, w *SafeCSVWriter, q string, args []interface{}, opts QueryOptions) (int64, error) {
+	queryCtx := ctx
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		queryCtx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	iter := c.session.Query(q, args...).WithContext(queryCtx).Iter()
+	columns := iter.Columns()
+	values := make([]string, len(columns))
+
+	var rowsAffected int64
+	var limitErr error
+	// MapScan must be given a new map for each row.
+	for row := make(map[string]interface{}); iter.MapScan(row); row = make(map[string]interface{}) {
+		if opts.MaxRows > 0 && rowsAffected == opts.MaxRows {
+			limitErr = &MaxRowsError{opts.MaxRows}
+			break
+		}
+		if w != nil {
+			for i, column := range columns {
+				values[i] = cassandraValue(w, i, row[column.Name])
+			}
+			if err := w.WriteResultRow(1, values); err != nil {
+				iter.Close()
+				return 0, err
+			}
+		}
+		rowsAffected++
+	}
+	if err := iter.Close(); err == context.DeadlineExceeded && ctx.Err() == nil {
+		return 0, &QueryTimeoutError{opts.Timeout}
+	} else if err != nil {
+		return 0, err
+	}
+
+	if w != nil {
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return 0, err
+		}
+	}
+	return rowsAffected, limitErr
+}
+
+func cassandraValue(w *SafeCSVWriter, column int, v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "\\N"
+	case []byte:
+		return w.Encode(column, string(v))
+	default:
+		return w.Encode(column, fmt.Sprint(v))
+	}
+}
+
+// The statements of a script run in order on the shared session.
+func (c *cassandraDb) RunScript(statements []string) error {
+	for _, statement := range statements {
+		if err := c.session.Query(statement).Exec(); err != nil {
+			return fmt.Errorf("error in statement %s: %v", strconv.Quote(statement), err)
+		}
+	}
+	return nil
+}
+
+func (c *cassandraDb) Begin() (Transaction, error) {
+	return nil, errors.New("cassandra does not support transactions")
+}
+
+func (c *cassandraDb) Session() (Session, error) {
+	return nil, errors.New("cassandra does not support single-connection sessions")
+}
+
+func (c *cassandraDb) NewSession(ctx context.Context) (Session, error) {
+	return nil, errors.New("cassandra does not support connection-mode=new")
+}
+
+func (c *cassandraDb) Close() {
+	c.session.Close()
+}
@@ -0,0 +1,259 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"flag"
+	"os"
+	"time"
+)
+
+var checkpointFile = flag.String("checkpoint-file", "",
+	"Save the stats of the jobs to this file every checkpoint-interval, so "+
+		"that a run that crashed can be continued with -resume.")
+var checkpointInterval = flag.Duration("checkpoint-interval", time.Minute,
+	"How often to save the stats of the jobs to the checkpoint-file.")
+var resumeRun = flag.Bool("resume", false,
+	"Continue the run saved to the checkpoint-file, skipping setup and "+
+		"warmup and running the jobs for what is left of the duration.")
+
+/*
+ * The state of a run saved to the checkpoint-file: how long its jobs ran, and
+ * their stats so far. Done is set once the jobs finished, so that a run that
+ * crashed after them only reports the stats and runs teardown.
+ */
+type runCheckpoint struct {
+	Elapsed time.Duration
+	Done    bool
+	Jobs    map[string]*jobStatsCheckpoint
+}
+
+// The run continued with -resume, if any.
+var resumed *runCheckpoint
+
+// Checks the checkpoint and restart flags against the config of the run.
+func checkCheckpointFlags(config *Config) error {
+	if *maxRunRestarts < 0 {
+		return errors.New("max-run-restarts cannot be negative")
+	}
+	if *checkpointFile == "" {
+		if *maxRunRestarts > 0 {
+			return errors.New("max-run-restarts requires -checkpoint-file")
+		}
+		return nil
+	}
+	switch {
+	case *checkpointInterval <= 0:
+		return errors.New("checkpoint-interval must be positive")
+	case config.Duration <= 0:
+		return errors.New("checkpoint-file requires the run to have a duration")
+	case config.CompareRounds > 0 || config.CacheComparison || len(config.Phases) > 0:
+		return errors.New("checkpoint-file cannot be used with compare-rounds, cache-comparison or phases")
+	case *watch:
+		return errors.New("checkpoint-file cannot be used with -watch")
+	}
+	return nil
+}
+
+/*
+ * Runs the jobs of the resumed run for what is left of its duration. If its
+ * jobs were done, only the stats of the checkpoint are returned.
+ */
+func resumeJobs(ctx context.Context, db Database, df DatabaseFlavor, config *Config) map[string]*JobStats {
+	remaining := config.Duration - resumed.Elapsed
+	if resumed.Done || remaining <= 0 {
+		return resumed.JobStats()
+	}
+	resumedConfig := *config
+	resumedConfig.Duration = remaining
+	return runJobs(ctx, db, df, &resumedConfig)
+}
+
+/*
+ * The stats of a job in a checkpoint. The slowest invocations are not saved,
+ * so they only cover the last attempt of the run.
+ */
+type jobStatsCheckpoint struct {
+	Totals               jobStats
+	Transactions         StreamingHistogram
+	Errors               StreamingHistogram
+	Throughput           IntervalThroughput
+	IdleIntervals        int
+	Latency              IntervalLatency
+	ErrorTimeline        ErrorTimeline
+	Workers              map[int]*jobStats
+	Steps                map[int]*jobStats
+	LatencyByUtilization map[int64]*StreamingStats
+	PerQuery             []*queryStats
+}
+
+func newRunCheckpoint(stats map[string]*JobStats, elapsed time.Duration, done bool) *runCheckpoint {
+	rc := &runCheckpoint{Elapsed: elapsed, Done: done, Jobs: make(map[string]*jobStatsCheckpoint)}
+	for name, js := range stats {
+		rc.Jobs[name] = &jobStatsCheckpoint{
+			Totals:               js.jobStats,
+			Transactions:         js.Transactions,
+			Errors:               js.Errors,
+			Throughput:           js.Throughput,
+			IdleIntervals:        js.Throughput.idleIntervals,
+			Latency:              js.Latency,
+			ErrorTimeline:        js.ErrorTimeline,
+			Workers:              js.Workers,
+			Steps:                js.Steps,
+			LatencyByUtilization: js.LatencyByUtilization,
+			PerQuery:             js.PerQuery,
+		}
+	}
+	return rc
+}
+
+// The stats of the jobs saved in the checkpoint.
+func (rc *runCheckpoint) JobStats() map[string]*JobStats {
+	stats := make(map[string]*JobStats)
+	for name, jc := range rc.Jobs {
+		js := &JobStats{
+			jobStats:             jc.Totals,
+			Transactions:         jc.Transactions,
+			Errors:               jc.Errors,
+			Throughput:           jc.Throughput,
+			Latency:              jc.Latency,
+			ErrorTimeline:        jc.ErrorTimeline,
+			Workers:              jc.Workers,
+			Steps:                jc.Steps,
+			LatencyByUtilization: jc.LatencyByUtilization,
+			PerQuery:             jc.PerQuery,
+		}
+		js.Throughput.idleIntervals = jc.IdleIntervals
+		stats[name] = js
+	}
+	return stats
+}
+
+/*
+ * Saves the checkpoint to the file, replacing it only once the new one is
+ * complete, so that a crash while saving leaves the previous checkpoint.
+ */
+func writeCheckpoint(path string, rc *runCheckpoint) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(rc); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := writeFileSync(tmp, buf.Bytes()); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func writeFileSync(path string, data []byte) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func readCheckpoint(path string) (*runCheckpoint, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var rc runCheckpoint
+	if err := gob.NewDecoder(f).Decode(&rc); err != nil {
+		return nil, err
+	}
+	return &rc, nil
+}
+
+// The fields of the stats that are not exported, for the checkpoints.
+
+type latencyHistogramGob struct {
+	Counts        map[int]uint64
+	Count         int
+	Min, Max      time.Duration
+	SubBucketBits int
+}
+
+func (lh *LatencyHistogram) GobEncode() ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(&latencyHistogramGob{lh.counts, lh.count, lh.min, lh.max, lh.subBucketBits})
+	return buf.Bytes(), err
+}
+
+func (lh *LatencyHistogram) GobDecode(b []byte) error {
+	var g latencyHistogramGob
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&g); err != nil {
+		return err
+	}
+	*lh = LatencyHistogram{g.Counts, g.Count, g.Min, g.Max, g.SubBucketBits}
+	return nil
+}
+
+type streamingStatsGob struct {
+	Count              int
+	Mean               float64
+	SumSquareDeviation float64
+}
+
+func (ss *StreamingStats) GobEncode() ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(&streamingStatsGob{ss.count, ss.mean, ss.sumSquareDeviation})
+	return buf.Bytes(), err
+}
+
+func (ss *StreamingStats) GobDecode(b []byte) error {
+	var g streamingStatsGob
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&g); err != nil {
+		return err
+	}
+	*ss = StreamingStats{g.Count, g.Mean, g.SumSquareDeviation}
+	return nil
+}
+
+type errorTimelineGob struct {
+	Interval time.Duration
+	Counts   map[string]map[int]uint64
+	Last     int
+}
+
+func (et *ErrorTimeline) GobEncode() ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(&errorTimelineGob{et.Interval, et.counts, et.last})
+	return buf.Bytes(), err
+}
+
+func (et *ErrorTimeline) GobDecode(b []byte) error {
+	var g errorTimelineGob
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&g); err != nil {
+		return err
+	}
+	*et = ErrorTimeline{g.Interval, g.Counts, g.Last}
+	return nil
+}
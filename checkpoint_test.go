@@ -0,0 +1,110 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	defer func(updates bool) { *intermediateUpdates = updates }(*intermediateUpdates)
+	*intermediateUpdates = false
+
+	config := &Config{Jobs: map[string]*Job{"test": {Name: "test"}}}
+	results := NewResultQueue(3)
+	done := make(chan map[string]*JobStats)
+	go func() { done <- processResults(config, results, nil) }()
+	results.Send(&JobResult{Name: "test", Start: 0, Elapsed: time.Millisecond, Queries: 1, RowsAffected: 2})
+	results.Send(&JobResult{Name: "test", Start: time.Second, Elapsed: 3 * time.Millisecond, Queries: 1, RowsAffected: 2})
+	results.Close()
+	stats := <-done
+
+	dir, err := ioutil.TempDir("", "dbbench-checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoint")
+	if err := writeCheckpoint(path, newRunCheckpoint(stats, time.Minute, false)); err != nil {
+		t.Fatal(err)
+	}
+	rc, err := readCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rc.Elapsed != time.Minute || rc.Done {
+		t.Errorf("Expected an unfinished checkpoint after 1m, got %v (done %v)", rc.Elapsed, rc.Done)
+	}
+	want, got := stats["test"], rc.JobStats()["test"]
+	if got == nil {
+		t.Fatal("Expected the stats of the job in the checkpoint")
+	}
+	if got.jobStats.Transactions.Count() != want.jobStats.Transactions.Count() || got.jobStats.Transactions.Mean() != want.jobStats.Transactions.Mean() {
+		t.Errorf("Expected %d transactions of mean %v, got %d of mean %v",
+			want.jobStats.Transactions.Count(), want.jobStats.Transactions.Mean(),
+			got.jobStats.Transactions.Count(), got.jobStats.Transactions.Mean())
+	}
+	if got.RowsAffected != want.RowsAffected {
+		t.Errorf("Expected %d rows affected, got %d", want.RowsAffected, got.RowsAffected)
+	}
+	if got.Latencies.Count() != want.Latencies.Count() || got.Latencies.Max() != want.Latencies.Max() {
+		t.Errorf("Expected a latency histogram of %d to %v, got %d to %v",
+			want.Latencies.Count(), want.Latencies.Max(), got.Latencies.Count(), got.Latencies.Max())
+	}
+}
+
+func TestResumedStatsContinueCheckpoint(t *testing.T) {
+	defer func(updates bool) { *intermediateUpdates = updates }(*intermediateUpdates)
+	*intermediateUpdates = false
+	defer func(rc *runCheckpoint) { resumed = rc }(resumed)
+
+	config := &Config{Jobs: map[string]*Job{"test": {Name: "test"}}}
+	run := func(start time.Duration) map[string]*JobStats {
+		results := NewResultQueue(1)
+		done := make(chan map[string]*JobStats)
+		go func() { done <- processResults(config, results, nil) }()
+		results.Send(&JobResult{Name: "test", Start: start, Elapsed: time.Millisecond, Queries: 1})
+		results.Close()
+		return <-done
+	}
+
+	resumed = newRunCheckpoint(run(0), time.Minute, false)
+	stats := run(time.Second)
+	if count := stats["test"].jobStats.Transactions.Count(); count != 2 {
+		t.Errorf("Expected 2 transactions over both attempts, got %d", count)
+	}
+}
+
+func TestRestartable(t *testing.T) {
+	for code, want := range map[int]bool{
+		exitSuccess:            false,
+		exitUsage:              false,
+		exitConnectionFailure:  true,
+		exitQueryErrors:        true,
+		exitInvalidRun:         false,
+		exitInterrupted:        false,
+		exitPreconditionFailed: false,
+	} {
+		if got := restartable(code); got != want {
+			t.Errorf("Expected restartable(%d) to be %v, got %v", code, want, got)
+		}
+	}
+}
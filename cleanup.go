@@ -0,0 +1,168 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var trackObjects = flag.Bool("track-objects", false,
+	"Record the tables, views, sequences, schemas and databases created by "+
+		"the setup queries and scripts in a dbbench_objects table of the "+
+		"database until the teardown finishes, so that the cleanup command "+
+		"can drop those left behind by a run that crashed.")
+var cleanupMinAge = flag.Duration("cleanup-min-age", time.Hour,
+	"Only drop the objects created at least this long ago with the cleanup "+
+		"command, to leave alone those of runs still in progress.")
+
+const objectsTable = `CREATE TABLE IF NOT EXISTS dbbench_objects (
+	run_id VARCHAR(32) NOT NULL,
+	seq INT NOT NULL,
+	kind VARCHAR(16) NOT NULL,
+	name VARCHAR(255) NOT NULL,
+	created_at TIMESTAMP NOT NULL
+)`
+
+var objectsColumns = []string{"run_id", "seq", "kind", "name", "created_at"}
+
+// The flavors whose CREATE and DROP statements support IF [NOT] EXISTS.
+var trackObjectsFlavors = map[string]bool{"mysql": true, "postgres": true, "vertica": true}
+
+/*
+ * Matches a statement creating an object, capturing whether it is temporary,
+ * its kind, and its name as written (quoted or qualified).
+ */
+var createStatementPattern = regexp.MustCompile(`(?is)^\s*create\s+(?:or\s+replace\s+)?` +
+	`(temporary\s+|temp\s+)?(table|view|sequence|schema|database)\s+(?:if\s+not\s+exists\s+)?` +
+	"((?:\"[^\"]*\"|`[^`]*`|[^\\s(\"`;])+)")
+
+// Orders the objects created by a run, so that they are dropped in reverse.
+var objectSeq int64
+
+// The object created by the statement, if it creates one that outlives its session.
+func createdObject(statement string) (kind, name string, ok bool) {
+	m := createStatementPattern.FindStringSubmatch(statement)
+	if m == nil || m[1] != "" {
+		return "", "", false
+	}
+	return strings.ToUpper(m[2]), m[3], true
+}
+
+// Whether the placeholders of the database flavor of the run are $1 rather than ?.
+func objectsOrdinal() bool {
+	sq, _ := supportedDatabaseFlavors[*driverName].(*sqlDatabaseFlavor)
+	return sq != nil && sq.name == "postgres"
+}
+
+// Returns why objects cannot be tracked in the database flavor, if they cannot.
+func checkTrackObjects(df DatabaseFlavor) error {
+	if sq, ok := df.(*sqlDatabaseFlavor); !ok || !trackObjectsFlavors[sq.name] {
+		return errors.New("track-objects and cleanup are only supported by the mysql, postgres, cockroachdb and vertica drivers")
+	}
+	return nil
+}
+
+// Records the objects created by the setup statements in the database.
+func recordCreatedObjects(db Database, statements []string) {
+	for _, statement := range statements {
+		kind, name, ok := createdObject(statement)
+		if !ok {
+			continue
+		}
+		if _, err := db.RunQuery(context.Background(), nil, objectsTable, nil); err != nil {
+			log.Printf("track-objects: %v", err)
+			return
+		}
+		if _, err := db.RunQuery(context.Background(), nil, insertStatement("dbbench_objects", objectsColumns, objectsOrdinal()),
+			[]interface{}{runID, atomic.AddInt64(&objectSeq, 1), kind, name, time.Now().UTC()}); err != nil {
+			log.Printf("track-objects: %v", err)
+		}
+	}
+}
+
+// Forgets the objects of the run once its teardown has finished.
+func forgetCreatedObjects(db Database) {
+	query := "DELETE FROM dbbench_objects WHERE run_id = ?"
+	if objectsOrdinal() {
+		query = "DELETE FROM dbbench_objects WHERE run_id = $1"
+	}
+	if _, err := db.RunQuery(context.Background(), nil, objectsTable, nil); err != nil {
+		log.Printf("track-objects: %v", err)
+	} else if _, err := db.RunQuery(context.Background(), nil, query, []interface{}{runID}); err != nil {
+		log.Printf("track-objects: %v", err)
+	}
+}
+
+/*
+ * Drops the objects recorded by runs that never finished their teardown, at
+ * least minAge ago, newest first. An object that cannot be dropped stays
+ * recorded, so that the cleanup can be retried.
+ */
+func cleanupObjects(db Database, minAge time.Duration) error {
+	if _, err := db.RunQuery(context.Background(), nil, objectsTable, nil); err != nil {
+		return err
+	}
+	query := "SELECT run_id, seq, kind, name FROM dbbench_objects WHERE created_at <= ? ORDER BY run_id, seq DESC"
+	forget := "DELETE FROM dbbench_objects WHERE run_id = ? AND seq = ?"
+	if objectsOrdinal() {
+		query = "SELECT run_id, seq, kind, name FROM dbbench_objects WHERE created_at <= $1 ORDER BY run_id, seq DESC"
+		forget = "DELETE FROM dbbench_objects WHERE run_id = $1 AND seq = $2"
+	}
+	var buf bytes.Buffer
+	if _, err := db.RunQuery(context.Background(), NewSafeCSVWriterTo(&buf), query, []interface{}{time.Now().Add(-minAge).UTC()}); err != nil {
+		return err
+	}
+
+	var dropped, failed int
+	r := csv.NewReader(&buf)
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		} else if len(record) != 4 {
+			return fmt.Errorf("unexpected dbbench_objects record %q", record)
+		}
+		runID, seq, kind, name := record[0], record[1], record[2], record[3]
+		if _, err := db.RunQuery(context.Background(), nil, fmt.Sprintf("DROP %s IF EXISTS %s", kind, name), nil); err != nil {
+			log.Printf("Error dropping %s %s of run %s: %v", strings.ToLower(kind), name, runID, err)
+			failed++
+			continue
+		}
+		log.Printf("Dropped %s %s of run %s", strings.ToLower(kind), name, runID)
+		dropped++
+		if _, err := db.RunQuery(context.Background(), nil, forget, []interface{}{runID, seq}); err != nil {
+			return err
+		}
+	}
+	log.Printf("Dropped %d objects left behind by earlier runs", dropped)
+	if failed > 0 {
+		return fmt.Errorf("could not drop %d objects", failed)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"testing"
+)
+
+func TestCreatedObject(t *testing.T) {
+	for _, c := range []struct {
+		statement string
+		kind      string
+		name      string
+	}{
+		{"create table t(a int)", "TABLE", "t"},
+		{"CREATE TABLE IF NOT EXISTS db.t (a int)", "TABLE", "db.t"},
+		{"create table `my table` (a int)", "TABLE", "`my table`"},
+		{`create table "s"."My Table"(a int)`, "TABLE", `"s"."My Table"`},
+		{"\n  create or replace view v as select 1", "VIEW", "v"},
+		{"create database if not exists bench", "DATABASE", "bench"},
+		{"create schema s;", "SCHEMA", "s"},
+		{"create sequence seq start 1", "SEQUENCE", "seq"},
+		{"create temporary table t(a int)", "", ""},
+		{"create index i on t(a)", "", ""},
+		{"insert into t values (1)", "", ""},
+	} {
+		kind, name, ok := createdObject(c.statement)
+		if ok != (c.kind != "") || kind != c.kind || name != c.name {
+			t.Errorf("Expected %q to create %s %s but got %s %s (%v)",
+				c.statement, c.kind, c.name, kind, name, ok)
+		}
+	}
+}
@@ -0,0 +1,413 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"container/heap"
+	"context"
+	"flag"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var virtualTime = flag.Bool("virtual-time", false,
+	"Run the jobs on a simulated clock against the fake driver, to check "+
+		"the schedule of a runfile (start, stop, rates, ramps, phases, ...) "+
+		"in a fraction of its duration.")
+
+/*
+ * The source of time of the scheduling engine, so that it can run on a
+ * simulated clock (see virtualClock) as well as on the real one.
+ */
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+type Timer interface {
+	Chan() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+type Ticker interface {
+	Chan() <-chan time.Time
+	Stop()
+}
+
+var clock Clock = realClock{}
+
+func clockSince(t time.Time) time.Duration {
+	return clock.Now().Sub(t)
+}
+
+func clockUntil(t time.Time) time.Duration {
+	return t.Sub(clock.Now())
+}
+
+func clockAfter(d time.Duration) <-chan time.Time {
+	return clock.NewTimer(d).Chan()
+}
+
+func clockSleep(d time.Duration) {
+	<-clockAfter(d)
+}
+
+/*
+ * Sleeps for the duration, or until the context is done, returning its error.
+ * In a goroutine started by clockGo (with a context derived from the one it
+ * was passed), the virtual clock counts the goroutine as blocked on it.
+ */
+func clockSleepContext(ctx context.Context, d time.Duration) error {
+	timer := clock.NewTimer(d)
+	defer timer.Stop()
+	if vc, ok := ctx.Value(clockGoroutineKey{}).(*virtualClock); ok && vc == clock {
+		vt := timer.(*virtualTimer)
+		vc.block(vt)
+		defer vc.unblock(vt)
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.Chan():
+		return nil
+	}
+}
+
+// The context value that marks a goroutine started by clockGo.
+type clockGoroutineKey struct{}
+
+/*
+ * Runs f in a new goroutine that a virtual clock waits for: the clock does
+ * not advance while f runs, only while it is blocked in clockSleepContext (on
+ * the context passed to it) or once it has returned. On the real clock, this
+ * is just go f(ctx).
+ */
+func clockGo(ctx context.Context, f func(ctx context.Context)) {
+	vc, ok := clock.(*virtualClock)
+	if !ok {
+		go f(ctx)
+		return
+	}
+	// Counted before the goroutine starts, so that the clock cannot advance
+	// before it gets to run.
+	vc.addRunning(1)
+	go func() {
+		defer vc.addRunning(-1)
+		f(context.WithValue(ctx, clockGoroutineKey{}, vc))
+	}()
+}
+
+// Like context.WithTimeout, but the timeout elapses on the clock.
+func clockWithTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := clock.(realClock); ok {
+		return context.WithTimeout(ctx, d)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	timer := clock.NewTimer(d)
+	go func() {
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+		case <-timer.Chan():
+			cancel()
+		}
+	}()
+	return ctx, cancel
+}
+
+type realClock struct{}
+
+type realTimer struct{ *time.Timer }
+
+type realTicker struct{ *time.Ticker }
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTimer(d time.Duration) Timer { return realTimer{time.NewTimer(d)} }
+
+func (realClock) NewTicker(d time.Duration) Ticker { return realTicker{time.NewTicker(d)} }
+
+func (t realTimer) Chan() <-chan time.Time { return t.C }
+
+func (t realTicker) Chan() <-chan time.Time { return t.C }
+
+const (
+	// How long the clock must go unused before a virtualClock jumps to the
+	// next timer.
+	virtualTimeQuiet = 50 * time.Microsecond
+	// How long a virtualClock waits for the clock to go unused before it
+	// jumps to the next timer anyway (once no goroutine of clockGo is
+	// running), so that jobs running queries without latency cannot stop
+	// time.
+	virtualTimeMaxBusy = 10 * time.Millisecond
+	// How often a virtualClock without timers checks for new ones.
+	virtualTimeIdlePoll = time.Millisecond
+)
+
+/*
+ * A simulated clock, which only moves when it is advanced. Advance moves it
+ * by a fixed amount (e.g. in tests), and Run moves it to the next timer once
+ * nothing uses the clock, so a schedule runs as fast as its queries do.
+ *
+ * Run never advances while a goroutine started by clockGo is running: it
+ * keeps an explicit count of them, which goes down while one is blocked on
+ * the clock and back up once its timer wakes it. Other goroutines are only
+ * waited for by watching for the clock to go unused.
+ *
+ * Timers and tickers fire in the order of their deadlines, and like real
+ * tickers, a ticker drops the ticks its receiver is not ready for.
+ */
+type virtualClock struct {
+	// Counts the uses of the clock, accessed atomically.
+	activity uint64
+
+	m      sync.Mutex
+	now    time.Time
+	timers virtualTimers
+	seq    uint64
+	// The goroutines of clockGo that are not blocked on the clock.
+	running int
+}
+
+type virtualTimer struct {
+	vc       *virtualClock
+	c        chan time.Time
+	deadline time.Time
+	period   time.Duration
+	seq      uint64
+	index    int // in timers, or -1 if not pending
+	// Whether a goroutine of clockGo is blocked on the timer.
+	waiter bool
+}
+
+func newVirtualClock(now time.Time) *virtualClock {
+	return &virtualClock{now: now}
+}
+
+func (vc *virtualClock) Now() time.Time {
+	vc.m.Lock()
+	defer vc.m.Unlock()
+
+	atomic.AddUint64(&vc.activity, 1)
+	return vc.now
+}
+
+func (vc *virtualClock) newTimer(d, period time.Duration) *virtualTimer {
+	vc.m.Lock()
+	defer vc.m.Unlock()
+
+	vt := &virtualTimer{vc: vc, c: make(chan time.Time, 1), period: period, index: -1}
+	vc.schedule(vt, d)
+	return vt
+}
+
+func (vc *virtualClock) NewTimer(d time.Duration) Timer {
+	return vc.newTimer(d, 0)
+}
+
+func (vc *virtualClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	return virtualTicker{vc.newTimer(d, d)}
+}
+
+// Must be called with vc.m held.
+func (vc *virtualClock) schedule(vt *virtualTimer, d time.Duration) {
+	atomic.AddUint64(&vc.activity, 1)
+	vc.seq++
+	vt.deadline, vt.seq = vc.now.Add(d), vc.seq
+	heap.Push(&vc.timers, vt)
+	vc.fire()
+}
+
+// Fires the timers that are due. Must be called with vc.m held.
+func (vc *virtualClock) fire() {
+	for len(vc.timers) > 0 && !vc.timers[0].deadline.After(vc.now) {
+		vt := heap.Pop(&vc.timers).(*virtualTimer)
+		select {
+		case vt.c <- vc.now:
+		default:
+		}
+		if vt.waiter {
+			// Its goroutine runs again from now on.
+			vt.waiter = false
+			vc.running++
+		}
+		if vt.period > 0 {
+			vt.deadline = vt.deadline.Add(vt.period)
+			heap.Push(&vc.timers, vt)
+		}
+	}
+}
+
+func (vc *virtualClock) addRunning(n int) {
+	vc.m.Lock()
+	defer vc.m.Unlock()
+
+	atomic.AddUint64(&vc.activity, 1)
+	vc.running += n
+}
+
+// Counts the running goroutine of clockGo as blocked on the timer.
+func (vc *virtualClock) block(vt *virtualTimer) {
+	vc.m.Lock()
+	defer vc.m.Unlock()
+
+	if vt.index >= 0 {
+		vt.waiter = true
+		vc.running--
+	}
+}
+
+// Counts the goroutine blocked on the timer as running again, unless the timer woke it.
+func (vc *virtualClock) unblock(vt *virtualTimer) {
+	vc.m.Lock()
+	defer vc.m.Unlock()
+
+	if vt.waiter {
+		vt.waiter = false
+		vc.running++
+	}
+}
+
+// Moves the clock forward by d, firing the timers due on the way in order.
+func (vc *virtualClock) Advance(d time.Duration) {
+	vc.m.Lock()
+	defer vc.m.Unlock()
+
+	end := vc.now.Add(d)
+	for len(vc.timers) > 0 && !vc.timers[0].deadline.After(end) {
+		vc.now = vc.timers[0].deadline
+		vc.fire()
+	}
+	vc.now = end
+}
+
+/*
+ * Moves the clock to the next timer, unless a goroutine of clockGo is
+ * running. Returns false if there is no timer.
+ */
+func (vc *virtualClock) advanceToNext() bool {
+	vc.m.Lock()
+	defer vc.m.Unlock()
+
+	if len(vc.timers) == 0 {
+		return false
+	} else if vc.running > 0 {
+		return true
+	}
+	if vc.timers[0].deadline.After(vc.now) {
+		vc.now = vc.timers[0].deadline
+	}
+	vc.fire()
+	return true
+}
+
+/*
+ * Advances the clock whenever it goes unused, and every goroutine of clockGo
+ * is blocked on it, until the context is done. The quiet periods are far
+ * shorter than time.Sleep can wait, so this spins.
+ */
+func (vc *virtualClock) Run(ctx context.Context) {
+	lastActivity := atomic.LoadUint64(&vc.activity)
+	quietSince, lastAdvance := time.Now(), time.Now()
+	for ctx.Err() == nil {
+		runtime.Gosched()
+
+		now := time.Now()
+		if activity := atomic.LoadUint64(&vc.activity); activity != lastActivity {
+			lastActivity, quietSince = activity, now
+		}
+		if now.Sub(quietSince) < virtualTimeQuiet && now.Sub(lastAdvance) < virtualTimeMaxBusy {
+			continue
+		}
+		if !vc.advanceToNext() {
+			time.Sleep(virtualTimeIdlePoll)
+		}
+		lastActivity = atomic.LoadUint64(&vc.activity)
+		quietSince, lastAdvance = time.Now(), time.Now()
+	}
+}
+
+type virtualTicker struct{ *virtualTimer }
+
+func (vt *virtualTimer) Chan() <-chan time.Time {
+	return vt.c
+}
+
+func (vt virtualTicker) Stop() {
+	vt.virtualTimer.Stop()
+}
+
+func (vt *virtualTimer) Stop() bool {
+	vt.vc.m.Lock()
+	defer vt.vc.m.Unlock()
+
+	atomic.AddUint64(&vt.vc.activity, 1)
+	if vt.index < 0 {
+		return false
+	}
+	heap.Remove(&vt.vc.timers, vt.index)
+	return true
+}
+
+func (vt *virtualTimer) Reset(d time.Duration) bool {
+	vt.vc.m.Lock()
+	defer vt.vc.m.Unlock()
+
+	pending := vt.index >= 0
+	if pending {
+		heap.Remove(&vt.vc.timers, vt.index)
+	}
+	vt.vc.schedule(vt, d)
+	return pending
+}
+
+// A min-heap of the pending timers by deadline, then by when they were set.
+type virtualTimers []*virtualTimer
+
+func (vts virtualTimers) Len() int { return len(vts) }
+
+func (vts virtualTimers) Less(i, j int) bool {
+	if !vts[i].deadline.Equal(vts[j].deadline) {
+		return vts[i].deadline.Before(vts[j].deadline)
+	}
+	return vts[i].seq < vts[j].seq
+}
+
+func (vts virtualTimers) Swap(i, j int) {
+	vts[i], vts[j] = vts[j], vts[i]
+	vts[i].index, vts[j].index = i, j
+}
+
+func (vts *virtualTimers) Push(x interface{}) {
+	vt := x.(*virtualTimer)
+	vt.index = len(*vts)
+	*vts = append(*vts, vt)
+}
+
+func (vts *virtualTimers) Pop() interface{} {
+	old := *vts
+	vt := old[len(old)-1]
+	vt.index = -1
+	*vts = old[:len(old)-1]
+	return vt
+}
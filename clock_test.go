@@ -0,0 +1,144 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestVirtualClock(t *testing.T) {
+	origin := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	vc := newVirtualClock(origin)
+
+	late := vc.NewTimer(3 * time.Second)
+	early := vc.NewTimer(time.Second)
+	stopped := vc.NewTimer(2 * time.Second)
+	ticker := vc.NewTicker(time.Second)
+	if !stopped.Stop() {
+		t.Error("Expected stopping a pending timer to succeed")
+	}
+
+	vc.Advance(1500 * time.Millisecond)
+	if now := vc.Now(); !now.Equal(origin.Add(1500 * time.Millisecond)) {
+		t.Errorf("Expected the clock at %v but got %v", origin.Add(1500*time.Millisecond), now)
+	}
+	select {
+	case fired := <-early.Chan():
+		if !fired.Equal(origin.Add(time.Second)) {
+			t.Errorf("Expected the timer to fire at its deadline but got %v", fired)
+		}
+	default:
+		t.Error("Expected the early timer to have fired")
+	}
+	select {
+	case <-late.Chan():
+		t.Error("Unexpected late timer firing before its deadline")
+	default:
+	}
+	if early.Stop() {
+		t.Error("Expected stopping a fired timer to fail")
+	}
+
+	// The receiver is not ready for the ticks at 2s and 3s, so one is dropped.
+	vc.Advance(2 * time.Second)
+	if tick := <-ticker.Chan(); !tick.Equal(origin.Add(time.Second)) {
+		t.Errorf("Expected the tick at 1s but got %v", tick)
+	}
+	select {
+	case tick := <-ticker.Chan():
+		t.Errorf("Unexpected buffered tick at %v", tick)
+	default:
+	}
+	<-late.Chan()
+	ticker.Stop()
+
+	select {
+	case <-stopped.Chan():
+		t.Error("Unexpected stopped timer firing")
+	default:
+	}
+	stopped.Reset(time.Second)
+	vc.Advance(time.Second)
+	if fired := <-stopped.Chan(); !fired.Equal(origin.Add(4500 * time.Millisecond)) {
+		t.Errorf("Expected the reset timer to fire at 4.5s but got %v", fired)
+	}
+}
+
+func TestVirtualClockWaitsForGoroutines(t *testing.T) {
+	vc := newVirtualClock(time.Now())
+	defer func(c Clock) { clock = c }(clock)
+	clock = vc
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Always has a timer for the clock to jump to.
+	ticker := vc.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	go vc.Run(ctx)
+
+	elapsed := make(chan [2]time.Duration)
+	clockGo(ctx, func(ctx context.Context) {
+		start := clock.Now()
+		// Runs for longer than the clock waits for it to go unused.
+		time.Sleep(5 * virtualTimeMaxBusy)
+		busy := clockSince(start)
+		clockSleepContext(ctx, time.Second)
+		elapsed <- [2]time.Duration{busy, clockSince(start)}
+	})
+	if e := <-elapsed; e[0] != 0 || e[1] != time.Second {
+		t.Errorf("Expected the clock to only move while the goroutine slept, by 1s, but it moved %v then %v",
+			e[0], e[1])
+	}
+}
+
+func TestVirtualTimeSchedule(t *testing.T) {
+	vc := newVirtualClock(time.Now())
+	defer func(c Clock) { clock = c }(clock)
+	clock = vc
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go vc.Run(ctx)
+
+	df := supportedDatabaseFlavors["fake"]
+	db, err := df.Connect(&ConnectionConfig{Params: "latency=10ms"})
+	if err != nil {
+		t.Fatalf("Error connecting to fake database: %v", err)
+	}
+	defer db.Close()
+
+	// An hour of invocations, which only takes as long as running them.
+	start := time.Now()
+	jobs := map[string]*Job{
+		"test": {Name: "test", Queries: []string{"select 1"}, Rate: 2, BatchSize: 1, Start: time.Minute, Stop: time.Hour},
+	}
+	var results int
+	for jr := range makeJobResultQueue(ctx, db, df, jobs).Results() {
+		results++
+		if jr.Elapsed != 10*time.Millisecond {
+			t.Errorf("Expected each invocation to take 10ms but got %v", jr.Elapsed)
+		} else if jr.Start < time.Minute || jr.Start > time.Hour {
+			t.Errorf("Unexpected invocation at %v, outside of the schedule", jr.Start)
+		}
+	}
+	if expected := 2 * 59 * 60; results < expected-1 || results > expected {
+		t.Errorf("Expected %d invocations but got %d", expected, results)
+	}
+	if elapsed := time.Since(start); elapsed > time.Minute {
+		t.Errorf("Expected the schedule to run faster than real time but it took %v", elapsed)
+	}
+}
@@ -0,0 +1,183 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/awreece/goini"
+)
+
+func printFlavors(w io.Writer) {
+	names := make([]string, 0, len(supportedDatabaseFlavors))
+	for name := range supportedDatabaseFlavors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		flavor := supportedDatabaseFlavors[name]
+		errorCodes := "supported"
+		if !flavor.HasErrorCodes() {
+			errorCodes = "unsupported"
+		}
+		fmt.Fprintf(w, "%s\n    %s\n    error codes %s\n", name, flavor.Describe(), errorCodes)
+		if codes := flavor.RetryableErrorCodes(); len(codes) > 0 {
+			fmt.Fprintf(w, "    retryable error codes %s\n", strings.Join(codes, ", "))
+		}
+		if classes := errorClassNames(flavor); len(classes) > 0 {
+			fmt.Fprintf(w, "    error classes %s\n", strings.Join(classes, ", "))
+		}
+	}
+}
+
+func printOptionSet(w io.Writer, section string, options goini.DecodeOptionSet) {
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(w, "%s options:\n", section)
+	for _, name := range names {
+		option := options[name]
+		kind := ""
+		if option.Kind == goini.MultiOption {
+			kind = " (may be repeated)"
+		}
+		fmt.Fprintf(w, "  %s%s\n      %s\n", name, kind, option.Usage)
+	}
+}
+
+func printOptions(w io.Writer) {
+	printOptionSet(w, "Global", globalOptions)
+	fmt.Fprintln(w)
+	printOptionSet(w, "Setup, teardown, and cache-flush", setupOptions)
+	fmt.Fprintln(w)
+	printOptionSet(w, "Warmup", warmupOptions)
+	fmt.Fprintln(w)
+	printOptionSet(w, "Job", jobOptions)
+	fmt.Fprintln(w)
+	printOptionSet(w, "Event ([event:<name>])", eventOptions)
+	fmt.Fprintln(w)
+	printOptionSet(w, "Phase ([phase:<name>])", phaseOptions)
+}
+
+/*
+ * Prints the effective config, after the defaults and command line overrides
+ * are applied, as JSON with every option (even those left as the default) in
+ * a canonical order so that it can be diffed.
+ */
+func printConfig(w io.Writer, config *Config) error {
+	connection := GlobalConfig
+	if connection.Password != "" {
+		connection.Password = "XXX"
+	}
+	b, err := json.MarshalIndent(map[string]interface{}{
+		"Driver":     *driverName,
+		"Connection": canonicalValue(reflect.ValueOf(connection)),
+		"Config":     canonicalValue(reflect.ValueOf(config)),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
+
+/*
+ * Converts a value of the config to one that encodes to canonical JSON:
+ * structs become objects of their exported fields (sorted by name when
+ * encoded), durations are formatted, and the readers and writers of a job,
+ * which cannot be printed, become true if set.
+ */
+func canonicalValue(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+	case reflect.Func, reflect.Chan:
+		return nil
+	}
+
+	switch x := v.Interface().(type) {
+	case time.Duration:
+		return x.String()
+	case time.Time:
+		if x.IsZero() {
+			return nil
+		}
+		return x.Format(time.RFC3339Nano)
+	case DatabaseFlavor:
+		for name, flavor := range supportedDatabaseFlavors {
+			if flavor == x {
+				return name
+			}
+		}
+		return x.Describe()
+	case *regexp.Regexp:
+		return x.String()
+	case Set:
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, fmt.Sprint(k))
+		}
+		sort.Strings(keys)
+		return keys
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return canonicalValue(v.Elem())
+	case reflect.Struct:
+		fields := make(map[string]interface{})
+		for i := 0; i < v.NumField(); i++ {
+			if field := v.Type().Field(i); field.PkgPath == "" {
+				fields[field.Name] = canonicalValue(v.Field(i))
+			}
+		}
+		if len(fields) == 0 {
+			return true
+		}
+		return fields
+	case reflect.Slice, reflect.Array:
+		values := make([]interface{}, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			values = append(values, canonicalValue(v.Index(i)))
+		}
+		return values
+	case reflect.Map:
+		values := make(map[string]interface{}, v.Len())
+		for _, k := range v.MapKeys() {
+			values[fmt.Sprint(k.Interface())] = canonicalValue(v.MapIndex(k))
+		}
+		return values
+	default:
+		return v.Interface()
+	}
+}
@@ -0,0 +1,99 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCanonicalValue(t *testing.T) {
+	type fields struct {
+		B      int
+		A      string
+		hidden int
+	}
+	var nilJob *Job
+	at := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		name     string
+		v        interface{}
+		expected string
+	}{
+		{"nil pointer", nilJob, `null`},
+		{"func", func() {}, `null`},
+		{"chan", make(chan int), `null`},
+		{"duration", 1500 * time.Millisecond, `"1.5s"`},
+		{"zero time", time.Time{}, `null`},
+		{"time", at, `"2020-06-01T12:00:00Z"`},
+		{"flavor", supportedDatabaseFlavors["postgres"], `"postgres"`},
+		{"regexp", regexp.MustCompile("dead.*lock"), `"dead.*lock"`},
+		{"set", Set{"b": {}, "a": {}}, `["a","b"]`},
+		{"struct", fields{B: 1, A: "x"}, `{"A":"x","B":1}`},
+		{"struct pointer", &fields{B: 1}, `{"A":"","B":1}`},
+		{"opaque struct", struct{ hidden int }{1}, `true`},
+		{"slice", []time.Duration{time.Second, time.Minute}, `["1s","1m0s"]`},
+		{"map", map[int]time.Duration{2: time.Second, 1: time.Minute}, `{"1":"1m0s","2":"1s"}`},
+		{"number", 1.5, `1.5`},
+	} {
+		b, err := json.Marshal(canonicalValue(reflect.ValueOf(tc.v)))
+		if err != nil {
+			t.Errorf("%s: error encoding: %v", tc.name, err)
+		} else if string(b) != tc.expected {
+			t.Errorf("%s: expected %s, got %s", tc.name, tc.expected, b)
+		}
+	}
+}
+
+func TestPrintConfig(t *testing.T) {
+	defer func(c ConnectionConfig) { GlobalConfig = c }(GlobalConfig)
+	GlobalConfig.Password = "secret"
+
+	config := &Config{
+		Duration: time.Minute,
+		Jobs:     map[string]*Job{"b": {Name: "b"}, "a": {Name: "a", Rate: 10}},
+	}
+	var first, second bytes.Buffer
+	if err := printConfig(&first, config); err != nil {
+		t.Fatal(err)
+	}
+	if err := printConfig(&second, config); err != nil {
+		t.Fatal(err)
+	}
+	if first.String() != second.String() {
+		t.Errorf("Expected the same config to print the same, got\n%s\nand\n%s", first.String(), second.String())
+	}
+
+	printed := first.String()
+	if strings.Contains(printed, "secret") {
+		t.Errorf("Expected the password to be redacted, got\n%s", printed)
+	}
+	for _, expected := range []string{`"Password": "XXX"`, `"Duration": "1m0s"`, `"Rate": 10`} {
+		if !strings.Contains(printed, expected) {
+			t.Errorf("Expected %s in the config, got\n%s", expected, printed)
+		}
+	}
+	if strings.Index(printed, `"a": {`) > strings.Index(printed, `"b": {`) {
+		t.Errorf("Expected the jobs in order of name, got\n%s", printed)
+	}
+}
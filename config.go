@@ -17,13 +17,16 @@
 package main
 
 import (
-	"encoding/csv"
+	"database/sql"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -33,12 +36,55 @@ import (
 )
 
 type Config struct {
-	Flavor         DatabaseFlavor
-	Duration       time.Duration
-	Setup          []string
-	Teardown       []string
-	Jobs           map[string]*Job
-	AcceptedErrors Set
+	Flavor            DatabaseFlavor
+	Duration          time.Duration
+	Setup             []string
+	SetupScripts      [][]string
+	Teardown          []string
+	TeardownScripts   [][]string
+	Warmup            []string
+	WarmupConcurrency int
+	Jobs              map[string]*Job
+	AcceptedErrors    Set
+	CacheComparison   bool
+	CacheFlush        []string
+	CacheFlushScripts [][]string
+	StartAt           time.Time
+	WarmupDuration    time.Duration
+	Cooldown          time.Duration
+	CooldownSamples   []string
+	CompareRounds     int
+	CompareWindow     time.Duration
+	MinDuration       time.Duration
+	MaxDuration       time.Duration
+	CostModel         CostModel
+
+	// Pause all jobs while the overload-query is over the threshold, until
+	// it is back at or under the resume level.
+	OverloadQuery     string
+	OverloadThreshold float64
+	OverloadResume    float64
+
+	// Errors accepted whatever their code, by their message.
+	AcceptedErrorMessages []*regexp.Regexp
+
+	// Set by the shell, which logs the first unaccepted error of each code
+	// rather than exit, so that a bad query does not end the session.
+	ShowErrors  bool
+	shownErrors Set
+
+	// The default max-errors, max-error-rate and query-timeout of the jobs.
+	MaxErrors    uint64
+	MaxErrorRate float64
+	QueryTimeout time.Duration
+
+	Events []*Event
+
+	// Run one after another, each with the jobs that name it.
+	Phases []*Phase
+
+	// Input files referenced by the config, e.g. query files.
+	Files []string
 }
 
 func (c *Config) String() string {
@@ -50,7 +96,7 @@ func readQueriesFromReader(df DatabaseFlavor, r io.Reader) ([]string, error) {
 	if contents, err := ioutil.ReadAll(r); err != nil {
 		return nil, err
 	} else {
-		for _, query := range strings.Split(string(contents), df.QuerySeparator()) {
+		for _, query := range df.SplitQueries(string(contents)) {
 			err := df.CheckQuery(query)
 			if err != nil && err != EmptyQueryError {
 				return nil, fmt.Errorf("invalid query %v", err)
@@ -70,9 +116,44 @@ func readQueriesFromFile(df DatabaseFlavor, queryFile string) ([]string, error)
 	return readQueriesFromReader(df, file)
 }
 
+/*
+ * Reads the statements of a script. Unlike the queries of a query file, they
+ * are not checked by the flavor since a script runs on a single connection
+ * and so may change it (e.g. with USE or SET).
+ */
+func readScriptFromReader(df DatabaseFlavor, r io.Reader) ([]string, error) {
+	contents, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var statements []string
+	for _, statement := range df.SplitQueries(string(contents)) {
+		if strings.TrimSpace(statement) != "" {
+			statements = append(statements, statement)
+		}
+	}
+	if len(statements) == 0 {
+		return nil, EmptyQueryError
+	}
+	return statements, nil
+}
+
+func readScriptFromFile(df DatabaseFlavor, scriptFile string) ([]string, error) {
+	file, err := os.Open(scriptFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return readScriptFromReader(df, file)
+}
+
 type globalSectionParser struct {
-	config *Config
-	flavor DatabaseFlavor
+	config    *Config
+	flavor    DatabaseFlavor
+	costRates LinearCostModel
+
+	hasOverloadThreshold bool
+	hasOverloadResume    bool
 }
 
 var globalOptions = goini.DecodeOptionSet{
@@ -84,27 +165,223 @@ var globalOptions = goini.DecodeOptionSet{
 			return e
 		},
 	},
+	"cost-per-query": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Estimated cost of each query, to report the cost of the " +
+			"run and the cost per 1k transactions.",
+		Parse: func(v string, gsp interface{}) (e error) {
+			gsp.(*globalSectionParser).costRates.PerQuery, e = strconv.ParseFloat(v, 64)
+			return e
+		},
+	},
+	"cost-per-row": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Estimated cost of each row returned or affected.",
+		Parse: func(v string, gsp interface{}) (e error) {
+			gsp.(*globalSectionParser).costRates.PerRow, e = strconv.ParseFloat(v, 64)
+			return e
+		},
+	},
+	"cost-per-second": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Estimated cost of each second spent running queries.",
+		Parse: func(v string, gsp interface{}) (e error) {
+			gsp.(*globalSectionParser).costRates.PerSecond, e = strconv.ParseFloat(v, 64)
+			return e
+		},
+	},
+	"min-duration": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "The run is invalid (and dbbench exits with status 4) if " +
+			"the jobs finish in less than this duration.",
+		Parse: func(v string, gsp interface{}) (e error) {
+			gsp.(*globalSectionParser).config.MinDuration, e = time.ParseDuration(v)
+			return e
+		},
+	},
+	"max-duration": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "The run is invalid (and dbbench exits with status 4) if " +
+			"the jobs take longer than this duration to finish.",
+		Parse: func(v string, gsp interface{}) (e error) {
+			gsp.(*globalSectionParser).config.MaxDuration, e = time.ParseDuration(v)
+			return e
+		},
+	},
+	"cache-comparison": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Run every job twice, first after the cache-flush section " +
+			"and then again with a warm cache, and compare the results.",
+		Parse: func(v string, gsp interface{}) (e error) {
+			gsp.(*globalSectionParser).config.CacheComparison, e = strconv.ParseBool(v)
+			return e
+		},
+	},
+	"compare-rounds": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Number of times to alternate running the workload against " +
+			"the -compare-url target and the main target.",
+		Parse: func(v string, gsp interface{}) (e error) {
+			gsp.(*globalSectionParser).config.CompareRounds, e = strconv.Atoi(v)
+			return e
+		},
+	},
+	"compare-window": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "How long to run the workload against each target in a " +
+			"comparison round.",
+		Parse: func(v string, gsp interface{}) (e error) {
+			gsp.(*globalSectionParser).config.CompareWindow, e = time.ParseDuration(v)
+			return e
+		},
+	},
+	"warmup": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "How long after each job starts its queries are left out " +
+			"of the final stats, e.g. while caches are cold. Unlike the " +
+			"warmup section, the queries of the jobs themselves warm up.",
+		Parse: func(v string, gsp interface{}) (e error) {
+			gsp.(*globalSectionParser).config.WarmupDuration, e = time.ParseDuration(v)
+			return e
+		},
+	},
+	"cooldown": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "How long to wait after the jobs complete before running " +
+			"teardown.",
+		Parse: func(v string, gsp interface{}) (e error) {
+			gsp.(*globalSectionParser).config.Cooldown, e = time.ParseDuration(v)
+			return e
+		},
+	},
+	"cooldown-sample-query": &goini.DecodeOption{Kind: goini.MultiOption,
+		Usage: "Query whose results are logged every intermediate stats " +
+			"interval during the cooldown, e.g. to sample server metrics.",
+		Parse: func(v string, gspi interface{}) error {
+			gsp := gspi.(*globalSectionParser)
+			if e := gsp.flavor.CheckQuery(v); e != nil {
+				return e
+			}
+			gsp.config.CooldownSamples = append(gsp.config.CooldownSamples, v)
+			return nil
+		},
+	},
+	"overload-query": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Query returning a server health metric (e.g. " +
+			"Threads_running) as its first column, sampled every " +
+			"intermediate stats interval. All jobs are paused while it " +
+			"is over overload-threshold.",
+		Parse: func(v string, gspi interface{}) error {
+			gsp := gspi.(*globalSectionParser)
+			if e := gsp.flavor.CheckQuery(v); e != nil {
+				return e
+			}
+			gsp.config.OverloadQuery = v
+			return nil
+		},
+	},
+	"overload-threshold": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "The value of the overload-query over which all jobs are " +
+			"paused.",
+		Parse: func(v string, gspi interface{}) (e error) {
+			gsp := gspi.(*globalSectionParser)
+			gsp.config.OverloadThreshold, e = strconv.ParseFloat(v, 64)
+			gsp.hasOverloadThreshold = true
+			return e
+		},
+	},
+	"overload-resume": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "The value of the overload-query at or under which paused " +
+			"jobs resume (default overload-threshold).",
+		Parse: func(v string, gspi interface{}) (e error) {
+			gsp := gspi.(*globalSectionParser)
+			gsp.config.OverloadResume, e = strconv.ParseFloat(v, 64)
+			gsp.hasOverloadResume = true
+			return e
+		},
+	},
+	"query-timeout": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Default query-timeout of the jobs.",
+		Parse: func(v string, gsp interface{}) (e error) {
+			gsp.(*globalSectionParser).config.QueryTimeout, e = parseQueryTimeout(v)
+			return e
+		},
+	},
+	"sla": &goini.DecodeOption{Kind: goini.MultiOption,
+		Usage: "The run is invalid (and dbbench exits with status 4) if " +
+			"a percentile of the latencies of the job is over a bound, " +
+			"given as <percentile>:<latency> (e.g. p99:20ms).",
+		Parse: func(v string, jp interface{}) error {
+			sla, err := parseSLABound(v)
+			if err == nil {
+				jp.(*jobParser).j.SLAs = append(jp.(*jobParser).j.SLAs, sla)
+			}
+			return err
+		},
+	},
+	"max-errors": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Default max-errors of the jobs.",
+		Parse: func(v string, gsp interface{}) (e error) {
+			gsp.(*globalSectionParser).config.MaxErrors, e = parseMaxErrors(v)
+			return e
+		},
+	},
+	"max-error-rate": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Default max-error-rate of the jobs.",
+		Parse: func(v string, gsp interface{}) (e error) {
+			gsp.(*globalSectionParser).config.MaxErrorRate, e = parseErrorRate(v)
+			return e
+		},
+	},
 	"error": &goini.DecodeOption{Kind: goini.MultiOption,
-		Usage: "Globally accepted errors.",
+		Usage: "Globally accepted errors: an error code, class=<name> for " +
+			"the codes of a class of errors of the database flavor (e.g. " +
+			"deadlock or duplicate-key, see the flavors command), or " +
+			"message=<regex> for the errors whose message matches.",
 		Parse: func(v string, gspi interface{}) error {
 			gsp := gspi.(*globalSectionParser)
+			if pattern := strings.TrimPrefix(v, "message="); pattern != v {
+				re, err := regexp.Compile(pattern)
+				if err != nil {
+					return fmt.Errorf("invalid error message pattern %s: %v",
+						strconv.Quote(pattern), err)
+				}
+				gsp.config.AcceptedErrorMessages = append(gsp.config.AcceptedErrorMessages, re)
+				return nil
+			}
+			codes := []string{v}
+			if class := strings.TrimPrefix(v, "class="); class != v {
+				var err error
+				if codes, err = errorClassCodes(gsp.flavor, class); err != nil {
+					return err
+				}
+			}
 			if gsp.config.AcceptedErrors == nil {
 				gsp.config.AcceptedErrors = make(Set)
 			}
-			gsp.config.AcceptedErrors.Add(v)
+			for _, code := range codes {
+				gsp.config.AcceptedErrors.Add(code)
+			}
 			return nil
 		},
 	},
 }
 
 func decodeGlobalSection(df DatabaseFlavor, s goini.RawSection, c *Config) error {
-	return globalOptions.Decode(s, &globalSectionParser{c, df})
+	gsp := &globalSectionParser{config: c, flavor: df}
+	if err := globalOptions.Decode(s, gsp); err != nil {
+		return err
+	}
+	c.CostModel = df.CostModel(gsp.costRates)
+
+	if (c.OverloadQuery != "") != gsp.hasOverloadThreshold {
+		return errors.New("overload-query and overload-threshold must be used together")
+	} else if gsp.hasOverloadResume && c.OverloadQuery == "" {
+		return errors.New("Cannot set overload-resume with no overload-query")
+	} else if !gsp.hasOverloadResume {
+		c.OverloadResume = c.OverloadThreshold
+	} else if c.OverloadResume > c.OverloadThreshold {
+		return errors.New("overload-resume cannot be greater than overload-threshold")
+	}
+	return nil
 }
 
 type setupSectionParser struct {
 	queries []string
+	scripts [][]string
 	df      DatabaseFlavor
 	basedir string
+	files   []string
 }
 
 var setupOptions = goini.DecodeOptionSet{
@@ -130,6 +407,7 @@ var setupOptions = goini.DecodeOptionSet{
 			if !filepath.IsAbs(v) {
 				v = filepath.Join(ssp.basedir, v)
 			}
+			ssp.files = append(ssp.files, v)
 			if qs, err := readQueriesFromFile(ssp.df, v); err != nil {
 				return err
 			} else {
@@ -138,24 +416,290 @@ var setupOptions = goini.DecodeOptionSet{
 			}
 		},
 	},
+	"script-file": &goini.DecodeOption{Kind: goini.MultiOption,
+		Usage: "Script to be executed statement by statement, in order, on " +
+			"a single connection after the queries of the section. Unlike " +
+			"query-file, it may contain queries that affect the connection " +
+			"(e.g. USE or SET).",
+		Parse: func(v string, sspi interface{}) error {
+			ssp := sspi.(*setupSectionParser)
+			if !filepath.IsAbs(v) {
+				v = filepath.Join(ssp.basedir, v)
+			}
+			ssp.files = append(ssp.files, v)
+			if statements, err := readScriptFromFile(ssp.df, v); err != nil {
+				return err
+			} else {
+				ssp.scripts = append(ssp.scripts, statements)
+				return nil
+			}
+		},
+	},
+}
+
+type warmupSectionParser struct {
+	setupSectionParser
+	concurrency int
+}
+
+var warmupOptions = goini.DecodeOptionSet{
+	"query": &goini.DecodeOption{Kind: goini.MultiOption,
+		Usage: "Query to warm the caches (e.g. the buffer pool or an index) " +
+			"with after setup, before any jobs are started. Each query runs " +
+			"once and is timed separately from the jobs.",
+		Parse: func(v string, wspi interface{}) error {
+			return setupOptions["query"].Parse(v, &wspi.(*warmupSectionParser).setupSectionParser)
+		},
+	},
+	"query-file": &goini.DecodeOption{Kind: goini.MultiOption,
+		Usage: "File of queries to warm the caches with, as for query.",
+		Parse: func(v string, wspi interface{}) error {
+			return setupOptions["query-file"].Parse(v, &wspi.(*warmupSectionParser).setupSectionParser)
+		},
+	},
+	"concurrency": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Number of warmup queries run at once (default 1).",
+		Parse: func(v string, wspi interface{}) (e error) {
+			wsp := wspi.(*warmupSectionParser)
+			wsp.concurrency, e = strconv.Atoi(v)
+			if e == nil && wsp.concurrency <= 0 {
+				return errors.New("concurrency must be positive")
+			}
+			return e
+		},
+	},
 }
 
-func decodeSetupSection(df DatabaseFlavor, s goini.RawSection, basedir string, ss *[]string) error {
+func decodeWarmupSection(df DatabaseFlavor, s goini.RawSection, basedir string, config *Config) error {
+	parser := warmupSectionParser{setupSectionParser{df: df, basedir: basedir}, 1}
+	if err := warmupOptions.Decode(s, &parser); err != nil {
+		return err
+	}
+	config.Warmup = parser.queries
+	if len(config.Warmup) > 0 {
+		config.WarmupConcurrency = parser.concurrency
+	}
+	config.Files = append(config.Files, parser.files...)
+	return nil
+}
+
+func decodeSetupSection(df DatabaseFlavor, s goini.RawSection, basedir string, ss *[]string, scripts *[][]string, files *[]string) error {
 	parser := setupSectionParser{df: df, basedir: basedir}
 	err := setupOptions.Decode(s, &parser)
 	if err == nil {
 		*ss = parser.queries
+		*scripts = parser.scripts
+		*files = append(*files, parser.files...)
 	}
 	return err
 }
 
+var eventOptions = goini.DecodeOptionSet{
+	"start": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "When the event should happen, as a duration elapsed since setup.",
+		Parse: func(v string, e interface{}) (err error) {
+			e.(*Event).Start, err = time.ParseDuration(v)
+			return err
+		},
+	},
+	"query": &goini.DecodeOption{Kind: goini.MultiOption,
+		Usage: "Query (e.g. CREATE INDEX) executed in order on a separate " +
+			"connection when the event happens.",
+		Parse: func(v string, e interface{}) error {
+			e.(*Event).Queries = append(e.(*Event).Queries, v)
+			return nil
+		},
+	},
+	"command": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Shell command executed when the event happens, e.g. to start " +
+			"a backup.",
+		Parse: func(v string, e interface{}) error {
+			e.(*Event).Command = v
+			return nil
+		},
+	},
+}
+
+func decodeEventSection(df DatabaseFlavor, section goini.RawSection, event *Event) error {
+	if err := eventOptions.Decode(section, event); err != nil {
+		return err
+	} else if len(event.Queries) == 0 && event.Command == "" {
+		return errors.New("no query or command provided")
+	} else if len(event.Queries) > 0 && event.Command != "" {
+		return errors.New("cannot have both queries and a command")
+	}
+	for _, query := range event.Queries {
+		if err := df.CheckQuery(query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var phaseOptions = goini.DecodeOptionSet{
+	"duration": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "How long the jobs of the phase run (by default, the " +
+			"global duration).",
+		Parse: func(v string, p interface{}) (err error) {
+			p.(*Phase).Duration, err = time.ParseDuration(v)
+			return err
+		},
+	},
+	"setup": &goini.DecodeOption{Kind: goini.MultiOption,
+		Usage: "Query executed in order before the jobs of the phase start.",
+		Parse: func(v string, p interface{}) error {
+			p.(*Phase).Setup = append(p.(*Phase).Setup, v)
+			return nil
+		},
+	},
+	"teardown": &goini.DecodeOption{Kind: goini.MultiOption,
+		Usage: "Query executed in order after the jobs of the phase stop.",
+		Parse: func(v string, p interface{}) error {
+			p.(*Phase).Teardown = append(p.(*Phase).Teardown, v)
+			return nil
+		},
+	},
+}
+
+func decodeConfigPhases(df DatabaseFlavor, iniConfig *goini.RawConfig, config *Config) error {
+	for _, name := range iniConfig.Sections() {
+		if !strings.HasPrefix(name, phaseSectionPrefix) {
+			continue
+		}
+
+		phase := &Phase{
+			Name:     strings.TrimPrefix(name, phaseSectionPrefix),
+			Duration: config.Duration,
+		}
+		if err := phaseOptions.Decode(iniConfig.Section(name), phase); err != nil {
+			return fmt.Errorf("Error parsing phase %s: %v",
+				strconv.Quote(phase.Name), err)
+		}
+		for _, query := range append(phase.Setup, phase.Teardown...) {
+			if err := df.CheckQuery(query); err != nil {
+				return fmt.Errorf("Error parsing phase %s: %v",
+					strconv.Quote(phase.Name), err)
+			}
+		}
+		config.Phases = append(config.Phases, phase)
+	}
+	return nil
+}
+
+/*
+ * Checks that each job runs in a phase that exists, and each phase runs a
+ * job, and that the jobs fit in the duration of each of their phases.
+ */
+func checkConfigPhases(config *Config) error {
+	if len(config.Phases) == 0 {
+		for name, job := range config.Jobs {
+			if len(job.Phases) > 0 {
+				return fmt.Errorf("job %s has a phase, but there are no phase sections",
+					strconv.Quote(name))
+			}
+		}
+		return nil
+	}
+
+	if config.CacheComparison || config.CompareRounds > 0 {
+		return errors.New("phases cannot be used with cache-comparison or compare-rounds")
+	} else if len(config.Events) > 0 {
+		return errors.New("phases cannot be used with events")
+	}
+
+	phases := make(map[string]*Phase)
+	for _, phase := range config.Phases {
+		// The stats of each phase are recorded with its name as the pass.
+		if *resultsDSN != "" && len(phase.Name) > maxPassLength {
+			return fmt.Errorf("phase %s has a name longer than the %d characters the results database allows",
+				strconv.Quote(phase.Name), maxPassLength)
+		}
+		phases[phase.Name] = phase
+	}
+	jobs := make(map[string]int)
+	for name, job := range config.Jobs {
+		if len(job.Phases) == 0 {
+			return fmt.Errorf("job %s is not in any phase", strconv.Quote(name))
+		}
+		for _, p := range job.Phases {
+			phase, ok := phases[p]
+			if !ok {
+				return fmt.Errorf("job %s is in phase %s, which does not exist",
+					strconv.Quote(name), strconv.Quote(p))
+			} else if phase.Duration > 0 && (job.Start > phase.Duration || job.Stop > phase.Duration) {
+				return fmt.Errorf("job %s stops after phase %s finishes.",
+					strconv.Quote(name), strconv.Quote(p))
+			} else if phase.Duration > 0 && job.Warmup > 0 && job.Start+job.Warmup >= phase.Duration {
+				return fmt.Errorf("job %s warms up until after phase %s finishes.",
+					strconv.Quote(name), strconv.Quote(p))
+			}
+			jobs[p]++
+		}
+	}
+	for _, phase := range config.Phases {
+		if jobs[phase.Name] == 0 {
+			return fmt.Errorf("phase %s has no jobs", strconv.Quote(phase.Name))
+		}
+	}
+	return nil
+}
+
+func decodeConfigEvents(df DatabaseFlavor, iniConfig *goini.RawConfig, config *Config) error {
+	for _, name := range iniConfig.Sections() {
+		if !strings.HasPrefix(name, eventSectionPrefix) {
+			continue
+		}
+
+		event := &Event{Name: strings.TrimPrefix(name, eventSectionPrefix)}
+		if err := decodeEventSection(df, iniConfig.Section(name), event); err != nil {
+			return fmt.Errorf("Error parsing event %s: %v",
+				strconv.Quote(event.Name), err)
+		}
+		config.Events = append(config.Events, event)
+	}
+	return nil
+}
+
 type jobParser struct {
 	j                 *Job
 	df                DatabaseFlavor
 	basedir           string
 	queryArgsFile     io.Reader
+	queryArgsJSON     bool
+	queryArgsRegular  bool // Not a pipe, so safe to peek at before the run.
+	maxRowsAction     bool
 	queryArgsDelim    rune
+	queryResultsMasks []ColumnMask
+	queryResultsEnc   []ColumnEncoding
+	resultSetIndex    bool
+	qps               float64
 	multiQueryAllowed bool
+	changesDatabase   bool
+	files             []string
+}
+
+/*
+ * Checks a query run by the workers of the job. Whether they may change the
+ * database (e.g. with USE) depends on the multi-query-mode, which is only
+ * known once the whole section is decoded.
+ */
+func (jp *jobParser) checkWorkerQuery(q string) error {
+	err := jp.df.CheckQuery(q)
+	if err == ChangeDatabaseError {
+		jp.changesDatabase = true
+		return nil
+	}
+	return err
+}
+
+// The flavor of the job, checking queries with checkWorkerQuery.
+type workerQueryFlavor struct {
+	DatabaseFlavor
+	jp *jobParser
+}
+
+func (wf workerQueryFlavor) CheckQuery(q string) error {
+	return wf.jp.checkWorkerQuery(q)
 }
 
 var jobOptions = goini.DecodeOptionSet{
@@ -166,94 +710,647 @@ var jobOptions = goini.DecodeOptionSet{
 			return e
 		},
 	},
-	"stop": &goini.DecodeOption{Kind: goini.UniqueOption,
-		Usage: "When this job should stop, as a duration elapsed since setup.",
-		Parse: func(v string, jp interface{}) (e error) {
-			jp.(*jobParser).j.Stop, e = time.ParseDuration(v)
+	"start-at": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Wall clock time (RFC 3339) when this job should start, " +
+			"e.g. 2024-06-01T02:00:00Z.",
+		Parse: func(v string, jp interface{}) (e error) {
+			jp.(*jobParser).j.StartAt, e = time.Parse(time.RFC3339, v)
+			return e
+		},
+	},
+	"extends": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Name of a [template:<name>] section whose options the job " +
+			"has, other than those it sets itself.",
+		Parse: func(v string, jp interface{}) error {
+			// Already applied by resolveJobTemplate.
+			return nil
+		},
+	},
+	"after": &goini.DecodeOption{Kind: goini.MultiOption,
+		Usage: "Another job that must finish before this one starts. May " +
+			"be given more than once to wait for several jobs. The start " +
+			"and stop of this job are then relative to when they finish.",
+		Parse: func(v string, jp interface{}) error {
+			jp.(*jobParser).j.After = append(jp.(*jobParser).j.After, v)
+			return nil
+		},
+	},
+	"stop": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "When this job should stop, as a duration elapsed since setup.",
+		Parse: func(v string, jp interface{}) (e error) {
+			jp.(*jobParser).j.Stop, e = time.ParseDuration(v)
+			return e
+		},
+	},
+	"warmup": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "How long after this job starts its queries are left out of " +
+			"the final stats, e.g. while caches are cold. Overrides the " +
+			"global warmup.",
+		Parse: func(v string, jp interface{}) (e error) {
+			jp.(*jobParser).j.Warmup, e = time.ParseDuration(v)
+			return e
+		},
+	},
+	"query": &goini.DecodeOption{Kind: goini.MultiOption,
+		Usage: "Query to execute for the job. " +
+			"Must be a single query and cannot have any effect on the " +
+			"connection (e.g USE or BEGIN), unless multi-query-mode is " +
+			"single-connection, which allows USE.",
+		Parse: func(v string, jpi interface{}) error {
+			jp := jpi.(*jobParser)
+			if e := jp.checkWorkerQuery(v); e != nil {
+				return e
+			} else {
+				jp.j.Queries = append(jp.j.Queries, v)
+				return nil
+			}
+		},
+	},
+	"query-file": &goini.DecodeOption{Kind: goini.MultiOption,
+		Usage: "File containing queries to execute for the job. " +
+			"Queries are separated as in the dialect of the database (e.g. " +
+			"by ; or by GO lines for mssql) and cannot have any " +
+			"effect on the connection (e.g USE or BEGIN).",
+		Parse: func(v string, jpi interface{}) error {
+			jp := jpi.(*jobParser)
+			if !filepath.IsAbs(v) {
+				v = filepath.Join(jp.basedir, v)
+			}
+			jp.files = append(jp.files, v)
+			if qs, err := readQueriesFromFile(workerQueryFlavor{jp.df, jp}, v); err != nil {
+				return err
+			} else {
+				jp.j.Queries = append(jp.j.Queries, qs...)
+				return nil
+			}
+		},
+	},
+	"follow-query": &goini.DecodeOption{Kind: goini.MultiOption,
+		Usage: "Query to execute after the queries of an invocation of " +
+			"the job only if they returned at least follow-query-min-rows " +
+			"rows, e.g. to delete a row found by polling a queue. Takes no " +
+			"query args; its results are not written to the query-results-file.",
+		Parse: func(v string, jpi interface{}) error {
+			jp := jpi.(*jobParser)
+			if e := jp.checkWorkerQuery(v); e != nil {
+				return e
+			}
+			jp.j.FollowQueries = append(jp.j.FollowQueries, v)
+			return nil
+		},
+	},
+	"follow-query-min-rows": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Rows the queries of an invocation must return for its " +
+			"follow-query to run (default 1).",
+		Parse: func(v string, jp interface{}) (e error) {
+			jp.(*jobParser).j.FollowQueryMinRows, e = strconv.ParseInt(v, 10, 64)
+			if e == nil && jp.(*jobParser).j.FollowQueryMinRows <= 0 {
+				return errors.New("follow-query-min-rows must be positive")
+			}
+			return e
+		},
+	},
+	"query-dir": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Directory of .sql query files; each invocation of the job " +
+			"runs the queries of one file, chosen at random by weight. The " +
+			"weight of a file is given by its name (e.g. 5 for lookup@5.sql, " +
+			"default 1) or by a " + queryDirManifest + " of <file>,<weight> " +
+			"records in the directory.",
+		Parse: func(v string, jpi interface{}) error {
+			jp := jpi.(*jobParser)
+			if !filepath.IsAbs(v) {
+				v = filepath.Join(jp.basedir, v)
+			}
+			sets, files, err := readQuerySetsFromDir(workerQueryFlavor{jp.df, jp}, v)
+			if err != nil {
+				return err
+			}
+			jp.j.QuerySets = sets
+			jp.files = append(jp.files, files...)
+			return nil
+		},
+	},
+	"hot-rows": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Bind a row id chosen at random from 1 to this many to the " +
+			"single placeholder of each query of an invocation, so that " +
+			"the invocations contend for a few hot rows.",
+		Parse: func(v string, jp interface{}) (e error) {
+			jp.(*jobParser).j.HotRows, e = strconv.ParseInt(v, 10, 64)
+			if e == nil && jp.(*jobParser).j.HotRows <= 0 {
+				return errors.New("hot-rows must be positive")
+			}
+			return e
+		},
+	},
+	"query-args-file": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "File containing csv delimited query args, one line per " +
+			"query, where \\N is NULL. Files ending in .json, .jsonl, or " +
+			".ndjson instead contain a JSON array of args or an object of " +
+			"named args per line. Named args are only supported by the " +
+			"mssql and vertica drivers.",
+		Parse: func(v string, jpi interface{}) error {
+			jp := jpi.(*jobParser)
+			if !filepath.IsAbs(v) {
+				v = filepath.Join(jp.basedir, v)
+			}
+			jp.files = append(jp.files, v)
+			jp.queryArgsJSON = isJSONArgsFile(v)
+			f, err := os.Open(v)
+			if err != nil {
+				return err
+			}
+			fi, err := f.Stat()
+			if err != nil {
+				return err
+			}
+			jp.queryArgsFile = f
+			jp.queryArgsRegular = fi.Mode().IsRegular()
+			return nil
+		},
+	},
+	"query-args-mode": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "What to do once the query-args-file runs out of args: " +
+			"stop (the default) ends the job, loop starts over from the " +
+			"beginning of the file, error aborts the run and marks it " +
+			"invalid, and random instead picks a random line of the file " +
+			"for every query.",
+		Parse: func(v string, jpi interface{}) error {
+			jp := jpi.(*jobParser)
+			switch v {
+			case "stop", "loop", "random", "error":
+				jp.j.QueryArgsMode = v
+				return nil
+			}
+			return fmt.Errorf("invalid query-args-mode %s", strconv.Quote(v))
+		},
+	},
+	"query-args-delim": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Field separator for csv delimited query args.",
+		Parse: func(v string, jpi interface{}) error {
+			jp := jpi.(*jobParser)
+			if s, err := strconv.Unquote(v); err != nil {
+				return err
+			} else if len(s) != 1 {
+				return errors.New("Must provide exactly one character for delimiter")
+			} else {
+				jp.queryArgsDelim, _ = utf8.DecodeRuneInString(s)
+				return nil
+			}
+		},
+	},
+	"query-args-columns": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Comma separated list of the (one based) columns of the " +
+			"query-args-file to bind, in order, e.g. 3,1,5.",
+		Parse: func(v string, jpi interface{}) error {
+			jp := jpi.(*jobParser)
+			for _, c := range strings.Split(v, ",") {
+				column, err := strconv.Atoi(strings.TrimSpace(c))
+				if err != nil {
+					return err
+				} else if column < 1 {
+					return errors.New("query-args-columns must be positive")
+				}
+				jp.j.QueryArgsColumns = append(jp.j.QueryArgsColumns, column-1)
+			}
+			return nil
+		},
+	},
+	"query-args-encoding": &goini.DecodeOption{Kind: goini.MultiOption,
+		Usage: "Decode binary values of the query-args-file, as " +
+			"[<column>:]<encoding> where column is one based and encoding " +
+			"is one of base64 or hex. Without a column, every column is " +
+			"decoded.",
+		Parse: func(v string, jpi interface{}) error {
+			jp := jpi.(*jobParser)
+			ce, err := ParseColumnEncoding(v)
+			if err != nil {
+				return err
+			}
+			jp.j.QueryArgsEncodings = append(jp.j.QueryArgsEncodings, ce)
+			return nil
+		},
+	},
+	"query-results-file": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Results from executed queries will be written to this file " +
+			"as comma separated values. If the file already exists, it " +
+			"will be truncated",
+		Parse: func(v string, jpi interface{}) (err error) {
+			jp := jpi.(*jobParser)
+			if !filepath.IsAbs(v) {
+				v = filepath.Join(jp.basedir, v)
+			}
+			jp.j.QueryResults, err = NewSafeCSVWriter(v)
+			return err
+		},
+	},
+	"log-file": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Log the messages of the job (e.g. when it starts and " +
+			"stops, errors, and outlier and plan captures) to this file " +
+			"rather than to the main log. If the file already exists, it " +
+			"will be truncated (or appended to with -resume).",
+		Parse: func(v string, jpi interface{}) error {
+			jp := jpi.(*jobParser)
+			if !filepath.IsAbs(v) {
+				v = filepath.Join(jp.basedir, v)
+			}
+			f, err := createOutputFile(v)
+			if err != nil {
+				return err
+			}
+			jp.j.setLogFile(f)
+			return nil
+		},
+	},
+	"verify-repeatable": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Execute each query twice back-to-back and report any " +
+			"invocation where the two results differ (rows must be returned " +
+			"in a deterministic order, e.g. with ORDER BY).",
+		Parse: func(v string, jp interface{}) (e error) {
+			jp.(*jobParser).j.VerifyRepeatable, e = strconv.ParseBool(v)
+			return e
+		},
+	},
+	"phase": &goini.DecodeOption{Kind: goini.MultiOption,
+		Usage: "A phase of the run (a [phase:<name>] section) the job runs " +
+			"in. May be given more than once to run the job in several " +
+			"phases.",
+		Parse: func(v string, jp interface{}) error {
+			jp.(*jobParser).j.Phases = append(jp.(*jobParser).j.Phases, v)
+			return nil
+		},
+	},
+	"report": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Whether to show the intermediate stats of this job (e.g. " +
+			"false for a noisy background job). The job is still included " +
+			"in the final stats and the stats files.",
+		Parse: func(v string, jp interface{}) error {
+			report, err := strconv.ParseBool(v)
+			jp.(*jobParser).j.HideIntermediateStats = !report
+			return err
+		},
+	},
+	"query-results-mask": &goini.DecodeOption{Kind: goini.MultiOption,
+		Usage: "Mask a column of the query-results-file, as " +
+			"<column>:<rule> where column is one based and rule is one of " +
+			"hash, null, or truncate:<length in characters>. Hashes are " +
+			"keyed by -query-results-mask-key. NULL is left as it is.",
+		Parse: func(v string, jpi interface{}) error {
+			jp := jpi.(*jobParser)
+			if mask, err := ParseColumnMask(v); err != nil {
+				return err
+			} else {
+				jp.queryResultsMasks = append(jp.queryResultsMasks, mask)
+				return nil
+			}
+		},
+	},
+	"query-results-set-index": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Prefix each row of the query-results-file with the (one " +
+			"based) index of the result set it belongs to, to tell apart " +
+			"the result sets of a stored procedure or batch.",
+		Parse: func(v string, jp interface{}) (e error) {
+			jp.(*jobParser).resultSetIndex, e = strconv.ParseBool(v)
+			return e
+		},
+	},
+	"query-results-encoding": &goini.DecodeOption{Kind: goini.MultiOption,
+		Usage: "Encode binary values in the query-results-file, as " +
+			"[<column>:]<encoding> where column is one based and encoding " +
+			"is one of base64 or hex. Without a column, every column is " +
+			"encoded. NULL is always written as \\N.",
+		Parse: func(v string, jpi interface{}) error {
+			jp := jpi.(*jobParser)
+			ce, err := ParseColumnEncoding(v)
+			if err != nil {
+				return err
+			}
+			jp.queryResultsEnc = append(jp.queryResultsEnc, ce)
+			return nil
+		},
+	},
+	"rate": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "The number of batches executed per second (default 0.0).",
+		Parse: func(v string, jpi interface{}) (e error) {
+			jp := jpi.(*jobParser)
+			jp.j.Rate, e = strconv.ParseFloat(v, 64)
+			if e == nil && jp.j.Rate < 0 {
+				return errors.New("invalid negative value for rate")
+			}
+			return e
+		},
+	},
+	"qps": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "The number of job invocations executed per second, as an " +
+			"alternative to rate that does not depend on batch-size.",
+		Parse: func(v string, jpi interface{}) (e error) {
+			jp := jpi.(*jobParser)
+			jp.qps, e = strconv.ParseFloat(v, 64)
+			if e == nil && jp.qps <= 0 {
+				return errors.New("qps must be positive")
+			}
+			return e
+		},
+	},
+	"rate-ramp": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Change the rate (batches per second) over the run, as " +
+			"<from>..<to> over <duration> [in <n> steps] (e.g. 10..1000 " +
+			"over 5m), linearly or in steps, then hold it at <to>.",
+		Parse: func(v string, jpi interface{}) (e error) {
+			jp := jpi.(*jobParser)
+			if jp.j.RateRamp, e = parseRamp(v); e == nil &&
+				(jp.j.RateRamp.From <= 0 || jp.j.RateRamp.To <= 0) {
+				return errors.New("rate-ramp must be positive")
+			}
+			return e
+		},
+	},
+	"concurrency-ramp": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Add queue-depth workers over the run, as <from>..<to> " +
+			"over <duration> [in <n> steps] (e.g. 1..64 over 10m), " +
+			"linearly or in steps, then keep all <to> of them.",
+		Parse: func(v string, jpi interface{}) (e error) {
+			jp := jpi.(*jobParser)
+			if jp.j.ConcurrencyRamp, e = parseRamp(v); e != nil {
+				return e
+			}
+			r := jp.j.ConcurrencyRamp
+			if r.From < 1 || r.To <= r.From || r.From != math.Trunc(r.From) || r.To != math.Trunc(r.To) {
+				return errors.New("concurrency-ramp must go up from a whole number of workers, at least 1, to a larger one")
+			}
+			return nil
+		},
+	},
+	"require-query": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Query run when the job starts, which fails the run (with " +
+			"exit code 6) unless it returns a row, e.g. to check that the " +
+			"dataset is loaded.",
+		Parse: func(v string, jpi interface{}) error {
+			jp := jpi.(*jobParser)
+			if e := jp.df.CheckQuery(v); e != nil {
+				return e
+			}
+			jp.j.RequireQuery = v
+			return nil
+		},
+	},
+	"require-rows": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "The least value of the first column of the require-query " +
+			"(e.g. a count(*) of the table the job reads).",
+		Parse: func(v string, jpi interface{}) (e error) {
+			jp := jpi.(*jobParser)
+			jp.j.RequireRows, e = strconv.ParseInt(v, 10, 64)
+			if e == nil && jp.j.RequireRows <= 0 {
+				e = errors.New("require-rows must be positive")
+			}
+			return e
+		},
+	},
+	"utilization-query": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Query returning a server utilization metric (e.g. " +
+			"Threads_running) as its first column. The rate is adjusted " +
+			"every intermediate stats interval to hold target-utilization.",
+		Parse: func(v string, jpi interface{}) error {
+			jp := jpi.(*jobParser)
+			if e := jp.df.CheckQuery(v); e != nil {
+				return e
+			}
+			jp.j.UtilizationQuery = v
+			return nil
+		},
+	},
+	"latency-target": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Adjust the rate, starting from rate or qps, every " +
+			"intermediate stats interval to hold the p99 latency of the " +
+			"job at this target, and log the highest throughput within it.",
+		Parse: func(v string, jpi interface{}) (e error) {
+			jp := jpi.(*jobParser)
+			jp.j.LatencyTarget, e = time.ParseDuration(v)
+			if e == nil && jp.j.LatencyTarget <= 0 {
+				return errors.New("latency-target must be positive")
+			}
+			return e
+		},
+	},
+	"target-utilization": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "The value of the utilization-query to hold by adjusting the " +
+			"rate.",
+		Parse: func(v string, jpi interface{}) (e error) {
+			jp := jpi.(*jobParser)
+			jp.j.TargetUtilization, e = strconv.ParseFloat(v, 64)
+			if e == nil && jp.j.TargetUtilization <= 0 {
+				return errors.New("target-utilization must be positive")
+			}
+			return e
+		},
+	},
+	"alert-p99": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Log a prominent alert (also sent to the alert-webhook) when " +
+			"the p99 latency of the job over its last alert-intervals stats " +
+			"intervals exceeds this goal, and again when it recovers.",
+		Parse: func(v string, jpi interface{}) (e error) {
+			jp := jpi.(*jobParser)
+			jp.j.AlertP99, e = time.ParseDuration(v)
+			if e == nil && jp.j.AlertP99 <= 0 {
+				return errors.New("alert-p99 must be positive")
+			}
 			return e
 		},
 	},
-	"query": &goini.DecodeOption{Kind: goini.MultiOption,
-		Usage: "Query to execute for the job. " +
-			"Must be a single query and cannot have any effect on the " +
-			"connection (e.g USE or BEGIN).",
+	"alert-intervals": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Stats intervals over which the p99 latency is compared " +
+			"with alert-p99 (default 3).",
+		Parse: func(v string, jpi interface{}) (e error) {
+			jp := jpi.(*jobParser)
+			jp.j.AlertIntervals, e = strconv.Atoi(v)
+			if e == nil && jp.j.AlertIntervals <= 0 {
+				return errors.New("alert-intervals must be positive")
+			}
+			return e
+		},
+	},
+	"outlier-multiple": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Capture and log the server context (e.g. the process list) " +
+			"when a query runs longer than this multiple of the running " +
+			"median latency of the job.",
+		Parse: func(v string, jpi interface{}) (e error) {
+			jp := jpi.(*jobParser)
+			jp.j.OutlierMultiple, e = strconv.ParseFloat(v, 64)
+			if e == nil && jp.j.OutlierMultiple <= 1 {
+				return errors.New("outlier-multiple must be greater than 1")
+			}
+			return e
+		},
+	},
+	"outlier-capture-query": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Query run on a separate connection to capture the server " +
+			"context of an outlier (default depends on the driver, e.g. " +
+			"show full processlist).",
 		Parse: func(v string, jpi interface{}) error {
 			jp := jpi.(*jobParser)
 			if e := jp.df.CheckQuery(v); e != nil {
 				return e
-			} else {
-				jp.j.Queries = append(jp.j.Queries, v)
-				return nil
 			}
+			jp.j.OutlierCaptureQuery = v
+			return nil
 		},
 	},
-	"query-file": &goini.DecodeOption{Kind: goini.MultiOption,
-		Usage: "File containing queries to execute for the job. " +
-			"Queries are separated by the query-separator and cannot have any " +
-			"effect on the connection (e.g USE or BEGIN).",
-		Parse: func(v string, jpi interface{}) error {
+	"jitter": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Random delay before each invocation of a queue-depth job, " +
+			"as <min>..<max> (e.g. 0..20ms) or just <max>. The delay is " +
+			"not counted in the latency of the invocation, and the final " +
+			"stats report the rate it paces the job to.",
+		Parse: func(v string, jpi interface{}) (err error) {
 			jp := jpi.(*jobParser)
-			if !filepath.IsAbs(v) {
-				v = filepath.Join(jp.basedir, v)
+			bounds := strings.SplitN(v, "..", 2)
+			if len(bounds) == 2 {
+				if jp.j.JitterMin, err = time.ParseDuration(bounds[0]); err != nil {
+					return err
+				}
 			}
-			if qs, err := readQueriesFromFile(jp.df, v); err != nil {
+			if jp.j.JitterMax, err = time.ParseDuration(bounds[len(bounds)-1]); err != nil {
 				return err
-			} else {
-				jp.j.Queries = append(jp.j.Queries, qs...)
-				return nil
 			}
+			if jp.j.JitterMin < 0 || jp.j.JitterMax < jp.j.JitterMin {
+				return fmt.Errorf("invalid jitter %s", strconv.Quote(v))
+			}
+			return nil
 		},
 	},
-	"query-args-file": &goini.DecodeOption{Kind: goini.UniqueOption,
-		Usage: "File containing csv delimited query args, one line per " +
-			"query.",
-		Parse: func(v string, jpi interface{}) (err error) {
-			jp := jpi.(*jobParser)
-			if !filepath.IsAbs(v) {
-				v = filepath.Join(jp.basedir, v)
+	"min-count": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "The run is invalid (and dbbench exits with status 4) if " +
+			"the job completes fewer transactions, e.g. because its " +
+			"query-args-file ran out.",
+		Parse: func(v string, jp interface{}) (e error) {
+			jp.(*jobParser).j.MinCount, e = strconv.ParseUint(v, 10, 64)
+			return e
+		},
+	},
+	"max-errors": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Abort the run, after which teardown still runs and " +
+			"dbbench exits with status 4, once the job has more errors " +
+			"than this, whether or not they are accepted. Errors that " +
+			"are not accepted are then tolerated up to this limit.",
+		Parse: func(v string, jp interface{}) (e error) {
+			jp.(*jobParser).j.MaxErrors, e = parseMaxErrors(v)
+			return e
+		},
+	},
+	"max-error-rate": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Abort the run like max-errors once more than this " +
+			"percentage of the queries of the job fail (e.g. 5%), " +
+			"checked from its 100th query on.",
+		Parse: func(v string, jp interface{}) (e error) {
+			jp.(*jobParser).j.MaxErrorRate, e = parseErrorRate(v)
+			return e
+		},
+	},
+	"max-rows": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Stop reading the results of a query after this many rows; " +
+			"by default the query then fails with error code max-rows.",
+		Parse: func(v string, jp interface{}) (e error) {
+			jp.(*jobParser).j.MaxRows, e = strconv.ParseInt(v, 10, 64)
+			if e == nil && jp.(*jobParser).j.MaxRows <= 0 {
+				return errors.New("max-rows must be positive")
 			}
-			jp.queryArgsFile, err = os.Open(v)
-			return err
+			return e
 		},
 	},
-	"query-args-delim": &goini.DecodeOption{Kind: goini.UniqueOption,
-		Usage: "Field separator for csv delimited query args.",
+	"query-timeout": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Cancel a query of the job that runs for longer than this, " +
+			"which then fails with error code timeout (accept it with " +
+			"error=timeout). With mysql, the statement keeps running on " +
+			"the server, which is not sent a KILL QUERY.",
+		Parse: func(v string, jp interface{}) (e error) {
+			jp.(*jobParser).j.QueryTimeout, e = parseQueryTimeout(v)
+			return e
+		},
+	},
+	"max-retries": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Retry a query up to this many times when it fails with a " +
+			"retryable error code of the database flavor (e.g. 40001 for " +
+			"cockroachdb); retries are counted separately from aborts.",
+		Parse: func(v string, jp interface{}) (e error) {
+			jp.(*jobParser).j.MaxRetries, e = strconv.ParseUint(v, 10, 64)
+			return e
+		},
+	},
+	"transaction": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Run the queries of each invocation, and its follow-query, " +
+			"in a single transaction on one connection, which is rolled " +
+			"back at the first error. The job may then have several " +
+			"queries without multi-query-mode.",
+		Parse: func(v string, jp interface{}) (e error) {
+			jp.(*jobParser).j.Transaction, e = strconv.ParseBool(v)
+			return e
+		},
+	},
+	"max-rows-action": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "What to do when a query exceeds max-rows: error (the " +
+			"default) or truncate, which counts the query as a success.",
 		Parse: func(v string, jpi interface{}) error {
 			jp := jpi.(*jobParser)
-			if s, err := strconv.Unquote(v); err != nil {
-				return err
-			} else if len(s) != 1 {
-				return errors.New("Must provide exactly one character for delimiter")
-			} else {
-				jp.queryArgsDelim, _ = utf8.DecodeRuneInString(s)
-				return nil
+			switch v {
+			case "error":
+			case "truncate":
+				jp.j.MaxRowsTruncate = true
+			default:
+				return fmt.Errorf("invalid max-rows-action %s", strconv.Quote(v))
 			}
+			jp.maxRowsAction = true
+			return nil
 		},
 	},
-	"query-results-file": &goini.DecodeOption{Kind: goini.UniqueOption,
-		Usage: "Results from executed queries will be written to this file " +
-			"as comma separated values. If the file already exists, it " +
-			"will be truncated",
-		Parse: func(v string, jpi interface{}) (err error) {
-			jp := jpi.(*jobParser)
-			if !filepath.IsAbs(v) {
-				v = filepath.Join(jp.basedir, v)
-			}
-			jp.j.QueryResults, err = NewSafeCSVWriter(v)
-			return err
+	"all-result-sets": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Read (and count the rows of) every result set returned by " +
+			"a query, e.g. a stored procedure, rather than just the first " +
+			"(as is always done for CALL, EXEC, and EXECUTE statements). " +
+			"Every statement of the job is then run as a query, so the " +
+			"rows it counts are the sum of the rows of its result sets; " +
+			"the rows changed by the statements of a stored procedure are " +
+			"not reported for queries by database/sql, and are not counted.",
+		Parse: func(v string, jp interface{}) (e error) {
+			jp.(*jobParser).j.AllResultSets, e = strconv.ParseBool(v)
+			return e
 		},
 	},
-	"rate": &goini.DecodeOption{Kind: goini.UniqueOption,
-		Usage: "The number of batches executed per second (default 0.0).",
+	"prepare": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Prepare each query of the job once per connection and " +
+			"execute the prepared statement with the args of each " +
+			"invocation, to benchmark the server-side prepared statement " +
+			"path (e.g. rather than interpolateParams for mysql). Not " +
+			"allowed with query templates, whose text differs for each " +
+			"invocation.",
+		Parse: func(v string, jp interface{}) (e error) {
+			jp.(*jobParser).j.Prepare, e = strconv.ParseBool(v)
+			return e
+		},
+	},
+	"explain-sample-rate": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Fraction of queries (e.g. 0.001) rerun with EXPLAIN ANALYZE " +
+			"on a separate connection with the same args. Note that EXPLAIN " +
+			"ANALYZE executes the query, including any writes.",
 		Parse: func(v string, jpi interface{}) (e error) {
 			jp := jpi.(*jobParser)
-			jp.j.Rate, e = strconv.ParseFloat(v, 64)
-			if e == nil && jp.j.Rate < 0 {
-				return errors.New("invalid negative value for rate")
+			jp.j.ExplainSampleRate, e = strconv.ParseFloat(v, 64)
+			if e == nil && (jp.j.ExplainSampleRate <= 0 || jp.j.ExplainSampleRate > 1) {
+				return errors.New("explain-sample-rate must be in (0, 1]")
 			}
 			return e
 		},
 	},
+	"explain-file": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Sampled plans will be written to this file as comma " +
+			"separated values <job name, start micros, elapsed micros, " +
+			"query, plan> instead of being logged.",
+		Parse: func(v string, jpi interface{}) (err error) {
+			jp := jpi.(*jobParser)
+			if !filepath.IsAbs(v) {
+				v = filepath.Join(jp.basedir, v)
+			}
+			jp.j.ExplainResults, err = NewSafeCSVWriter(v)
+			return err
+		},
+	},
 	"batch-size": &goini.DecodeOption{Kind: goini.UniqueOption,
 		Usage: "Number of jobs started during one batch (default 1).",
 		Parse: func(v string, jp interface{}) (e error) {
@@ -287,50 +1384,241 @@ var jobOptions = goini.DecodeOptionSet{
 	"multi-query-mode": &goini.DecodeOption{Kind: goini.UniqueOption,
 		Usage: "Set to 'multi-connection' to signal that the job will execute " +
 			"multiple queries, but it is safe for them to be on different " +
-			"connections.",
+			"connections, or to 'single-connection' to run the queries of " +
+			"each queue-depth worker on a connection of its own, so that " +
+			"they share its session state (e.g. SET, USE, or temporary tables).",
 		Parse: func(v string, jp interface{}) error {
 			if v == "multi-connection" {
 				jp.(*jobParser).multiQueryAllowed = true
 				return nil
+			} else if v == "single-connection" {
+				jp.(*jobParser).multiQueryAllowed = true
+				jp.(*jobParser).j.SingleConnection = true
+				return nil
 			} else {
 				return fmt.Errorf("invalid value for multi-query-mode: %s",
 					strconv.Quote(v))
 			}
 		},
 	},
+	"route-arg": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Run each invocation on the queue-depth worker that owns " +
+			"its key, this arg (from 1) of its first query, so that each " +
+			"range of keys (see route-range-size) is always run by the " +
+			"same worker. Keys that are not integers are hashed.",
+		Parse: func(v string, jp interface{}) (e error) {
+			jp.(*jobParser).j.RouteArg, e = strconv.Atoi(v)
+			if e == nil && jp.(*jobParser).j.RouteArg <= 0 {
+				return errors.New("route-arg must be positive")
+			}
+			return e
+		},
+	},
+	"route-range-size": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "The number of consecutive integer keys of a route-arg " +
+			"range, which are run by the same worker (1 by default).",
+		Parse: func(v string, jp interface{}) (e error) {
+			jp.(*jobParser).j.RouteRangeSize, e = strconv.ParseUint(v, 10, 64)
+			if e == nil && jp.(*jobParser).j.RouteRangeSize == 0 {
+				return errors.New("route-range-size must be positive")
+			}
+			return e
+		},
+	},
+	"connection-mode": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Set to 'new' to open a new connection for each invocation " +
+			"of the job, rather than use one of the pool, and report the " +
+			"connect latency separately from the query latency ('pool' " +
+			"by default).",
+		Parse: func(v string, jp interface{}) error {
+			switch v {
+			case "pool":
+				jp.(*jobParser).j.NewConnection = false
+			case "new":
+				jp.(*jobParser).j.NewConnection = true
+			default:
+				return fmt.Errorf("invalid value for connection-mode: %s",
+					strconv.Quote(v))
+			}
+			return nil
+		},
+	},
+	"query-log-max-lateness": &goini.DecodeOption{Kind: goini.UniqueOption,
+		Usage: "Drop (and count) replayed queries that cannot be issued " +
+			"within this duration of their time in the query-log-file.",
+		Parse: func(v string, jp interface{}) (e error) {
+			jp.(*jobParser).j.QueryLogLateness, e = time.ParseDuration(v)
+			return e
+		},
+	},
 	"query-log-file": &goini.DecodeOption{Kind: goini.UniqueOption,
 		Usage: "A flat text file containing a log file to replay instead of a " +
 			"normal job. The query log format is a series of newline " +
 			"delimited records containing a time in microseconds and a query " +
-			"separated by a comma. For example, '8644882534,select 1'.",
+			"separated by a comma. For example, '8644882534,select 1'. The " +
+			"time may be followed by a semicolon and base64 encoded JSON " +
+			"args, as written by -record-issued-queries.",
 		Parse: func(v string, jpi interface{}) (e error) {
 			jp := jpi.(*jobParser)
 			if !filepath.IsAbs(v) {
 				v = filepath.Join(jp.basedir, v)
 			}
+			jp.files = append(jp.files, v)
 			jp.j.QueryLog, e = os.Open(v)
 			return e
 		},
 	},
 }
 
-func decodeJobSection(df DatabaseFlavor, section goini.RawSection, basedir string, job *Job) error {
+// Translates the synthetic statements (e.g. {{sleep 10ms}}) of the queries of the job.
+func expandJobSyntheticStatements(df DatabaseFlavor, job *Job) error {
+	if err := expandSyntheticStatements(df, job.Queries); err != nil {
+		return err
+	} else if err := expandSyntheticStatements(df, job.FollowQueries); err != nil {
+		return err
+	}
+	for _, set := range job.QuerySets {
+		if err := expandSyntheticStatements(df, set.Queries); err != nil {
+			return fmt.Errorf("query-dir file %s: %v", strconv.Quote(set.Name), err)
+		}
+	}
+	return nil
+}
+
+func decodeJobSection(df DatabaseFlavor, section goini.RawSection, basedir string, job *Job, files *[]string) error {
 	jp := jobParser{j: job, df: df, basedir: basedir}
 
 	if err := jobOptions.Decode(section, &jp); err != nil {
 		return err
-	} else if len(job.Queries) == 0 && job.QueryLog == nil {
+	} else if err := expandJobSyntheticStatements(df, job); err != nil {
+		return err
+	} else if err := job.parseQueryTemplates(); err != nil {
+		return err
+	} else if job.Prepare && len(job.templates) > 0 {
+		// Each expansion would be prepared, and cached, as a statement of its own.
+		return errors.New("Cannot set prepare with query templates, bind the values as args instead")
+	} else if jp.qps > 0 && job.Rate > 0 {
+		return errors.New("Cannot set both rate and qps")
+	} else if job.RateRamp != nil && (job.Rate > 0 || jp.qps > 0) {
+		return errors.New("Cannot set rate-ramp with rate or qps")
+	} else if job.RateRamp != nil && job.ConcurrencyRamp != nil {
+		return errors.New("Cannot set both rate-ramp and concurrency-ramp")
+	} else if job.ConcurrencyRamp != nil && job.QueueDepth > 0 {
+		return errors.New("Cannot set concurrency-ramp with queue-depth")
+	} else if job.RateRamp != nil {
+		// The rate starts at the bottom of the ramp.
+		job.Rate = job.RateRamp.From
+	} else if job.ConcurrencyRamp != nil {
+		job.QueueDepth = uint64(job.ConcurrencyRamp.To)
+	} else if jp.qps > 0 {
+		// Each tick of the rate starts a batch of invocations.
+		batchSize := job.BatchSize
+		if batchSize == 0 {
+			batchSize = 1
+		}
+		job.Rate = jp.qps / float64(batchSize)
+	}
+
+	if len(job.Queries) == 0 && len(job.QuerySets) == 0 && job.QueryLog == nil {
 		return errors.New("no query provided")
 	} else if len(job.Queries) > 0 && job.QueryLog != nil {
 		return errors.New("cannot have both queries and a query log")
-	} else if len(job.Queries) > 1 && !jp.multiQueryAllowed {
+	} else if len(job.QuerySets) > 0 && (len(job.Queries) > 0 || job.QueryLog != nil) {
+		return errors.New("cannot have both a query-dir and queries or a query log")
+	} else if len(job.QuerySets) > 0 && jp.queryArgsFile != nil {
+		return errors.New("Cannot set query-args-file with query-dir")
+	} else if len(job.Queries) > 1 && !jp.multiQueryAllowed && !job.Transaction {
 		return fmt.Errorf("must have only one query")
 	} else if job.Rate == 0 && job.BatchSize > 0 {
 		return errors.New("can only specify batch-size with rate")
 	} else if jp.queryArgsDelim != 0 && jp.queryArgsFile == nil {
 		return errors.New("Cannot set query-args-delim with no query-args-file")
+	} else if jp.queryArgsDelim != 0 && jp.queryArgsJSON {
+		return errors.New("Cannot set query-args-delim with a JSON query-args-file")
+	} else if job.QueryArgsMode != "" && jp.queryArgsFile == nil {
+		return errors.New("Cannot set query-args-mode with no query-args-file")
+	} else if (job.QueryArgsMode == "loop" || job.QueryArgsMode == "random") && !jp.queryArgsRegular {
+		return errors.New("looping over or sampling the query-args-file requires a regular file")
+	} else if len(job.QueryArgsColumns) > 0 && jp.queryArgsFile == nil {
+		return errors.New("Cannot set query-args-columns with no query-args-file")
 	} else if jp.queryArgsFile != nil && job.QueryLog != nil {
 		return errors.New("Cannot use query-args-file with query-log-file")
+	} else if job.VerifyRepeatable && job.QueryResults != nil {
+		return errors.New("Cannot use verify-repeatable with query-results-file")
+	} else if job.RequireRows > 0 && job.RequireQuery == "" {
+		return errors.New("Cannot set require-rows with no require-query")
+	} else if (job.UtilizationQuery != "") != (job.TargetUtilization > 0) {
+		return errors.New("utilization-query and target-utilization must be used together")
+	} else if job.UtilizationQuery != "" && job.Rate == 0 {
+		return errors.New("can only specify utilization-query with rate")
+	} else if job.UtilizationQuery != "" && job.RateRamp != nil {
+		return errors.New("Cannot set both utilization-query and rate-ramp")
+	} else if job.LatencyTarget > 0 && (job.UtilizationQuery != "" || job.RateRamp != nil) {
+		return errors.New("Cannot set latency-target with utilization-query or rate-ramp")
+	} else if job.LatencyTarget > 0 && job.Rate == 0 {
+		return errors.New("can only specify latency-target with rate")
+	} else if job.OutlierCaptureQuery != "" && job.OutlierMultiple == 0 {
+		return errors.New("Cannot set outlier-capture-query with no outlier-multiple")
+	} else if job.QueryLogLateness > 0 && job.QueryLog == nil {
+		return errors.New("Cannot set query-log-max-lateness with no query-log-file")
+	} else if job.Start > 0 && !job.StartAt.IsZero() {
+		return errors.New("Cannot set both start and start-at")
+	} else if len(job.After) > 0 && !job.StartAt.IsZero() {
+		return errors.New("Cannot set both after and start-at")
+	} else if len(jp.queryResultsMasks) > 0 && job.QueryResults == nil {
+		return errors.New("Cannot set query-results-mask with no query-results-file")
+	} else if jp.maxRowsAction && job.MaxRows == 0 {
+		return errors.New("Cannot set max-rows-action with no max-rows")
+	} else if job.ExplainResults != nil && job.ExplainSampleRate == 0 {
+		return errors.New("Cannot set explain-file with no explain-sample-rate")
+	} else if len(jp.queryResultsEnc) > 0 && job.QueryResults == nil {
+		return errors.New("Cannot set query-results-encoding with no query-results-file")
+	} else if jp.resultSetIndex && job.QueryResults == nil {
+		return errors.New("Cannot set query-results-set-index with no query-results-file")
+	} else if len(job.QueryArgsEncodings) > 0 && jp.queryArgsFile == nil {
+		return errors.New("Cannot set query-args-encoding with no query-args-file")
+	} else if job.MaxRetries > 0 && len(df.RetryableErrorCodes()) == 0 {
+		return errors.New("Cannot set max-retries for a database flavor with no retryable error codes")
+	} else if job.FollowQueryMinRows > 0 && len(job.FollowQueries) == 0 {
+		return errors.New("Cannot set follow-query-min-rows with no follow-query")
+	} else if job.AlertIntervals > 0 && job.AlertP99 == 0 {
+		return errors.New("Cannot set alert-intervals with no alert-p99")
+	} else if job.HotRows > 0 && (jp.queryArgsFile != nil || job.QueryLog != nil) {
+		return errors.New("Cannot set hot-rows with a query-args-file or query-log-file")
+	} else if job.Transaction && job.QueryLog != nil {
+		return errors.New("Cannot use transaction with query-log-file")
+	} else if job.Transaction && job.VerifyRepeatable {
+		return errors.New("Cannot use transaction with verify-repeatable")
+	} else if jp.changesDatabase && !job.SingleConnection && !job.NewConnection {
+		return errors.New("queries cannot change database unless multi-query-mode is single-connection " +
+			"or connection-mode is new")
+	}
+
+	if job.AlertP99 > 0 && job.AlertIntervals == 0 {
+		job.AlertIntervals = defaultAlertIntervals
+	}
+
+	for _, set := range job.QuerySets {
+		if len(set.Queries) > 1 && !jp.multiQueryAllowed && !job.Transaction {
+			return fmt.Errorf("query-dir file %s must have only one query", strconv.Quote(set.Name))
+		}
+	}
+
+	if sq, ok := df.(*sqlDatabaseFlavor); ok && job.HotRows > 0 {
+		queries := append([]string(nil), job.Queries...)
+		for _, set := range job.QuerySets {
+			queries = append(queries, set.Queries...)
+		}
+		for _, q := range queries {
+			if n := sq.Placeholders(q); n != 1 {
+				return fmt.Errorf("query %s has %d placeholders but hot-rows "+
+					"binds a single row id", strconv.Quote(q), n)
+			}
+		}
+	}
+
+	if len(job.FollowQueries) > 0 && job.FollowQueryMinRows == 0 {
+		job.FollowQueryMinRows = 1
 	}
 
 	differentJobTypes := 0
@@ -356,13 +1644,153 @@ func decodeJobSection(df DatabaseFlavor, section goini.RawSection, basedir strin
 		job.BatchSize = 1
 	}
 
+	if job.JitterMax > 0 && job.QueueDepth == 0 {
+		return errors.New("can only specify jitter with queue-depth")
+	}
+	if job.SingleConnection && job.QueueDepth == 0 {
+		return errors.New("can only use multi-query-mode=single-connection with queue-depth")
+	}
+	if job.SingleConnection && job.NewConnection {
+		return errors.New("cannot use connection-mode=new with multi-query-mode=single-connection")
+	}
+	if job.RouteArg > 0 {
+		if job.QueueDepth == 0 || job.ConcurrencyRamp != nil {
+			return errors.New("can only use route-arg with queue-depth")
+		} else if jp.queryArgsFile == nil && job.HotRows == 0 {
+			return errors.New("route-arg requires the args of a query-args-file or hot-rows")
+		} else if job.HotRows > 0 && job.RouteArg > 1 {
+			return errors.New("hot-rows binds a single arg, so route-arg must be 1")
+		}
+		if job.RouteRangeSize == 0 {
+			job.RouteRangeSize = 1
+		}
+	} else if job.RouteRangeSize > 0 {
+		return errors.New("Cannot set route-range-size with no route-arg")
+	}
+
+	*files = append(*files, jp.files...)
+
+	if job.OutlierMultiple > 0 && job.OutlierCaptureQuery == "" {
+		if sq, ok := df.(*sqlDatabaseFlavor); ok {
+			job.OutlierCaptureQuery = defaultOutlierCaptureQueries[sq.name]
+		}
+		if job.OutlierCaptureQuery == "" {
+			return errors.New("no default outlier-capture-query for this database flavor")
+		}
+	}
+
+	if job.ExplainSampleRate > 0 {
+		if sq, ok := df.(*sqlDatabaseFlavor); ok {
+			job.ExplainPrefix = explainAnalyzePrefixes[sq.name]
+		}
+		if job.ExplainPrefix == "" {
+			return errors.New("explain-sample-rate is not supported for this database flavor")
+		}
+	}
+
+	if len(jp.queryResultsMasks) > 0 {
+		job.QueryResults.SetMasks(jp.queryResultsMasks)
+	}
+	if len(jp.queryResultsEnc) > 0 {
+		job.QueryResults.SetEncodings(jp.queryResultsEnc)
+	}
+	if jp.resultSetIndex {
+		job.QueryResults.SetResultSetIndex(true)
+	}
+
 	if jp.queryArgsFile != nil {
-		job.QueryArgs = csv.NewReader(jp.queryArgsFile)
-		if jp.queryArgsDelim != 0 {
-			job.QueryArgs.Comma = jp.queryArgsDelim
+		open := func(r io.Reader) QueryArgsReader {
+			return NewCSVArgsReader(r, jp.queryArgsDelim)
+		}
+		if jp.queryArgsJSON {
+			open = NewJSONArgsReader
+		}
+
+		switch {
+		case job.QueryArgsMode == "loop":
+			lar := newLoopingArgsReader(jp.queryArgsFile.(io.ReadSeeker), open)
+			lar.onRewind = job.argsRewound
+			job.QueryArgs = lar
+		case job.QueryArgsMode == "random":
+			rar, err := newRandomArgsReader(open(jp.queryArgsFile),
+				func(n int) int { return job.rand().Intn(n) })
+			if err != nil {
+				return fmt.Errorf("error reading query-args-file: %v", err)
+			}
+			job.QueryArgs = rar
+		default:
+			job.QueryArgs = open(jp.queryArgsFile)
+		}
+	}
+	if jp.queryArgsRegular {
+		return checkQueryArgsArity(df, job)
+	}
+
+	return nil
+}
+
+/*
+ * Verifies that every query of the job has as many placeholders as there
+ * are args in the first record of its query-args-file, so that mismatches
+ * fail before the run rather than on every invocation.
+ */
+func checkQueryArgsArity(df DatabaseFlavor, job *Job) error {
+	par := &peekingArgsReader{r: job.QueryArgs}
+	job.QueryArgs = par
+
+	args, err := par.Peek()
+	if err == io.EOF {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error reading query-args-file: %v", err)
+	}
+
+	for _, arg := range args {
+		if _, ok := arg.(sql.NamedArg); ok && !df.SupportsNamedArgs() {
+			return errors.New("query-args-file has named args, which the driver does not support")
+		} else if ok {
+			// Named args are matched by name, not by position.
+			return nil
+		}
+	}
+
+	arity := len(args)
+	if len(job.QueryArgsColumns) > 0 {
+		for _, column := range job.QueryArgsColumns {
+			if column >= len(args) {
+				return fmt.Errorf("query-args-columns has column %d but "+
+					"query-args-file has %d columns", column+1, len(args))
+			}
 		}
+		arity = len(job.QueryArgsColumns)
 	}
 
+	sq, ok := df.(*sqlDatabaseFlavor)
+	if !ok {
+		return nil
+	}
+	for _, q := range job.Queries {
+		if n := sq.Placeholders(q); n != arity {
+			return fmt.Errorf("query %s has %d placeholders but each "+
+				"query-args-file record has %d args", strconv.Quote(q), n, arity)
+		}
+	}
+	return nil
+}
+
+/*
+ * Rejects a job section named like an event, phase, or template section but
+ * with a space rather than a colon after the kind (e.g. [event ddl]), whose
+ * options would otherwise be reported as unexpected options of a job.
+ */
+func checkSectionKind(name string) error {
+	for _, prefix := range []string{eventSectionPrefix, phaseSectionPrefix, jobTemplateSectionPrefix} {
+		kind := strings.TrimSuffix(prefix, ":")
+		if rest := strings.TrimPrefix(name, kind+" "); rest != name {
+			return fmt.Errorf("section [%s] would be parsed as a job, did you mean [%s%s]?",
+				name, prefix, strings.TrimSpace(rest))
+		}
+	}
 	return nil
 }
 
@@ -370,14 +1798,25 @@ func decodeConfigJobs(df DatabaseFlavor, iniConfig *goini.RawConfig, basedir str
 	config.Jobs = make(map[string]*Job)
 	for _, name := range iniConfig.Sections() {
 		// Don't try to parse a reserved section as a job.
-		if name == "setup" || name == "teardown" || name == "global" {
+		if name == "setup" || name == "teardown" || name == "global" ||
+			name == "cache-flush" || name == "warmup" ||
+			strings.HasPrefix(name, eventSectionPrefix) ||
+			strings.HasPrefix(name, phaseSectionPrefix) ||
+			strings.HasPrefix(name, jobTemplateSectionPrefix) {
 			continue
 		}
-		section := iniConfig.Section(name)
+		if err := checkSectionKind(name); err != nil {
+			return err
+		}
+		section, err := resolveJobTemplate(iniConfig, iniConfig.Section(name), nil)
+		if err != nil {
+			return fmt.Errorf("Error parsing job %s: %v",
+				strconv.Quote(name), err)
+		}
 
 		job := new(Job)
 		job.Name = name
-		if err := decodeJobSection(df, section, basedir, job); err != nil {
+		if err := decodeJobSection(df, section, basedir, job, &config.Files); err != nil {
 			return fmt.Errorf("Error parsing job %s: %v",
 				strconv.Quote(name), err)
 		}
@@ -394,29 +1833,140 @@ func parseIniConfig(df DatabaseFlavor, iniConfig *goini.RawConfig, basedir strin
 	if err := decodeGlobalSection(df, iniConfig.GlobalSection, config); err != nil {
 		return nil, fmt.Errorf("Error parsing global section: %v", err)
 	}
-	if err := decodeSetupSection(df, iniConfig.Section("setup"), basedir, &config.Setup); err != nil {
+	if err := decodeSetupSection(df, iniConfig.Section("setup"), basedir, &config.Setup, &config.SetupScripts, &config.Files); err != nil {
 		return nil, fmt.Errorf("Error parsing setup section: %v", err)
 	}
-	if err := decodeSetupSection(df, iniConfig.Section("teardown"), basedir, &config.Teardown); err != nil {
+	if err := decodeWarmupSection(df, iniConfig.Section("warmup"), basedir, config); err != nil {
+		return nil, fmt.Errorf("Error parsing warmup section: %v", err)
+	}
+	if err := decodeSetupSection(df, iniConfig.Section("teardown"), basedir, &config.Teardown, &config.TeardownScripts, &config.Files); err != nil {
 		return nil, fmt.Errorf("Error parsing teardown section: %v", err)
 	}
+	if err := decodeSetupSection(df, iniConfig.Section("cache-flush"), basedir, &config.CacheFlush, &config.CacheFlushScripts, &config.Files); err != nil {
+		return nil, fmt.Errorf("Error parsing cache-flush section: %v", err)
+	}
 	if err := decodeConfigJobs(df, iniConfig, basedir, config); err != nil {
 		return nil, err
 	}
+	if err := decodeConfigEvents(df, iniConfig, config); err != nil {
+		return nil, err
+	}
+	if err := decodeConfigPhases(df, iniConfig, config); err != nil {
+		return nil, err
+	}
+	if (config.CompareRounds > 0) != (config.CompareWindow > 0) {
+		return nil, errors.New("compare-rounds and compare-window must be used together")
+	}
+	if len(config.CooldownSamples) > 0 && config.Cooldown == 0 {
+		return nil, errors.New("cooldown-sample-query requires cooldown")
+	}
+	if (len(config.CacheFlush) > 0 || len(config.CacheFlushScripts) > 0) && !config.CacheComparison {
+		return nil, errors.New("cache-flush section requires cache-comparison")
+	}
+	if config.MaxDuration > 0 && config.MinDuration > config.MaxDuration {
+		return nil, errors.New("min-duration cannot be greater than max-duration")
+	}
 
+	// With phases, the jobs are checked against the duration of each of
+	// their phases instead.
+	duration := config.Duration
+	if len(config.Phases) > 0 {
+		duration = 0
+	}
 	for name, job := range config.Jobs {
-		if config.Duration > 0 && job.Start > config.Duration {
+		if job.Warmup == 0 {
+			job.Warmup = config.WarmupDuration
+		}
+		if job.MaxErrors == 0 {
+			job.MaxErrors = config.MaxErrors
+		}
+		if job.MaxErrorRate == 0 {
+			job.MaxErrorRate = config.MaxErrorRate
+		}
+		if job.QueryTimeout == 0 {
+			job.QueryTimeout = config.QueryTimeout
+		}
+		job.AcceptedErrorMessages = config.AcceptedErrorMessages
+
+		if duration > 0 && job.Start > duration {
 			return nil, fmt.Errorf("job %s starts after test finishes.",
 				strconv.Quote(name))
-		} else if job.Stop > 0 && config.Duration > 0 && job.Stop > config.Duration {
+		} else if job.Stop > 0 && duration > 0 && job.Stop > duration {
 			return nil, fmt.Errorf("job %s stops after test finishes.",
 				strconv.Quote(name))
+		} else if job.Warmup > 0 && ((duration > 0 && job.Start+job.Warmup >= duration) ||
+			(job.Stop > 0 && job.Start+job.Warmup >= job.Stop)) {
+			return nil, fmt.Errorf("job %s warms up until after it stops.",
+				strconv.Quote(name))
+		} else if (config.CacheComparison || config.CompareRounds > 0) &&
+			(job.QueryLog != nil || job.QueryArgs != nil) {
+			return nil, fmt.Errorf("job %s cannot be run repeatedly for a comparison "+
+				"since it reads a query-log-file or query-args-file",
+				strconv.Quote(name))
 		}
 	}
+	if err := checkConfigPhases(config); err != nil {
+		return nil, err
+	}
+	if err := checkJobDependencies(config); err != nil {
+		return nil, err
+	}
 
 	return config, nil
 }
 
+/*
+ * Checks that each job runs after jobs that exist (and, with phases, run in
+ * each of its phases), and that no job ends up waiting for itself.
+ */
+func checkJobDependencies(config *Config) error {
+	for name, job := range config.Jobs {
+		for _, after := range job.After {
+			dependency, ok := config.Jobs[after]
+			if !ok {
+				return fmt.Errorf("job %s runs after job %s, which does not exist",
+					strconv.Quote(name), strconv.Quote(after))
+			}
+			for _, phase := range job.Phases {
+				if !dependency.inPhase(phase) {
+					return fmt.Errorf("job %s runs after job %s, which is not in phase %s",
+						strconv.Quote(name), strconv.Quote(after), strconv.Quote(phase))
+				}
+			}
+		}
+	}
+
+	// Jobs whose dependencies all finish, found by repeatedly removing jobs
+	// with no dependencies left; any jobs that remain are in a cycle.
+	finishes := make(map[string]bool, len(config.Jobs))
+	for progress := true; progress; {
+		progress = false
+		for name, job := range config.Jobs {
+			if finishes[name] {
+				continue
+			}
+			ready := true
+			for _, after := range job.After {
+				ready = ready && finishes[after]
+			}
+			if ready {
+				finishes[name], progress = true, true
+			}
+		}
+	}
+	var cycle []string
+	for name := range config.Jobs {
+		if !finishes[name] {
+			cycle = append(cycle, strconv.Quote(name))
+		}
+	}
+	if len(cycle) > 0 {
+		sort.Strings(cycle)
+		return fmt.Errorf("jobs %s can never start, since their after options form a cycle", strings.Join(cycle, ", "))
+	}
+	return nil
+}
+
 func parseConfig(df DatabaseFlavor, configFile string, baseDir string) (*Config, error) {
 	cp := goini.NewRawConfigParser()
 	cp.ParseFile(configFile)
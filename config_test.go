@@ -17,14 +17,19 @@
 package main
 
 import (
+	"errors"
+	"io/ioutil"
+	"os"
 	"path/filepath"
 	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/awreece/goini"
+	"github.com/go-sql-driver/mysql"
 )
 
 func TestExamplesParse(t *testing.T) {
@@ -66,6 +71,51 @@ func TestReadQueries(t *testing.T) {
 	}
 }
 
+func TestReadScript(t *testing.T) {
+	df := supportedDatabaseFlavors["mysql"]
+	statements, err := readScriptFromReader(df, strings.NewReader("use db;\nset x = 1;\n\nselect 1;\n"))
+	if err != nil {
+		t.Fatalf("Error reading script: %v", err)
+	}
+	expected := []string{"use db", "\nset x = 1", "\n\nselect 1"}
+	if !reflect.DeepEqual(statements, expected) {
+		t.Errorf("Failure reading script:\ngot\t\t%v\nbut expected\t%v",
+			quotedValue(statements), quotedValue(expected))
+	}
+
+	if _, err := readScriptFromReader(df, strings.NewReader(" ;\n;")); err != EmptyQueryError {
+		t.Errorf("Expected EmptyQueryError for an empty script but got %v", err)
+	}
+}
+
+func TestReadQuerySets(t *testing.T) {
+	df := supportedDatabaseFlavors["mysql"]
+	sets, files, err := readQuerySetsFromDir(df, "examples/query_dir")
+	if err != nil {
+		t.Fatalf("Error reading query dir: %v", err)
+	}
+	expected := []QuerySet{
+		{"count.sql", []string{"select count(*) from t"}, 1},
+		{"point_lookup@8.sql", []string{"select * from t where id = 1"}, 8},
+		{"update.sql", []string{"update t set a = a + 1 where id = 1"}, 2},
+	}
+	if !reflect.DeepEqual(sets, expected) {
+		t.Errorf("Failure reading query dir:\ngot\t\t%v\nbut expected\t%v", sets, expected)
+	}
+	if len(files) != 4 {
+		t.Errorf("Expected the manifest and 3 query files but got %v", files)
+	}
+
+	dir, err := ioutil.TempDir("", "query-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if _, _, err := readQuerySetsFromDir(df, dir); err == nil {
+		t.Errorf("Unexpected success reading a directory with no .sql files")
+	}
+}
+
 func TestParseIniConfig(t *testing.T) {
 	var goodCases = []struct {
 		in  string
@@ -159,6 +209,132 @@ func TestParseIniConfig(t *testing.T) {
 				},
 			},
 		},
+		{`
+			[warmup]
+			query=select * from t
+			query=select count(*) from t
+			concurrency=2
+
+			[count]
+			query=count(*) from t
+			`,
+			&Config{
+				Flavor:            supportedDatabaseFlavors["mysql"],
+				Warmup:            []string{"select * from t", "select count(*) from t"},
+				WarmupConcurrency: 2,
+				Jobs: map[string]*Job{
+					"count": &Job{
+						Name: "count", QueueDepth: 1,
+						Queries: []string{"count(*) from t"},
+					},
+				},
+			},
+		},
+		{`
+			[poll]
+			query=select id from queue limit 1
+			follow-query=delete from queue limit 1
+			`,
+			&Config{
+				Flavor: supportedDatabaseFlavors["mysql"],
+				Jobs: map[string]*Job{
+					"poll": &Job{
+						Name: "poll", QueueDepth: 1,
+						Queries:            []string{"select id from queue limit 1"},
+						FollowQueries:      []string{"delete from queue limit 1"},
+						FollowQueryMinRows: 1,
+					},
+				},
+			},
+		},
+		{`
+			[transfer]
+			query=update accounts set balance = balance - 1 where id = 1
+			query=update accounts set balance = balance + 1 where id = 2
+			transaction=true
+			`,
+			&Config{
+				Flavor: supportedDatabaseFlavors["mysql"],
+				Jobs: map[string]*Job{
+					"transfer": &Job{
+						Name: "transfer", QueueDepth: 1, Transaction: true,
+						Queries: []string{
+							"update accounts set balance = balance - 1 where id = 1",
+							"update accounts set balance = balance + 1 where id = 2",
+						},
+					},
+				},
+			},
+		},
+		{`
+			[session]
+			query=use reporting
+			query=set @limit = 10
+			query=select * from t limit ?
+			multi-query-mode=single-connection
+			queue-depth=4
+			`,
+			&Config{
+				Flavor: supportedDatabaseFlavors["mysql"],
+				Jobs: map[string]*Job{
+					"session": &Job{
+						Name: "session", QueueDepth: 4, SingleConnection: true,
+						Queries: []string{"use reporting", "set @limit = 10", "select * from t limit ?"},
+					},
+				},
+			},
+		},
+		{`
+			[sharded]
+			query=select * from t where id = ?
+			hot-rows=1000
+			route-arg=1
+			route-range-size=100
+			queue-depth=4
+			`,
+			&Config{
+				Flavor: supportedDatabaseFlavors["mysql"],
+				Jobs: map[string]*Job{
+					"sharded": &Job{
+						Name: "sharded", QueueDepth: 4, HotRows: 1000, RouteArg: 1, RouteRangeSize: 100,
+						Queries: []string{"select * from t where id = ?"},
+					},
+				},
+			},
+		},
+		{`
+			[connection storm]
+			query=use reporting
+			query=select 1
+			multi-query-mode=multi-connection
+			connection-mode=new
+			`,
+			&Config{
+				Flavor: supportedDatabaseFlavors["mysql"],
+				Jobs: map[string]*Job{
+					"connection storm": &Job{
+						Name: "connection storm", QueueDepth: 1, NewConnection: true,
+						Queries: []string{"use reporting", "select 1"},
+					},
+				},
+			},
+		},
+		{`
+			[prototype]
+			query={{sleep 10ms}}
+			query=select 1
+			multi-query-mode=multi-connection
+			`,
+			&Config{
+				Flavor: supportedDatabaseFlavors["mysql"],
+				Jobs: map[string]*Job{
+					"prototype": &Job{
+						Name: "prototype", QueueDepth: 1,
+						Queries: []string{"SELECT SLEEP(0.01)", "select 1"},
+					},
+				},
+			},
+		},
 		{
 			`
 			[run 2 queries at a time for 10 seconds, starting at 5s]
@@ -223,10 +399,526 @@ func TestParseIniConfig(t *testing.T) {
 				},
 			},
 		},
+		{
+			`
+			[test job]
+			query=select 1+1
+			require-query=select count(*) from t
+			require-rows=1000000
+			`,
+			&Config{
+				Flavor: supportedDatabaseFlavors["mysql"],
+				Jobs: map[string]*Job{
+					"test job": &Job{
+						Name: "test job", QueueDepth: 1,
+						Queries:      []string{"select 1+1"},
+						RequireQuery: "select count(*) from t",
+						RequireRows:  1000000,
+					},
+				},
+			},
+		},
+		{
+			`
+			error=class=deadlock
+			error=class=duplicate-key
+
+			[test job]
+			query=select 1+1
+			`,
+			&Config{
+				Flavor: supportedDatabaseFlavors["mysql"],
+				Jobs: map[string]*Job{
+					"test job": &Job{
+						Name: "test job", QueueDepth: 1,
+						Queries: []string{"select 1+1"},
+					},
+				},
+				AcceptedErrors: Set{
+					"1213": struct{}{},
+					"1062": struct{}{},
+					"1586": struct{}{},
+				},
+			},
+		},
+		{
+			`
+			cache-comparison=true
+
+			[cache-flush]
+			query=select 1
+
+			[test job]
+			query=select 1+1
+			`,
+			&Config{
+				Flavor:          supportedDatabaseFlavors["mysql"],
+				CacheComparison: true,
+				CacheFlush:      []string{"select 1"},
+				Jobs: map[string]*Job{
+					"test job": &Job{
+						Name: "test job", QueueDepth: 1,
+						Queries: []string{"select 1+1"},
+					},
+				},
+			},
+		},
+		{
+			`
+			[test job]
+			query=select * from t order by id
+			verify-repeatable=true
+			`,
+			&Config{
+				Flavor: supportedDatabaseFlavors["mysql"],
+				Jobs: map[string]*Job{
+					"test job": &Job{
+						Name: "test job", QueueDepth: 1,
+						Queries:          []string{"select * from t order by id"},
+						VerifyRepeatable: true,
+					},
+				},
+			},
+		},
+		{
+			`
+			[test job]
+			query=select 1+1
+			outlier-multiple=10
+			`,
+			&Config{
+				Flavor: supportedDatabaseFlavors["mysql"],
+				Jobs: map[string]*Job{
+					"test job": &Job{
+						Name: "test job", QueueDepth: 1,
+						Queries:             []string{"select 1+1"},
+						OutlierMultiple:     10,
+						OutlierCaptureQuery: "show full processlist",
+					},
+				},
+			},
+		},
+		{
+			`
+			warmup=30s
+
+			[cold job]
+			query=select 1+1
+
+			[warm job]
+			query=select 1+1
+			warmup=5s
+			`,
+			&Config{
+				Flavor:         supportedDatabaseFlavors["mysql"],
+				WarmupDuration: 30 * time.Second,
+				Jobs: map[string]*Job{
+					"cold job": &Job{
+						Name: "cold job", QueueDepth: 1,
+						Queries: []string{"select 1+1"},
+						Warmup:  30 * time.Second,
+					},
+					"warm job": &Job{
+						Name: "warm job", QueueDepth: 1,
+						Queries: []string{"select 1+1"},
+						Warmup:  5 * time.Second,
+					},
+				},
+			},
+		},
+		{
+			`
+			[template:select]
+			query=select 1+1
+			query=select 2+2
+			count=10
+
+			[template:point_select]
+			extends=select
+			query=select * from t where id = ?
+			rate=10
+
+			[slow]
+			extends=point_select
+
+			[fast]
+			extends=point_select
+			rate=100
+			`,
+			&Config{
+				Flavor: supportedDatabaseFlavors["mysql"],
+				Jobs: map[string]*Job{
+					"slow": &Job{
+						Name: "slow", Rate: 10, BatchSize: 1, Count: 10,
+						Queries: []string{"select * from t where id = ?"},
+					},
+					"fast": &Job{
+						Name: "fast", Rate: 100, BatchSize: 1, Count: 10,
+						Queries: []string{"select * from t where id = ?"},
+					},
+				},
+			},
+		},
+		{
+			`
+			max-errors=100
+			max-error-rate=5%
+			query-timeout=30s
+
+			[default job]
+			query=select 1+1
+
+			[strict job]
+			query=select 1+1
+			max-errors=10
+			max-error-rate=0.5
+			query-timeout=100ms
+			`,
+			&Config{
+				Flavor:       supportedDatabaseFlavors["mysql"],
+				MaxErrors:    100,
+				MaxErrorRate: 5,
+				QueryTimeout: 30 * time.Second,
+				Jobs: map[string]*Job{
+					"default job": &Job{
+						Name: "default job", QueueDepth: 1,
+						Queries:      []string{"select 1+1"},
+						MaxErrors:    100,
+						MaxErrorRate: 5,
+						QueryTimeout: 30 * time.Second,
+					},
+					"strict job": &Job{
+						Name: "strict job", QueueDepth: 1,
+						Queries:      []string{"select 1+1"},
+						MaxErrors:    10,
+						MaxErrorRate: 0.5,
+						QueryTimeout: 100 * time.Millisecond,
+					},
+				},
+			},
+		},
+		{
+			`
+			[rate job]
+			query=select 1+1
+			rate-ramp=10..1000 over 5m
+
+			[concurrency job]
+			query=select 1+1
+			concurrency-ramp=1..8 over 1m in 4 steps
+			`,
+			&Config{
+				Flavor: supportedDatabaseFlavors["mysql"],
+				Jobs: map[string]*Job{
+					"rate job": &Job{
+						Name: "rate job", Rate: 10, BatchSize: 1,
+						Queries:  []string{"select 1+1"},
+						RateRamp: &Ramp{From: 10, To: 1000, Over: 5 * time.Minute},
+					},
+					"concurrency job": &Job{
+						Name: "concurrency job", QueueDepth: 8,
+						Queries:         []string{"select 1+1"},
+						ConcurrencyRamp: &Ramp{From: 1, To: 8, Over: time.Minute, Steps: 4},
+					},
+				},
+			},
+		},
+		{
+			`
+			duration=10s
+
+			[phase:load]
+			setup=select 1
+			teardown=select 2
+
+			[phase:read]
+			duration=1m
+
+			[inserts]
+			query=select 1+1
+			phase=load
+			phase=read
+
+			[reads]
+			query=select 1+1
+			phase=read
+			stop=30s
+			`,
+			&Config{
+				Flavor:   supportedDatabaseFlavors["mysql"],
+				Duration: 10 * time.Second,
+				Phases: []*Phase{
+					{Name: "load", Duration: 10 * time.Second, Setup: []string{"select 1"}, Teardown: []string{"select 2"}},
+					{Name: "read", Duration: time.Minute},
+				},
+				Jobs: map[string]*Job{
+					"inserts": &Job{
+						Name: "inserts", QueueDepth: 1,
+						Queries: []string{"select 1+1"},
+						Phases:  []string{"load", "read"},
+					},
+					"reads": &Job{
+						Name: "reads", QueueDepth: 1,
+						Queries: []string{"select 1+1"},
+						Phases:  []string{"read"},
+						Stop:    30 * time.Second,
+					},
+				},
+			},
+		},
+		{
+			`
+			overload-query=show status like 'Threads_running'
+			overload-threshold=64
+
+			[test job]
+			query=select 1+1
+			`,
+			&Config{
+				Flavor:            supportedDatabaseFlavors["mysql"],
+				OverloadQuery:     "show status like 'Threads_running'",
+				OverloadThreshold: 64,
+				OverloadResume:    64,
+				Jobs: map[string]*Job{
+					"test job": &Job{
+						Name: "test job", QueueDepth: 1,
+						Queries: []string{"select 1+1"},
+					},
+				},
+			},
+		},
+		{
+			`
+			[load]
+			query=select 1+1
+			count=100
+
+			[test job]
+			query=select 1+1
+			after=load
+			stop=10s
+			`,
+			&Config{
+				Flavor: supportedDatabaseFlavors["mysql"],
+				Jobs: map[string]*Job{
+					"load": &Job{
+						Name: "load", QueueDepth: 1, Count: 100,
+						Queries: []string{"select 1+1"},
+					},
+					"test job": &Job{
+						Name: "test job", QueueDepth: 1,
+						Queries: []string{"select 1+1"},
+						After:   []string{"load"},
+						Stop:    10 * time.Second,
+					},
+				},
+			},
+		},
+		{
+			`
+			cost-per-query=0.01
+			cost-per-second=2
+
+			[test job]
+			query=select 1+1
+			`,
+			&Config{
+				Flavor:    supportedDatabaseFlavors["mysql"],
+				CostModel: &LinearCostModel{PerQuery: 0.01, PerSecond: 2},
+				Jobs: map[string]*Job{
+					"test job": &Job{
+						Name: "test job", QueueDepth: 1,
+						Queries: []string{"select 1+1"},
+					},
+				},
+			},
+		},
+		{
+			`
+			[test job]
+			query=select 1+1
+			queue-depth=4
+			jitter=1ms..20ms
+			`,
+			&Config{
+				Flavor: supportedDatabaseFlavors["mysql"],
+				Jobs: map[string]*Job{
+					"test job": &Job{
+						Name: "test job", QueueDepth: 4,
+						Queries:   []string{"select 1+1"},
+						JitterMin: time.Millisecond,
+						JitterMax: 20 * time.Millisecond,
+					},
+				},
+			},
+		},
+		{
+			`
+			[test job]
+			query=select 1+1
+			qps=10
+			batch-size=2
+			`,
+			&Config{
+				Flavor: supportedDatabaseFlavors["mysql"],
+				Jobs: map[string]*Job{
+					"test job": &Job{
+						Name: "test job", Rate: 5, BatchSize: 2,
+						Queries: []string{"select 1+1"},
+					},
+				},
+			},
+		},
+		{
+			`
+			[monitor]
+			query=select 1
+			report=false
+			`,
+			&Config{
+				Flavor: supportedDatabaseFlavors["mysql"],
+				Jobs: map[string]*Job{
+					"monitor": &Job{
+						Name: "monitor", QueueDepth: 1,
+						Queries:               []string{"select 1"},
+						HideIntermediateStats: true,
+					},
+				},
+			},
+		},
+		{
+			`
+			[test job]
+			query=select 1+1
+			explain-sample-rate=0.01
+			`,
+			&Config{
+				Flavor: supportedDatabaseFlavors["mysql"],
+				Jobs: map[string]*Job{
+					"test job": &Job{
+						Name: "test job", QueueDepth: 1,
+						Queries:           []string{"select 1+1"},
+						ExplainSampleRate: 0.01,
+						ExplainPrefix:     "explain analyze ",
+					},
+				},
+			},
+		},
+		{
+			`
+			[test job]
+			query=select 1+1
+
+			[event:index]
+			start=10s
+			query=create index i on t (a)
+			`,
+			&Config{
+				Flavor: supportedDatabaseFlavors["mysql"],
+				Jobs: map[string]*Job{
+					"test job": &Job{
+						Name: "test job", QueueDepth: 1,
+						Queries: []string{"select 1+1"},
+					},
+				},
+				Events: []*Event{
+					&Event{
+						Name: "index", Start: 10 * time.Second,
+						Queries: []string{"create index i on t (a)"},
+					},
+				},
+			},
+		},
 	}
 
 	var badCases = []string{
 		"[test]\nrate=1",
+		"[cache-flush]\nquery=select 1\n[test]\nquery=select 1",
+		"[test]\nquery=select 1\noutlier-capture-query=show processlist",
+		"[test]\nquery=select 1\n[event:backup]\nstart=1s",
+		"[test]\nquery=select 1\n[event backup]\nstart=1s\nquery=select 1",
+		"[test]\nquery=select ?\nquery-args-columns=2,1",
+		"[test]\nquery=select {{rand_int 1 10}}\nprepare=true",
+		"[test]\nquery=select ?\nquery-args-encoding=hex",
+		"[test]\nquery=select 1\nexplain-sample-rate=2",
+		"[test]\nquery=select 1\nrate=1\njitter=5ms",
+		"[test]\nquery=select 1\njitter=5ms..1ms",
+		"min-duration=2s\nmax-duration=1s\n[test]\nquery=select 1",
+		"[test]\nquery=select ?, ?\nquery-args-file=examples/data_file_names.csv",
+		"[test]\nquery=select ?\nquery-args-mode=loop",
+		"[test]\nquery=select 1\nlog-file=examples/missing/test.log",
+		"duration=10s\nwarmup=10s\n[test]\nquery=select 1",
+		"[test]\nquery=select 1\nstart=5s\nstop=10s\nwarmup=5s",
+		"[test]\nquery=select ?\nquery-args-mode=error",
+		"[test]\nquery=select ?\nquery-args-file=examples/data_file_names.csv\nquery-args-mode=forever",
+		"[test]\nquery=select 1\nquery-results-encoding=base32",
+		"[setup]\nscript-file=examples/missing.sql\n[test]\nquery=select 1",
+		"[test]\nquery=select 1\nquery-results-set-index=true",
+		"[test]\nquery=select 1\nrate=1\nqps=1",
+		"[test]\nquery=select 1\nqps=0",
+		"[test]\nquery=select 1\nlatency-target=20ms",
+		"[test]\nquery=select 1\nafter=load",
+		"[a]\nquery=select 1\nafter=b\n[b]\nquery=select 1\nafter=a",
+		"[phase:p]\n[phase:q]\n[load]\nquery=select 1\nphase=p\n[test]\nquery=select 1\nphase=q\nafter=load",
+		"overload-query=select 1\n[test]\nquery=select 1",
+		"overload-query=select 1\noverload-threshold=5\noverload-resume=6\n[test]\nquery=select 1",
+		"overload-resume=6\n[test]\nquery=select 1",
+		"[test]\nquery=select 1\nphase=load",
+		"[phase:load]\n[test]\nquery=select 1",
+		"[phase:load]\n[test]\nquery=select 1\nphase=read",
+		"[phase:load]\n[phase:read]\n[test]\nquery=select 1\nphase=load",
+		"[phase:load]\nduration=10s\n[test]\nquery=select 1\nphase=load\nstop=20s",
+		"cache-comparison=true\n[phase:load]\n[test]\nquery=select 1\nphase=load",
+		"[phase:load]\n[event:backup]\nquery=select 1\n[test]\nquery=select 1\nphase=load",
+		"[test]\nquery=select 1\nrate=5\nlatency-target=0s",
+		"[test]\nquery=select 1\nrate-ramp=10..100 over 1m\nlatency-target=20ms",
+		"[test]\nquery=select 1\nrate=5\nrate-ramp=10..100 over 1m",
+		"[test]\nquery=select 1\nrate-ramp=0..100 over 1m",
+		"[test]\nquery=select 1\nrate-ramp=10..100 in 1m",
+		"[test]\nquery=select 1\nrate-ramp=10..100 over 1m in 1 steps",
+		"[test]\nquery=select 1\nqueue-depth=4\nconcurrency-ramp=1..8 over 1m",
+		"[test]\nquery=select 1\nconcurrency-ramp=8..1 over 1m",
+		"[test]\nquery=select 1\nconcurrency-ramp=1..2.5 over 1m",
+		"[test]\nquery=select 1\nrate-ramp=1..2 over 1m\nconcurrency-ramp=1..2 over 1m",
+		"[warmup]\nquery=select 1\nconcurrency=0\n[test]\nquery=select 1",
+		"[test]\nquery=select 1\nhot-rows=4",
+		"[test]\nquery=select ?\nhot-rows=0",
+		"[test]\nquery=select 1\nfollow-query-min-rows=2",
+		"[test]\nquery=select 1\nalert-intervals=5",
+		"[test]\nquery=select 1\nquery-dir=examples/query_dir",
+		"[test]\nquery-dir=examples/missing",
+		"[test]\nquery=select 1\nalert-p99=0s",
+		"[test]\nquery=select 1\nfollow-query=select 2\nfollow-query-min-rows=0",
+		"[test]\nquery=select 1\ntransaction=yes please",
+		"[test]\nquery=select 1\ntransaction=true\nverify-repeatable=true",
+		"[test]\nquery-log-file=examples/query.log\ntransaction=true",
+		"[test]\nquery=begin\nquery=select 1\ntransaction=true",
+		"[test]\nquery=use db\nquery=select 1\nmulti-query-mode=multi-connection",
+		"[test]\nquery={{burn_cpu 5ms}}",
+		"[test]\nquery=select {{nap 10}}",
+		"[test]\nquery=select 1\nfollow-query={{sleep forever}}",
+		"[test]\nquery=select 1\nfollow-query=use db",
+		"[test]\nquery=use db\nmulti-query-mode=single-connection\nrate=10",
+		"error=class=overheating\n[test]\nquery=select 1",
+		"[test]\nquery=select 1\nrequire-rows=10",
+		"[test]\nquery=select 1\nrequire-query=select 1\nrequire-rows=0",
+		"[test]\nquery=select 1\nmax-errors=0",
+		"[test]\nquery=select 1\nextends=missing",
+		"[test]\nquery=select 1\nquery-timeout=0s",
+		"query-timeout=soon\n[test]\nquery=select 1",
+		"[template:a]\nextends=b\n[template:b]\nextends=a\n[test]\nquery=select 1\nextends=a",
+		"[template:a]\nquery=select 1\n[test]\nextends=a\nextends=a",
+		"[test]\nquery=select 1\nmax-error-rate=0%",
+		"[test]\nquery=select 1\nconnection-mode=sometimes",
+		"[test]\nquery=select ?\nhot-rows=10\nroute-arg=0",
+		"[test]\nquery=select 1\nroute-arg=1",
+		"[test]\nquery=select ?\nhot-rows=10\nroute-arg=2",
+		"[test]\nquery=select ?\nhot-rows=10\nroute-range-size=10",
+		"[test]\nquery=select ?\nhot-rows=10\nroute-arg=1\nrate=10",
+		"[test]\nquery=select 1\nmulti-query-mode=single-connection\nqueue-depth=2\nconnection-mode=new",
+		"[test]\nquery=select 1\nmax-error-rate=150%",
+		"max-error-rate=lots\n[test]\nquery=select 1",
+		"error=message=(\n[test]\nquery=select 1",
 	}
 
 	df := supportedDatabaseFlavors["mysql"]
@@ -266,3 +958,176 @@ func TestParseIniConfig(t *testing.T) {
 		}
 	}
 }
+
+func TestNamedQueryArgs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dbbench")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "args.jsonl"), []byte(`{"id": 1}`+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for driver, ok := range map[string]bool{"mysql": false, "postgres": false, "mssql": true} {
+		cp := goini.NewRawConfigParser()
+		cp.Parse(strings.NewReader("[test]\nquery=select @id\nquery-args-file=args.jsonl"))
+		iniConfig, err := cp.Finish()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = parseIniConfig(supportedDatabaseFlavors[driver], iniConfig, dir)
+		if ok && err != nil {
+			t.Errorf("Unexpected error for named args with %s: %v", driver, err)
+		} else if !ok && err == nil {
+			t.Errorf("Expected an error for named args with %s", driver)
+		}
+	}
+}
+
+func TestLintConfig(t *testing.T) {
+	var cases = []struct {
+		in       string
+		driver   string
+		warnings int
+	}{
+		{"[test]\nquery=select 1", "mysql", 0},
+		{"[test]\nquery=select 1\nrate=1\nbatch-size=5000", "mysql", 1},
+		{"[test]\nquery=select 1\nstart=5s\nstop=5s", "mysql", 1},
+		{"error=1205\n[test]\nquery=select 1", "mysql", 0},
+		{"error=1205\n[test]\nquery=select 1", "vertica", 1},
+		// Messages are matched without error codes.
+		{"error=message=timeout\n[test]\nquery=select 1", "vertica", 0},
+		{"error=timeout\n[test]\nquery=select 1\nquery-timeout=1s", "vertica", 0},
+		// Never raised without a job that sets the option.
+		{"error=timeout\n[test]\nquery=select 1", "mysql", 1},
+		{"error=max-rows\n[test]\nquery=select 1\nmax-rows=10", "mysql", 0},
+		{"error=max-rows\n[test]\nquery=select 1\nmax-rows=10\nmax-rows-action=truncate", "mysql", 1},
+		{"error=connect\n[test]\nquery=select 1", "vertica", 0},
+		// Raised by -simulate-errors.
+		{"error=1\n[test]\nquery=select 1", "fake", 0},
+	}
+
+	defer func(old SimulatedErrorsFlagValue) { simulatedErrors = old }(simulatedErrors)
+	simulatedErrors.Set("1:0.5")
+
+	for _, c := range cases {
+		cp := goini.NewRawConfigParser()
+		cp.Parse(strings.NewReader(c.in))
+		iniConfig, err := cp.Finish()
+		if err != nil {
+			t.Errorf("Error parsing config %s: %v", strconv.Quote(c.in), err)
+			continue
+		}
+
+		config, err := parseIniConfig(supportedDatabaseFlavors[c.driver], iniConfig, ".")
+		if err != nil {
+			t.Errorf("Error parsing ini config %s: %v", strconv.Quote(c.in), err)
+			continue
+		}
+
+		if warnings := lintConfig(config); len(warnings) != c.warnings {
+			t.Errorf("Expected %d warnings for %s but got %v",
+				c.warnings, strconv.Quote(c.in), quotedValue(warnings))
+		}
+	}
+
+	defer func(old bool) { *traceComments = old }(*traceComments)
+	*traceComments = true
+	cp := goini.NewRawConfigParser()
+	cp.Parse(strings.NewReader("[test]\nquery=select 1\nprepare=true"))
+	iniConfig, err := cp.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := parseIniConfig(supportedDatabaseFlavors["mysql"], iniConfig, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if warnings := lintConfig(config); len(warnings) != 1 {
+		t.Errorf("Expected a warning for prepare with -trace-comments but got %v",
+			quotedValue(warnings))
+	}
+}
+
+func TestAcceptsError(t *testing.T) {
+	config := &Config{
+		AcceptedErrors:        Set{"1205": struct{}{}},
+		AcceptedErrorMessages: []*regexp.Regexp{regexp.MustCompile(`(?i)too many connections`)},
+	}
+	for _, c := range []struct {
+		flavor   string
+		errs     []error
+		accepted uint64
+	}{
+		{"mysql", []error{&mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"}}, 1},
+		{"mysql", []error{&mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}}, 0},
+		// The message is matched for every error, not only the first of
+		// its code.
+		{"mysql", []error{
+			&mysql.MySQLError{Number: 1040, Message: "Host is blocked"},
+			&mysql.MySQLError{Number: 1040, Message: "Too many connections"},
+		}, 1},
+		// Without error codes, errors can still be accepted by message.
+		{"vertica", []error{errors.New("Too many connections")}, 1},
+		{"mssql", []error{errors.New("too many connections")}, 1},
+	} {
+		ec := make(ErrorCounts)
+		for _, err := range c.errs {
+			if e := ec.Add(err, "select 1", supportedDatabaseFlavors[c.flavor], config.AcceptedErrorMessages); e != nil {
+				t.Errorf("Error counting %v for %s: %v", err, c.flavor, e)
+			}
+		}
+		if accepted := ec.TotalAccepted(config); accepted != c.accepted {
+			t.Errorf("Expected %d of %v for %s to be accepted, got %d", c.accepted, c.errs, c.flavor, accepted)
+		}
+		if unhandled := ec.UnhandledErrors(config).TotalErrors(); unhandled != uint64(len(c.errs))-c.accepted {
+			t.Errorf("Expected %d of %v for %s to be unhandled, got %d",
+				uint64(len(c.errs))-c.accepted, c.errs, c.flavor, unhandled)
+		}
+	}
+
+	ec := make(ErrorCounts)
+	if err := ec.Add(errors.New("Deadlock"), "select 1", supportedDatabaseFlavors["vertica"], config.AcceptedErrorMessages); err == nil {
+		t.Error("Expected an error counting an error without a code that matches no accepted message")
+	}
+
+	if _, err := errorClassCodes(supportedDatabaseFlavors["vertica"], "deadlock"); err == nil {
+		t.Error("Unexpected error class of a flavor without error codes")
+	}
+	if codes, err := errorClassCodes(supportedDatabaseFlavors["cockroachdb"], "serialization"); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(codes, []string{"40001"}) {
+		t.Errorf("Unexpected serialization codes %v", codes)
+	}
+}
+
+func TestMaxRetriesFlavors(t *testing.T) {
+	var cases = []struct {
+		driver string
+		ok     bool
+	}{
+		{"mysql", true},
+		{"postgres", true},
+		{"cockroachdb", true},
+		{"mssql", false},
+		{"fake", false},
+	}
+
+	in := "[test]\nquery=select 1\nmax-retries=3"
+	for _, c := range cases {
+		cp := goini.NewRawConfigParser()
+		cp.Parse(strings.NewReader(in))
+		iniConfig, err := cp.Finish()
+		if err != nil {
+			t.Fatalf("Error parsing config %s: %v", strconv.Quote(in), err)
+		}
+
+		_, err = parseIniConfig(supportedDatabaseFlavors[c.driver], iniConfig, ".")
+		if c.ok && err != nil {
+			t.Errorf("Unexpected error setting max-retries for %s: %v", c.driver, err)
+		} else if !c.ok && err == nil {
+			t.Errorf("Unexpected success setting max-retries for %s", c.driver)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+/*
+ * Estimates the cost of running a job invocation against a metered database,
+ * in whatever currency the rates are given in.
+ */
+type CostModel interface {
+	Cost(jr *JobResult) float64
+}
+
+/*
+ * The default cost model, linear in the number of queries, the number of
+ * rows, and the time spent running queries.
+ */
+type LinearCostModel struct {
+	PerQuery  float64
+	PerRow    float64
+	PerSecond float64
+}
+
+func (lcm *LinearCostModel) Cost(jr *JobResult) float64 {
+	return lcm.PerQuery*float64(jr.Queries) +
+		lcm.PerRow*float64(jr.RowsAffected) +
+		lcm.PerSecond*jr.Elapsed.Seconds()
+}
+
+/*
+ * The cost model of the flavors that do not have their own, linear in the
+ * rates, or nil if none are set.
+ */
+func defaultCostModel(rates LinearCostModel) CostModel {
+	if rates == (LinearCostModel{}) {
+		return nil
+	}
+	return &rates
+}
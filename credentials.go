@@ -0,0 +1,195 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var defaultsFile = flag.String("defaults-file", "",
+	"Read the user, password, host, port and database of the connection, "+
+		"unless they are given otherwise, from the [client] and [dbbench] "+
+		"sections of this my.cnf style option file.")
+
+// Read when the username or password flags are not set.
+const (
+	usernameEnv = "DBBENCH_USERNAME"
+	passwordEnv = "DBBENCH_PASSWORD"
+)
+
+/*
+ * Fills in the username and password of the connection that the flags (or
+ * url) leave unset from the environment, and then whatever is still unset
+ * from the defaults-file, so that passwords need not be on the command line.
+ */
+func loadCredentials(cc *ConnectionConfig) error {
+	if cc.Username == "" {
+		cc.Username = os.Getenv(usernameEnv)
+	}
+	if cc.Password == "" {
+		cc.Password = os.Getenv(passwordEnv)
+	}
+	if *defaultsFile == "" {
+		return nil
+	}
+
+	options, err := readOptionFile(*defaultsFile)
+	if err != nil {
+		return fmt.Errorf("reading defaults-file: %v", err)
+	}
+	for _, o := range []struct {
+		field *string
+		name  string
+	}{
+		{&cc.Username, "user"},
+		{&cc.Password, "password"},
+		{&cc.Host, "host"},
+		{&cc.Database, "database"},
+	} {
+		if *o.field == "" {
+			*o.field = options[o.name]
+		}
+	}
+	if v := options["port"]; cc.Port == 0 && v != "" {
+		if cc.Port, err = strconv.Atoi(v); err != nil {
+			return fmt.Errorf("invalid port %s in defaults-file", strconv.Quote(v))
+		}
+	}
+	return nil
+}
+
+/*
+ * Reads the options of the [client] and [dbbench] sections of a MySQL style
+ * option file, the later of them overriding the earlier. Options without a
+ * value (e.g. skip-ssl) and other sections are ignored. Like mysql, a # outside
+ * of quotes starts a comment and values are unquoted and unescaped.
+ */
+func readOptionFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	options := make(map[string]string)
+	var section string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(stripOptionComment(scanner.Text()))
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		} else if strings.HasPrefix(line, "!include") {
+			return nil, errors.New("!include and !includedir are not supported")
+		} else if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.ToLower(strings.TrimSpace(line[1 : len(line)-1]))
+			continue
+		}
+
+		kv := strings.SplitN(line, "=", 2)
+		if (section != "client" && section != "dbbench") || len(kv) < 2 {
+			continue
+		}
+		key := strings.ReplaceAll(strings.ToLower(strings.TrimSpace(kv[0])), "_", "-")
+		options[key] = unescapeOption(unquoteOption(strings.TrimSpace(kv[1])))
+	}
+	return options, scanner.Err()
+}
+
+// Cuts a line at the first # that is not inside quotes, as mysql does.
+func stripOptionComment(line string) string {
+	var quote byte
+	escaped := false
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case (c == '"' || c == '\'') && !escaped:
+			if quote == 0 {
+				quote = c
+			} else if quote == c {
+				quote = 0
+			}
+		case c == '#' && quote == 0:
+			return line[:i]
+		}
+		escaped = quote != 0 && line[i] == '\\' && !escaped
+	}
+	return line
+}
+
+// Strips the quotes around the value of an option, if it has them.
+func unquoteOption(v string) string {
+	if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+		return v[1 : len(v)-1]
+	}
+	return v
+}
+
+// The escape sequences of option values, others keep their backslash.
+var optionEscapes = map[byte]byte{
+	'n': '\n', 't': '\t', 'r': '\r', 'b': '\b', 's': ' ',
+	'"': '"', '\'': '\'', '\\': '\\',
+}
+
+// Replaces the escape sequences of an option value, e.g. \\ and \".
+func unescapeOption(v string) string {
+	if !strings.Contains(v, "\\") {
+		return v
+	}
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		if v[i] == '\\' && i+1 < len(v) {
+			i++
+			if c, ok := optionEscapes[v[i]]; ok {
+				b.WriteByte(c)
+			} else {
+				b.WriteByte('\\')
+				b.WriteByte(v[i])
+			}
+			continue
+		}
+		b.WriteByte(v[i])
+	}
+	return b.String()
+}
+
+/*
+ * Where the value of a password param ends depends on the form of the DSN: at
+ * the next & of a url, at the next ; of an ADO style connection string, and at
+ * the next space of a libpq keyword/value string, unless it is quoted there.
+ */
+var (
+	urlPasswordParam     = regexp.MustCompile(`(?i)\b(password|pwd|sslpassword)=[^&]*`)
+	adoPasswordParam     = regexp.MustCompile(`(?i)\b(password|pwd|sslpassword)\s*=[^;]*`)
+	keywordPasswordParam = regexp.MustCompile(`(?i)\b(password|pwd|sslpassword)\s*=\s*('(?:[^'\\]|\\.)*'|\S*)`)
+)
+
+// Masks the passwords given as params of the DSN, for logging it.
+func redactDSN(dsn string) string {
+	re := keywordPasswordParam
+	if strings.Contains(dsn, "://") || strings.Contains(dsn, "?") {
+		re = urlPasswordParam
+	} else if strings.Contains(dsn, ";") {
+		re = adoPasswordParam
+	}
+	return re.ReplaceAllString(dsn, "${1}=XXX")
+}
@@ -17,10 +17,13 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
 /*
@@ -42,24 +45,138 @@ type DatabaseFlavor interface {
 	 * pool so queries that affect the connection (e.g. "use", "begin") are
 	 * disallowed.
 	 *
-	 * If the query is empty, returns EmptyQueryError.
+	 * If the query is empty, returns EmptyQueryError. If the query changes
+	 * the database of the connection, returns ChangeDatabaseError, since
+	 * it may still be run in a Session.
 	 */
 	CheckQuery(string) error
 
 	/*
-	 * The separator used in query files for this flavor of database
-	 * (e.g. ";") for most SQL databases.
+	 * Splits the contents of a query file into queries, as separated in
+	 * the dialect of this flavor of database (e.g. by ";" for most SQL
+	 * databases or by GO lines for SQL Server).
 	 */
-	QuerySeparator() string
+	SplitQueries(contents string) []string
 
 	/*
 	 * The extracted error code (string) from the error (error) thrown by the database driver. This is needed to let
 	 * dbbench handle arbitrary errors from any given database flavor.
 	 */
 	ErrorCode(error) (string, error)
+
+	/*
+	 * Whether ErrorCode extracts codes from the errors of the driver at all,
+	 * rather than always returning ErrorCodesUnsupported.
+	 */
+	HasErrorCodes() bool
+
+	/*
+	 * Whether the driver binds named args (sql.Named) by their name, rather
+	 * than rejecting them or binding them by position.
+	 */
+	SupportsNamedArgs() bool
+
+	/*
+	 * The cost model of a run given the rates configured in the runfile, or
+	 * nil if it has no cost. A flavor may price its own metrics (e.g. the
+	 * bytes scanned as reported by the driver); most use defaultCostModel.
+	 */
+	CostModel(rates LinearCostModel) CostModel
+
+	/*
+	 * The error codes of transient failures (e.g. serialization failures)
+	 * after which a query may be retried by jobs with max-retries.
+	 */
+	RetryableErrorCodes() []string
+
+	/*
+	 * A short human readable description of the flavor and its connection
+	 * defaults (e.g. the data source name used if no connection properties
+	 * are specified).
+	 */
+	Describe() string
 }
 
 var EmptyQueryError = errors.New("empty query found")
+var ChangeDatabaseError = errors.New("cannot change database")
+
+/*
+ * Options for how a query is run and its results read.
+ */
+type QueryOptions struct {
+	// If positive, stop reading the results of the query once it returns
+	// more than this many rows and return a *MaxRowsError with MaxRows
+	// records affected.
+	MaxRows int64
+
+	// Read every result set returned by the query (e.g. by a stored
+	// procedure), not just the first.
+	AllResultSets bool
+
+	// Prepare the query once per connection and execute the prepared
+	// statement, rather than sending the query text each time.
+	Prepare bool
+
+	// If positive, cancel the query once it has run for this long and
+	// return a *QueryTimeoutError.
+	Timeout time.Duration
+}
+
+/*
+ * Returned when a query returns more rows than the max-rows of its job. It
+ * is counted with its own error code rather than one parsed by the flavor.
+ */
+type MaxRowsError struct {
+	MaxRows int64
+}
+
+const maxRowsErrorCode = "max-rows"
+
+func (mre *MaxRowsError) Error() string {
+	return fmt.Sprintf("query returned more than %d rows", mre.MaxRows)
+}
+
+/*
+ * Returned when a query runs for longer than the query-timeout of its job. It
+ * is counted with its own error code whatever error the driver returns for
+ * the cancelled query.
+ */
+type QueryTimeoutError struct {
+	Timeout time.Duration
+}
+
+const queryTimeoutErrorCode = "timeout"
+
+func (qte *QueryTimeoutError) Error() string {
+	return fmt.Sprintf("query did not finish within %v", qte.Timeout)
+}
+
+/*
+ * Returned when opening the connection of an invocation fails. Unless the
+ * flavor finds a code in the error (e.g. too many connections), it is counted
+ * with its own error code, since failing to reach the server (e.g. a refused
+ * dial) is not an error of the database.
+ */
+type ConnectError struct {
+	Err error
+}
+
+const connectErrorCode = "connect"
+
+func (ce *ConnectError) Error() string {
+	return fmt.Sprintf("error connecting: %v", ce.Err)
+}
+
+func parseQueryTimeout(v string) (time.Duration, error) {
+	timeout, err := time.ParseDuration(v)
+	if err == nil && timeout <= 0 {
+		return 0, errors.New("query-timeout must be positive")
+	}
+	return timeout, err
+}
+
+// Returned by ErrorCode for database flavors that cannot parse errors.
+var ErrorCodesUnsupported = errors.New("Database flavor currently does not support parsing errors")
 
 /*
  * The user specified parameters for connecting to a database. If any
@@ -68,10 +185,11 @@ var EmptyQueryError = errors.New("empty query found")
 type ConnectionConfig struct {
 	Username string
 	Password string
-	Host     string
+	Host     string // May be a comma separated list of host[:port].
 	Port     int
 	Database string
 	Params   string
+	TLS      TLSConfig
 }
 
 /*
@@ -91,16 +209,21 @@ func (cc *ConnectionConfig) OverrideFromURL(u url.URL) {
 	if isPassSet {
 		cc.Password = pass
 	}
-	if u.Hostname() != "" {
-		cc.Host = u.Hostname()
-	}
-	if u.Port() != "" {
-		cc.Port, _ = strconv.Atoi(u.Port())
+	if strings.Contains(u.Host, ",") {
+		// Several hosts, each with its own port.
+		cc.Host = u.Host
+	} else {
+		if u.Hostname() != "" {
+			cc.Host = u.Hostname()
+		}
+		if u.Port() != "" {
+			cc.Port, _ = strconv.Atoi(u.Port())
+		}
 	}
 	if u.Path != "" {
 		cc.Database = strings.Trim(u.Path, "/")
 	}
-	if u.Query() != nil {
+	if u.RawQuery != "" {
 		cc.Params = u.Query().Encode()
 	}
 }
@@ -118,7 +241,37 @@ type Database interface {
 	 * so that it is safe to call RunQuery from arbitrarily many
 	 * goroutines without blocking.
 	 */
-	RunQuery(results *SafeCSVWriter, query string, args []interface{}) (int64, error)
+	RunQuery(ctx context.Context, results *SafeCSVWriter, query string, args []interface{}) (int64, error)
+
+	/*
+	 * Like RunQuery, but with per job options for reading the results.
+	 */
+	RunQueryWithOptions(ctx context.Context, results *SafeCSVWriter, query string, args []interface{}, opts QueryOptions) (int64, error)
+
+	/*
+	 * Runs the statements of a script in order on a single connection, so
+	 * that statements which affect the connection (e.g. USE or SET) apply
+	 * to the rest of the script. The connection is not reused afterwards.
+	 */
+	RunScript(statements []string) error
+
+	/*
+	 * Begins a transaction, whose queries run on a single connection until
+	 * it is committed or rolled back.
+	 */
+	Begin() (Transaction, error)
+
+	/*
+	 * Reserves a single connection, whose session state (e.g. SET, USE, or
+	 * temporary tables) persists between the queries run on it.
+	 */
+	Session() (Session, error)
+
+	/*
+	 * Opens a new connection, rather than reserving one of the pool, as a
+	 * session. Closing the session closes the connection.
+	 */
+	NewSession(ctx context.Context) (Session, error)
 
 	/*
 	 * Close the database, reclaiming any resources.
@@ -128,10 +281,33 @@ type Database interface {
 	Close()
 }
 
+/*
+ * A connection reserved by a Database. It is not safe for concurrent use.
+ */
+type Session interface {
+	RunQueryWithOptions(ctx context.Context, results *SafeCSVWriter, query string, args []interface{}, opts QueryOptions) (int64, error)
+	Begin() (Transaction, error)
+	Close() error
+}
+
+/*
+ * A transaction begun by a Database. It is not safe for concurrent use.
+ */
+type Transaction interface {
+	RunQueryWithOptions(ctx context.Context, results *SafeCSVWriter, query string, args []interface{}, opts QueryOptions) (int64, error)
+	Commit() error
+	Rollback() error
+}
+
 // TODO: implement error parsing for mssql and vertica
 var supportedDatabaseFlavors = map[string]DatabaseFlavor{
-	"mysql":    &sqlDatabaseFlavor{"mysql", mySQLDataSourceName, checkSQLQuery, mySQLErrorCodeParser},
-	"mssql":    &sqlDatabaseFlavor{"mssql", sqlServerDataSourceName, checkSQLQuery, unimplementedErrorCodeParser},
-	"postgres": &sqlDatabaseFlavor{"postgres", postgresDataSourceName, checkSQLQuery, postgresErrorCodeParser},
-	"vertica":  &sqlDatabaseFlavor{"vertica", verticaDataSourceName, checkSQLQuery, unimplementedErrorCodeParser},
+	"mysql":    &sqlDatabaseFlavor{"mysql", mySQLDataSourceName, checkSQLQuery, splitOnSemicolons, mySQLErrorCodeParser, questionMarkPlaceholders, []string{"1213"}},
+	"mssql":    &sqlDatabaseFlavor{"mssql", sqlServerDataSourceName, checkSQLServerQuery, splitGoBatches, nil, sqlServerPlaceholders, nil},
+	"postgres": &sqlDatabaseFlavor{"postgres", postgresDataSourceName, checkSQLQuery, splitOnSemicolons, postgresErrorCodeParser, ordinalPlaceholders, []string{"40001", "40P01"}},
+	"vertica":  &sqlDatabaseFlavor{"vertica", verticaDataSourceName, checkVerticaQuery, splitOnUnquotedSemicolons, nil, questionMarkPlaceholders, nil},
+	// Deadlock victims (and serialization failures) may be retried. CockroachDB
+	// speaks the Postgres protocol, but aborts conflicting serializable
+	// transactions with 40001 for the client to retry.
+	"cockroachdb": &sqlDatabaseFlavor{"postgres", cockroachDataSourceName, checkSQLQuery, splitOnSemicolons, postgresErrorCodeParser, ordinalPlaceholders, []string{"40001"}},
+	"fake":        &fakeDatabaseFlavor{},
 }
@@ -0,0 +1,198 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A number of bytes, set with an optional KB, MB, GB or TB suffix (in powers
+// of 1024).
+type ByteSizeFlagValue uint64
+
+var byteSizeUnits = []struct {
+	suffix string
+	size   uint64
+}{
+	{"TB", 1 << 40},
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+func (bsfv *ByteSizeFlagValue) Set(v string) error {
+	s := strings.ToUpper(strings.TrimSpace(v))
+	unit := uint64(1)
+	for _, u := range byteSizeUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s, unit = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size %s, must be a number of bytes, KB, MB, GB or TB",
+			strconv.Quote(v))
+	}
+	*bsfv = ByteSizeFlagValue(n * float64(unit))
+	return nil
+}
+
+func (bsfv *ByteSizeFlagValue) String() string {
+	for _, u := range byteSizeUnits {
+		if uint64(*bsfv) >= u.size {
+			return strconv.FormatFloat(float64(*bsfv)/float64(u.size), 'f', -1, 64) + u.suffix
+		}
+	}
+	return "0"
+}
+
+var minFreeDisk = ByteSizeFlagValue(100 << 20)
+
+func init() {
+	flag.Var(&minFreeDisk, "min-free-disk",
+		"Abort the run (still reporting its stats and running teardown) once a "+
+			"filesystem that its output files are written to has less free "+
+			"space than this, e.g. 1GB, rather than fill the disk. 0 to never "+
+			"check.")
+}
+
+// How often the free space of the filesystems of the output files is checked.
+const diskCheckInterval = time.Second
+
+/*
+ * The directories of the files the run writes to: the stats, results,
+ * explain, logs and query log files, and the temporary directory if query
+ * results may be spilled to it.
+ */
+func outputDirs(config *Config) []string {
+	dirs := make(map[string]bool)
+	var paths []string
+	for _, f := range []*os.File{queryStatsFile.GetFile(), intervalStatsFile.GetFile(), runLog} {
+		if f != nil {
+			paths = append(paths, f.Name())
+		}
+	}
+	if *recordIssuedQueries != "" {
+		paths = append(paths, *recordIssuedQueries)
+	}
+	for _, job := range config.Jobs {
+		if job.LogFile != nil {
+			paths = append(paths, job.LogFile.Name())
+		}
+		if job.QueryResults != nil && job.QueryResults.path != "" {
+			paths = append(paths, job.QueryResults.path)
+			if *queryResultsMemory > 0 {
+				dirs[os.TempDir()] = true
+			}
+		}
+		if job.ExplainResults != nil && job.ExplainResults.path != "" {
+			paths = append(paths, job.ExplainResults.path)
+			if *queryResultsMemory > 0 {
+				dirs[os.TempDir()] = true
+			}
+		}
+	}
+
+	for _, path := range paths {
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		dirs[filepath.Dir(path)] = true
+	}
+	sorted := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		sorted = append(sorted, dir)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+/*
+ * Checks the free space of the filesystems of the output files of a run, and
+ * cancels the run the first time one of them has less than min-free-disk.
+ */
+type diskWatcher struct {
+	dirs   []string
+	min    uint64
+	cancel context.CancelFunc
+	// Returns the bytes free on the filesystem of the directory.
+	free func(dir string) (uint64, error)
+
+	mu  sync.Mutex
+	low string
+}
+
+func newDiskWatcher(dirs []string, min uint64, cancel context.CancelFunc) *diskWatcher {
+	return &diskWatcher{dirs: dirs, min: min, cancel: cancel, free: freeDiskSpace}
+}
+
+// Checks every diskCheckInterval until the run is over or the disk is low.
+func (dw *diskWatcher) Run(ctx context.Context) {
+	ticker := clock.NewTicker(diskCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.Chan():
+		}
+		if !dw.check() {
+			return
+		}
+	}
+}
+
+// Returns false once there is no point in checking again.
+func (dw *diskWatcher) check() bool {
+	for _, dir := range dw.dirs {
+		free, err := dw.free(dir)
+		if err != nil {
+			log.Printf("Not checking the free disk space of the output files: %v", err)
+			return false
+		}
+		if free < dw.min {
+			min := ByteSizeFlagValue(dw.min)
+			dw.mu.Lock()
+			dw.low = fmt.Sprintf("%d bytes free for the output files in %s, less than min-free-disk %s",
+				free, dir, min.String())
+			dw.mu.Unlock()
+			log.Printf("Aborting the run with %d bytes free for the output files in %s", free, dir)
+			dw.cancel()
+			return false
+		}
+	}
+	return true
+}
+
+// Why the run was aborted, or "" if the disk never ran low.
+func (dw *diskWatcher) Low() string {
+	dw.mu.Lock()
+	defer dw.mu.Unlock()
+	return dw.low
+}
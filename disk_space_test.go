@@ -0,0 +1,99 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestByteSizeFlagValue(t *testing.T) {
+	for _, c := range []struct {
+		in  string
+		out ByteSizeFlagValue
+	}{
+		{"0", 0},
+		{"4096", 4096},
+		{"512B", 512},
+		{"64KB", 64 << 10},
+		{"100MB", 100 << 20},
+		{"1.5gb", 3 << 29},
+		{"2 TB", 2 << 40},
+	} {
+		var bsfv ByteSizeFlagValue
+		if err := bsfv.Set(c.in); err != nil {
+			t.Errorf("For %s, unexpected error %v", c.in, err)
+		} else if bsfv != c.out {
+			t.Errorf("For %s\n\texpected %d\n\tbut got %d", c.in, c.out, bsfv)
+		}
+	}
+
+	for _, in := range []string{"", "lots", "-1MB", "10PB"} {
+		var bsfv ByteSizeFlagValue
+		if err := bsfv.Set(in); err == nil {
+			t.Errorf("Expected an error for %s", in)
+		}
+	}
+}
+
+func TestDiskWatcher(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	free := map[string]uint64{"/stats": 1 << 30, "/results": 1 << 30}
+	dw := newDiskWatcher([]string{"/results", "/stats"}, 100<<20, cancel)
+	dw.free = func(dir string) (uint64, error) { return free[dir], nil }
+
+	if !dw.check() || ctx.Err() != nil || dw.Low() != "" {
+		t.Fatalf("Expected the run to go on with 1GB free")
+	}
+	free["/stats"] = 10 << 20
+	if dw.check() {
+		t.Errorf("Expected the check to stop with 10MB free")
+	}
+	if ctx.Err() == nil {
+		t.Errorf("Expected the run to be cancelled with 10MB free")
+	}
+	if expected := "10485760 bytes free for the output files in /stats, less than min-free-disk 100MB"; dw.Low() != expected {
+		t.Errorf("Expected\n\t%s\nbut got\n\t%s", expected, dw.Low())
+	}
+
+	// A platform without support is not a reason to abort the run.
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	dw = newDiskWatcher([]string{"/stats"}, 100<<20, cancel)
+	dw.free = func(string) (uint64, error) { return 0, errors.New("unsupported") }
+	if dw.check() || ctx.Err() != nil || dw.Low() != "" {
+		t.Errorf("Expected the check to stop without cancelling the run")
+	}
+}
+
+func TestOutputDirs(t *testing.T) {
+	// Results are not spilled to the temporary directory.
+	defer func(memory int) { *queryResultsMemory = memory }(*queryResultsMemory)
+	*queryResultsMemory = 0
+
+	config := &Config{Jobs: map[string]*Job{
+		"results": {Name: "results", QueryResults: &SafeCSVWriter{path: "/results/test.csv"}},
+		"explain": {Name: "explain", ExplainResults: &SafeCSVWriter{path: "/explain/plans.csv"}},
+		"logged":  {Name: "logged", ExplainResults: NewSafeCSVWriterTo(nil)},
+	}}
+	if dirs, expected := outputDirs(config), []string{"/explain", "/results"}; !reflect.DeepEqual(dirs, expected) {
+		t.Errorf("Expected output dirs %v but got %v", expected, dirs)
+	}
+}
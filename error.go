@@ -17,9 +17,13 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // Go's map can only handle comparable types as a key. We can't be sure that an error thrown by any possible database
@@ -43,10 +47,59 @@ func (ec ErrorCounts) String() string {
 	return str.String()
 }
 
-func (ec ErrorCounts) Add(err error, query string, df DatabaseFlavor) error {
-	code, e := df.ErrorCode(err)
+// The code by which an error returned by a query is counted.
+func errorCode(err error, df DatabaseFlavor) (string, error) {
+	if se, ok := err.(*SimulatedError); ok {
+		return se.Code, nil
+	} else if _, ok := err.(*MaxRowsError); ok {
+		return maxRowsErrorCode, nil
+	} else if _, ok := err.(*QueryTimeoutError); ok {
+		return queryTimeoutErrorCode, nil
+	} else if ce, ok := err.(*ConnectError); ok {
+		if code, e := df.ErrorCode(ce.Err); e == nil {
+			return code, nil
+		}
+		return connectErrorCode, nil
+	}
+	return df.ErrorCode(err)
+}
+
+// Whether the query that returned the error may be retried.
+func isRetryable(err error, df DatabaseFlavor) bool {
+	code, e := errorCode(err, df)
 	if e != nil {
-		return e
+		return false
+	}
+	for _, c := range df.RetryableErrorCodes() {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// The prefix of the code by which errors matching an accepted message are counted.
+const acceptedMessageCodePrefix = "message="
+
+/*
+ * Counts the error of the query by its code, unless its message matches one
+ * of the accepted error messages, in which case it is counted by that
+ * message instead (so that errors can be accepted by message even if the
+ * database flavor has no error codes).
+ */
+func (ec ErrorCounts) Add(err error, query string, df DatabaseFlavor, acceptedMessages []*regexp.Regexp) error {
+	var code string
+	for _, re := range acceptedMessages {
+		if re.MatchString(err.Error()) {
+			code = acceptedMessageCodePrefix + re.String()
+			break
+		}
+	}
+	if code == "" {
+		var e error
+		if code, e = errorCode(err, df); e != nil {
+			return e
+		}
 	}
 	if _, ok := ec[code]; !ok {
 		ec[code] = errorCounts{make(errorsPerQuery), err}
@@ -62,9 +115,27 @@ func (ec ErrorCounts) TotalErrors() (total uint64) {
 	return
 }
 
-func (ec ErrorCounts) TotalAccepted(df DatabaseFlavor, errors Set) (total uint64) {
+/*
+ * The error of the lowest error code, or nil if there are none. The counts do
+ * not record the order of the errors, so this is not necessarily the first
+ * error, but it is the same one for the same errors.
+ */
+func (ec ErrorCounts) LowestCodeError() error {
+	var lowest string
+	for code := range ec {
+		if lowest == "" || code < lowest {
+			lowest = code
+		}
+	}
+	if lowest == "" {
+		return nil
+	}
+	return ec[lowest].Error
+}
+
+func (ec ErrorCounts) TotalAccepted(config *Config) (total uint64) {
 	for errCode, ecc := range ec {
-		if errors.Contains(errCode) {
+		if config.acceptsError(errCode) {
 			total += ecc.Total()
 		}
 	}
@@ -72,16 +143,168 @@ func (ec ErrorCounts) TotalAccepted(df DatabaseFlavor, errors Set) (total uint64
 }
 
 // Return a new ErrorCounts that contains just the subset of unhandled errors
-func (ec ErrorCounts) UnhandledErrors(df DatabaseFlavor, errors Set) (newEc ErrorCounts) {
+func (ec ErrorCounts) UnhandledErrors(config *Config) (newEc ErrorCounts) {
 	newEc = make(ErrorCounts)
 	for errCode, ecc := range ec {
-		if !errors.Contains(errCode) {
+		if !config.acceptsError(errCode) {
 			newEc[errCode] = ecc
 		}
 	}
 	return
 }
 
+/*
+ * Whether the errors counted by the code are accepted by the error options.
+ * Errors are matched against the accepted messages as they are counted, so
+ * those that match are counted by a code of their own.
+ */
+func (c *Config) acceptsError(code string) bool {
+	return c.AcceptedErrors.Contains(code) || strings.HasPrefix(code, acceptedMessageCodePrefix)
+}
+
+// The error codes of each class of errors, by database flavor.
+var errorClasses = map[string]map[string][]string{
+	"mysql": {
+		"deadlock":      {"1213"},
+		"duplicate-key": {"1062", "1586"},
+		"lock-timeout":  {"1205"},
+		"timeout":       {"3024"},
+	},
+	"postgres": {
+		"deadlock":      {"40P01"},
+		"duplicate-key": {"23505"},
+		"lock-timeout":  {"55P03"},
+		"serialization": {"40001"},
+		"timeout":       {"57014"},
+	},
+}
+
+// The classes of errors of the database flavor, sorted.
+func errorClassNames(df DatabaseFlavor) []string {
+	var names []string
+	if sq, ok := df.(*sqlDatabaseFlavor); ok {
+		for name := range errorClasses[sq.name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// The error codes of the class of errors of the database flavor.
+func errorClassCodes(df DatabaseFlavor, class string) ([]string, error) {
+	names := errorClassNames(df)
+	if len(names) == 0 {
+		return nil, errors.New("the database flavor has no error classes; use error codes or message=<regex>")
+	}
+	codes, ok := errorClasses[df.(*sqlDatabaseFlavor).name][class]
+	if !ok {
+		return nil, fmt.Errorf("unknown error class %s, expected one of %s",
+			strconv.Quote(class), strings.Join(names, ", "))
+	}
+	return codes, nil
+}
+
+/*
+ * Counts errors per error code per stats interval (by the time the invocation
+ * that failed started), to show whether they clustered around a moment.
+ */
+type ErrorTimeline struct {
+	Interval time.Duration
+	counts   map[string]map[int]uint64 // error code -> interval -> count
+	last     int
+}
+
+// The widest timeline shown, in characters; longer runs merge intervals.
+const maxErrorTimelineWidth = 60
+
+var errorTimelineLevels = []rune("▁▂▃▄▅▆▇█")
+
+func (et *ErrorTimeline) Add(start time.Duration, ec ErrorCounts) {
+	if len(ec) == 0 || et.Interval <= 0 {
+		return
+	}
+	if et.counts == nil {
+		et.counts = make(map[string]map[int]uint64)
+	}
+	interval := int(start / et.Interval)
+	if interval > et.last {
+		et.last = interval
+	}
+	for code, ecc := range ec {
+		if _, ok := et.counts[code]; !ok {
+			et.counts[code] = make(map[int]uint64)
+		}
+		et.counts[code][interval] += ecc.Total()
+	}
+}
+
+func (et *ErrorTimeline) Codes() []string {
+	codes := make([]string, 0, len(et.counts))
+	for code := range et.counts {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// The count of errors with the code in each interval, from the first.
+func (et *ErrorTimeline) Counts(code string) []uint64 {
+	counts := make([]uint64, et.last+1)
+	for interval, count := range et.counts[code] {
+		counts[interval] = count
+	}
+	return counts
+}
+
+/*
+ * Renders a row per error code, each character of which is the errors in
+ * one or more intervals scaled to the busiest, or . if there were none.
+ */
+func (et *ErrorTimeline) String() string {
+	if len(et.counts) == 0 {
+		return ""
+	}
+	perColumn := (et.last + maxErrorTimelineWidth) / maxErrorTimelineWidth
+	var str strings.Builder
+	str.WriteString(fmt.Sprintf("Errors by interval (%v per column, from 0s to %v):\n",
+		time.Duration(perColumn)*et.Interval, time.Duration(et.last+1)*et.Interval))
+	for _, code := range et.Codes() {
+		columns := make([]uint64, 0, maxErrorTimelineWidth)
+		var total, peak uint64
+		var peakAt int
+		for i, count := range et.Counts(code) {
+			if i%perColumn == 0 {
+				columns = append(columns, 0)
+			}
+			columns[len(columns)-1] += count
+			total += count
+			if count > peak {
+				peak, peakAt = count, i
+			}
+		}
+
+		var max uint64
+		for _, count := range columns {
+			if count > max {
+				max = count
+			}
+		}
+		var line strings.Builder
+		for _, count := range columns {
+			if count == 0 {
+				line.WriteRune('.')
+			} else {
+				level := int((count*uint64(len(errorTimelineLevels)) - 1) / max)
+				line.WriteRune(errorTimelineLevels[level])
+			}
+		}
+		str.WriteString(fmt.Sprintf("%12s: %s %d total, peak %d at %v\n",
+			code, line.String(), total, peak, time.Duration(peakAt)*et.Interval))
+	}
+	return str.String()
+}
+
 func (epq errorsPerQuery) String() string {
 	var str strings.Builder
 
@@ -0,0 +1,97 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The max-error-rate of a job is only checked once it has run this many
+// queries, so that an early error or two does not abort the run.
+const errorRateMinQueries = 100
+
+/*
+ * Counts the queries and errors (accepted or not) of a job with a max-errors
+ * or max-error-rate over the whole run, and cancels the run the first time
+ * the job goes over either of them.
+ */
+type errorBudget struct {
+	job    string
+	max    uint64
+	rate   float64
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	queries  uint64
+	errors   uint64
+	exceeded string
+}
+
+func newErrorBudget(job *Job, cancel context.CancelFunc) *errorBudget {
+	return &errorBudget{job: job.Name, max: job.MaxErrors, rate: job.MaxErrorRate, cancel: cancel}
+}
+
+func (eb *errorBudget) Observe(jr *JobResult) {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	eb.queries += uint64(jr.Queries)
+	eb.errors += jr.Errors.TotalErrors()
+	if eb.exceeded != "" {
+		return
+	}
+
+	if eb.max > 0 && eb.errors > eb.max {
+		eb.exceeded = fmt.Sprintf("%d errors, more than max-errors %d", eb.errors, eb.max)
+	} else if rate := 100 * float64(eb.errors) / float64(eb.queries); eb.rate > 0 &&
+		eb.queries >= errorRateMinQueries && rate > eb.rate {
+		eb.exceeded = fmt.Sprintf("%d errors in %d queries (%.2f%%), more than max-error-rate %v%%",
+			eb.errors, eb.queries, rate, eb.rate)
+	} else {
+		return
+	}
+	log.Printf("%s: aborting the run after %s", eb.job, eb.exceeded)
+	eb.cancel()
+}
+
+// Why the job aborted the run, or "" if it did not.
+func (eb *errorBudget) Exceeded() string {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	return eb.exceeded
+}
+
+func parseErrorRate(v string) (float64, error) {
+	rate, err := strconv.ParseFloat(strings.TrimSuffix(v, "%"), 64)
+	if err != nil || rate <= 0 || rate > 100 {
+		return 0, fmt.Errorf("invalid error rate %s, must be a percentage over 0 and at most 100",
+			strconv.Quote(v))
+	}
+	return rate, nil
+}
+
+func parseMaxErrors(v string) (uint64, error) {
+	max, err := strconv.ParseUint(v, 10, 64)
+	if err != nil || max == 0 {
+		return 0, fmt.Errorf("invalid max-errors %s, must be a positive integer", strconv.Quote(v))
+	}
+	return max, nil
+}
@@ -0,0 +1,123 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// Sections with this prefix describe events rather than jobs.
+const eventSectionPrefix = "event:"
+
+/*
+ * Something that happens at a point during the run (e.g. creating an index or
+ * starting a backup) whose impact on the jobs should be measured.
+ */
+type Event struct {
+	Name    string
+	Start   time.Duration
+	Queries []string
+	Command string
+}
+
+// The phases of the run relative to an event.
+const (
+	beforeEvent = "before"
+	duringEvent = "during"
+	afterEvent  = "after"
+)
+
+type eventPhase struct {
+	name  string
+	phase string
+}
+
+// When an event was during the run, if it started and finished.
+type eventWindow struct {
+	Start, End *time.Time
+}
+
+func (ew *eventWindow) set(phase string, t time.Time) {
+	if phase == duringEvent {
+		ew.Start = &t
+	} else if phase == afterEvent {
+		ew.End = &t
+	}
+}
+
+// Describes the window relative to the start of the run.
+func (ew *eventWindow) describe(runStart time.Time) string {
+	switch {
+	case ew.Start == nil:
+		return "never started"
+	case ew.End == nil:
+		return fmt.Sprintf("started %v into the run and never finished", ew.Start.Sub(runStart))
+	}
+	return fmt.Sprintf("ran from %v to %v into the run", ew.Start.Sub(runStart), ew.End.Sub(runStart))
+}
+
+func (e *Event) String() string {
+	return quotedStruct(e)
+}
+
+func (e *Event) run(db Database) {
+	if e.Command != "" {
+		output, err := exec.Command("sh", "-c", e.Command).CombinedOutput()
+		if err != nil {
+			log.Printf("error in event %s command: %v\n%s", strconv.Quote(e.Name), err, output)
+		}
+		return
+	}
+
+	for _, query := range e.Queries {
+		if _, err := db.RunQuery(context.Background(), nil, query, nil); err != nil {
+			log.Printf("error in event %s query %s: %v",
+				strconv.Quote(e.Name), strconv.Quote(query), err)
+			return
+		}
+	}
+}
+
+/*
+ * Runs each of the events at its start on a separate connection, announcing
+ * when each event begins and ends on phases. Phases must have room for two
+ * announcements per event, since they may be made after the results are
+ * no longer being processed.
+ */
+func runEvents(ctx context.Context, db Database, events []*Event, phases chan<- eventPhase) {
+	for _, event := range events {
+		go func(e *Event) {
+			select {
+			case <-ctx.Done():
+				return
+			case <-clockAfter(e.Start):
+			}
+
+			log.Printf("Starting event %s", strconv.Quote(e.Name))
+			phases <- eventPhase{e.Name, duringEvent}
+			start := clock.Now()
+			e.run(db)
+			log.Printf("Finished event %s after %v", strconv.Quote(e.Name), clockSince(start))
+			phases <- eventPhase{e.Name, afterEvent}
+		}(event)
+	}
+}
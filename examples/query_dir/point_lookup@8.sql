@@ -0,0 +1 @@
+select * from t where id = 1;
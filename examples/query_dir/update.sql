@@ -0,0 +1 @@
+update t set a = a + 1 where id = 1;
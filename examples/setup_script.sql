@@ -0,0 +1,5 @@
+create database if not exists dbbench_script;
+use dbbench_script;
+set session sql_mode = 'STRICT_ALL_TABLES';
+create table t(a int primary key, b varchar(20));
+insert into t values (1, 'one'), (2, 'two');
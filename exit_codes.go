@@ -0,0 +1,49 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"log"
+	"os"
+)
+
+/*
+ * The exit codes of dbbench, by what went wrong, so that automation can tell
+ * failures apart without parsing the logs.
+ */
+const (
+	exitSuccess = 0
+	// Invalid flags or config (also used by log.Fatal).
+	exitUsage = 1
+	// Could not connect to the database.
+	exitConnectionFailure = 2
+	// A query failed with an error not in accept-errors, or a setup or
+	// teardown query failed.
+	exitQueryErrors = 3
+	// The run completed but failed a run guard (e.g. min-duration).
+	exitInvalidRun = 4
+	// The run was stopped by an interrupt.
+	exitInterrupted = 5
+	// The require-query of a job was not met when it started.
+	exitPreconditionFailed = 6
+)
+
+// Like log.Fatalf, but exits with the given code.
+func fatalf(code int, format string, v ...interface{}) {
+	log.Printf(format, v...)
+	os.Exit(code)
+}
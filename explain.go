@@ -0,0 +1,104 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Prefixes that run a query and report its plan with timings, by driver.
+var explainAnalyzePrefixes = map[string]string{
+	"mysql":    "explain analyze ",
+	"postgres": "explain analyze ",
+}
+
+/*
+ * Reruns a random sample of the queries of a job with EXPLAIN ANALYZE and
+ * records the plans along with the latency of the sampled execution.
+ */
+type explainSampler struct {
+	rate   float64
+	prefix string
+	w      *SafeCSVWriter // The plans are logged if nil.
+	logf   func(format string, v ...interface{})
+
+	wg sync.WaitGroup
+	// Set while a plan is being captured, so that sampling never piles up
+	// extra load on the database.
+	capturing int32
+}
+
+func newExplainSampler(rate float64, prefix string, w *SafeCSVWriter,
+	logf func(format string, v ...interface{})) *explainSampler {
+	return &explainSampler{rate: rate, prefix: prefix, w: w, logf: logf}
+}
+
+/*
+ * Maybe captures the plan of the query in the background, on a separate
+ * connection with the same args. Start and elapsed describe the execution
+ * that was sampled; start matches the query-stats-file.
+ */
+func (es *explainSampler) Sample(db Database, name string, start, elapsed time.Duration,
+	query string, args []interface{}) {
+
+	if rand.Float64() >= es.rate || !atomic.CompareAndSwapInt32(&es.capturing, 0, 1) {
+		return
+	}
+
+	es.wg.Add(1)
+	go func() {
+		defer es.wg.Done()
+		defer atomic.StoreInt32(&es.capturing, 0)
+
+		var buf bytes.Buffer
+		if _, err := db.RunQuery(context.Background(), NewSafeCSVWriterTo(&buf), es.prefix+query, args); err != nil {
+			es.logf("%s: error explaining %s: %v", name, strconv.Quote(query), err)
+			return
+		}
+
+		if es.w == nil {
+			es.logf("%s: %s took %v; plan:\n%s",
+				name, strconv.Quote(query), elapsed, buf.String())
+			return
+		}
+		err := es.w.Write([]string{
+			name,
+			strconv.FormatInt(start.Nanoseconds()/1000, 10),
+			strconv.FormatInt(elapsed.Nanoseconds()/1000, 10),
+			query,
+			buf.String(),
+		})
+		if err == nil {
+			es.w.Flush()
+			err = es.w.Error()
+		}
+		if err != nil {
+			es.logf("%s: error writing plan of %s: %v", name, strconv.Quote(query), err)
+		}
+	}()
+}
+
+// Waits for any plan being captured.
+func (es *explainSampler) Wait() {
+	es.wg.Wait()
+}
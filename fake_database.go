@@ -0,0 +1,215 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+/*
+ * A database flavor that does not connect to anything. Queries sleep for a
+ * synthetic latency and return synthetic rows, which allows validating
+ * runfiles and measuring the overhead of dbbench itself.
+ *
+ * It is configured by the connection parameters, e.g.
+ *
+ *     -driver=fake -params="latency=1ms&distribution=exponential&rows=10"
+ */
+type fakeDatabaseFlavor struct{}
+
+type fakeDb struct {
+	latency        time.Duration
+	distribution   string
+	rows           int64
+	connectLatency time.Duration
+}
+
+func (fdf *fakeDatabaseFlavor) Connect(cc *ConnectionConfig) (Database, error) {
+	params, err := url.ParseQuery(cc.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	db := &fakeDb{distribution: "constant"}
+	if v := params.Get("latency"); v != "" {
+		if db.latency, err = time.ParseDuration(v); err != nil {
+			return nil, err
+		}
+	}
+	if v := params.Get("distribution"); v != "" {
+		db.distribution = v
+	}
+	if v := params.Get("rows"); v != "" {
+		if db.rows, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, err
+		}
+	}
+	if v := params.Get("connect-latency"); v != "" {
+		if db.connectLatency, err = time.ParseDuration(v); err != nil {
+			return nil, err
+		}
+	}
+
+	switch db.distribution {
+	case "constant", "uniform", "exponential":
+	default:
+		return nil, fmt.Errorf("invalid fake latency distribution %s",
+			strconv.Quote(db.distribution))
+	}
+
+	log.Printf("Using fake database with %v %s latency and %d rows",
+		db.latency, db.distribution, db.rows)
+	return db, nil
+}
+
+func (fdf *fakeDatabaseFlavor) CheckQuery(q string) error {
+	return checkSQLQuery(q)
+}
+
+func (fdf *fakeDatabaseFlavor) SplitQueries(contents string) []string {
+	return splitOnSemicolons(contents)
+}
+
+func (fdf *fakeDatabaseFlavor) ErrorCode(e error) (string, error) {
+	return "", ErrorCodesUnsupported
+}
+
+func (fdf *fakeDatabaseFlavor) SupportsNamedArgs() bool {
+	return true
+}
+
+func (fdf *fakeDatabaseFlavor) CostModel(rates LinearCostModel) CostModel {
+	return defaultCostModel(rates)
+}
+
+func (fdf *fakeDatabaseFlavor) HasErrorCodes() bool {
+	return false
+}
+
+func (fdf *fakeDatabaseFlavor) RetryableErrorCodes() []string {
+	return nil
+}
+
+func (fdf *fakeDatabaseFlavor) Describe() string {
+	return "no database, queries sleep and return synthetic rows, configured by " +
+		"params latency=<duration>&distribution=constant|uniform|exponential&rows=<count>" +
+		"&connect-latency=<duration>"
+}
+
+func (db *fakeDb) sampleLatency() time.Duration {
+	switch db.distribution {
+	case "uniform":
+		// Uniform between 0 and twice the mean.
+		return time.Duration(rand.Int63n(2*int64(db.latency) + 1))
+	case "exponential":
+		return time.Duration(rand.ExpFloat64() * float64(db.latency))
+	default:
+		return db.latency
+	}
+}
+
+func (db *fakeDb) RunQuery(ctx context.Context, w *SafeCSVWriter, q string, args []interface{}) (int64, error) {
+	return db.RunQueryWithOptions(ctx, w, q, args, QueryOptions{})
+}
+
+func (db *fakeDb) RunQueryWithOptions(ctx context.Context, w *SafeCSVWriter, q string, args []interface{}, opts QueryOptions) (int64, error) {
+	latency := db.sampleLatency()
+	if opts.Timeout > 0 && latency > opts.Timeout {
+		if err := clockSleepContext(ctx, opts.Timeout); err != nil {
+			return 0, err
+		}
+		return 0, &QueryTimeoutError{opts.Timeout}
+	}
+	if err := clockSleepContext(ctx, latency); err != nil {
+		return 0, err
+	}
+
+	rows := db.rows
+	var err error
+	if opts.MaxRows > 0 && rows > opts.MaxRows {
+		rows, err = opts.MaxRows, &MaxRowsError{opts.MaxRows}
+	}
+
+	if w != nil {
+		for i := int64(0); i < rows; i++ {
+			if err := w.WriteResultRow(1, []string{strconv.FormatInt(i, 10), q}); err != nil {
+				return 0, err
+			}
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return 0, err
+		}
+	}
+	return rows, err
+}
+
+func (db *fakeDb) RunScript(statements []string) error {
+	for _, statement := range statements {
+		if _, err := db.RunQuery(context.Background(), nil, statement, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Transactions of the fake database only run their queries.
+func (db *fakeDb) Begin() (Transaction, error) {
+	return &fakeTx{db}, nil
+}
+
+// Sessions of the fake database have no state.
+func (db *fakeDb) Session() (Session, error) {
+	return &fakeSession{db}, nil
+}
+
+// New sessions of the fake database take the connect-latency to open.
+func (db *fakeDb) NewSession(ctx context.Context) (Session, error) {
+	if err := clockSleepContext(ctx, db.connectLatency); err != nil {
+		return nil, err
+	}
+	return &fakeSession{db}, nil
+}
+
+func (db *fakeDb) Close() {
+}
+
+type fakeSession struct {
+	*fakeDb
+}
+
+func (s *fakeSession) Close() error {
+	return nil
+}
+
+type fakeTx struct {
+	*fakeDb
+}
+
+func (tx *fakeTx) Commit() error {
+	return nil
+}
+
+func (tx *fakeTx) Rollback() error {
+	return nil
+}
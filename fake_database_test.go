@@ -0,0 +1,331 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestFakeDatabase(t *testing.T) {
+	df := supportedDatabaseFlavors["fake"]
+	db, err := df.Connect(&ConnectionConfig{Params: "latency=1ms&rows=3"})
+	if err != nil {
+		t.Fatalf("Error connecting to fake database: %v", err)
+	}
+	defer db.Close()
+
+	var buf bytes.Buffer
+	start := time.Now()
+	rows, err := db.RunQuery(context.Background(), NewSafeCSVWriterTo(&buf), "select 1", nil)
+	if err != nil {
+		t.Fatalf("Error running query: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Millisecond {
+		t.Errorf("Expected query to take at least 1ms but took %v", elapsed)
+	}
+	if rows != 3 {
+		t.Errorf("Expected 3 rows but got %d", rows)
+	}
+	if expected := "0,select 1\n1,select 1\n2,select 1\n"; buf.String() != expected {
+		t.Errorf("Expected results %q but got %q", expected, buf.String())
+	}
+
+	for _, params := range []string{"latency=fast", "distribution=bimodal", "rows=many"} {
+		if _, err := df.Connect(&ConnectionConfig{Params: params}); err == nil {
+			t.Errorf("Unexpected success connecting with %q", params)
+		}
+	}
+}
+
+func TestMaxRows(t *testing.T) {
+	db, err := supportedDatabaseFlavors["fake"].Connect(&ConnectionConfig{Params: "latency=0s&rows=3"})
+	if err != nil {
+		t.Fatalf("Error connecting to fake database: %v", err)
+	}
+	defer db.Close()
+
+	job := &Job{Name: "test", MaxRows: 2}
+	qi := queryInvocation{query: "select 1"}
+	if rows, err := job.runQuery(context.Background(), db, nil, qi); rows != 2 {
+		t.Errorf("Expected 2 rows but got %d", rows)
+	} else if _, ok := err.(*MaxRowsError); !ok {
+		t.Errorf("Expected max rows error but got %v", err)
+	}
+
+	job.MaxRowsTruncate = true
+	if rows, err := job.runQuery(context.Background(), db, nil, qi); rows != 2 || err != nil {
+		t.Errorf("Expected 2 rows and no error but got %d, %v", rows, err)
+	}
+
+	ec := make(ErrorCounts)
+	if err := ec.Add(&MaxRowsError{2}, "select 1", supportedDatabaseFlavors["fake"], nil); err != nil {
+		t.Errorf("Error counting max rows error: %v", err)
+	} else if _, ok := ec[maxRowsErrorCode]; !ok {
+		t.Errorf("Expected max rows error to be counted as %s", maxRowsErrorCode)
+	}
+}
+
+func TestQueryTimeout(t *testing.T) {
+	db, err := supportedDatabaseFlavors["fake"].Connect(&ConnectionConfig{Params: "latency=1s"})
+	if err != nil {
+		t.Fatalf("Error connecting to fake database: %v", err)
+	}
+	defer db.Close()
+
+	job := &Job{Name: "test", QueryTimeout: time.Millisecond}
+	start := time.Now()
+	if _, err := job.runQuery(context.Background(), db, nil, queryInvocation{query: "select 1"}); err == nil {
+		t.Errorf("Expected the query to time out")
+	} else if code, _ := errorCode(err, supportedDatabaseFlavors["fake"]); code != queryTimeoutErrorCode {
+		t.Errorf("Expected the timeout to be counted as %s but got %s", queryTimeoutErrorCode, code)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected the query to give up after 1ms but took %v", elapsed)
+	}
+}
+
+func TestInvokeCancelled(t *testing.T) {
+	df := supportedDatabaseFlavors["fake"]
+	db, err := df.Connect(&ConnectionConfig{Params: "latency=1s"})
+	if err != nil {
+		t.Fatalf("Error connecting to fake database: %v", err)
+	}
+	defer db.Close()
+
+	ji := &jobInvocation{name: "test", queries: []queryInvocation{{query: "select 1"}}}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if jr := ji.Invoke(ctx, db, df, &Job{Name: "test"}, 0); jr != nil {
+		t.Errorf("Expected the cancelled invocation not to be counted but got %v", jr)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected the query to be cancelled after 1ms but took %v", elapsed)
+	}
+}
+
+func TestInvokeNewConnection(t *testing.T) {
+	df := supportedDatabaseFlavors["fake"]
+	db, err := df.Connect(&ConnectionConfig{Params: "latency=1ms&connect-latency=50ms"})
+	if err != nil {
+		t.Fatalf("Error connecting to fake database: %v", err)
+	}
+	defer db.Close()
+
+	ji := &jobInvocation{name: "test", queries: []queryInvocation{{query: "select 1"}}}
+	jr := ji.Invoke(context.Background(), db, df, &Job{Name: "test", NewConnection: true}, 0)
+	if jr.Connect < 50*time.Millisecond {
+		t.Errorf("Expected connecting to take at least 50ms but took %v", jr.Connect)
+	}
+	if jr.Elapsed >= jr.Connect {
+		t.Errorf("Expected the query latency %v not to count the connect latency %v", jr.Elapsed, jr.Connect)
+	}
+	if ji.session == nil {
+		t.Errorf("Expected the query to run on the new connection")
+	}
+}
+
+// A database that cannot be reached to open new connections.
+type unreachableDb struct {
+	Database
+}
+
+func (unreachableDb) NewSession(ctx context.Context) (Session, error) {
+	return nil, &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+}
+
+func TestInvokeConnectError(t *testing.T) {
+	db, err := supportedDatabaseFlavors["fake"].Connect(&ConnectionConfig{Params: "latency=1ms"})
+	if err != nil {
+		t.Fatalf("Error connecting to fake database: %v", err)
+	}
+	defer db.Close()
+
+	// The mysql flavor finds no error code in a dial error.
+	df := supportedDatabaseFlavors["mysql"]
+	ji := &jobInvocation{name: "test", queries: []queryInvocation{{query: "select 1"}}}
+	jr := ji.Invoke(context.Background(), unreachableDb{db}, df, &Job{Name: "test", NewConnection: true}, 0)
+	ec, ok := jr.Errors[connectErrorCode]
+	if !ok || ec.errorsPerQuery["CONNECT"] != 1 {
+		t.Fatalf("Expected the dial error to be counted as %s of CONNECT, got %v", connectErrorCode, jr.Errors)
+	}
+	config := &Config{AcceptedErrors: Set{connectErrorCode: struct{}{}}}
+	if accepted := jr.Errors.TotalAccepted(config); accepted != 1 {
+		t.Errorf("Expected error=%s to accept the dial error, got %d accepted", connectErrorCode, accepted)
+	}
+}
+
+func TestFollowQuery(t *testing.T) {
+	df := supportedDatabaseFlavors["fake"]
+	ji := &jobInvocation{name: "test", queries: []queryInvocation{{query: "select 1"}}}
+	job := &Job{Name: "test", FollowQueries: []string{"delete 1", "delete 2"}, FollowQueryMinRows: 2}
+	for _, c := range []struct {
+		rows         int64
+		queries      int
+		rowsAffected int64
+	}{
+		{0, 1, 0},
+		{1, 1, 1},
+		{2, 3, 6},
+		{3, 3, 9},
+	} {
+		db := &fakeDb{rows: c.rows}
+		jr := ji.Invoke(context.Background(), db, df, job, 0)
+		if jr.Queries != c.queries || jr.RowsAffected != c.rowsAffected {
+			t.Errorf("For %d rows\n\texpected %d queries and %d rows\n\tbut got %d and %d",
+				c.rows, c.queries, c.rowsAffected, jr.Queries, jr.RowsAffected)
+		}
+	}
+}
+
+func TestPerQueryStats(t *testing.T) {
+	df := supportedDatabaseFlavors["fake"]
+	db := &fakeDb{rows: 2}
+	ji := &jobInvocation{name: "test", queries: []queryInvocation{
+		{query: "select 1"},
+		{query: "select 'a'", template: "select {{rand_string 1}}"},
+	}}
+	job := &Job{Name: "test", FollowQueries: []string{"delete 1"}}
+
+	var js JobStats
+	for i := 0; i < 2; i++ {
+		jr := ji.Invoke(context.Background(), db, df, job, 0)
+		if len(jr.PerQuery) != 3 {
+			t.Fatalf("Expected the timings of 3 queries, got %v", jr.PerQuery)
+		}
+		js.Update(&Config{}, jr)
+	}
+
+	expected := []string{"select 1", "select {{rand_string 1}}", "delete 1"}
+	if len(js.PerQuery) != len(expected) {
+		t.Fatalf("Expected stats of %d queries, got %d", len(expected), len(js.PerQuery))
+	}
+	for i, qs := range js.PerQuery {
+		if qs.Query != expected[i] || qs.Latencies.Count() != 2 || qs.Rows != 4 {
+			t.Errorf("Expected 2 runs of %s with 4 rows, got %d runs of %s with %d rows",
+				expected[i], qs.Latencies.Count(), qs.Query, qs.Rows)
+		}
+	}
+	if !strings.Contains(js.String(), "Queries:\n") {
+		t.Errorf("Expected a per-query table in\n%v", &js)
+	}
+
+	single := &jobInvocation{name: "test", queries: []queryInvocation{{query: "select 1"}}}
+	if jr := single.Invoke(context.Background(), db, df, &Job{Name: "test"}, 0); jr.PerQuery != nil {
+		t.Errorf("Expected no per-query timings for a single query, got %v", jr.PerQuery)
+	}
+}
+
+func TestHotRows(t *testing.T) {
+	job := &Job{Name: "test", Queries: []string{"select ? for update", "update t set a = 1 where id = ?"}, HotRows: 3}
+	seen := make(map[int64]bool)
+	for i := 0; i < 100; i++ {
+		ji, err := job.getNextJobInvocation()
+		if err != nil {
+			t.Fatal(err)
+		}
+		row := ji.queries[0].args[0].(int64)
+		if row < 1 || row > 3 {
+			t.Errorf("Expected a hot row from 1 to 3 but got %d", row)
+		} else if other := ji.queries[1].args[0].(int64); other != row {
+			t.Errorf("Expected both queries to lock row %d but got %d", row, other)
+		}
+		seen[row] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("Expected every hot row to be chosen but got %v", seen)
+	}
+}
+
+func TestJobRunsAfter(t *testing.T) {
+	df := supportedDatabaseFlavors["fake"]
+	db, err := df.Connect(&ConnectionConfig{Params: "latency=1ms"})
+	if err != nil {
+		t.Fatalf("Error connecting to fake database: %v", err)
+	}
+	defer db.Close()
+
+	jobs := map[string]*Job{
+		"load":  {Name: "load", Queries: []string{"select 1"}, QueueDepth: 2, Count: 20},
+		"query": {Name: "query", Queries: []string{"select 2"}, QueueDepth: 2, Count: 20, After: []string{"load"}},
+	}
+	var loaded int
+	for jr := range makeJobResultQueue(context.Background(), db, df, jobs).Results() {
+		if jr.Name == "load" {
+			loaded++
+		} else if loaded < 20 {
+			t.Fatalf("Expected query to run after all 20 invocations of load, but only %d had finished", loaded)
+		}
+	}
+}
+
+func TestCheckRequirement(t *testing.T) {
+	df := supportedDatabaseFlavors["fake"]
+	for _, c := range []struct {
+		params string
+		job    *Job
+		met    bool
+	}{
+		{"rows=0", &Job{}, true},
+		{"rows=0", &Job{RequireQuery: "select 1"}, false},
+		{"rows=3", &Job{RequireQuery: "select 1"}, true},
+		// The first column of the first row of the fake database is 0.
+		{"rows=3", &Job{RequireQuery: "select count(*) from t", RequireRows: 1}, false},
+		{"rows=0", &Job{RequireQuery: "select count(*) from t", RequireRows: 1}, false},
+	} {
+		db, err := df.Connect(&ConnectionConfig{Params: "latency=0s&" + c.params})
+		if err != nil {
+			t.Fatalf("Error connecting to fake database: %v", err)
+		}
+		if err := c.job.checkRequirement(db); (err == nil) != c.met {
+			t.Errorf("Expected require-query %q with %s to be met %v, but got %v",
+				c.job.RequireQuery, c.params, c.met, err)
+		}
+		db.Close()
+	}
+}
+
+func TestUnmetRequirementAbortsRun(t *testing.T) {
+	df := supportedDatabaseFlavors["fake"]
+	db, err := df.Connect(&ConnectionConfig{Params: "latency=1ms&rows=0"})
+	if err != nil {
+		t.Fatalf("Error connecting to fake database: %v", err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	jobs := map[string]*Job{
+		"load":  {Name: "load", Queries: []string{"select 1"}, QueueDepth: 1, RequireQuery: "select 1", cancelRun: cancel},
+		"other": {Name: "other", Queries: []string{"select 2"}, QueueDepth: 1, Start: time.Hour, cancelRun: cancel},
+	}
+	for range makeJobResultQueue(ctx, db, df, jobs).Results() {
+		t.Fatalf("Unexpected result of a run whose require-query was not met")
+	}
+	if _, ok := jobs["load"].failure.(unmetRequirementError); !ok {
+		t.Errorf("Expected the unmet require-query to fail the job, got %v", jobs["load"].failure)
+	}
+	if jobs["other"].failure != nil {
+		t.Errorf("Expected the other job to be stopped without failing, got %v", jobs["other"].failure)
+	}
+}
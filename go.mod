@@ -6,6 +6,7 @@ require (
 	github.com/awreece/goini v0.0.0-20170814002257-6b3ccd8204f1
 	github.com/denisenkom/go-mssqldb v0.0.0-20200620013148-b91950f658ec
 	github.com/go-sql-driver/mysql v1.5.0
+	github.com/gocql/gocql v1.7.0
 	github.com/lib/pq v1.7.0
 	github.com/vertica/vertica-sql-go v1.1.0
 	golang.org/x/net v0.0.0-20200707034311-ab3426394381
@@ -0,0 +1,116 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A bound on a percentile of the latencies of a job, e.g. p99:20ms.
+type slaBound struct {
+	Percentile float64
+	Latency    time.Duration
+}
+
+func parseSLABound(v string) (slaBound, error) {
+	parts := strings.SplitN(v, ":", 2)
+	if len(parts) != 2 {
+		return slaBound{}, fmt.Errorf("invalid sla %s, expected <percentile>:<latency>", strconv.Quote(v))
+	}
+	var percentiles PercentilesFlagValue
+	if err := percentiles.Set(parts[0]); err != nil {
+		return slaBound{}, err
+	} else if len(percentiles) != 1 {
+		return slaBound{}, fmt.Errorf("invalid sla %s, expected a single percentile", strconv.Quote(v))
+	}
+	latency, err := time.ParseDuration(parts[1])
+	if err != nil {
+		return slaBound{}, err
+	} else if latency <= 0 {
+		return slaBound{}, errors.New("sla latency must be positive")
+	}
+	return slaBound{percentiles[0], latency}, nil
+}
+
+func (sb slaBound) String() string {
+	return fmt.Sprintf("p%s:%v", strconv.FormatFloat(sb.Percentile, 'g', -1, 64), sb.Latency)
+}
+
+/*
+ * Checks the run against the min-duration, max-duration, min-count and sla
+ * guards, and for jobs that aborted the run (e.g. with too many errors, or
+ * by running out of args with query-args-mode=error), returning why the run
+ * is invalid (if it is). Stats may be nil if per job stats are not available.
+ */
+func checkRunGuards(config *Config, elapsed time.Duration, stats map[string]*JobStats) []string {
+	var problems []string
+	if config.MinDuration > 0 && elapsed < config.MinDuration {
+		problems = append(problems, fmt.Sprintf(
+			"run took %v, less than min-duration %v", elapsed, config.MinDuration))
+	}
+	if config.MaxDuration > 0 && elapsed > config.MaxDuration {
+		problems = append(problems, fmt.Sprintf(
+			"run took %v, more than max-duration %v", elapsed, config.MaxDuration))
+	}
+
+	names := make([]string, 0, len(config.Jobs))
+	for name := range config.Jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		job := config.Jobs[name]
+		if job.failure != nil {
+			problems = append(problems, fmt.Sprintf(
+				"job %s aborted the run: %v", strconv.Quote(name), job.failure))
+		}
+		if job.errorBudget != nil {
+			if exceeded := job.errorBudget.Exceeded(); exceeded != "" {
+				problems = append(problems, fmt.Sprintf(
+					"job %s aborted the run after %s", strconv.Quote(name), exceeded))
+			}
+		}
+		if stats == nil {
+			continue
+		}
+		var count uint64
+		if s, ok := stats[name]; ok {
+			count = uint64(s.jobStats.Transactions.Count())
+		}
+		if count < job.MinCount {
+			problems = append(problems, fmt.Sprintf(
+				"job %s completed %d transactions, less than min-count %d",
+				strconv.Quote(name), count, job.MinCount))
+		}
+		if s, ok := stats[name]; ok && s.jobStats.Latencies.Count() > 0 {
+			for _, sla := range job.SLAs {
+				if latency := s.jobStats.Latencies.Percentiles(sla.Percentile)[0]; latency > sla.Latency {
+					problems = append(problems, fmt.Sprintf(
+						"job %s had a p%s latency of %v, over its sla %v", strconv.Quote(name),
+						strconv.FormatFloat(sla.Percentile, 'g', -1, 64), latency, sla))
+				}
+			}
+		}
+	}
+	return problems
+}
@@ -0,0 +1,117 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckRunGuards(t *testing.T) {
+	config := &Config{
+		MinDuration: time.Minute,
+		MaxDuration: time.Hour,
+		Jobs: map[string]*Job{
+			"short": &Job{Name: "short", MinCount: 10},
+			"fine":  &Job{Name: "fine", MinCount: 1},
+		},
+	}
+	stats := map[string]*JobStats{"fine": &JobStats{}, "short": &JobStats{}}
+	for i := 0; i < 5; i++ {
+		stats["fine"].jobStats.Transactions.Add(1)
+		stats["short"].jobStats.Transactions.Add(1)
+	}
+
+	if problems := checkRunGuards(config, 10*time.Minute, stats); len(problems) != 1 {
+		t.Errorf("Expected only the short job to be invalid, got %v", problems)
+	}
+	if problems := checkRunGuards(config, time.Second, nil); len(problems) != 1 {
+		t.Errorf("Expected only min-duration to be invalid, got %v", problems)
+	}
+	if problems := checkRunGuards(config, 2*time.Hour, nil); len(problems) != 1 {
+		t.Errorf("Expected only max-duration to be invalid, got %v", problems)
+	}
+}
+
+func TestCheckRunGuardsSLA(t *testing.T) {
+	sla, err := parseSLABound("p99:20ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{Jobs: map[string]*Job{"test": &Job{Name: "test", SLAs: []slaBound{sla}}}}
+	stats := map[string]*JobStats{"test": &JobStats{}}
+	for i := 0; i < 100; i++ {
+		stats["test"].jobStats.Latencies.Add(10 * time.Millisecond)
+	}
+
+	if problems := checkRunGuards(config, time.Second, stats); len(problems) != 0 {
+		t.Errorf("Expected the p99 within the sla to be valid, got %v", problems)
+	}
+	// 2 of 102 is more than 1%, so the p99 is now 30ms.
+	stats["test"].jobStats.Latencies.Add(30 * time.Millisecond)
+	stats["test"].jobStats.Latencies.Add(30 * time.Millisecond)
+	if problems := checkRunGuards(config, time.Second, stats); len(problems) != 1 {
+		t.Errorf("Expected the p99 over the sla to be invalid, got %v", problems)
+	}
+
+	for _, v := range []string{"p99", "p99:", "p101:1ms", "p50,p99:1ms", "p99:-1ms", "p99:fast"} {
+		if _, err := parseSLABound(v); err == nil {
+			t.Errorf("Expected an error parsing sla %q", v)
+		}
+	}
+}
+
+func TestErrorBudget(t *testing.T) {
+	result := func(queries int, errors uint64) *JobResult {
+		return &JobResult{Queries: queries,
+			Errors: ErrorCounts{"1205": errorCounts{errorsPerQuery{"select 1": errors}, nil}}}
+	}
+
+	var cancelled int
+	job := &Job{Name: "budgeted", MaxErrors: 3}
+	job.errorBudget = newErrorBudget(job, func() { cancelled++ })
+	config := &Config{Jobs: map[string]*Job{"budgeted": job}}
+
+	job.errorBudget.Observe(result(10, 3))
+	if cancelled != 0 || len(checkRunGuards(config, time.Second, nil)) != 0 {
+		t.Errorf("Expected max-errors not to be exceeded with 3 errors")
+	}
+	job.errorBudget.Observe(result(1, 1))
+	job.errorBudget.Observe(result(1, 1))
+	if cancelled != 1 {
+		t.Errorf("Expected the run to be cancelled once, got %d", cancelled)
+	}
+	if problems := checkRunGuards(config, time.Second, nil); len(problems) != 1 {
+		t.Errorf("Expected the job to invalidate the run, got %v", problems)
+	}
+
+	cancelled = 0
+	job = &Job{Name: "rated", MaxErrorRate: 5}
+	job.errorBudget = newErrorBudget(job, func() { cancelled++ })
+	job.errorBudget.Observe(result(10, 5))
+	if cancelled != 0 {
+		t.Errorf("Expected max-error-rate not to be checked before %d queries", errorRateMinQueries)
+	}
+	job.errorBudget.Observe(result(90, 0))
+	if cancelled != 0 {
+		t.Errorf("Expected max-error-rate not to be exceeded with 5%% errors")
+	}
+	job.errorBudget.Observe(result(1, 1))
+	if cancelled != 1 || job.errorBudget.Exceeded() == "" {
+		t.Errorf("Expected max-error-rate to be exceeded with 6 errors in 101 queries")
+	}
+}
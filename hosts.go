@@ -0,0 +1,226 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// One address of the comma separated list of hosts of a ConnectionConfig.
+type hostPort struct {
+	host string
+	port int
+}
+
+func (hp hostPort) String() string {
+	return net.JoinHostPort(hp.host, strconv.Itoa(hp.port))
+}
+
+/*
+ * Parses a comma separated list of hosts, each a name or IP address with an
+ * optional port, e.g. "db1:5433,db2" or "[::1]:3307". An IPv6 literal without
+ * a port may also be given without brackets. Hosts without a port use
+ * defaultPort, and an empty list is localhost.
+ */
+func parseHosts(hosts string, defaultPort int) ([]hostPort, error) {
+	if hosts == "" {
+		return []hostPort{{"localhost", defaultPort}}, nil
+	}
+
+	var parsed []hostPort
+	for _, h := range strings.Split(hosts, ",") {
+		h = strings.TrimSpace(h)
+		hp := hostPort{h, defaultPort}
+		var port string
+		if strings.HasPrefix(h, "[") {
+			end := strings.Index(h, "]")
+			if end < 0 || (end+1 < len(h) && h[end+1] != ':') {
+				return nil, fmt.Errorf("invalid host %s", strconv.Quote(h))
+			}
+			hp.host, port = h[1:end], strings.TrimPrefix(h[end+1:], ":")
+		} else if strings.Count(h, ":") == 1 {
+			i := strings.Index(h, ":")
+			hp.host, port = h[:i], h[i+1:]
+		}
+		if port != "" {
+			var err error
+			if hp.port, err = strconv.Atoi(port); err != nil {
+				return nil, fmt.Errorf("invalid port in host %s", strconv.Quote(h))
+			}
+		}
+		if hp.host == "" {
+			return nil, fmt.Errorf("empty host in %s", strconv.Quote(hosts))
+		}
+		parsed = append(parsed, hp)
+	}
+	return parsed, nil
+}
+
+/*
+ * Formats the hosts as a comma separated list of host:port pairs, bracketing
+ * IPv6 literals. The hosts are assumed to have been checked by parseHosts.
+ */
+func formatHosts(hosts string, defaultPort int) string {
+	parsed, err := parseHosts(hosts, defaultPort)
+	if err != nil {
+		return hosts
+	}
+	addrs := make([]string, 0, len(parsed))
+	for _, hp := range parsed {
+		addrs = append(addrs, hp.String())
+	}
+	return strings.Join(addrs, ",")
+}
+
+/*
+ * The drivers that connect to the first of several hosts that accepts the
+ * connection, by the name of the driver of the database flavor. Other flavors
+ * only support a single host.
+ */
+var multiHostDrivers = map[string]string{
+	"postgres": "dbbench-postgres-multi-host",
+}
+
+func init() {
+	sql.Register(multiHostDrivers["postgres"], &multiHostDriver{&pq.Driver{}})
+}
+
+/*
+ * Opens a connection to the first host of a multi-host Postgres URL, e.g.
+ * postgres://user@db1:5432,db2:5432/test?target_session_attrs=read-write,
+ * that accepts it. With target_session_attrs=read-write, hosts that only
+ * allow reads (e.g. standbys) are skipped. The driver of Postgres is older
+ * than its support for these URLs.
+ */
+type multiHostDriver struct {
+	d driver.Driver
+}
+
+func (mhd *multiHostDriver) Open(dsn string) (driver.Conn, error) {
+	dsns, readWrite, err := splitMultiHostDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []string
+	for _, single := range dsns {
+		conn, err := mhd.d.Open(single)
+		if err == nil && readWrite {
+			if err = checkWritable(conn); err != nil {
+				conn.Close()
+			}
+		}
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err.Error())
+	}
+	return nil, fmt.Errorf("could not connect to any host: %s", strings.Join(errs, "; "))
+}
+
+/*
+ * Splits a multi-host Postgres URL into a URL for each host, and returns
+ * whether the connection must allow writes.
+ */
+func splitMultiHostDSN(dsn string) ([]string, bool, error) {
+	const scheme = "postgres://"
+	if !strings.HasPrefix(dsn, scheme) {
+		return nil, false, fmt.Errorf("expected a %s URL", scheme)
+	}
+	rest := strings.TrimPrefix(dsn, scheme)
+
+	var rawQuery string
+	if i := strings.Index(rest, "?"); i >= 0 {
+		rest, rawQuery = rest[:i], rest[i+1:]
+	}
+	params, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, false, err
+	}
+	var readWrite bool
+	switch attrs := params.Get("target_session_attrs"); attrs {
+	case "", "any":
+	case "read-write":
+		readWrite = true
+	default:
+		return nil, false, fmt.Errorf("unsupported target_session_attrs %s", strconv.Quote(attrs))
+	}
+	// The driver would pass it on to the server as a setting.
+	params.Del("target_session_attrs")
+
+	var userinfo string
+	if i := strings.LastIndex(rest, "@"); i >= 0 {
+		userinfo, rest = rest[:i+1], rest[i+1:]
+	}
+	hosts, path := rest, ""
+	if i := strings.Index(rest, "/"); i >= 0 {
+		hosts, path = rest[:i], rest[i:]
+	}
+
+	var dsns []string
+	for _, host := range strings.Split(hosts, ",") {
+		u := scheme + userinfo + host + path
+		if len(params) > 0 {
+			u += "?" + params.Encode()
+		}
+		dsns = append(dsns, u)
+	}
+	return dsns, readWrite, nil
+}
+
+var readOnlyHostError = errors.New("host only allows reads")
+
+// Returns readOnlyHostError unless the connection allows writes.
+func checkWritable(conn driver.Conn) error {
+	queryer, ok := conn.(driver.Queryer)
+	if !ok {
+		return errors.New("cannot check whether the host allows writes")
+	}
+	rows, err := queryer.Query("show transaction_read_only", nil)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	values := make([]driver.Value, len(rows.Columns()))
+	if err := rows.Next(values); err == io.EOF {
+		return errors.New("no result checking whether the host allows writes")
+	} else if err != nil {
+		return err
+	}
+	var readOnly string
+	switch v := values[0].(type) {
+	case []byte:
+		readOnly = string(v)
+	case string:
+		readOnly = v
+	}
+	if readOnly != "off" {
+		return readOnlyHostError
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestParseHosts(t *testing.T) {
+	for hosts, expected := range map[string]string{
+		"":                    "localhost:5432",
+		"db1":                 "db1:5432",
+		"db1:5433":            "db1:5433",
+		"::1":                 "[::1]:5432",
+		"[::1]":               "[::1]:5432",
+		"[fd00::2]:5433":      "[fd00::2]:5433",
+		"db1:5433, db2,[::1]": "db1:5433,db2:5432,[::1]:5432",
+	} {
+		if _, err := parseHosts(hosts, 5432); err != nil {
+			t.Errorf("Unexpected error parsing %q: %v", hosts, err)
+		} else if actual := formatHosts(hosts, 5432); actual != expected {
+			t.Errorf("For %q expected %q, got %q", hosts, expected, actual)
+		}
+	}
+
+	for _, hosts := range []string{"db1:port", "[::1", "[::1]5432", "db1,,db2", ":5432"} {
+		if _, err := parseHosts(hosts, 5432); err == nil {
+			t.Errorf("Expected an error parsing %q", hosts)
+		}
+	}
+}
+
+func TestDataSourceNamesBracketIPv6(t *testing.T) {
+	cc := &ConnectionConfig{Host: "fd00::2", Port: 3307}
+	if dsn := mySQLDataSourceName(cc); dsn != "root:@tcp([fd00::2]:3307)/?"+
+		"allowAllFiles=true&interpolateParams=true&allowCleartextPasswords=true&tls=preferred" {
+		t.Errorf("Unexpected MySQL DSN %s", dsn)
+	}
+
+	cc = &ConnectionConfig{Host: "[fd00::2]:5433,db2", Database: "test", Params: "application_name=test"}
+	if dsn := postgresDataSourceName(cc); dsn != "postgres://root:@[fd00::2]:5433,db2:5432/test?application_name=test" {
+		t.Errorf("Unexpected Postgres DSN %s", dsn)
+	}
+}
+
+func TestSplitMultiHostDSN(t *testing.T) {
+	dsns, readWrite, err := splitMultiHostDSN(
+		"postgres://u:p@ss@db1:5432,[::1]:5433/test?sslmode=disable&target_session_attrs=read-write")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"postgres://u:p@ss@db1:5432/test?sslmode=disable",
+		"postgres://u:p@ss@[::1]:5433/test?sslmode=disable",
+	}
+	if !reflect.DeepEqual(dsns, expected) || !readWrite {
+		t.Errorf("Expected %v and read-write, got %v and %v", expected, dsns, readWrite)
+	}
+
+	if _, _, err := splitMultiHostDSN("postgres://db1,db2/?target_session_attrs=standby"); err == nil {
+		t.Errorf("Expected an error for an unsupported target_session_attrs")
+	}
+}
+
+// A driver whose connections to each host fail, or report whether they are read only.
+type hostsDriver struct {
+	readOnly map[string]string // dsn -> transaction_read_only
+	opened   []string
+}
+
+func (hd *hostsDriver) Open(dsn string) (driver.Conn, error) {
+	hd.opened = append(hd.opened, dsn)
+	readOnly, ok := hd.readOnly[dsn]
+	if !ok {
+		return nil, errors.New("connection refused")
+	}
+	return &hostsConn{readOnly}, nil
+}
+
+type hostsConn struct {
+	readOnly string
+}
+
+func (hc *hostsConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("unsupported")
+}
+func (hc *hostsConn) Close() error              { return nil }
+func (hc *hostsConn) Begin() (driver.Tx, error) { return nil, errors.New("unsupported") }
+
+func (hc *hostsConn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	return &hostsRows{[]string{hc.readOnly}}, nil
+}
+
+type hostsRows struct {
+	values []string
+}
+
+func (hr *hostsRows) Columns() []string { return []string{"transaction_read_only"} }
+func (hr *hostsRows) Close() error      { return nil }
+
+func (hr *hostsRows) Next(dest []driver.Value) error {
+	if len(hr.values) == 0 {
+		return io.EOF
+	}
+	dest[0], hr.values = []byte(hr.values[0]), hr.values[1:]
+	return nil
+}
+
+func TestMultiHostDriver(t *testing.T) {
+	hd := &hostsDriver{readOnly: map[string]string{
+		"postgres://db2/test": "on",
+		"postgres://db3/test": "off",
+	}}
+	mhd := &multiHostDriver{hd}
+
+	conn, err := mhd.Open("postgres://db1,db2,db3/test")
+	if err != nil {
+		t.Fatal(err)
+	} else if conn.(*hostsConn).readOnly != "on" {
+		t.Errorf("Expected a connection to the first host that accepts it")
+	}
+
+	hd.opened = nil
+	conn, err = mhd.Open("postgres://db1,db2,db3/test?target_session_attrs=read-write")
+	if err != nil {
+		t.Fatal(err)
+	} else if conn.(*hostsConn).readOnly != "off" {
+		t.Errorf("Expected a connection to the first host that allows writes")
+	}
+	if len(hd.opened) != 3 {
+		t.Errorf("Expected to try all 3 hosts, tried %v", hd.opened)
+	}
+
+	if _, err := mhd.Open("postgres://db1,db2/test?target_session_attrs=read-write"); err == nil {
+		t.Errorf("Expected an error when no host allows writes")
+	}
+}
@@ -19,74 +19,607 @@ package main
 import (
 	"bufio"
 	"context"
-	"encoding/csv"
+	"fmt"
+	"hash"
 	"io"
 	"log"
+	"math/rand"
+	"os"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"text/template"
 	"time"
 )
 
 type queryInvocation struct {
 	query string
 	args  []interface{}
+
+	// The query as configured, if its template was expanded into query.
+	template string
+
+	// The trace comment of the invocation, with -trace-comments.
+	comment string
+}
+
+// The query the per-query stats of the invocation are kept under.
+func (qi queryInvocation) statement() string {
+	if qi.template != "" {
+		return qi.template
+	}
+	return qi.query
+}
+
+// The query as sent to the server, with the trace comment of the invocation.
+func (qi queryInvocation) issued() string {
+	return qi.comment + qi.query
 }
 
 type jobInvocation struct {
 	name    string
 	queries []queryInvocation
+
+	// When the invocation was supposed to be issued, if it is run at a rate
+	// or replayed from a query log.
+	scheduled time.Time
+
+	// The connection of the worker running the invocation, if the job has a
+	// connection per worker.
+	session Session
+
+	// Whether a query of the invocation was cancelled by the end of the job.
+	cancelled bool
+
+	// The number of the invocation in the run of the job, from 1, and its
+	// trace comment, if -trace-comments is set.
+	seq     int64
+	comment string
 }
 
 type Job struct {
 	Name    string
 	Queries []string
 
+	// From query-dir; each invocation runs the queries of one set instead.
+	QuerySets []QuerySet
+
 	QueueDepth uint64
 	Rate       float64
 	Count      uint64
 	BatchSize  uint64
 
-	QueryLog     io.ReadCloser
-	QueryArgs    *csv.Reader
-	QueryResults *SafeCSVWriter
+	QueryLog           io.ReadCloser
+	QueryLogLateness   time.Duration
+	QueryArgs          QueryArgsReader
+	QueryArgsColumns   []int // Zero based; all columns if empty.
+	QueryArgsEncodings []ColumnEncoding
+	QueryResults       *SafeCSVWriter
+
+	// Where the messages of the job go, if not to the main log.
+	LogFile *os.File
+	logger  *log.Logger
+
+	// How QueryArgs is read (query-args-mode): stop (the default, if
+	// empty), loop, random, or error.
+	QueryArgsMode string
+
+	// Each invocation binds a row id chosen at random from 1..HotRows to
+	// the placeholder of each of its queries, to contend for the rows.
+	HotRows int64
+
+	VerifyRepeatable bool
+
+	MaxRows         int64
+	MaxRowsTruncate bool
+
+	// Cancel a query that runs for longer than this, counting it as an
+	// error with code timeout.
+	QueryTimeout time.Duration
+
+	// Times to retry a query that fails with a retryable error code of the
+	// database flavor (e.g. a serialization failure). With Transaction, the
+	// whole transaction is retried.
+	MaxRetries uint64
+
+	// Run the queries of each invocation, and its follow queries, in a
+	// single transaction.
+	Transaction bool
+
+	// Whether each queue-depth worker runs its queries on a connection of
+	// its own (multi-query-mode=single-connection).
+	SingleConnection bool
+
+	// Run each invocation on the queue-depth worker that owns its key, the
+	// RouteArg-th (from 1) arg of its first query (route-arg). Integer keys
+	// are owned by range, RouteRangeSize consecutive keys to a worker, and
+	// other keys by hash.
+	RouteArg       int
+	RouteRangeSize uint64
+
+	// Open a new connection for each invocation (connection-mode=new),
+	// rather than use one of the pool, to time connecting separately.
+	NewConnection bool
+
+	MinCount uint64
+
+	// The run is invalid if a percentile of the latencies of the job is
+	// over its bound.
+	SLAs []slaBound
+
+	// Abort the run (still running teardown) once the job has more errors,
+	// or a higher percentage of failed queries, than this. Its errors that
+	// are not accepted then do not stop dbbench right away.
+	MaxErrors    uint64
+	MaxErrorRate float64
+	errorBudget  *errorBudget
+
+	// The accept-errors messages of the config, which errors are matched
+	// against before their code is looked up.
+	AcceptedErrorMessages []*regexp.Regexp
+
+	// Cancels the run, once the job fails with failure (e.g. on an
+	// unreadable query-args-file or an unmet require-query).
+	cancelRun context.CancelFunc
+	failure   error
+
+	// Random delay before each invocation of a queue-depth job.
+	JitterMin time.Duration
+	JitterMax time.Duration
+
+	AllResultSets bool
+
+	// Prepare each query once per connection.
+	Prepare bool
+
+	// Run after each invocation whose queries return at least
+	// FollowQueryMinRows rows, e.g. to act on a polled queue.
+	FollowQueries      []string
+	FollowQueryMinRows int64
+
+	// Set by report=false.
+	HideIntermediateStats bool
+
+	// The phases the job runs in, if the runfile has phases.
+	Phases []string
+
+	// Set for the run if the config has an overload-query.
+	overload *overloadGuard
 
-	Start time.Duration
-	Stop  time.Duration
+	// Checked when the job starts, which fails the run unless the query
+	// returns a row, whose first column is at least RequireRows if set.
+	RequireQuery string
+	RequireRows  int64
+
+	UtilizationQuery  string
+	TargetUtilization float64
+	// Adjust the rate to hold the p99 latency of the job at this target.
+	LatencyTarget time.Duration
+
+	// Alert when the p99 latency over the last AlertIntervals stats
+	// intervals exceeds AlertP99.
+	AlertP99       time.Duration
+	AlertIntervals int
+
+	OutlierMultiple     float64
+	OutlierCaptureQuery string
+	outliers            *outlierDetector
+
+	ExplainSampleRate float64
+	ExplainPrefix     string
+	ExplainResults    *SafeCSVWriter
+	explains          *explainSampler
+
+	Start   time.Duration
+	StartAt time.Time
+	Stop    time.Duration
+
+	// Jobs that must finish before this one starts; Start and Stop are then
+	// relative to when the last of them finished.
+	After []string
+
+	// How long after the job starts its results are left out of the final
+	// stats.
+	Warmup time.Duration
+
+	// Offered rate (batches per second) or queue depth that changes over
+	// the run; the stats of each step of the ramp are reported separately.
+	RateRamp        *Ramp
+	ConcurrencyRamp *Ramp
+	rampStart       time.Time
+	rampLoggedStep  int
+
+	// The random streams of the job and of each of its workers, created on
+	// first use so that the seed is only chosen if needed.
+	rng        *rand.Rand
+	workerRngs []*rand.Rand
+
+	// Query -> template, for the queries with {{ actions, and the number of
+	// the current invocation, for seq and the trace comments.
+	templates     map[string]*template.Template
+	invocationSeq int64
+
+	// The number of records read from QueryArgs, and whether it started
+	// over (with query-args-mode=loop).
+	argsRecords uint64
+	argsLooped  bool
+
+	// The error that stopped the job from sending invocations before the
+	// end of its count or duration, if any.
+	invocationErr error
 }
 
 type JobResult struct {
-	Name         string
-	Start        time.Duration
-	Elapsed      time.Duration
-	Queries      int
-	RowsAffected int64
-	Errors       ErrorCounts
+	Name          string
+	Start         time.Duration
+	Elapsed       time.Duration
+	Queries       int
+	RowsAffected  int64
+	Errors        ErrorCounts
+	NonRepeatable int
+	Retries       int
+	Dropped       bool
+	Utilization   float64
+	// Which of the queue-depth workers ran the job, or 0 if the job is not
+	// run by a fixed set of workers.
+	Worker int
+	// How long a scheduled invocation waited in the client before its first
+	// query was sent, which is not counted in Elapsed.
+	QueueWait time.Duration
+	// How long opening the connection of the invocation took, with
+	// connection-mode=new, which is not counted in Elapsed.
+	Connect time.Duration
+	// Each query that succeeded, if the invocation runs more than one.
+	PerQuery []QueryTiming
+	// Whether the invocation started during the warmup of the job, so is
+	// left out of the final stats.
+	Warmup bool
+	// The step of the ramp of the job the invocation started in, or 0 if
+	// the job has no ramp.
+	RampStep int
+	// The number of the invocation in the run of the job, from 1, as in its
+	// trace comment.
+	Seq int64
+	// The queries of the invocation and when it started, only kept if
+	// slowest-invocations is set.
+	invocation []queryInvocation
+	startedAt  time.Time
+}
+
+type QueryTiming struct {
+	Query   string
+	Elapsed time.Duration
+	Rows    int64
+}
+
+// Whether the invocation records the elapsed time and rows of each query.
+func (ji *jobInvocation) timesQueries(job *Job) bool {
+	return len(ji.queries)+len(job.FollowQueries) > 1
+}
+
+func (ji *jobInvocation) addError(ctx context.Context, job *Job, errorCounts ErrorCounts, df DatabaseFlavor, qi queryInvocation, err error) {
+	if ctx.Err() != nil {
+		// The query was cut off rather than failed.
+		ji.cancelled = true
+		return
+	}
+
+	// Attempt to handle the error
+	e := errorCounts.Add(err, qi.query, df, job.AcceptedErrorMessages)
+	if e != nil {
+		// Error handling not available for this DB flavor
+		fatalf(exitQueryErrors, "%v. Error occurred while running %v:\n%v", e, ji.name, err)
+	}
+}
+
+// Either a Database or a Transaction.
+type queryRunner interface {
+	RunQueryWithOptions(ctx context.Context, results *SafeCSVWriter, query string, args []interface{}, opts QueryOptions) (int64, error)
+}
+
+/*
+ * Runs a single query of the job, enforcing max-rows and query-timeout and
+ * reading every result set if all-result-sets is set.
+ */
+func (job *Job) runQuery(ctx context.Context, r queryRunner, w *SafeCSVWriter, qi queryInvocation) (int64, error) {
+	if db, ok := r.(Database); ok && job.MaxRows == 0 && !job.AllResultSets && !job.Prepare && job.QueryTimeout == 0 {
+		return db.RunQuery(ctx, w, qi.issued(), qi.args)
+	}
+
+	opts := QueryOptions{MaxRows: job.MaxRows, AllResultSets: job.AllResultSets, Prepare: job.Prepare,
+		Timeout: job.QueryTimeout}
+	query := qi.issued()
+	if job.Prepare {
+		// A statement is prepared once per connection, not per invocation.
+		query = qi.query
+	}
+	rows, err := r.RunQueryWithOptions(ctx, w, query, qi.args, opts)
+	if _, ok := err.(*MaxRowsError); ok && job.MaxRowsTruncate {
+		err = nil
+	}
+	return rows, err
+}
+
+func (ji *jobInvocation) recordIssued(qi queryInvocation) {
+	if issuedQueries != nil {
+		if err := issuedQueries.Write(clock.Now(), qi.query, qi.args); err != nil {
+			log.Printf("%s: error recording issued query: %v", ji.name, err)
+		}
+	}
+}
+
+// The session of the invocation if it has one, and otherwise the database.
+func (ji *jobInvocation) runner(db Database) queryRunner {
+	if ji.session != nil {
+		return ji.session
+	}
+	return db
+}
+
+/*
+ * Runs the queries of the invocation and returns its result, or nil if the
+ * end of the job (ctx) cancelled one of them. With connection-mode=new, they
+ * run on a connection opened for the invocation.
+ */
+func (ji *jobInvocation) Invoke(ctx context.Context, db Database, df DatabaseFlavor, job *Job, start time.Duration) *JobResult {
+	var connect time.Duration
+	if job.NewConnection {
+		connectStart := clock.Now()
+		s, err := db.NewSession(ctx)
+		connect = clockSince(connectStart)
+		if err != nil {
+			errorCounts := make(ErrorCounts)
+			ji.addError(ctx, job, errorCounts, df, queryInvocation{query: "CONNECT"}, &ConnectError{err})
+			if ji.cancelled {
+				return nil
+			}
+			return &JobResult{Name: ji.name, Start: start, Errors: errorCounts, Connect: connect, Seq: ji.seq}
+		}
+		defer s.Close()
+		ji.session = s
+	}
+
+	var r *JobResult
+	if job.Transaction {
+		r = ji.invokeTransaction(ctx, db, df, job, start)
+	} else {
+		r = ji.invokeQueries(ctx, db, df, job, start)
+	}
+	if r != nil {
+		r.Connect = connect
+		r.Seq = ji.seq
+	}
+	return r
 }
 
-func (ji *jobInvocation) Invoke(db Database, df DatabaseFlavor, results *SafeCSVWriter, start time.Duration) *JobResult {
+func (ji *jobInvocation) invokeQueries(ctx context.Context, db Database, df DatabaseFlavor, job *Job, start time.Duration) *JobResult {
 	var elapsed time.Duration
 	var rowsAffected int64
+	var nonRepeatable, retries int
+	var timings *[]QueryTiming
+	if ji.timesQueries(job) {
+		timings = new([]QueryTiming)
+	}
 	errorCounts := make(ErrorCounts)
+	runner := ji.runner(db)
 
 	for _, qi := range ji.queries {
-		runQueryStart := time.Now()
-		rows, err := db.RunQuery(results, qi.query, qi.args)
-		elapsed += time.Since(runQueryStart)
+		results := job.QueryResults
+		var checksum hash.Hash64
+		if job.VerifyRepeatable {
+			results, checksum = NewChecksumCSVWriter()
+		}
+
+		var watchDone func()
+		if job.outliers != nil {
+			watchDone = job.outliers.Watch(db, ji.name, qi.query)
+		}
+
+		ji.recordIssued(qi)
+
+		runQueryStart := clock.Now()
+		rows, err := job.runQuery(ctx, runner, results, qi)
+		for attempt := uint64(0); err != nil && attempt < job.MaxRetries && isRetryable(err, df); attempt++ {
+			retries++
+			rows, err = job.runQuery(ctx, runner, results, qi)
+		}
+		queryElapsed := clockSince(runQueryStart)
+		elapsed += queryElapsed
+
+		if job.outliers != nil {
+			watchDone()
+			job.outliers.Add(queryElapsed)
+		}
 
 		if err != nil {
-			// Attempt to handle the error
-			e := errorCounts.Add(err, qi.query, df)
-			if e != nil {
-				// Error handling not available for this DB flavor
-				log.Fatalf("%v. Error occurred while running %v:\n%v", e, ji.name, err)
+			ji.addError(ctx, job, errorCounts, df, qi, err)
+			continue
+		}
+		rowsAffected += rows
+		if timings != nil {
+			*timings = append(*timings, QueryTiming{qi.statement(), queryElapsed, rows})
+		}
+
+		if job.explains != nil {
+			job.explains.Sample(db, ji.name, start, queryElapsed, qi.query, qi.args)
+		}
+
+		if job.VerifyRepeatable {
+			// Run the query again right away and compare the results; the
+			// repeated execution is not counted towards the job stats.
+			repeatResults, repeatChecksum := NewChecksumCSVWriter()
+			if _, err := job.runQuery(ctx, runner, repeatResults, qi); err != nil {
+				ji.addError(ctx, job, errorCounts, df, qi, err)
+			} else if repeatChecksum.Sum64() != checksum.Sum64() {
+				job.logf("%s: results of %s differed between repeated executions",
+					ji.name, strconv.Quote(qi.query))
+				nonRepeatable++
 			}
-		} else {
-			rowsAffected += rows
 		}
 	}
 
-	return &JobResult{ji.name, start, elapsed, len(ji.queries), rowsAffected, errorCounts}
+	queries := len(ji.queries)
+	if len(job.FollowQueries) > 0 && len(errorCounts) == 0 && rowsAffected >= job.FollowQueryMinRows {
+		followElapsed, followRows, followQueries := ji.follow(ctx, runner, df, job, errorCounts, timings)
+		elapsed += followElapsed
+		rowsAffected += followRows
+		queries += followQueries
+	}
+	if ji.cancelled {
+		return nil
+	}
+
+	return &JobResult{
+		Name:          ji.name,
+		Start:         start,
+		Elapsed:       elapsed,
+		Queries:       queries,
+		RowsAffected:  rowsAffected,
+		Errors:        errorCounts,
+		NonRepeatable: nonRepeatable,
+		Retries:       retries,
+		PerQuery:      derefTimings(timings),
+	}
+}
+
+func derefTimings(timings *[]QueryTiming) []QueryTiming {
+	if timings == nil {
+		return nil
+	}
+	return *timings
+}
+
+/*
+ * Runs the follow-query of the job in order, stopping at the first error.
+ * Returns their elapsed time, rows, and how many were run. The timing of each
+ * is appended to timings, unless it is nil.
+ */
+func (ji *jobInvocation) follow(ctx context.Context, runner queryRunner, df DatabaseFlavor, job *Job, errorCounts ErrorCounts, timings *[]QueryTiming) (time.Duration, int64, int) {
+	var elapsed time.Duration
+	var rowsAffected int64
+	for i, query := range job.FollowQueries {
+		qi := queryInvocation{query: query, comment: ji.comment}
+		ji.recordIssued(qi)
+
+		runQueryStart := clock.Now()
+		rows, err := job.runQuery(ctx, runner, nil, qi)
+		queryElapsed := clockSince(runQueryStart)
+		elapsed += queryElapsed
+		if err != nil {
+			ji.addError(ctx, job, errorCounts, df, qi, err)
+			return elapsed, rowsAffected, i + 1
+		}
+		rowsAffected += rows
+		if timings != nil {
+			*timings = append(*timings, QueryTiming{query, queryElapsed, rows})
+		}
+	}
+	return elapsed, rowsAffected, len(job.FollowQueries)
+}
+
+/*
+ * Runs the queries of the invocation in a single transaction, retrying the
+ * whole transaction up to max-retries times on a retryable error.
+ */
+func (ji *jobInvocation) invokeTransaction(ctx context.Context, db Database, df DatabaseFlavor, job *Job, start time.Duration) *JobResult {
+	var elapsed time.Duration
+	var rowsAffected int64
+	var queries, retries int
+	var timings *[]QueryTiming
+	if ji.timesQueries(job) {
+		timings = new([]QueryTiming)
+	}
+	errorCounts := make(ErrorCounts)
+
+	for attempt := uint64(0); ; attempt++ {
+		if timings != nil {
+			// Only the queries of the last attempt are counted.
+			*timings = (*timings)[:0]
+		}
+		txStart := clock.Now()
+		rows, n, failed, err := ji.runTransaction(ctx, db, job, timings)
+		elapsed += clockSince(txStart)
+		queries = n
+		if err == nil {
+			rowsAffected = rows
+			break
+		} else if attempt < job.MaxRetries && isRetryable(err, df) {
+			retries++
+			continue
+		}
+		ji.addError(ctx, job, errorCounts, df, failed, err)
+		break
+	}
+	if ji.cancelled {
+		return nil
+	}
+
+	return &JobResult{
+		Name:         ji.name,
+		Start:        start,
+		Elapsed:      elapsed,
+		Queries:      queries,
+		RowsAffected: rowsAffected,
+		Errors:       errorCounts,
+		Retries:      retries,
+		PerQuery:     derefTimings(timings),
+	}
+}
+
+/*
+ * Runs a single attempt of the transaction of the invocation, rolling it back
+ * at the first error. Returns the rows affected, how many queries were run,
+ * and the query that failed (BEGIN or COMMIT if those did). The timing of
+ * each query is appended to timings, unless it is nil.
+ */
+func (ji *jobInvocation) runTransaction(ctx context.Context, db Database, job *Job, timings *[]QueryTiming) (int64, int, queryInvocation, error) {
+	begin := db.Begin
+	if ji.session != nil {
+		begin = ji.session.Begin
+	}
+	tx, err := begin()
+	if err != nil {
+		return 0, 0, queryInvocation{query: "BEGIN"}, err
+	}
+
+	var rowsAffected int64
+	queries := 0
+	run := func(qi queryInvocation, w *SafeCSVWriter) error {
+		ji.recordIssued(qi)
+		queries++
+		runQueryStart := clock.Now()
+		rows, err := job.runQuery(ctx, tx, w, qi)
+		if err != nil {
+			// The error of the query is the one worth reporting.
+			tx.Rollback()
+			return err
+		}
+		rowsAffected += rows
+		if timings != nil {
+			*timings = append(*timings, QueryTiming{qi.statement(), clockSince(runQueryStart), rows})
+		}
+		return nil
+	}
+
+	for _, qi := range ji.queries {
+		if err := run(qi, job.QueryResults); err != nil {
+			return rowsAffected, queries, qi, err
+		}
+	}
+	if rowsAffected >= job.FollowQueryMinRows {
+		for _, query := range job.FollowQueries {
+			qi := queryInvocation{query: query, comment: ji.comment}
+			if err := run(qi, nil); err != nil {
+				return rowsAffected, queries, qi, err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return rowsAffected, queries, queryInvocation{query: "COMMIT"}, err
+	}
+	return rowsAffected, queries, queryInvocation{}, nil
 }
 
 func (ji *jobInvocation) String() string {
@@ -102,51 +635,134 @@ func (job *Job) getNextQueryArgs() ([]interface{}, error) {
 		return nil, nil
 	}
 
-	textArgs, err := job.QueryArgs.Read()
-	if err != nil {
-		if err != io.EOF {
-			// TODO(awreece) Avoid log.Fatal.
-			log.Fatalf("error parsing arg file for job %s: %v", job.Name, err)
+	args, err := job.QueryArgs.Read()
+	if err == io.EOF {
+		if job.QueryArgsMode == "error" {
+			return nil, fmt.Errorf("query-args-file ran out of args after %d records (query-args-mode=error)",
+				job.argsRecords)
 		}
+		job.logf("job %s: query-args-file ran out of args after %d records; stopping the job",
+			job.Name, job.argsRecords)
 		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("error parsing arg file for job %s: %v", job.Name, err)
 	}
+	job.argsRecords++
 
-	iargs := make([]interface{}, 0, len(textArgs))
-	for _, arg := range textArgs {
-		iargs = append(iargs, arg)
+	for i, arg := range args {
+		ce, ok := findColumnEncoding(job.QueryArgsEncodings, i)
+		if !ok {
+			continue
+		}
+		if args[i], err = decodeQueryArg(ce, arg); err != nil {
+			return nil, fmt.Errorf("error parsing arg file for job %s: column %d: %v",
+				job.Name, i+1, err)
+		}
+	}
+
+	if len(job.QueryArgsColumns) > 0 {
+		selected := make([]interface{}, 0, len(job.QueryArgsColumns))
+		for _, column := range job.QueryArgsColumns {
+			if column >= len(args) {
+				return nil, fmt.Errorf("error parsing arg file for job %s: no column %d in %d column record",
+					job.Name, column+1, len(args))
+			}
+			selected = append(selected, args[column])
+		}
+		args = selected
+	}
+
+	return args, nil
+}
+
+// Logs the first time the query-args-file is read again from the start.
+func (job *Job) argsRewound() {
+	if !job.argsLooped {
+		job.logf("job %s: query-args-file ran out of args after %d records; starting over (query-args-mode=loop)",
+			job.Name, job.argsRecords)
+		job.argsLooped = true
 	}
-	return iargs, nil
 }
 
 func (job *Job) getNextJobInvocation() (*jobInvocation, error) {
-	queryInvocations := make([]queryInvocation, 0, len(job.Queries))
-	for _, query := range job.Queries {
+	queries := job.Queries
+	if len(job.QuerySets) > 0 {
+		queries = chooseQuerySet(job.rand(), job.QuerySets).Queries
+	}
+	var hotRow int64
+	if job.HotRows > 0 {
+		hotRow = job.rand().Int63n(job.HotRows) + 1
+	}
+	job.invocationSeq++
+	comment := job.traceComment()
+	queryInvocations := make([]queryInvocation, 0, len(queries))
+	for _, query := range queries {
+		expanded, err := job.expandQueryTemplate(query)
+		if err != nil {
+			return nil, fmt.Errorf("error expanding query template for job %s: %v", job.Name, err)
+		}
+		qi := queryInvocation{query: expanded, comment: comment}
+		if expanded != query {
+			qi.template = query
+		}
+		if job.HotRows > 0 {
+			qi.args = []interface{}{hotRow}
+			queryInvocations = append(queryInvocations, qi)
+			continue
+		}
 		args, err := job.getNextQueryArgs()
 		if err != nil {
 			return nil, err
 		}
-		queryInvocations = append(queryInvocations, queryInvocation{query, args})
+		qi.args = args
+		queryInvocations = append(queryInvocations, qi)
 	}
-	return &jobInvocation{job.Name, queryInvocations}, nil
+	return &jobInvocation{name: job.Name, queries: queryInvocations, seq: job.invocationSeq, comment: comment}, nil
 }
 
-func (job *Job) startTickQueryChannel(ctx context.Context) <-chan *jobInvocation {
+// The trace comment of the current invocation, if -trace-comments is set.
+func (job *Job) traceComment() string {
+	if !*traceComments {
+		return ""
+	}
+	return traceComment(job.Name, job.invocationSeq)
+}
+
+/*
+ * Sends the invocations of the job at its rate, or at the rate of the
+ * autoscaler if the job has one.
+ */
+func (job *Job) startTickQueryChannel(ctx context.Context, autoscaler *rateAutoscaler) <-chan *jobInvocation {
 	ch := make(chan *jobInvocation)
 	go func() {
 		defer close(ch)
 
-		ticker := time.NewTicker(time.Duration(float64(time.Second) / job.Rate))
+		ticker := clock.NewTicker(time.Duration(float64(time.Second) / job.Rate))
 		defer ticker.Stop()
 
+		nextTick := ticker.Chan
+		if autoscaler != nil {
+			// The rate changes over time, so wait for each tick separately.
+			ticker.Stop()
+			nextTick = func() <-chan time.Time { return clockAfter(autoscaler.Interval()) }
+		} else if job.RateRamp != nil {
+			ticker.Stop()
+			nextTick = func() <-chan time.Time { return clockAfter(job.rampTickInterval()) }
+		}
+
 		for ticks := uint64(0); job.Count == 0 || ticks < job.Count; ticks++ {
 			ji, err := job.getNextJobInvocation()
 			if err != nil {
+				if err != io.EOF {
+					job.invocationErr = err
+				}
 				return
 			}
 			select {
 			case <-ctx.Done():
 				return
-			case <-ticker.C:
+			case tick := <-nextTick():
+				ji.scheduled = tick
 				for bi := uint64(0); bi < job.BatchSize; bi++ {
 					ch <- ji
 				}
@@ -162,32 +778,35 @@ func (job *Job) startLogQueryChannel(ctx context.Context) <-chan *jobInvocation
 		defer close(ch)
 
 		scanner := bufio.NewScanner(job.QueryLog)
-		var lastTime int64
+		var firstTime int64
+		var replayStart time.Time
 
 		for linesScanned := uint64(0); scanner.Scan() &&
 			(job.Count == 0 || linesScanned < job.Count); linesScanned++ {
-			line := scanner.Text()
-			parts := strings.SplitN(line, ",", 2)
-			if len(parts) != 2 {
-				log.Fatalf("%s: invalid query log on line %d",
-					job.Name, linesScanned+1)
-			}
-			if timeMicros, err := strconv.ParseInt(parts[0], 10, 64); err != nil {
-				log.Fatalf("%s: error parsing query log time on line %d: %v",
+			timeMicros, query, args, err := parseQueryLogRecord(scanner.Text())
+			if err != nil {
+				log.Fatalf("%s: error parsing query log on line %d: %v",
 					job.Name, linesScanned+1, err)
-			} else {
-				var timeToSleep = time.Duration(0)
-				if linesScanned > 0 {
-					timeToSleep = time.Duration(timeMicros-lastTime) * time.Microsecond
-				}
-				lastTime = timeMicros
+			}
+			if linesScanned == 0 {
+				firstTime = timeMicros
+				replayStart = clock.Now()
+			}
+			scheduled := replayStart.Add(time.Duration(timeMicros-firstTime) * time.Microsecond)
 
-				select {
-				case <-ctx.Done():
-					return
-				case <-time.NewTimer(timeToSleep).C:
-					// TODO(awreece) Support multi statement log files.
-					ch <- &jobInvocation{job.Name, []queryInvocation{{parts[1], nil}}}
+			select {
+			case <-ctx.Done():
+				return
+			case <-clockAfter(clockUntil(scheduled)):
+				// TODO(awreece) Support multi statement log files.
+				job.invocationSeq++
+				comment := job.traceComment()
+				ch <- &jobInvocation{
+					name:      job.Name,
+					queries:   []queryInvocation{{query: query, args: args, comment: comment}},
+					scheduled: scheduled,
+					seq:       job.invocationSeq,
+					comment:   comment,
 				}
 			}
 		}
@@ -195,9 +814,9 @@ func (job *Job) startLogQueryChannel(ctx context.Context) <-chan *jobInvocation
 	return ch
 }
 
-func (job *Job) startQueryChannel(ctx context.Context) <-chan *jobInvocation {
+func (job *Job) startQueryChannel(ctx context.Context, autoscaler *rateAutoscaler) <-chan *jobInvocation {
 	if job.Rate > 0 {
-		return job.startTickQueryChannel(ctx)
+		return job.startTickQueryChannel(ctx, autoscaler)
 	} else if job.QueryLog != nil {
 		return job.startLogQueryChannel(ctx)
 	} else {
@@ -207,6 +826,9 @@ func (job *Job) startQueryChannel(ctx context.Context) <-chan *jobInvocation {
 			for i := uint64(0); job.Count == 0 || i < job.Count; i++ {
 				ji, err := job.getNextJobInvocation()
 				if err != nil {
+					if err != io.EOF {
+						job.invocationErr = err
+					}
 					return
 				}
 				select {
@@ -220,80 +842,357 @@ func (job *Job) startQueryChannel(ctx context.Context) <-chan *jobInvocation {
 	}
 }
 
-func (job *Job) runLoop(ctx context.Context, db Database, df DatabaseFlavor, startTime time.Time, results chan<- *JobResult) {
-	log.Printf("starting %v", job.Name)
-	defer log.Printf("stopping %v", job.Name)
+// The random stream of the job, used by the goroutine issuing its invocations.
+func (job *Job) rand() *rand.Rand {
+	if job.rng == nil {
+		job.rng = newJobRand(job.Name, 0)
+	}
+	return job.rng
+}
+
+// The random stream of a queue-depth worker, used only by its holder.
+func (job *Job) workerRand(worker int) *rand.Rand {
+	if job.workerRngs[worker] == nil {
+		job.workerRngs[worker] = newJobRand(job.Name, worker)
+	}
+	return job.workerRngs[worker]
+}
+
+/*
+ * Sleeps for a random duration between JitterMin and JitterMax, so that the
+ * workers of a job do not all fire at once (e.g. after a stall). Returns false
+ * if the job was stopped while sleeping.
+ */
+func (job *Job) sleepJitter(ctx context.Context, worker int) bool {
+	jitter := job.JitterMin + time.Duration(job.workerRand(worker).Int63n(int64(job.JitterMax-job.JitterMin)+1))
+	return clockSleepContext(ctx, jitter) == nil
+}
+
+/*
+ * Runs the invocations of the job until it is done, returning the error that
+ * stopped it early, if any.
+ */
+func (job *Job) runLoop(ctx context.Context, db Database, df DatabaseFlavor, startTime time.Time, results *ResultQueue) error {
+	if job.JitterMax > 0 {
+		job.logf("starting %v with %v..%v jitter", job.Name, job.JitterMin, job.JitterMax)
+	} else if job.RateRamp != nil {
+		job.logf("starting %v with rate-ramp %v", job.Name, job.RateRamp)
+	} else if job.ConcurrencyRamp != nil {
+		job.logf("starting %v with concurrency-ramp %v", job.Name, job.ConcurrencyRamp)
+	} else if job.Warmup > 0 {
+		job.logf("starting %v with %v warmup", job.Name, job.Warmup)
+	} else {
+		job.logf("starting %v", job.Name)
+	}
+	defer job.logf("stopping %v", job.Name)
+
+	// The results of invocations started before then are left out of the
+	// final stats.
+	warmupEnd := clockSince(startTime) + job.Warmup
 
-	queueSem := make(chan interface{}, job.QueueDepth)
-	for i := uint64(0); i < job.QueueDepth; i++ {
-		queueSem <- nil
+	// Each of the queue-depth workers is identified by the token it holds.
+	queueSem := make(chan int, job.QueueDepth)
+	workers := job.QueueDepth
+	if job.ConcurrencyRamp != nil {
+		// The rest are added as the ramp goes up.
+		workers = uint64(job.ConcurrencyRamp.From)
+	}
+	for i := uint64(0); i < workers; i++ {
+		queueSem <- int(i + 1)
+	}
+	job.rampStart = clock.Now()
+	job.rampLoggedStep = 0
+
+	// Each run (e.g. round of a comparison) repeats the same random choices.
+	job.rng = nil
+	job.workerRngs = make([]*rand.Rand, job.QueueDepth+1)
+	job.invocationSeq = 0
+	job.argsRecords, job.argsLooped = 0, false
+	job.invocationErr = nil
+
+	// The connection of each worker, reserved on first use.
+	var sessions []Session
+	if job.SingleConnection {
+		sessions = make([]Session, job.QueueDepth+1)
+	}
+
+	// With route-arg, each invocation waits for the worker of its key
+	// rather than for any free worker.
+	var routed routedWorkers
+	if job.RouteArg > 0 {
+		routed = newRoutedWorkers(int(job.QueueDepth))
+	}
+	releaseWorker := func(worker int) {
+		if routed != nil {
+			routed.Release(worker)
+		} else {
+			queueSem <- worker
+		}
+	}
+
+	if job.OutlierMultiple > 0 {
+		job.outliers = newOutlierDetector(job.OutlierMultiple, job.OutlierCaptureQuery, job.logf)
+	}
+
+	if job.ExplainSampleRate > 0 {
+		job.explains = newExplainSampler(job.ExplainSampleRate, job.ExplainPrefix, job.ExplainResults, job.logf)
+	}
+
+	// Kept for this run of the job only, as a job may be run again (e.g. by
+	// compare-rounds) and must then start over from its configured rate.
+	var autoscaler *rateAutoscaler
+	if job.UtilizationQuery != "" {
+		autoscaler = newRateAutoscaler(job.Rate)
+		go autoscaler.Run(ctx, db, job)
+	} else if job.LatencyTarget > 0 {
+		autoscaler = newRateAutoscaler(job.Rate)
+		go autoscaler.RunLatencyTarget(ctx, job)
+	}
+
+	// Stopped before queueSem is closed, since it adds workers to it.
+	var rampWg sync.WaitGroup
+	rampCtx, stopRamp := context.WithCancel(ctx)
+	defer stopRamp()
+	if job.ConcurrencyRamp != nil {
+		rampWg.Add(1)
+		go func() {
+			defer rampWg.Done()
+			job.rampConcurrency(rampCtx, queueSem, int(workers))
+		}()
 	}
 
 	var wg sync.WaitGroup
-	for ji := range job.startQueryChannel(ctx) {
+	for ji := range job.startQueryChannel(ctx, autoscaler) {
+		if job.overload != nil && !job.overload.Wait(ctx) {
+			continue
+		}
 		wg.Add(1)
-		if job.QueueDepth > 0 {
-			<-queueSem
+		var worker int
+		if routed != nil {
+			worker = routed.Acquire(job.routeWorker(ji))
+		} else if job.QueueDepth > 0 {
+			worker = <-queueSem
 		}
-		go func(_ji *jobInvocation) {
+		// A virtual clock does not advance while the invocation runs, only
+		// while its queries wait on the clock.
+		_ji := ji
+		clockGo(ctx, func(ctx context.Context) {
 			defer wg.Done()
-			r := _ji.Invoke(db, df, job.QueryResults, time.Since(startTime))
+			if job.JitterMax > 0 && !job.sleepJitter(ctx, worker) {
+				releaseWorker(worker)
+				return
+			}
+			if job.QueryLogLateness > 0 && clockSince(_ji.scheduled) > job.QueryLogLateness {
+				// Model a client that gives up rather than queueing forever.
+				start := clockSince(startTime)
+				results.Send(&JobResult{Name: _ji.name, Start: start, Dropped: true, Warmup: start < warmupEnd})
+				return
+			}
+			var queueWait time.Duration
+			if !_ji.scheduled.IsZero() {
+				queueWait = clockSince(_ji.scheduled)
+			}
+			if sessions != nil {
+				if sessions[worker] == nil {
+					s, err := db.Session()
+					if err != nil {
+						fatalf(exitConnectionFailure, "%s: error reserving a connection for worker %d: %v",
+							job.Name, worker, err)
+					}
+					if q := sessionNameQuery(df, job.Name); q != "" {
+						if _, err := s.RunQueryWithOptions(context.Background(), nil, q, nil, QueryOptions{}); err != nil {
+							job.logf("%s: error naming the connection of worker %d: %v", job.Name, worker, err)
+						}
+					}
+					sessions[worker] = s
+				}
+				_ji.session = sessions[worker]
+			}
+			r := _ji.Invoke(ctx, db, df, job, clockSince(startTime))
+			if r == nil {
+				// The invocation was cut off by the end of the job, so it
+				// is not counted.
+				if job.QueueDepth > 0 {
+					releaseWorker(worker)
+				}
+				return
+			}
+			r.Worker = worker
+			r.QueueWait = queueWait
+			r.Warmup = r.Start < warmupEnd
+			r.RampStep = job.rampStep(startTime.Add(r.Start))
+			if *slowestInvocationCount > 0 {
+				r.invocation = _ji.queries
+				r.startedAt = startTime.Add(r.Start)
+			}
+			if autoscaler != nil {
+				r.Utilization = autoscaler.Utilization()
+				if job.LatencyTarget > 0 && r.Errors.TotalErrors() == 0 {
+					autoscaler.Observe(r.Elapsed)
+				}
+			}
+			if job.errorBudget != nil {
+				job.errorBudget.Observe(r)
+			}
 			if job.QueueDepth > 0 {
-				queueSem <- nil
+				releaseWorker(worker)
 			}
-			results <- r
-		}(ji)
+			results.Send(r)
+		})
 	}
 
 	// Do not return until all spawned goroutines have completed. This ensures
-	// that we will not close the results chan before all spawned goroutines
+	// that we will not close the results queue before all spawned goroutines
 	// have completed their sends on it.
 	wg.Wait()
+	if job.LatencyTarget > 0 {
+		if best := autoscaler.BestThroughput(); best > 0 {
+			job.logf("%s: highest throughput with p99 latency within %v: %.3f invocations per second",
+				job.Name, job.LatencyTarget, best)
+		} else {
+			job.logf("%s: p99 latency was never within %v", job.Name, job.LatencyTarget)
+		}
+	}
+	stopRamp()
+	rampWg.Wait()
 	close(queueSem)
+	for _, s := range sessions {
+		if s != nil {
+			s.Close()
+		}
+	}
+
+	if job.explains != nil {
+		job.explains.Wait()
+	}
+	return job.invocationErr
 }
 
-func (job *Job) Run(ctx context.Context, db Database, df DatabaseFlavor, results chan<- *JobResult) {
-	startTime := time.Now()
+// An error from a job whose require-query was not met when it started.
+type unmetRequirementError struct {
+	error
+}
+
+/*
+ * Runs the job once it is due to start, returning the error that stopped it
+ * early, if any.
+ */
+func (job *Job) Run(ctx context.Context, db Database, df DatabaseFlavor, results *ResultQueue) error {
+	startTime := clock.Now()
 
 	if job.Stop > 0 {
-		ctx, _ = context.WithTimeout(ctx, job.Stop)
+		var cancel context.CancelFunc
+		ctx, cancel = clockWithTimeout(ctx, job.Stop)
+		defer cancel()
 	}
 
-	defer job.cleanup()
+	start := job.Start
+	if !job.StartAt.IsZero() {
+		start = clockUntil(job.StartAt)
+	}
 
 	select {
 	case <-ctx.Done():
-		return
-	case <-time.NewTimer(job.Start).C:
-		job.runLoop(ctx, db, df, startTime, results)
+		return nil
+	case <-clockAfter(start):
+		if err := job.checkRequirement(db); err != nil {
+			return unmetRequirementError{err}
+		}
+		return job.runLoop(ctx, db, df, startTime, results)
+	}
+}
+
+// Returns why the require-query of the job is not met, if it is not.
+func (job *Job) checkRequirement(db Database) error {
+	if job.RequireQuery == "" {
+		return nil
+	}
+	if job.RequireRows > 0 {
+		value, err := sampleMetric(db, job.RequireQuery)
+		if err == io.EOF {
+			return fmt.Errorf("require-query %s returned no rows", strconv.Quote(job.RequireQuery))
+		} else if err != nil {
+			return fmt.Errorf("error running require-query %s: %v", strconv.Quote(job.RequireQuery), err)
+		} else if value < float64(job.RequireRows) {
+			return fmt.Errorf("require-query %s returned %g, under require-rows %d",
+				strconv.Quote(job.RequireQuery), value, job.RequireRows)
+		}
+		return nil
+	}
+	rows, err := db.RunQuery(context.Background(), nil, job.RequireQuery, nil)
+	if err != nil {
+		return fmt.Errorf("error running require-query %s: %v", strconv.Quote(job.RequireQuery), err)
+	} else if rows == 0 {
+		return fmt.Errorf("require-query %s returned no rows", strconv.Quote(job.RequireQuery))
 	}
+	return nil
 }
 
 func (job *Job) cleanup() {
 	if job.QueryResults != nil {
 		job.QueryResults.Close()
 	}
+	if job.ExplainResults != nil {
+		job.ExplainResults.Close()
+	}
 	if job.QueryLog != nil {
 		job.QueryLog.Close()
 	}
+	if job.LogFile != nil {
+		job.LogFile.Close()
+	}
 }
 
-func makeJobResultChan(ctx context.Context, db Database, df DatabaseFlavor, jobs map[string]*Job) <-chan *JobResult {
-	outChan := make(chan *JobResult)
+/*
+ * Waits for the jobs the job runs after to finish, each of which closes its
+ * channel of done when it does. Returns false if the context was done first.
+ */
+func (job *Job) waitForJobs(ctx context.Context, done map[string]chan struct{}) bool {
+	if len(job.After) == 0 {
+		return true
+	}
+	job.logf("%s: waiting for %s to finish", job.Name, strings.Join(job.After, ", "))
+	for _, name := range job.After {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-done[name]:
+		}
+	}
+	return true
+}
+
+func makeJobResultQueue(ctx context.Context, db Database, df DatabaseFlavor, jobs map[string]*Job) *ResultQueue {
+	results := NewResultQueue(*resultBufferSize)
+
+	done := make(map[string]chan struct{}, len(jobs))
+	for name := range jobs {
+		done[name] = make(chan struct{})
+	}
 
 	go func() {
 		var wg sync.WaitGroup
-		for _, job := range jobs {
+		for name, job := range jobs {
 			wg.Add(1)
-			go func(j *Job) {
-				j.Run(ctx, db, df, outChan)
-				wg.Done()
-			}(job)
+			go func(name string, j *Job) {
+				defer wg.Done()
+				defer close(done[name])
+				if !j.waitForJobs(ctx, done) {
+					return
+				}
+				if err := j.Run(ctx, db, df, results); err != nil {
+					log.Printf("%s: aborting the run: %v", name, err)
+					j.failure = err
+					if j.cancelRun != nil {
+						j.cancelRun()
+					}
+				}
+			}(name, job)
 		}
 
 		wg.Wait()
-		close(outChan)
+		results.Close()
 	}()
 
-	return outChan
+	return results
 }
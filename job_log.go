@@ -0,0 +1,82 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var logDir = flag.String("log-dir", "",
+	"Log the messages of each job without a log-file to <job name>.log in this directory.")
+
+/*
+ * Directs the messages of the job (e.g. when it starts and stops, errors, and
+ * outlier and plan captures) to f rather than to the main log.
+ */
+func (job *Job) setLogFile(f *os.File) {
+	job.LogFile = f
+	job.logger = log.New(f, "", log.LstdFlags)
+}
+
+// Logs a message of the job, to its log-file if it has one.
+func (job *Job) logf(format string, v ...interface{}) {
+	if job.logger != nil {
+		job.logger.Printf(format, v...)
+	} else {
+		log.Printf(format, v...)
+	}
+}
+
+// The name of the file in log-dir for the job, with only safe characters.
+func jobLogFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9',
+			r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, name) + ".log"
+}
+
+/*
+ * Opens a file in log-dir for each job of the config without a log-file, if
+ * log-dir is set.
+ */
+func openJobLogs(config *Config) error {
+	if *logDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(*logDir, 0755); err != nil {
+		return err
+	}
+	for name, job := range config.Jobs {
+		if job.LogFile != nil {
+			continue
+		}
+		f, err := createOutputFile(filepath.Join(*logDir, jobLogFileName(name)))
+		if err != nil {
+			return err
+		}
+		job.setLogFile(f)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJobLogFileName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"point-lookups": "point-lookups.log",
+		"bulk load 2":   "bulk_load_2.log",
+		"../etc/passwd": ".._etc_passwd.log",
+	} {
+		if actual := jobLogFileName(name); actual != expected {
+			t.Errorf("For %q expected %q, got %q", name, expected, actual)
+		}
+	}
+}
+
+func TestOpenJobLogs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dbbench-log-dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(d string) { *logDir = d }(*logDir)
+	*logDir = dir
+
+	own, err := os.Create(filepath.Join(dir, "own.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{Jobs: map[string]*Job{
+		"bulk load": &Job{Name: "bulk load"},
+		"own":       &Job{Name: "own"},
+	}}
+	config.Jobs["own"].setLogFile(own)
+	if err := openJobLogs(config); err != nil {
+		t.Fatal(err)
+	}
+	for _, job := range config.Jobs {
+		job.logf("starting %v", job.Name)
+		job.cleanup()
+	}
+
+	for file, expected := range map[string]string{
+		"bulk_load.log": "starting bulk load\n",
+		"own.txt":       "starting own\n",
+	} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			t.Fatal(err)
+		} else if !strings.HasSuffix(string(b), expected) {
+			t.Errorf("Expected %s to end with %q, got %q", file, expected, b)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "own.log")); !os.IsNotExist(err) {
+		t.Errorf("Expected no log-dir file for a job with a log-file, got %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/awreece/goini"
+)
+
+// Sections with this prefix hold options shared by the jobs that extend
+// them rather than describe jobs.
+const jobTemplateSectionPrefix = "template:"
+
+/*
+ * Returns the section of a job (or template) with the options of the
+ * template it extends filled in, and those of the template that one extends,
+ * and so on. An option set by the section itself replaces all the values of
+ * that option in the template, e.g. all of its queries.
+ */
+func resolveJobTemplate(iniConfig *goini.RawConfig, section goini.RawSection, extended []string) (goini.RawSection, error) {
+	names := section.GetPropertyValues("extends")
+	if len(names) == 0 {
+		return section, nil
+	} else if len(names) > 1 {
+		return nil, errors.New("property \"extends\" cannot be repeated")
+	}
+
+	name := names[0]
+	for _, e := range extended {
+		if e == name {
+			return nil, fmt.Errorf("template %s extends itself", strconv.Quote(name))
+		}
+	}
+	template := iniConfig.Section(jobTemplateSectionPrefix + name)
+	if template == nil {
+		return nil, fmt.Errorf("no template %s", strconv.Quote(name))
+	}
+	base, err := resolveJobTemplate(iniConfig, template, append(extended, name))
+	if err != nil {
+		return nil, err
+	}
+
+	resolved := make(goini.RawSection)
+	for property, values := range base {
+		resolved[property] = values
+	}
+	for property, values := range section {
+		resolved[property] = values
+	}
+	return resolved, nil
+}
@@ -0,0 +1,318 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
+
+var outputFormat = flag.String("output-format", "text",
+	"Format of the intermediate and final stats: text, which is logged, or "+
+		"json, which writes one JSON record per line to stdout.")
+
+func checkOutputFormat() error {
+	switch *outputFormat {
+	case "text", "json":
+		return nil
+	default:
+		return fmt.Errorf("unknown output-format %s, expected text or json",
+			strconv.Quote(*outputFormat))
+	}
+}
+
+func jsonOutput() bool {
+	return *outputFormat == "json"
+}
+
+/*
+ * The stats of a job in json output-format. Latencies are in microseconds, as
+ * in the query-stats-file.
+ */
+type jobStatsJSON struct {
+	Transactions             int                `json:"transactions"`
+	TPS                      float64            `json:"tps"`
+	LatencyMeanMicros        float64            `json:"latency_mean_micros"`
+	LatencyConfidenceMicros  float64            `json:"latency_confidence_micros"`
+	LatencyPercentilesMicros map[string]float64 `json:"latency_percentiles_micros,omitempty"`
+	LatencySLAPercent        map[string]float64 `json:"latency_sla_percent,omitempty"`
+	RowsAffected             int64              `json:"rows_affected"`
+	RPS                      float64            `json:"rps"`
+	Queries                  uint64             `json:"queries"`
+	QPS                      float64            `json:"qps"`
+	Errors                   uint64             `json:"errors"`
+	AcceptedErrors           uint64             `json:"accepted_errors"`
+	NonRepeatable            uint64             `json:"non_repeatable,omitempty"`
+	Retries                  uint64             `json:"retries,omitempty"`
+	QueueWaitP50Micros       float64            `json:"queue_wait_p50_micros,omitempty"`
+	QueueWaitP99Micros       float64            `json:"queue_wait_p99_micros,omitempty"`
+	QueueWaitMaxMicros       float64            `json:"queue_wait_max_micros,omitempty"`
+	ConnectP50Micros         float64            `json:"connect_p50_micros,omitempty"`
+	ConnectP99Micros         float64            `json:"connect_p99_micros,omitempty"`
+	ConnectMaxMicros         float64            `json:"connect_max_micros,omitempty"`
+	Dropped                  uint64             `json:"dropped,omitempty"`
+	Cost                     float64            `json:"cost,omitempty"`
+
+	// Only in the final stats of a job run at a rate, or with jitter.
+	RequestedQPS float64 `json:"requested_qps,omitempty"`
+	PacedQPS     float64 `json:"paced_qps,omitempty"`
+	AchievedQPS  float64 `json:"achieved_qps,omitempty"`
+
+	// Only in the final stats of a job.
+	LatencyMaxMicros           float64                  `json:"latency_max_micros,omitempty"`
+	ThroughputCV               *float64                 `json:"throughput_cv,omitempty"`
+	LongestStallMicros         *float64                 `json:"longest_stall_micros,omitempty"`
+	WorstIntervalLatencyMicros float64                  `json:"worst_interval_latency_micros,omitempty"`
+	Workers                    map[string]*jobStatsJSON `json:"workers,omitempty"`
+	Steps                      map[string]*jobStatsJSON `json:"ramp_steps,omitempty"`
+	PerQuery                   []*queryStatsJSON        `json:"per_query,omitempty"`
+	Slowest                    []*slowInvocationJSON    `json:"slowest_invocations,omitempty"`
+
+	// Error code -> errors in each stats interval, from the first.
+	ErrorTimeline               map[string][]uint64 `json:"error_timeline,omitempty"`
+	ErrorTimelineIntervalMicros float64             `json:"error_timeline_interval_micros,omitempty"`
+}
+
+type queryStatsJSON struct {
+	Query             string  `json:"query"`
+	Count             int     `json:"count"`
+	LatencyMeanMicros float64 `json:"latency_mean_micros"`
+	LatencyP50Micros  float64 `json:"latency_p50_micros"`
+	LatencyP99Micros  float64 `json:"latency_p99_micros"`
+	RowsAffected      int64   `json:"rows_affected"`
+}
+
+type slowInvocationJSON struct {
+	Time          time.Time    `json:"time"`
+	LatencyMicros float64      `json:"latency_micros"`
+	Queries       []*queryJSON `json:"queries"`
+	Error         string       `json:"error,omitempty"`
+}
+
+type queryJSON struct {
+	Query string        `json:"query"`
+	Args  []interface{} `json:"args,omitempty"`
+}
+
+// JSON cannot encode NaN or infinity, e.g. the TPS of a single transaction.
+func finite(x float64) float64 {
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		return 0
+	}
+	return x
+}
+
+func jsonMicros(d time.Duration) float64 {
+	return float64(d) / float64(time.Microsecond)
+}
+
+func (js *jobStats) JSON() *jobStatsJSON {
+	jsTime := js.Stop.Seconds() - js.Start.Seconds()
+	r := &jobStatsJSON{
+		Transactions:            js.Transactions.Count(),
+		TPS:                     finite(js.TPS()),
+		LatencyMeanMicros:       js.Transactions.Mean() / float64(time.Microsecond),
+		LatencyConfidenceMicros: js.Transactions.Confidence(*confidence) / float64(time.Microsecond),
+		RowsAffected:            js.RowsAffected,
+		RPS:                     finite(float64(js.RowsAffected) / jsTime),
+		Queries:                 js.Queries,
+		QPS:                     finite(float64(js.Queries) / jsTime),
+		Errors:                  js.TotalErrors,
+		AcceptedErrors:          js.AcceptedErrors,
+		NonRepeatable:           js.NonRepeatable,
+		Retries:                 js.Retries,
+		Dropped:                 js.Dropped,
+		Cost:                    js.Cost,
+	}
+	if js.QueueWait.Count() > 0 {
+		waits := js.QueueWait.Percentiles(50, 99)
+		r.QueueWaitP50Micros = jsonMicros(waits[0])
+		r.QueueWaitP99Micros = jsonMicros(waits[1])
+		r.QueueWaitMaxMicros = jsonMicros(js.QueueWait.Max())
+	}
+	if js.Connect.Count() > 0 {
+		connects := js.Connect.Percentiles(50, 99)
+		r.ConnectP50Micros = jsonMicros(connects[0])
+		r.ConnectP99Micros = jsonMicros(connects[1])
+		r.ConnectMaxMicros = jsonMicros(js.Connect.Max())
+	}
+	if js.Latencies.Count() > 0 && len(latencyPercentiles) > 0 {
+		r.LatencyPercentilesMicros = make(map[string]float64)
+		for i, v := range js.Latencies.Percentiles(latencyPercentiles...) {
+			r.LatencyPercentilesMicros[fmt.Sprintf("p%g", latencyPercentiles[i])] = jsonMicros(v)
+		}
+	}
+	if js.SLA != nil {
+		labels := slaLabels()
+		r.LatencySLAPercent = make(map[string]float64)
+		for i, p := range slaPercentages(js.SLA, js.Transactions.Count()) {
+			r.LatencySLAPercent[labels[i]] = p
+		}
+	}
+	return r
+}
+
+func (js *JobStats) JSON() *jobStatsJSON {
+	r := js.jobStats.JSON()
+	r.LatencyMaxMicros = jsonMicros(js.Latencies.Max())
+	if js.Throughput.Intervals.Count() > 0 {
+		// Without any interval there is no throughput to vary or stall.
+		cv, stall := finite(js.Throughput.CoefficientOfVariation()), jsonMicros(js.Throughput.LongestStall)
+		r.ThroughputCV, r.LongestStallMicros = &cv, &stall
+	}
+	r.WorstIntervalLatencyMicros = jsonMicros(js.Latency.Worst)
+	if codes := js.ErrorTimeline.Codes(); len(codes) > 0 {
+		r.ErrorTimeline = make(map[string][]uint64, len(codes))
+		for _, code := range codes {
+			r.ErrorTimeline[code] = js.ErrorTimeline.Counts(code)
+		}
+		r.ErrorTimelineIntervalMicros = jsonMicros(js.ErrorTimeline.Interval)
+	}
+	for _, qs := range js.PerQuery {
+		ps := qs.Latencies.Percentiles(50, 99)
+		r.PerQuery = append(r.PerQuery, &queryStatsJSON{
+			Query:             qs.Query,
+			Count:             qs.Latencies.Count(),
+			LatencyMeanMicros: jsonMicros(qs.Elapsed / time.Duration(qs.Latencies.Count())),
+			LatencyP50Micros:  jsonMicros(ps[0]),
+			LatencyP99Micros:  jsonMicros(ps[1]),
+			RowsAffected:      qs.Rows,
+		})
+	}
+	if js.Slowest != nil {
+		for _, s := range js.Slowest.Sorted() {
+			sj := &slowInvocationJSON{Time: s.Time, LatencyMicros: jsonMicros(s.Elapsed)}
+			for _, qi := range s.Queries {
+				sj.Queries = append(sj.Queries, &queryJSON{Query: qi.query, Args: qi.args})
+			}
+			if s.Error != nil {
+				sj.Error = s.Error.Error()
+			}
+			r.Slowest = append(r.Slowest, sj)
+		}
+	}
+	if len(js.Workers) > 0 {
+		r.Workers = make(map[string]*jobStatsJSON)
+		for worker, stats := range js.Workers {
+			r.Workers[strconv.Itoa(worker)] = stats.JSON()
+		}
+	}
+	if len(js.Steps) > 0 {
+		r.Steps = make(map[string]*jobStatsJSON)
+		for step, stats := range js.Steps {
+			r.Steps[strconv.Itoa(step)] = stats.JSON()
+		}
+	}
+	return r
+}
+
+func jobStatsJSONs(config *Config, stats map[string]*JobStats) map[string]*jobStatsJSON {
+	r := make(map[string]*jobStatsJSON, len(stats))
+	for name, s := range stats {
+		r[name] = s.JSON()
+		if requested := requestedQPS(config.Jobs[name]); requested > 0 {
+			r[name].RequestedQPS = requested
+			r[name].AchievedQPS = finite(s.InvocationsPerSecond())
+		} else if paced := jitterPacedQPS(config.Jobs[name], s); paced > 0 {
+			r[name].PacedQPS = paced
+			r[name].AchievedQPS = finite(s.InvocationsPerSecond())
+		}
+	}
+	return r
+}
+
+// The stats of a job over one stats interval.
+type intervalJSON struct {
+	Type        string        `json:"type"`
+	Time        time.Time     `json:"time"`
+	WindowStart time.Time     `json:"window_start"`
+	WindowEnd   time.Time     `json:"window_end"`
+	Job         string        `json:"job"`
+	Events      []string      `json:"events,omitempty"`
+	Stats       *jobStatsJSON `json:"stats"`
+}
+
+// How long all jobs were paused by the overload-query.
+type throttleJSON struct {
+	Type            string  `json:"type"`
+	Pauses          int     `json:"pauses"`
+	ThrottledMicros float64 `json:"throttled_micros"`
+}
+
+// The stats of a job before, during, or after an event.
+type eventJSON struct {
+	Type  string `json:"type"`
+	Job   string `json:"job"`
+	Event string `json:"event"`
+	Phase string `json:"phase"`
+	// When the event started and finished, if it did.
+	EventStart *time.Time    `json:"event_start,omitempty"`
+	EventEnd   *time.Time    `json:"event_end,omitempty"`
+	Stats      *jobStatsJSON `json:"stats"`
+}
+
+// The stats of the warmup queries.
+type warmupJSON struct {
+	Type  string        `json:"type"`
+	Name  string        `json:"name"`
+	Stats *jobStatsJSON `json:"stats"`
+}
+
+/*
+ * The final stats of a run. The stats of the jobs are in Cold and Warm rather
+ * than Jobs with cache-comparison.
+ */
+type summaryJSON struct {
+	Type       string                   `json:"type"`
+	Time       time.Time                `json:"time"`
+	Jobs       map[string]*jobStatsJSON `json:"jobs,omitempty"`
+	Cold       map[string]*jobStatsJSON `json:"cold,omitempty"`
+	Warm       map[string]*jobStatsJSON `json:"warm,omitempty"`
+	Phases     []*phaseJSON             `json:"phases,omitempty"`
+	InvalidRun []string                 `json:"invalid_run,omitempty"`
+}
+
+// The stats of the jobs of a phase, in the summary of a run with phases.
+type phaseJSON struct {
+	Name          string                   `json:"name"`
+	ElapsedMicros float64                  `json:"elapsed_micros"`
+	Jobs          map[string]*jobStatsJSON `json:"jobs"`
+}
+
+/*
+ * Writes a record to stdout, also writing it to the summary file if
+ * output-dir is set and the record is part of the final summary of a run.
+ */
+func writeJSONRecord(record interface{}, summary bool) {
+	b, err := json.Marshal(record)
+	if err != nil {
+		log.Fatalf("encoding stats as JSON: %v", err)
+	}
+	b = append(b, '\n')
+	var w io.Writer = os.Stdout
+	if summary && runSummary != nil {
+		w = io.MultiWriter(os.Stdout, runSummary)
+	}
+	w.Write(b)
+}
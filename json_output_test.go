@@ -0,0 +1,74 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestJobStatsJSON(t *testing.T) {
+	var js JobStats
+	js.Update(&Config{}, &JobResult{Name: "test", Start: time.Second,
+		Elapsed: 1500 * time.Microsecond, Queries: 1, RowsAffected: 2,
+		QueueWait: 200 * time.Microsecond, Connect: 3 * time.Millisecond})
+
+	b, err := json.Marshal(js.JSON())
+	if err != nil {
+		t.Fatalf("Error encoding stats of a single transaction: %v", err)
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	for field, expected := range map[string]float64{
+		"transactions":          1,
+		"rows_affected":         2,
+		"latency_mean_micros":   1500,
+		"latency_max_micros":    1500,
+		"queue_wait_max_micros": 200,
+		"connect_max_micros":    3000,
+	} {
+		if decoded[field] != expected {
+			t.Errorf("For %s\n\texpected %v\n\tbut got %v", field, expected, decoded[field])
+		}
+	}
+	// No interval ended, so there is no throughput variation or stall.
+	for _, field := range []string{"throughput_cv", "longest_stall_micros"} {
+		if v, ok := decoded[field]; ok {
+			t.Errorf("Expected no %s without intervals but got %v", field, v)
+		}
+	}
+
+	// A steady throughput has them, even though they are zero.
+	js.Throughput.Add(1, time.Second, time.Now())
+	js.Throughput.Add(1, time.Second, time.Now())
+	if b, err = json.Marshal(js.JSON()); err != nil {
+		t.Fatal(err)
+	}
+	decoded = nil
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"throughput_cv", "longest_stall_micros"} {
+		if decoded[field] != float64(0) {
+			t.Errorf("Expected %s to be 0 but got %v", field, decoded[field])
+		}
+	}
+}
@@ -0,0 +1,120 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Batches larger than this are sent all at once and are likely a mistake.
+const maxReasonableBatchSize = 1000
+
+/*
+ * Returns warnings about parts of the config that are valid but are likely
+ * not what the user intended.
+ */
+func lintConfig(config *Config) []string {
+	warnings := unreferencedErrors(config)
+
+	names := make([]string, 0, len(config.Jobs))
+	for name := range config.Jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		job := config.Jobs[name]
+		quotedName := strconv.Quote(name)
+
+		if job.Rate > 0 && job.BatchSize > maxReasonableBatchSize {
+			warnings = append(warnings, fmt.Sprintf(
+				"job %s issues bursts of %d queries at once (rate %g with "+
+					"batch-size %d)", quotedName, job.BatchSize, job.Rate,
+				job.BatchSize))
+		}
+		if *maxActiveConns > 0 && job.QueueDepth > uint64(*maxActiveConns) {
+			warnings = append(warnings, fmt.Sprintf(
+				"job %s has queue-depth %d which exceeds -max-active-conns %d, "+
+					"so executions will wait for a connection", quotedName,
+				job.QueueDepth, *maxActiveConns))
+		}
+		if job.Stop > 0 && job.Start == job.Stop {
+			warnings = append(warnings, fmt.Sprintf(
+				"job %s starts when it stops and will never run", quotedName))
+		}
+		if *traceComments && job.Prepare {
+			warnings = append(warnings, fmt.Sprintf(
+				"job %s prepares its queries, so they are issued without "+
+					"the comments of -trace-comments", quotedName))
+		}
+	}
+
+	return warnings
+}
+
+/*
+ * Warns about the accepted error codes that nothing in the run can raise: the
+ * codes counted by dbbench itself for options that no job sets, and the codes
+ * of the database for a flavor that does not extract them. The codes of
+ * -simulate-errors are raised whatever the flavor.
+ */
+func unreferencedErrors(config *Config) []string {
+	simulated := make(Set)
+	for _, r := range simulatedErrors.rates {
+		simulated.Add(r.code)
+	}
+	anyJob := func(f func(job *Job) bool) bool {
+		for _, job := range config.Jobs {
+			if f(job) {
+				return true
+			}
+		}
+		return false
+	}
+
+	codes := make([]string, 0, len(config.AcceptedErrors))
+	for code := range config.AcceptedErrors {
+		codes = append(codes, code.(string))
+	}
+	sort.Strings(codes)
+
+	var warnings []string
+	for _, code := range codes {
+		var reason string
+		switch {
+		case simulated.Contains(code) || code == connectErrorCode:
+			// Any job may fail to connect.
+		case code == maxRowsErrorCode:
+			if !anyJob(func(job *Job) bool { return job.MaxRows > 0 && !job.MaxRowsTruncate }) {
+				reason = "no job sets max-rows without truncating"
+			}
+		case code == queryTimeoutErrorCode:
+			if !anyJob(func(job *Job) bool { return job.QueryTimeout > 0 }) {
+				reason = "no job sets query-timeout"
+			}
+		case !config.Flavor.HasErrorCodes():
+			reason = "the database flavor does not support error codes"
+		}
+		if reason != "" {
+			warnings = append(warnings, fmt.Sprintf(
+				"accepted error %v is never raised since %s", quotedValue(code), reason))
+		}
+	}
+	return warnings
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Queries that show what the server is doing, by database driver.
+var defaultOutlierCaptureQueries = map[string]string{
+	"mysql":    "show full processlist",
+	"postgres": "select * from pg_stat_activity",
+}
+
+const (
+	// Latencies needed before outliers are detected.
+	outlierMinSamples = 100
+	// How often the running median is recomputed.
+	outlierMedianInterval = 100
+)
+
+/*
+ * Detects queries running much longer than the running median latency of a
+ * job and logs what the server is doing while they run.
+ */
+type outlierDetector struct {
+	multiple     float64
+	captureQuery string
+	logf         func(format string, v ...interface{})
+
+	m          sync.Mutex
+	latencies  LatencyHistogram
+	median     time.Duration
+	sinceCheck int
+
+	// Set while a capture is running, so a burst of outliers only results
+	// in a single capture.
+	capturing int32
+}
+
+func newOutlierDetector(multiple float64, captureQuery string,
+	logf func(format string, v ...interface{})) *outlierDetector {
+	return &outlierDetector{multiple: multiple, captureQuery: captureQuery, logf: logf}
+}
+
+func (od *outlierDetector) Add(latency time.Duration) {
+	od.m.Lock()
+	defer od.m.Unlock()
+
+	od.latencies.Add(latency)
+	od.sinceCheck++
+	if od.latencies.Count() >= outlierMinSamples && od.sinceCheck >= outlierMedianInterval {
+		od.median = od.latencies.Percentiles(50)[0]
+		od.sinceCheck = 0
+	}
+}
+
+// How long a query may run before it is an outlier, or 0 if not yet known.
+func (od *outlierDetector) Threshold() time.Duration {
+	od.m.Lock()
+	defer od.m.Unlock()
+
+	return time.Duration(od.multiple * float64(od.median))
+}
+
+/*
+ * Starts a timer that captures the server context if the query is still
+ * running after the threshold. The returned function must be called once the
+ * query completes.
+ */
+func (od *outlierDetector) Watch(db Database, name, query string) (done func()) {
+	threshold := od.Threshold()
+	if threshold == 0 {
+		return func() {}
+	}
+
+	timer := time.AfterFunc(threshold, func() {
+		if !atomic.CompareAndSwapInt32(&od.capturing, 0, 1) {
+			return
+		}
+		defer atomic.StoreInt32(&od.capturing, 0)
+
+		var buf bytes.Buffer
+		if _, err := db.RunQuery(context.Background(), NewSafeCSVWriterTo(&buf), od.captureQuery, nil); err != nil {
+			od.logf("%s: error capturing context of outlier %s: %v",
+				name, strconv.Quote(query), err)
+			return
+		}
+		od.logf("%s: %s running longer than %v (%gx the median); server context:\n%s",
+			name, strconv.Quote(query), threshold, od.multiple, buf.String())
+	})
+	return func() { timer.Stop() }
+}
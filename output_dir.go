@@ -0,0 +1,128 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var outputDir = flag.String("output-dir", "",
+	"Collect the runfile, query files, effective config, logs, query stats, and summary "+
+		"of each run into a new timestamped directory under this directory.")
+
+// The files of the current run if output-dir is set.
+var runLog *os.File
+var runSummary *os.File
+
+// Whether the query-stats-file is written to the output directory, rather
+// than to where the user asked.
+var queryStatsInOutputDir bool
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+/*
+ * Creates the directory for this run under output-dir, copies the inputs of
+ * the run into it, and directs the logs, query stats, and summary into it.
+ */
+func prepareOutputDir(configFile string, config *Config) error {
+	dir := filepath.Join(*outputDir, "dbbench-"+time.Now().Format("20060102-150405"))
+	if err := os.MkdirAll(filepath.Join(dir, "files"), 0755); err != nil {
+		return err
+	}
+
+	if err := copyFile(configFile, filepath.Join(dir, filepath.Base(configFile))); err != nil {
+		return err
+	}
+	for i, file := range config.Files {
+		// Prefix the index, since files in different directories may share a name.
+		dst := filepath.Join(dir, "files", fmt.Sprintf("%d-%s", i, filepath.Base(file)))
+		if err := copyFile(file, dst); err != nil {
+			return err
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "config.txt"),
+		[]byte(config.String()+"\n"), 0644); err != nil {
+		return err
+	}
+
+	if queryStatsFile.GetFile() == nil || queryStatsInOutputDir {
+		queryStatsFile.Set(filepath.Join(dir, "query-stats.csv"))
+		if err := queryStatsFile.Open(); err != nil {
+			return err
+		}
+		queryStatsInOutputDir = true
+	}
+
+	closeOutputDir()
+	var err error
+	if runLog, err = os.Create(filepath.Join(dir, "dbbench.log")); err != nil {
+		return err
+	}
+	log.SetOutput(io.MultiWriter(os.Stderr, runLog))
+	if runSummary, err = os.Create(filepath.Join(dir, "summary.txt")); err != nil {
+		return err
+	}
+
+	log.Printf("Writing output to %s", dir)
+	return nil
+}
+
+func closeOutputDir() {
+	if runLog != nil {
+		log.SetOutput(os.Stderr)
+		runLog.Close()
+		runLog = nil
+	}
+	if runSummary != nil {
+		runSummary.Close()
+		runSummary = nil
+	}
+}
+
+/*
+ * Logs part of the final summary of a run, also writing it to the summary
+ * file if output-dir is set.
+ */
+func logSummary(format string, v ...interface{}) {
+	log.Printf(format, v...)
+	if runSummary != nil {
+		fmt.Fprintf(runSummary, format+"\n", v...)
+	}
+}
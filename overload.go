@@ -0,0 +1,134 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+/*
+ * Pauses all jobs while a server health metric (e.g. Threads_running) is over
+ * a threshold, so that an unattended run cannot tip over a shared server. The
+ * jobs resume once the metric is back at or under the resume level.
+ */
+type overloadGuard struct {
+	query     string
+	threshold float64
+	resume    float64
+
+	m sync.Mutex
+	// Closed while the jobs may run; replaced when they are paused.
+	open        chan struct{}
+	pausedSince time.Time
+	throttled   time.Duration
+	pauses      int
+}
+
+func newOverloadGuard(config *Config) *overloadGuard {
+	open := make(chan struct{})
+	close(open)
+	return &overloadGuard{
+		query:     config.OverloadQuery,
+		threshold: config.OverloadThreshold,
+		resume:    config.OverloadResume,
+		open:      open,
+	}
+}
+
+/*
+ * Waits until the jobs may run, returning false if the context was done
+ * first.
+ */
+func (og *overloadGuard) Wait(ctx context.Context) bool {
+	og.m.Lock()
+	open := og.open
+	og.m.Unlock()
+
+	select {
+	case <-open:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Pauses or resumes the jobs by a sample of the metric.
+func (og *overloadGuard) update(value float64) {
+	og.m.Lock()
+	defer og.m.Unlock()
+
+	paused := !og.pausedSince.IsZero()
+	if !paused && value > og.threshold {
+		log.Printf("Pausing all jobs: overload-query returned %g, over %g", value, og.threshold)
+		og.open = make(chan struct{})
+		og.pausedSince = clock.Now()
+		og.pauses++
+	} else if paused && value <= og.resume {
+		log.Printf("Resuming all jobs: overload-query returned %g", value)
+		og.release()
+	}
+}
+
+// Lets the jobs run again. The caller must hold the lock.
+func (og *overloadGuard) release() {
+	if !og.pausedSince.IsZero() {
+		og.throttled += clockSince(og.pausedSince)
+		og.pausedSince = time.Time{}
+		close(og.open)
+	}
+}
+
+/*
+ * Samples the overload-query every stats interval, pausing and resuming the
+ * jobs, until the context is done. The jobs are resumed when it is.
+ */
+func (og *overloadGuard) Run(ctx context.Context, db Database) {
+	ticker := clock.NewTicker(*updateInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			og.m.Lock()
+			og.release()
+			og.m.Unlock()
+			return
+		case <-ticker.Chan():
+			value, err := sampleMetric(db, og.query)
+			if err != nil {
+				log.Printf("error sampling overload-query: %v", err)
+				continue
+			}
+			og.update(value)
+		}
+	}
+}
+
+// How long, and how many times, the jobs were paused.
+func (og *overloadGuard) Throttled() (time.Duration, int) {
+	og.m.Lock()
+	defer og.m.Unlock()
+
+	throttled := og.throttled
+	if !og.pausedSince.IsZero() {
+		throttled += clockSince(og.pausedSince)
+	}
+	return throttled, og.pauses
+}
@@ -0,0 +1,55 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestOverloadGuard(t *testing.T) {
+	og := newOverloadGuard(&Config{OverloadQuery: "select 1", OverloadThreshold: 10, OverloadResume: 5})
+	ctx := context.Background()
+	if !og.Wait(ctx) {
+		t.Fatal("Expected the jobs to run before the first sample")
+	}
+
+	og.update(11)
+	waitCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if og.Wait(waitCtx) {
+		t.Fatal("Expected the jobs to be paused over the threshold")
+	}
+
+	// Under the threshold, but not yet at the resume level.
+	og.update(8)
+	if _, pauses := og.Throttled(); pauses != 1 {
+		t.Errorf("Expected 1 pause, got %d", pauses)
+	}
+	done := make(chan bool)
+	go func() { done <- og.Wait(ctx) }()
+	og.update(5)
+	if !<-done {
+		t.Fatal("Expected the jobs to resume at the resume level")
+	}
+
+	throttled, pauses := og.Throttled()
+	if pauses != 1 || throttled < 10*time.Millisecond {
+		t.Errorf("Expected 1 pause of at least 10ms, got %d for %v", pauses, throttled)
+	}
+}
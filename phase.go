@@ -0,0 +1,126 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Sections with this prefix describe phases rather than jobs.
+const phaseSectionPrefix = "phase:"
+
+/*
+ * A stage of the run (e.g. loading data, then a mixed workload, then reads
+ * only) in which only the jobs that name it run. The phases run one after
+ * another in the order of the runfile, and the stats of each are reported
+ * separately.
+ */
+type Phase struct {
+	Name     string
+	Duration time.Duration
+	Setup    []string
+	Teardown []string
+}
+
+func (p *Phase) String() string {
+	return quotedStruct(p)
+}
+
+// The config of a run of the jobs of the phase.
+func (p *Phase) config(config *Config) *Config {
+	phaseConfig := *config
+	phaseConfig.Duration = p.Duration
+	// The duration of the whole run is checked once all phases are done.
+	phaseConfig.MinDuration, phaseConfig.MaxDuration = 0, 0
+	phaseConfig.Jobs = make(map[string]*Job)
+	for name, job := range config.Jobs {
+		if job.inPhase(p.Name) {
+			phaseConfig.Jobs[name] = job
+		}
+	}
+	return &phaseConfig
+}
+
+func (job *Job) inPhase(phase string) bool {
+	for _, p := range job.Phases {
+		if p == phase {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ * Runs each phase of the config in turn, with its setup and teardown,
+ * recording the stats of its jobs, and returns the problems found by the run
+ * guards.
+ */
+func runPhases(ctx context.Context, db, jobDb Database, df DatabaseFlavor, config *Config, summary *summaryJSON) []string {
+	var problems []string
+	var overview []string
+	for _, phase := range config.Phases {
+		if ctx.Err() != nil {
+			break
+		}
+		log.Printf("Running phase %s", phase.Name)
+		runQueries(db, "phase "+phase.Name+" setup", phase.Setup, nil)
+
+		phaseConfig := phase.config(config)
+		phaseStart := clock.Now()
+		stats := runJobs(ctx, jobDb, df, phaseConfig)
+		elapsed := clockSince(phaseStart)
+		for _, problem := range checkRunGuards(phaseConfig, elapsed, stats) {
+			problems = append(problems, fmt.Sprintf("phase %s: %s", phase.Name, problem))
+		}
+		if resultsDb != nil {
+			resultsDb.RecordJobStats(phase.Name, stats)
+		}
+		if jsonOutput() {
+			summary.Phases = append(summary.Phases, &phaseJSON{phase.Name, jsonMicros(elapsed), jobStatsJSONs(phaseConfig, stats)})
+		} else {
+			logJobSummaries(phaseConfig, stats, phase.Name+": ")
+			overview = append(overview, phaseOverview(phase.Name, elapsed, stats))
+		}
+
+		runQueries(db, "phase "+phase.Name+" teardown", phase.Teardown, nil)
+	}
+	for _, line := range overview {
+		logSummary("%s", line)
+	}
+	return problems
+}
+
+// A line summing up the stats of all jobs of a phase.
+func phaseOverview(name string, elapsed time.Duration, stats map[string]*JobStats) string {
+	names := make([]string, 0, len(stats))
+	var transactions int
+	var errors uint64
+	for job, s := range stats {
+		names = append(names, strconv.Quote(job))
+		transactions += s.jobStats.Transactions.Count()
+		errors += s.TotalErrors
+	}
+	sort.Strings(names)
+	return fmt.Sprintf("phase %s: %v, %d transactions (%.3f TPS), %d errors, jobs %v",
+		name, elapsed.Round(time.Millisecond), transactions,
+		float64(transactions)/elapsed.Seconds(), errors, names)
+}
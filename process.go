@@ -21,6 +21,9 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"math"
+	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -29,7 +32,19 @@ import (
 var confidence = flag.Float64("confidence", 0.99, "Confidence interval.")
 var updateInterval = flag.Duration("intermediate-stats-interval", 1*time.Second,
 	"Show intermediate stats at this interval.")
+var alignStatsToClock = flag.Bool("align-stats-to-clock", false,
+	"End the intermediate stats windows on wall clock multiples of the "+
+		"intermediate-stats-interval (e.g. on the minute) rather than on "+
+		"multiples of it since the start of the run; the first window is then shorter.")
 var intermediateUpdates = flag.Bool("intermediate-stats", true, "Show intermediate stats every update-interval.")
+var intermediateStatsJobs = flag.String("intermediate-stats-jobs", "",
+	"Only show the intermediate stats of these comma separated jobs; the "+
+		"others are still included in the final stats and the stats files.")
+var statsByWorker = flag.Bool("stats-by-worker", false,
+	"Also show the final stats of each queue-depth worker, to reveal skew across workers.")
+var slowestInvocationCount = flag.Int("slowest-invocations", 0,
+	"Also show the queries, arguments and errors of this many of the slowest "+
+		"invocations of each job in its final stats.")
 
 /*
  * We use a FileFlagValue so that the query-stats-file is opened when we
@@ -39,28 +54,81 @@ var queryStatsFile WriteFileFlagValue
 
 func init() {
 	flag.Var(&queryStatsFile, "query-stats-file",
-		"Log query specific stats to CSV file. <job name, start micros, elapsed micros, rows affected>")
+		"Log query specific stats to CSV file, in the query-stats-schema.")
 }
 
 type jobStats struct {
 	Transactions   StreamingStats
 	Errors         StreamingStats
+	Latencies      LatencyHistogram
 	Queries        uint64
 	RowsAffected   int64
 	TotalErrors    uint64
 	AcceptedErrors uint64
+	NonRepeatable  uint64
+	Retries        uint64
+	QueueWait      LatencyHistogram
+	Connect        LatencyHistogram
+	Dropped        uint64
+	Cost           float64
 	Start          time.Duration
 	Stop           time.Duration
+	// The number of transactions under each latency-sla threshold.
+	SLA []uint64
 }
 
 type JobStats struct {
 	jobStats
 	Transactions StreamingHistogram
 	Errors       StreamingHistogram
+	Throughput   IntervalThroughput
+	Latency      IntervalLatency
+
+	ErrorTimeline ErrorTimeline
+
+	// Stats of each worker, if stats-by-worker is set.
+	Workers map[int]*jobStats
+
+	// Stats of each step (from 1) of the rate-ramp or concurrency-ramp of
+	// the job.
+	Steps map[int]*jobStats
+
+	// Transaction latency keyed by the utilization (rounded to the nearest
+	// integer) sampled when the transaction completed.
+	LatencyByUtilization map[int64]*StreamingStats
+
+	// Stats of each query of a job that runs more than one per invocation,
+	// in the order the queries were first run.
+	PerQuery []*queryStats
+
+	// The slowest invocations of the job, if slowest-invocations is set.
+	Slowest *slowestInvocations
+}
+
+type queryStats struct {
+	Query     string
+	Latencies LatencyHistogram
+	Elapsed   time.Duration
+	Rows      int64
+}
+
+func (js *JobStats) statsOf(query string) *queryStats {
+	for _, qs := range js.PerQuery {
+		if qs.Query == query {
+			return qs
+		}
+	}
+	qs := &queryStats{Query: query}
+	js.PerQuery = append(js.PerQuery, qs)
+	return qs
 }
 
 func (js *jobStats) Update(config *Config, jr *JobResult) {
-	js.AcceptedErrors += jr.Errors.TotalAccepted(config.Flavor, config.AcceptedErrors)
+	if jr.Dropped {
+		js.Dropped++
+		return
+	}
+	js.AcceptedErrors += jr.Errors.TotalAccepted(config)
 	if totalErrors := jr.Errors.TotalErrors(); totalErrors > 0 {
 		// TODO(msilver): why do we have both? it appears the concept of "transaction" within dbbench maps to one end to
 		// end execution of a job, even if that job contains multiple queries (this is only possible with the
@@ -73,8 +141,30 @@ func (js *jobStats) Update(config *Config, jr *JobResult) {
 		// Only count transactions that succeed
 		js.RowsAffected += jr.RowsAffected
 		js.Transactions.Add(float64(jr.Elapsed))
+		js.Latencies.Add(jr.Elapsed)
+		if len(latencySLA) > 0 {
+			if js.SLA == nil {
+				js.SLA = make([]uint64, len(latencySLA))
+			}
+			for i, d := range latencySLA {
+				if jr.Elapsed < d {
+					js.SLA[i]++
+				}
+			}
+		}
 	}
 	js.Queries += uint64(jr.Queries)
+	js.NonRepeatable += uint64(jr.NonRepeatable)
+	js.Retries += uint64(jr.Retries)
+	if jr.QueueWait > 0 {
+		js.QueueWait.Add(jr.QueueWait)
+	}
+	if jr.Connect > 0 {
+		js.Connect.Add(jr.Connect)
+	}
+	if config.CostModel != nil {
+		js.Cost += config.CostModel.Cost(jr)
+	}
 	if js.Start == 0 || jr.Start < js.Start {
 		js.Start = jr.Start
 	}
@@ -83,87 +173,600 @@ func (js *jobStats) Update(config *Config, jr *JobResult) {
 	}
 }
 
+// Transactions per second between the first and last result.
+func (js *jobStats) TPS() float64 {
+	return float64(js.Transactions.Count()) / (js.Stop.Seconds() - js.Start.Seconds())
+}
+
+// Invocations (successful or not) per second between the first and last result.
+func (js *jobStats) InvocationsPerSecond() float64 {
+	return float64(js.Transactions.Count()+js.Errors.Count()) / (js.Stop.Seconds() - js.Start.Seconds())
+}
+
+/*
+ * The invocations per second requested of a job run at a fixed rate (by rate
+ * or qps), or 0 for other jobs.
+ */
+func requestedQPS(job *Job) float64 {
+	if job == nil || job.Rate == 0 || job.RateRamp != nil {
+		return 0
+	}
+	return job.Rate * float64(job.BatchSize)
+}
+
+/*
+ * The invocations per second that the jitter of a queue-depth job paces it
+ * to, given its mean latency: each worker waits the mean jitter before each
+ * of its invocations. 0 for jobs without jitter.
+ */
+func jitterPacedQPS(job *Job, js *JobStats) float64 {
+	if job == nil || job.JitterMax == 0 || job.ConcurrencyRamp != nil {
+		return 0
+	}
+	n := js.jobStats.Transactions.Count() + js.jobStats.Errors.Count()
+	if n == 0 {
+		return 0
+	}
+	latency := (js.jobStats.Transactions.Mean()*float64(js.jobStats.Transactions.Count()) +
+		js.jobStats.Errors.Mean()*float64(js.jobStats.Errors.Count())) / float64(n)
+	cycle := time.Duration(latency) + (job.JitterMin+job.JitterMax)/2
+	return float64(job.QueueDepth) / cycle.Seconds()
+}
+
 func (js *jobStats) String() string {
 	jsTime := js.Stop.Seconds() - js.Start.Seconds()
-	return fmt.Sprintf("%d transactions (%.3f TPS), latency %v±%v; %d rows (%.3f RPS), %d queries (%.3f QPS); %d aborts (%.3f%%), latency %v±%v",
-		js.Transactions.Count(), float64(js.Transactions.Count())/jsTime,
-		time.Duration(js.Transactions.Mean()), time.Duration(js.Transactions.Confidence(*confidence)),
+	var percentiles string
+	if js.Latencies.Count() > 0 && len(latencyPercentiles) > 0 {
+		percentiles = fmt.Sprintf(" (%s)", js.Latencies.PercentilesString(latencyPercentiles))
+	}
+	str := fmt.Sprintf("%d transactions (%.3f TPS), latency %v±%v%s; %d rows (%.3f RPS), %d queries (%.3f QPS); %d aborts (%.3f%%), latency %v±%v",
+		js.Transactions.Count(), js.TPS(),
+		time.Duration(js.Transactions.Mean()), time.Duration(js.Transactions.Confidence(*confidence)), percentiles,
 		js.RowsAffected, float64(js.RowsAffected)/jsTime,
 		js.Queries, float64(js.Queries)/jsTime,
 		// TODO(msilver) see above re inconsistent counting methods. Should we divide by js.Transactions.Count() instead?
 		js.TotalErrors, 100*float64(js.TotalErrors)/float64(js.Queries),
 		time.Duration(js.Errors.Mean()), time.Duration(js.Errors.Confidence(*confidence)))
+	if js.SLA != nil {
+		labels := slaLabels()
+		str += "; latency SLA"
+		for i, p := range slaPercentages(js.SLA, js.Transactions.Count()) {
+			str += fmt.Sprintf(" %s %.2f%%", labels[i], p)
+		}
+	}
+	if js.NonRepeatable > 0 {
+		str += fmt.Sprintf("; %d non-repeatable results", js.NonRepeatable)
+	}
+	if js.Retries > 0 {
+		str += fmt.Sprintf("; %d retries", js.Retries)
+	}
+	if js.QueueWait.Count() > 0 {
+		waits := js.QueueWait.Percentiles(50, 99)
+		str += fmt.Sprintf("; client queue wait p50 %v p99 %v max %v",
+			waits[0], waits[1], js.QueueWait.Max())
+	}
+	if js.Connect.Count() > 0 {
+		connects := js.Connect.Percentiles(50, 99)
+		str += fmt.Sprintf("; connect latency p50 %v p99 %v max %v",
+			connects[0], connects[1], js.Connect.Max())
+	}
+	if js.Dropped > 0 {
+		str += fmt.Sprintf("; %d dropped late", js.Dropped)
+	}
+	if js.Cost > 0 {
+		str += fmt.Sprintf("; estimated cost %.4f", js.Cost)
+		if count := js.Transactions.Count(); count > 0 {
+			str += fmt.Sprintf(" (%.4f per 1k transactions)", 1000*js.Cost/float64(count))
+		}
+	}
+	return str
 }
 
-func (js *JobStats) Update(config *Config, jr *JobResult) {
-	unhandledErrors := jr.Errors.UnhandledErrors(config.Flavor, config.AcceptedErrors)
-	if len(unhandledErrors) > 0 {
-		log.Fatalf("Unexpected errors while running %v:\n%v", jr.Name, unhandledErrors)
+// Exits if the result has errors that are not accepted, unless they are shown.
+func checkUnhandledErrors(config *Config, jr *JobResult) {
+	if job, ok := config.Jobs[jr.Name]; ok && job.errorBudget != nil {
+		// The job aborts the run itself once it has too many errors.
+		return
+	}
+	unhandledErrors := jr.Errors.UnhandledErrors(config)
+	if len(unhandledErrors) > 0 && config.ShowErrors {
+		if config.shownErrors == nil {
+			config.shownErrors = make(Set)
+		}
+		for code, ec := range unhandledErrors {
+			if !config.shownErrors.Contains(code) {
+				config.shownErrors.Add(code)
+				log.Printf("%s: error %s: %v", jr.Name, code, ec.Error)
+			}
+		}
+	} else if len(unhandledErrors) > 0 {
+		fatalf(exitQueryErrors, "Unexpected errors while running %v:\n%v", jr.Name, unhandledErrors)
 	}
+}
+
+func (js *JobStats) Update(config *Config, jr *JobResult) {
+	checkUnhandledErrors(config, jr)
 	js.jobStats.Update(config, jr)
+	if *statsByWorker && jr.Worker > 0 {
+		if js.Workers == nil {
+			js.Workers = make(map[int]*jobStats)
+		}
+		if _, ok := js.Workers[jr.Worker]; !ok {
+			js.Workers[jr.Worker] = new(jobStats)
+		}
+		js.Workers[jr.Worker].Update(config, jr)
+	}
+	if jr.RampStep > 0 {
+		if js.Steps == nil {
+			js.Steps = make(map[int]*jobStats)
+		}
+		if _, ok := js.Steps[jr.RampStep]; !ok {
+			js.Steps[jr.RampStep] = new(jobStats)
+		}
+		js.Steps[jr.RampStep].Update(config, jr)
+	}
+	if jr.Dropped {
+		return
+	}
+	if *slowestInvocationCount > 0 {
+		if js.Slowest == nil {
+			js.Slowest = newSlowestInvocations(*slowestInvocationCount)
+		}
+		js.Slowest.Add(jr)
+	}
+	for _, qt := range jr.PerQuery {
+		qs := js.statsOf(qt.Query)
+		qs.Latencies.Add(qt.Elapsed)
+		qs.Elapsed += qt.Elapsed
+		qs.Rows += qt.Rows
+	}
 	if jr.Errors.TotalErrors() == 0 {
 		js.Transactions.Add(uint64(jr.Elapsed))
+		if jr.Utilization > 0 {
+			if js.LatencyByUtilization == nil {
+				js.LatencyByUtilization = make(map[int64]*StreamingStats)
+			}
+			bucket := int64(math.Round(jr.Utilization))
+			if _, ok := js.LatencyByUtilization[bucket]; !ok {
+				js.LatencyByUtilization[bucket] = new(StreamingStats)
+			}
+			js.LatencyByUtilization[bucket].Add(float64(jr.Elapsed))
+		}
 	} else {
 		js.Errors.Add(uint64(jr.Elapsed))
+		js.ErrorTimeline.Add(jr.Start, jr.Errors)
 	}
 }
 
 func (js *JobStats) String() string {
 	var str strings.Builder
-	str.WriteString(fmt.Sprintf("%v\nTransactions:\n%v", js.jobStats.String(), js.Transactions.Histogram()))
+	str.WriteString(js.jobStats.String())
+	if js.Throughput.Intervals.Count() > 1 {
+		str.WriteString(fmt.Sprintf("; %v", &js.Throughput))
+	}
+	if js.Latency.Means.Count() > 1 {
+		str.WriteString(fmt.Sprintf("; %v", &js.Latency))
+	}
+	str.WriteString(fmt.Sprintf("\nTransactions:\n%v", transactionHistogram(&js.Transactions, &js.Latencies)))
+	if js.Latencies.Count() > 0 {
+		str.WriteString(fmt.Sprintf("Latency distribution:\n%v", js.Latencies.Distribution()))
+	}
 	if abortHistogram := js.Errors.Histogram(); len(abortHistogram) > 0 {
 		str.WriteString(fmt.Sprintf("Aborts:\n%v", abortHistogram))
 	}
+	str.WriteString(js.ErrorTimeline.String())
+	if len(js.Workers) > 0 {
+		str.WriteString("Workers:\n")
+		workers := make([]int, 0, len(js.Workers))
+		for worker := range js.Workers {
+			workers = append(workers, worker)
+		}
+		sort.Ints(workers)
+		for _, worker := range workers {
+			str.WriteString(fmt.Sprintf("%12d: %v\n", worker, js.Workers[worker]))
+		}
+	}
+	if len(js.Steps) > 0 {
+		str.WriteString("Ramp steps:\n")
+		steps := make([]int, 0, len(js.Steps))
+		for step := range js.Steps {
+			steps = append(steps, step)
+		}
+		sort.Ints(steps)
+		for _, step := range steps {
+			str.WriteString(fmt.Sprintf("%12d: %v\n", step, js.Steps[step]))
+		}
+	}
+	if len(js.PerQuery) > 0 {
+		var total time.Duration
+		for _, qs := range js.PerQuery {
+			total += qs.Elapsed
+		}
+		str.WriteString("Queries:\n")
+		for _, qs := range js.PerQuery {
+			ps := qs.Latencies.Percentiles(50, 99)
+			str.WriteString(fmt.Sprintf("%11.1f%%: mean %v p50 %v p99 %v, %d rows [%6d] %s\n",
+				100*float64(qs.Elapsed)/float64(total),
+				qs.Elapsed/time.Duration(qs.Latencies.Count()), ps[0], ps[1],
+				qs.Rows, qs.Latencies.Count(), abbreviateQuery(qs.Query)))
+		}
+	}
+	if js.Slowest != nil && js.Slowest.Len() > 0 {
+		str.WriteString(fmt.Sprintf("Slowest invocations:\n%v", js.Slowest))
+	}
+	if len(js.LatencyByUtilization) > 0 {
+		str.WriteString("Latency by utilization:\n")
+		buckets := make([]int64, 0, len(js.LatencyByUtilization))
+		for bucket := range js.LatencyByUtilization {
+			buckets = append(buckets, bucket)
+		}
+		sort.Slice(buckets, func(i, j int) bool { return buckets[i] < buckets[j] })
+		for _, bucket := range buckets {
+			stats := js.LatencyByUtilization[bucket]
+			str.WriteString(fmt.Sprintf("%12d: %v [%6d]\n",
+				bucket, time.Duration(stats.Mean()), stats.Count()))
+		}
+	}
 	return str.String()
 }
 
-func processResults(config *Config, resultChan <-chan *JobResult) map[string]*JobStats {
-	var resultFile *csv.Writer
+// Returns the query on a single line, shortened to fit in a table.
+func abbreviateQuery(query string) string {
+	const max = 60
+	runes := []rune(strings.Join(strings.Fields(query), " "))
+	if len(runes) > max {
+		return string(runes[:max-3]) + "..."
+	}
+	return string(runes)
+}
+
+// The files that already start with their schema header.
+var headerWritten = make(map[*os.File]bool)
+
+/*
+ * Writes the header of the schema unless it was already written to f, since
+ * the same file is used by every run of a process (e.g. with -watch).
+ */
+func writeHeaderOnce(f *os.File, schema *csvSchema, w *csv.Writer) {
+	if headerWritten[f] {
+		return
+	}
+	if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() && fi.Size() > 0 {
+		// Appended to with -resume.
+		headerWritten[f] = true
+		return
+	}
+	headerWritten[f] = true
+	schema.WriteHeader(w)
+}
+
+/*
+ * Returns the jobs whose intermediate stats are shown, checking that the jobs
+ * of intermediate-stats-jobs exist.
+ */
+func intermediateStatsShown(config *Config) (map[string]bool, error) {
+	var only map[string]bool
+	if *intermediateStatsJobs != "" {
+		only = make(map[string]bool)
+		for _, name := range strings.Split(*intermediateStatsJobs, ",") {
+			if _, ok := config.Jobs[name]; !ok {
+				return nil, fmt.Errorf("intermediate-stats-jobs: unknown job %s", strconv.Quote(name))
+			}
+			only[name] = true
+		}
+	}
+
+	shown := make(map[string]bool)
+	for name, job := range config.Jobs {
+		shown[name] = !job.HideIntermediateStats && (only == nil || only[name])
+	}
+	return shown, nil
+}
+
+/*
+ * An intermediate stats window from Start to End, where Origin is the start of
+ * the run.
+ */
+type statsWindow struct {
+	Origin, Start, End time.Time
+}
+
+/*
+ * Fires at the end of each stats window. Unlike a time.Ticker, the windows do
+ * not drift with the delivery of ticks: the Nth window ends N intervals after
+ * the start of the run (or on a wall clock boundary), so interval N of one
+ * run lines up with interval N of another. A window whose end was missed
+ * (e.g. because processing fell behind) is extended to the last boundary.
+ */
+type statsWindows struct {
+	interval time.Duration
+	current  statsWindow
+	timer    Timer
+}
+
+func newStatsWindows(origin time.Time, interval time.Duration, alignToClock bool) *statsWindows {
+	end := origin.Add(interval)
+	if alignToClock {
+		end = origin.Truncate(interval).Add(interval)
+	}
+	return &statsWindows{
+		interval: interval,
+		current:  statsWindow{origin, origin, end},
+		timer:    clock.NewTimer(clockUntil(end)),
+	}
+}
+
+func (sw *statsWindows) C() <-chan time.Time {
+	return sw.timer.Chan()
+}
+
+// Returns the window that ended by now and starts the next one.
+func (sw *statsWindows) Next(now time.Time) statsWindow {
+	w := sw.current
+	for !w.End.Add(sw.interval).After(now) {
+		w.End = w.End.Add(sw.interval)
+	}
+	sw.current = statsWindow{w.Origin, w.End, w.End.Add(sw.interval)}
+	sw.timer.Reset(clockUntil(sw.current.End))
+	return w
+}
+
+func (sw *statsWindows) Stop() {
+	sw.timer.Stop()
+}
+
+/*
+ * Aggregates the job results into stats until the results queue is closed.
+ *
+ * The phases of the events of the config are received on phases. The
+ * intermediate stats are labeled with the events in progress, and the stats
+ * before, during, and after each event are logged at the end.
+ */
+func processResults(config *Config, results *ResultQueue, phases <-chan eventPhase) map[string]*JobStats {
 	var allTestStats = make(map[string]*JobStats)
+	// A resumed run picks up the stats where its checkpoint left them, and
+	// its results continue the times of those before.
+	var resumedElapsed time.Duration
+	if resumed != nil {
+		allTestStats, resumedElapsed = resumed.JobStats(), resumed.Elapsed
+	}
 	var recentTestStats = make(map[string]*jobStats)
 
-	if queryStatsFile.GetFile() != nil {
-		defer queryStatsFile.GetFile().Close()
-		resultFile = csv.NewWriter(queryStatsFile.GetFile())
-		defer resultFile.Flush()
+	// event name -> phase, and event name -> phase -> job name -> stats
+	var eventPhases = make(map[string]string)
+	var eventStats = make(map[string]map[string]map[string]*jobStats)
+	var eventWindows = make(map[string]*eventWindow)
+	// The events in progress at any time during the current stats window.
+	var windowEvents []string
+	for _, event := range config.Events {
+		eventPhases[event.Name] = beforeEvent
+		eventWindows[event.Name] = new(eventWindow)
+		eventStats[event.Name] = map[string]map[string]*jobStats{
+			beforeEvent: make(map[string]*jobStats),
+			duringEvent: make(map[string]*jobStats),
+			afterEvent:  make(map[string]*jobStats),
+		}
 	}
 
-	ticker := time.NewTicker(*updateInterval)
-	if !*intermediateUpdates {
-		ticker.Stop()
+	sinks, err := newStatsSinks(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	processStart := clock.Now()
+
+	var alerts = make(map[string]*latencyAlert)
+	for name, job := range config.Jobs {
+		if job.AlertP99 > 0 {
+			alerts[name] = newLatencyAlert(job.AlertP99, job.AlertIntervals)
+		}
+	}
+
+	// Unless only the query-stats-file is written, the windows end even when
+	// intermediate stats are not shown so that the per-interval throughput of
+	// each job can be tracked.
+	var windows *statsWindows
+	var windowEnds <-chan time.Time
+	trackWindows := windowsNeeded(sinks, alerts)
+	if trackWindows {
+		windows = newStatsWindows(processStart, *updateInterval, *alignStatsToClock)
+		defer windows.Stop()
+		windowEnds = windows.C()
+	}
+
+	var checkpoints <-chan time.Time
+	if *checkpointFile != "" {
+		ticker := clock.NewTicker(*checkpointInterval)
+		defer ticker.Stop()
+		checkpoints = ticker.Chan()
+	}
+	checkpoint := func(done bool) {
+		rc := newRunCheckpoint(allTestStats, resumedElapsed+clockSince(processStart), done)
+		if err := writeCheckpoint(*checkpointFile, rc); err != nil {
+			log.Printf("Error saving the checkpoint: %v", err)
+		}
 	}
-	defer ticker.Stop()
 
 	for {
 		select {
-		case jr, ok := <-resultChan:
+		case jr, ok := <-results.Results():
 			if !ok {
+				results.LogOverflows()
+				if *checkpointFile != "" {
+					checkpoint(true)
+				}
+				for _, sink := range sinks {
+					sink.Close()
+				}
+				if !trackWindows && *reconstructIntervals && len(sinks) > 0 {
+					// Only the query-stats-file was written.
+					qs := sinks[0].(*queryStatsSink)
+					if err := qs.reconstructIntervals(allTestStats, processStart, clockSince(processStart), *updateInterval); err != nil {
+						log.Printf("Error reconstructing the intervals from the query-stats-file: %v", err)
+					}
+				}
+				for _, event := range config.Events {
+					window := eventWindows[event.Name]
+					if !jsonOutput() {
+						logSummary("Event %s %s", strconv.Quote(event.Name), window.describe(processStart.Add(-resumedElapsed)))
+					}
+					for _, phase := range []string{beforeEvent, duringEvent, afterEvent} {
+						for name, stats := range eventStats[event.Name][phase] {
+							if jsonOutput() {
+								writeJSONRecord(&eventJSON{"event", name, event.Name, phase,
+									window.Start, window.End, stats.JSON()}, true)
+							} else {
+								logSummary("%s (%s %s): %v", name, phase, event.Name, stats)
+							}
+						}
+					}
+				}
 				return allTestStats
 			}
-			if resultFile != nil {
-				resultFile.Write([]string{
-					jr.Name,
-					strconv.FormatInt(jr.Start.Nanoseconds()/1000, 10),
-					strconv.FormatInt(jr.Elapsed.Nanoseconds()/1000, 10),
-					strconv.FormatInt(jr.RowsAffected, 10),
-					strconv.FormatUint(jr.Errors.TotalErrors(), 10),
-				})
+			jr.Start += resumedElapsed
+			for _, sink := range sinks {
+				sink.Result(jr)
 			}
-			if _, ok := allTestStats[jr.Name]; !ok {
-				allTestStats[jr.Name] = new(JobStats)
+			if trackWindows {
+				if _, ok := recentTestStats[jr.Name]; !ok {
+					recentTestStats[jr.Name] = new(jobStats)
+				}
+				recentTestStats[jr.Name].Update(config, jr)
 			}
-			if _, ok := recentTestStats[jr.Name]; !ok {
-				recentTestStats[jr.Name] = new(jobStats)
+			if jr.Warmup {
+				// Unexpected errors during the warmup still stop the run.
+				checkUnhandledErrors(config, jr)
+				continue
 			}
 
+			if _, ok := allTestStats[jr.Name]; !ok {
+				allTestStats[jr.Name] = &JobStats{ErrorTimeline: ErrorTimeline{Interval: *updateInterval}}
+			}
 			allTestStats[jr.Name].Update(config, jr)
-			recentTestStats[jr.Name].Update(config, jr)
+			for event, phase := range eventPhases {
+				stats := eventStats[event][phase]
+				if _, ok := stats[jr.Name]; !ok {
+					stats[jr.Name] = new(jobStats)
+				}
+				stats[jr.Name].Update(config, jr)
+			}
 
-		case <-ticker.C:
-			for name, stats := range recentTestStats {
-				log.Printf("%s: %v", name, stats)
+		case ep := <-phases:
+			eventPhases[ep.name] = ep.phase
+			eventWindows[ep.name].set(ep.phase, clock.Now())
+			if ep.phase == duringEvent {
+				windowEvents = append(windowEvents, ep.name)
+			}
+
+		case <-checkpoints:
+			checkpoint(false)
+
+		case now := <-windowEnds:
+			window := windows.Next(now)
+			for name, stats := range allTestStats {
+				var transactions int
+				if recent, ok := recentTestStats[name]; ok {
+					transactions = recent.Transactions.Count()
+					if transactions > 0 {
+						stats.Latency.Add(time.Duration(recent.Transactions.Mean()), window.End)
+					}
+				}
+				stats.Throughput.Add(transactions, window.End.Sub(window.Start), window.End)
+			}
+			for name, alert := range alerts {
+				var latencies LatencyHistogram
+				if recent, ok := recentTestStats[name]; ok {
+					latencies = recent.Latencies
+				}
+				if p99, crossed := alert.Add(&latencies); crossed {
+					alert.report(name, p99, now)
+				}
+			}
+			is := &intervalStats{window, now, windowEvents, recentTestStats}
+			for _, sink := range sinks {
+				sink.Interval(is)
 			}
 			recentTestStats = make(map[string]*jobStats)
+			windowEvents = nil
+			for _, event := range config.Events {
+				if eventPhases[event.Name] == duringEvent {
+					windowEvents = append(windowEvents, event.Name)
+				}
+			}
+		}
+	}
+}
+
+// Logs the final stats of each job, each line starting with the prefix.
+func logJobSummaries(config *Config, stats map[string]*JobStats, prefix string) {
+	for name, s := range stats {
+		logSummary("%s%s: %v", prefix, name, s)
+		if requested := requestedQPS(config.Jobs[name]); requested > 0 {
+			logSummary("%s%s: %.3f invocations per second of %.3f requested",
+				prefix, name, s.InvocationsPerSecond(), requested)
+		} else if paced := jitterPacedQPS(config.Jobs[name], s); paced > 0 {
+			job := config.Jobs[name]
+			logSummary("%s%s: %.3f invocations per second of %.3f paced by the %v..%v jitter",
+				prefix, name, s.InvocationsPerSecond(), paced, job.JitterMin, job.JitterMax)
+		}
+	}
+}
+
+/*
+ * Logs the stats of the cold and warm passes of each job, followed by a
+ * summary of how the mean latency changed once the cache was warm.
+ */
+func logCacheComparison(coldStats, warmStats map[string]*JobStats) {
+	for name, cold := range coldStats {
+		logSummary("%s (cold): %v", name, cold)
+		warm, ok := warmStats[name]
+		if !ok {
+			continue
+		}
+		logSummary("%s (warm): %v", name, warm)
+
+		coldLatency := time.Duration(cold.jobStats.Transactions.Mean())
+		warmLatency := time.Duration(warm.jobStats.Transactions.Mean())
+		if warmLatency > 0 {
+			logSummary("%s: cold latency %v, warm latency %v (%.3fx)", name,
+				coldLatency, warmLatency, float64(coldLatency)/float64(warmLatency))
 		}
 	}
 }
+
+type comparisonRound struct {
+	a, b map[string]*JobStats
+}
+
+/*
+ * Logs, for each job, the mean and standard deviation of the per-round
+ * difference between target B and target A.
+ */
+func logPairedComparison(rounds []comparisonRound) {
+	latencyDiffs := make(map[string]*StreamingStats)
+	tpsDiffs := make(map[string]*StreamingStats)
+
+	for i, round := range rounds {
+		for name, a := range round.a {
+			b, ok := round.b[name]
+			if !ok {
+				continue
+			}
+			logSummary("round %d %s (A): %v", i+1, name, &a.jobStats)
+			logSummary("round %d %s (B): %v", i+1, name, &b.jobStats)
+
+			if _, ok := latencyDiffs[name]; !ok {
+				latencyDiffs[name] = new(StreamingStats)
+				tpsDiffs[name] = new(StreamingStats)
+			}
+			latencyDiffs[name].Add(b.jobStats.Transactions.Mean() - a.jobStats.Transactions.Mean())
+			tpsDiffs[name].Add(b.TPS() - a.TPS())
+		}
+	}
+
+	for name, latencyDiff := range latencyDiffs {
+		tpsDiff := tpsDiffs[name]
+		logSummary("%s: B-A latency %v (stddev %v), B-A TPS %.3f (stddev %.3f) over %d rounds",
+			name, time.Duration(latencyDiff.Mean()), time.Duration(latencyDiff.SampleStdDev()),
+			tpsDiff.Mean(), tpsDiff.SampleStdDev(), latencyDiff.Count())
+	}
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWarmupExcludedFromFinalStats(t *testing.T) {
+	defer func(updates bool) { *intermediateUpdates = updates }(*intermediateUpdates)
+	*intermediateUpdates = false
+
+	config := &Config{Jobs: map[string]*Job{"test": {Name: "test"}}}
+	results := NewResultQueue(3)
+	done := make(chan map[string]*JobStats)
+	go func() { done <- processResults(config, results, nil) }()
+
+	results.Send(&JobResult{Name: "test", Start: 0, Elapsed: time.Second, Queries: 1, Warmup: true})
+	results.Send(&JobResult{Name: "test", Start: time.Second, Elapsed: time.Millisecond, Queries: 1})
+	results.Send(&JobResult{Name: "test", Start: 2 * time.Second, Elapsed: time.Millisecond, Queries: 1})
+	results.Close()
+	stats := <-done
+
+	if count := stats["test"].jobStats.Transactions.Count(); count != 2 {
+		t.Errorf("Expected 2 transactions after the warmup, got %d", count)
+	}
+	if mean := time.Duration(stats["test"].jobStats.Transactions.Mean()); mean != time.Millisecond {
+		t.Errorf("Expected a mean latency of 1ms after the warmup, got %v", mean)
+	}
+	if stats["test"].jobStats.Start != time.Second {
+		t.Errorf("Expected the stats to start after the warmup, got %v", stats["test"].jobStats.Start)
+	}
+}
+
+func TestShownErrorsDoNotExit(t *testing.T) {
+	defer func(updates bool) { *intermediateUpdates = updates }(*intermediateUpdates)
+	*intermediateUpdates = false
+
+	config := &Config{Jobs: map[string]*Job{"shell": {Name: "shell"}}, ShowErrors: true}
+	results := NewResultQueue(2)
+	done := make(chan map[string]*JobStats)
+	go func() { done <- processResults(config, results, nil) }()
+
+	failed := make(ErrorCounts)
+	failed["1"] = errorCounts{errorsPerQuery{"select 1": 1}, &SimulatedError{Code: "1"}}
+	results.Send(&JobResult{Name: "shell", Start: 0, Elapsed: time.Millisecond, Queries: 1, Errors: failed})
+	results.Send(&JobResult{Name: "shell", Start: time.Millisecond, Elapsed: time.Millisecond, Queries: 1, Errors: failed})
+	results.Close()
+	stats := <-done
+
+	if n := stats["shell"].TotalErrors; n != 2 {
+		t.Errorf("Expected 2 errors to be counted, got %d", n)
+	}
+	if !config.shownErrors.Contains("1") {
+		t.Errorf("Expected error 1 to be shown")
+	}
+}
+
+func TestJitterPacedQPS(t *testing.T) {
+	job := &Job{Name: "test", QueueDepth: 4, JitterMin: 10 * time.Millisecond, JitterMax: 30 * time.Millisecond}
+	js := new(JobStats)
+	if paced := jitterPacedQPS(job, js); paced != 0 {
+		t.Errorf("Expected no pacing without results, got %v", paced)
+	}
+
+	// Each worker takes 20ms of jitter and 5ms of latency per invocation.
+	js.jobStats.Transactions.Add(float64(4 * time.Millisecond))
+	js.jobStats.Transactions.Add(float64(6 * time.Millisecond))
+	if paced := jitterPacedQPS(job, js); paced < 159.99 || paced > 160.01 {
+		t.Errorf("Expected 4 workers paced to 160 invocations per second, got %v", paced)
+	}
+
+	job.JitterMin, job.JitterMax = 0, 0
+	if paced := jitterPacedQPS(job, js); paced != 0 {
+		t.Errorf("Expected no pacing without jitter, got %v", paced)
+	}
+}
@@ -0,0 +1,288 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/*
+ * A source of arguments for the queries of a job, one set of arguments per
+ * query. Read returns io.EOF once there are no more arguments.
+ */
+type QueryArgsReader interface {
+	Read() ([]interface{}, error)
+}
+
+/*
+ * Wraps a QueryArgsReader so that the first set of arguments can be
+ * inspected before the job runs.
+ */
+type peekingArgsReader struct {
+	r      QueryArgsReader
+	peeked bool
+	args   []interface{}
+	err    error
+}
+
+func (par *peekingArgsReader) Peek() ([]interface{}, error) {
+	if !par.peeked {
+		par.args, par.err = par.r.Read()
+		par.peeked = true
+	}
+	return par.args, par.err
+}
+
+func (par *peekingArgsReader) Read() ([]interface{}, error) {
+	if par.peeked {
+		par.peeked = false
+		return par.args, par.err
+	}
+	return par.r.Read()
+}
+
+/*
+ * Starts over from the beginning of the query-args-file every time it runs
+ * out of arguments (query-args-mode=loop). A file with no arguments at all
+ * still returns io.EOF rather than looping forever.
+ */
+type loopingArgsReader struct {
+	f    io.ReadSeeker
+	open func(io.Reader) QueryArgsReader
+	r    QueryArgsReader
+	read bool // Whether any args were read since the last rewind.
+
+	// Called before the file is read again from the start, if set.
+	onRewind func()
+}
+
+func newLoopingArgsReader(f io.ReadSeeker, open func(io.Reader) QueryArgsReader) *loopingArgsReader {
+	return &loopingArgsReader{f: f, open: open, r: open(f)}
+}
+
+func (lar *loopingArgsReader) Read() ([]interface{}, error) {
+	args, err := lar.r.Read()
+	if err == io.EOF && lar.read {
+		if _, err := lar.f.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if lar.onRewind != nil {
+			lar.onRewind()
+		}
+		lar.r = lar.open(lar.f)
+		lar.read = false
+		args, err = lar.r.Read()
+	}
+	if err == nil {
+		lar.read = true
+	}
+	return args, err
+}
+
+/*
+ * Returns a uniformly chosen record of the query-args-file for every read
+ * (query-args-mode=random). The whole file is loaded up front.
+ */
+type randomArgsReader struct {
+	records [][]interface{}
+	intn    func(int) int
+}
+
+func newRandomArgsReader(r QueryArgsReader, intn func(int) int) (*randomArgsReader, error) {
+	rar := &randomArgsReader{intn: intn}
+	for {
+		args, err := r.Read()
+		if err == io.EOF {
+			return rar, nil
+		} else if err != nil {
+			return nil, err
+		}
+		rar.records = append(rar.records, args)
+	}
+}
+
+func (rar *randomArgsReader) Read() ([]interface{}, error) {
+	if len(rar.records) == 0 {
+		return nil, io.EOF
+	}
+	// Copy the record, since the caller decodes the args in place.
+	record := rar.records[rar.intn(len(rar.records))]
+	return append([]interface{}(nil), record...), nil
+}
+
+type csvArgsReader struct {
+	r *csv.Reader
+}
+
+func NewCSVArgsReader(r io.Reader, delim rune) QueryArgsReader {
+	cr := csv.NewReader(r)
+	if delim != 0 {
+		cr.Comma = delim
+	}
+	return &csvArgsReader{cr}
+}
+
+func (car *csvArgsReader) Read() ([]interface{}, error) {
+	textArgs, err := car.r.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	iargs := make([]interface{}, 0, len(textArgs))
+	for _, arg := range textArgs {
+		if arg == "\\N" {
+			iargs = append(iargs, nil)
+		} else {
+			iargs = append(iargs, arg)
+		}
+	}
+	return iargs, nil
+}
+
+/*
+ * Decodes a string argument (or the string value of a named argument) with
+ * the given encoding. NULL arguments are never decoded.
+ */
+func decodeQueryArg(ce ColumnEncoding, arg interface{}) (interface{}, error) {
+	switch a := arg.(type) {
+	case string:
+		return ce.Decode(a)
+	case sql.NamedArg:
+		v, err := decodeQueryArg(ce, a.Value)
+		return sql.Named(a.Name, v), err
+	}
+	return arg, nil
+}
+
+/*
+ * Reads arguments from newline delimited JSON. Each line is either an array
+ * of positional arguments or an object of named arguments.
+ */
+type jsonArgsReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+// The longest line of a JSON query-args-file, well over the default of bufio.
+const maxJSONArgsLine = 256 << 20
+
+func NewJSONArgsReader(r io.Reader) QueryArgsReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxJSONArgsLine)
+	return &jsonArgsReader{scanner: scanner}
+}
+
+// Whether the file should be read with a jsonArgsReader.
+func isJSONArgsFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json", ".jsonl", ".ndjson":
+		return true
+	}
+	return false
+}
+
+/*
+ * Converts a decoded JSON value to a query argument. JSON null is SQL NULL
+ * and nested arrays and objects are passed as their JSON text. Bytes (e.g.
+ * the binary args of a query log) are passed as they are.
+ */
+func jsonArg(v interface{}) (interface{}, error) {
+	switch a := v.(type) {
+	case nil, string, bool, []byte:
+		return a, nil
+	case json.Number:
+		return a.String(), nil
+	default:
+		b, err := json.Marshal(a)
+		return string(b), err
+	}
+}
+
+func (jar *jsonArgsReader) Read() ([]interface{}, error) {
+	var line []byte
+	for len(line) == 0 {
+		if !jar.scanner.Scan() {
+			if err := jar.scanner.Err(); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+		jar.line++
+		line = bytes.TrimSpace(jar.scanner.Bytes())
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+	var record interface{}
+	if err := decoder.Decode(&record); err != nil {
+		return nil, fmt.Errorf("line %d: %v", jar.line, err)
+	}
+
+	args, err := jsonRecordArgs(record)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %v", jar.line, err)
+	}
+	return args, nil
+}
+
+/*
+ * Converts a decoded JSON array to positional args, or a decoded JSON object
+ * to named args.
+ */
+func jsonRecordArgs(record interface{}) ([]interface{}, error) {
+	switch r := record.(type) {
+	case []interface{}:
+		iargs := make([]interface{}, 0, len(r))
+		for _, v := range r {
+			arg, err := jsonArg(v)
+			if err != nil {
+				return nil, err
+			}
+			iargs = append(iargs, arg)
+		}
+		return iargs, nil
+	case map[string]interface{}:
+		// Sort the names so the arguments are always in the same order.
+		names := make([]string, 0, len(r))
+		for name := range r {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		iargs := make([]interface{}, 0, len(r))
+		for _, name := range names {
+			arg, err := jsonArg(r[name])
+			if err != nil {
+				return nil, err
+			}
+			iargs = append(iargs, sql.Named(name, arg))
+		}
+		return iargs, nil
+	default:
+		return nil, errors.New("expected a JSON array or object")
+	}
+}
@@ -0,0 +1,229 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONArgsReader(t *testing.T) {
+	r := NewJSONArgsReader(strings.NewReader(
+		`[1, "a", null, true]
+
+{"b": 2.5, "a": [1, 2]}
+`))
+
+	expected := [][]interface{}{
+		{"1", "a", nil, true},
+		{sql.Named("a", "[1,2]"), sql.Named("b", "2.5")},
+	}
+	for i, e := range expected {
+		args, err := r.Read()
+		if err != nil {
+			t.Fatalf("Unexpected error reading line %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(args, e) {
+			t.Errorf("Expected %v for line %d, got %v", e, i, args)
+		}
+	}
+	if _, err := r.Read(); err != io.EOF {
+		t.Errorf("Expected EOF, got %v", err)
+	}
+
+	r = NewJSONArgsReader(strings.NewReader("3\n"))
+	if _, err := r.Read(); err == nil {
+		t.Errorf("Expected error for scalar JSON record")
+	}
+
+	// Longer than the default limit of a bufio.Scanner.
+	long := strings.Repeat("x", 100000)
+	r = NewJSONArgsReader(strings.NewReader(`["` + long + `"]` + "\n"))
+	if args, err := r.Read(); err != nil {
+		t.Errorf("Unexpected error reading a long line: %v", err)
+	} else if !reflect.DeepEqual(args, []interface{}{long}) {
+		t.Errorf("Expected the long arg to be read whole")
+	}
+}
+
+func TestLoopingArgsReader(t *testing.T) {
+	open := func(r io.Reader) QueryArgsReader { return NewCSVArgsReader(r, 0) }
+	r := newLoopingArgsReader(strings.NewReader("a\nb\n"), open)
+	var rewinds int
+	r.onRewind = func() { rewinds++ }
+	for i, e := range []string{"a", "b", "a", "b", "a"} {
+		args, err := r.Read()
+		if err != nil {
+			t.Fatalf("Unexpected error reading record %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(args, []interface{}{e}) {
+			t.Errorf("Expected %v for record %d, got %v", e, i, args)
+		}
+	}
+	if rewinds != 2 {
+		t.Errorf("Expected 2 rewinds, got %d", rewinds)
+	}
+
+	r = newLoopingArgsReader(strings.NewReader(""), open)
+	if _, err := r.Read(); err != io.EOF {
+		t.Errorf("Expected EOF for an empty file, got %v", err)
+	}
+}
+
+func TestRandomArgsReader(t *testing.T) {
+	picks := []int{2, 0, 2}
+	r, err := newRandomArgsReader(
+		NewCSVArgsReader(strings.NewReader("a\nb\nc\n"), 0),
+		func(n int) int {
+			if n != 3 {
+				t.Errorf("Expected 3 records, got %d", n)
+			}
+			i := picks[0]
+			picks = picks[1:]
+			return i
+		})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for i, e := range []string{"c", "a", "c"} {
+		args, err := r.Read()
+		if err != nil {
+			t.Fatalf("Unexpected error reading record %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(args, []interface{}{e}) {
+			t.Errorf("Expected %v for record %d, got %v", e, i, args)
+		}
+		// Callers decode args in place, which must not change the records.
+		args[0] = "x"
+	}
+
+	r, _ = newRandomArgsReader(NewCSVArgsReader(strings.NewReader(""), 0), nil)
+	if _, err := r.Read(); err != io.EOF {
+		t.Errorf("Expected EOF for an empty file, got %v", err)
+	}
+}
+
+func TestQueryArgsEncodings(t *testing.T) {
+	job := &Job{
+		Name:      "test",
+		QueryArgs: NewCSVArgsReader(strings.NewReader("68690a,\\N,aGk=\n"), 0),
+		QueryArgsEncodings: []ColumnEncoding{
+			{Column: 0, Encoding: "hex"}, {Column: 2, Encoding: "base64"},
+		},
+	}
+
+	args, err := job.getNextQueryArgs()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []interface{}{[]byte("hi\n"), nil, []byte("hi")}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("Expected %v, got %v", expected, args)
+	}
+
+	ce, err := ParseColumnEncoding("hex")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	w := NewSafeCSVWriterTo(nil)
+	w.SetEncodings([]ColumnEncoding{ce})
+	if v := w.Encode(3, "hi\n"); v != "68690a" {
+		t.Errorf("Expected 68690a, got %s", v)
+	}
+}
+
+func TestQueryArgsErrorAbortsRun(t *testing.T) {
+	df := supportedDatabaseFlavors["fake"]
+	db, err := df.Connect(&ConnectionConfig{Params: "latency=1ms"})
+	if err != nil {
+		t.Fatalf("Error connecting to fake database: %v", err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	job := &Job{
+		Name:               "test",
+		Queries:            []string{"select ?"},
+		QueueDepth:         1,
+		QueryArgs:          NewCSVArgsReader(strings.NewReader("61\nzz\n62\n"), 0),
+		QueryArgsEncodings: []ColumnEncoding{{Column: 0, Encoding: "hex"}},
+		cancelRun:          cancel,
+	}
+	var results int
+	for range makeJobResultQueue(ctx, db, df, map[string]*Job{"test": job}).Results() {
+		results++
+	}
+	// The second record is not hex, so the job stops after the first.
+	prefix := "error parsing arg file for job test: column 1: "
+	if job.failure == nil || !strings.HasPrefix(job.failure.Error(), prefix) || results != 1 {
+		t.Errorf("Expected an error starting with %q after 1 invocation, got %v after %d",
+			prefix, job.failure, results)
+	}
+	if ctx.Err() == nil {
+		t.Errorf("Expected the run to be cancelled")
+	}
+	problems := checkRunGuards(&Config{Jobs: map[string]*Job{"test": job}}, 0, nil)
+	if len(problems) != 1 {
+		t.Errorf("Expected the failure to invalidate the run, got %q", problems)
+	}
+}
+
+func TestQueryArgsModeError(t *testing.T) {
+	df := supportedDatabaseFlavors["fake"]
+	db, err := df.Connect(&ConnectionConfig{Params: "latency=1ms"})
+	if err != nil {
+		t.Fatalf("Error connecting to fake database: %v", err)
+	}
+	defer db.Close()
+
+	for _, mode := range []string{"", "stop", "error"} {
+		ctx, cancel := context.WithCancel(context.Background())
+		job := &Job{
+			Name:          "test",
+			Queries:       []string{"select ?"},
+			QueueDepth:    1,
+			Count:         10,
+			QueryArgs:     NewCSVArgsReader(strings.NewReader("1\n2\n"), 0),
+			QueryArgsMode: mode,
+			cancelRun:     cancel,
+		}
+		config := &Config{Duration: time.Minute, Jobs: map[string]*Job{"test": job}}
+		var results int
+		for range makeJobResultQueue(ctx, db, df, config.Jobs).Results() {
+			results++
+		}
+		problems := checkRunGuards(config, time.Second, nil)
+		cancel()
+
+		if results != 2 {
+			t.Errorf("Expected 2 invocations with query-args-mode %q, got %d", mode, results)
+		}
+		// Running out of args before the count or duration only makes the
+		// run invalid with query-args-mode=error.
+		if invalid := len(problems) > 0; invalid != (mode == "error") {
+			t.Errorf("Expected the run with query-args-mode %q to be invalid: %v, got %q",
+				mode, mode == "error", problems)
+		}
+	}
+}
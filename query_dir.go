@@ -0,0 +1,167 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/*
+ * The optional manifest of a query-dir, with a <file>,<weight> record per
+ * query file. Its weights override those in the file names.
+ */
+const queryDirManifest = "weights.csv"
+
+/*
+ * The queries of one file of a query-dir, which are run in order by an
+ * invocation of the job chosen at random in proportion to Weight.
+ */
+type QuerySet struct {
+	Name    string
+	Queries []string
+	Weight  float64
+}
+
+/*
+ * Parses the weight of a query file from its name, e.g. 5 for
+ * lookup@5.sql, defaulting to 1.
+ */
+func querySetWeight(name string) (float64, error) {
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	i := strings.LastIndex(base, "@")
+	if i < 0 {
+		return 1, nil
+	}
+	weight, err := strconv.ParseFloat(base[i+1:], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid weight in file name %s", strconv.Quote(name))
+	}
+	return weight, nil
+}
+
+func readQueryDirManifest(path string) (map[string]float64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	weights := make(map[string]float64)
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = 2
+	r.Comment = '#'
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return weights, nil
+		} else if err != nil {
+			return nil, err
+		}
+		weight, err := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid weight for %s: %v", strconv.Quote(record[0]), err)
+		}
+		weights[strings.TrimSpace(record[0])] = weight
+	}
+}
+
+/*
+ * Reads every .sql file of the directory as a weighted query set, returning
+ * them in file name order along with the files read. Files of weight 0 are
+ * skipped.
+ */
+func readQuerySetsFromDir(df DatabaseFlavor, dir string) ([]QuerySet, []string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var files []string
+	var manifest map[string]float64
+	manifestPath := filepath.Join(dir, queryDirManifest)
+	if _, err := os.Stat(manifestPath); err == nil {
+		if manifest, err = readQueryDirManifest(manifestPath); err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", manifestPath, err)
+		}
+		files = append(files, manifestPath)
+	}
+
+	var sets []QuerySet
+	found := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".sql" {
+			continue
+		}
+		found[entry.Name()] = true
+
+		weight, ok := manifest[entry.Name()]
+		if !ok {
+			if weight, err = querySetWeight(entry.Name()); err != nil {
+				return nil, nil, err
+			}
+		}
+		if weight < 0 {
+			return nil, nil, fmt.Errorf("negative weight for %s", strconv.Quote(entry.Name()))
+		} else if weight == 0 {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		queries, err := readQueriesFromFile(df, path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", path, err)
+		} else if len(queries) == 0 {
+			return nil, nil, fmt.Errorf("%s: %v", path, EmptyQueryError)
+		}
+		files = append(files, path)
+		sets = append(sets, QuerySet{entry.Name(), queries, weight})
+	}
+
+	for name := range manifest {
+		if !found[name] {
+			return nil, nil, fmt.Errorf("%s: no query file %s", manifestPath, strconv.Quote(name))
+		}
+	}
+	if len(sets) == 0 {
+		return nil, nil, fmt.Errorf("no .sql files in %s", dir)
+	}
+	return sets, files, nil
+}
+
+// Chooses one of the query sets at random, in proportion to their weights.
+func chooseQuerySet(r *rand.Rand, sets []QuerySet) *QuerySet {
+	var total float64
+	for i := range sets {
+		total += sets[i].Weight
+	}
+	x := r.Float64() * total
+	for i := range sets {
+		if x < sets[i].Weight {
+			return &sets[i]
+		}
+		x -= sets[i].Weight
+	}
+	return &sets[len(sets)-1]
+}
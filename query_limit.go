@@ -0,0 +1,125 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+var maxTotalQueries = flag.Uint64("max-total-queries", 0,
+	"Stop the run once this many queries have been issued across all jobs, "+
+		"e.g. to bound the cost of a metered database. Queries that were "+
+		"already started or scheduled may still run.")
+
+/*
+ * Counts the queries issued through any of the databases it wraps and
+ * cancels the run once the limit is reached.
+ */
+type queryLimit struct {
+	max    uint64
+	issued uint64
+	cancel context.CancelFunc
+	once   sync.Once
+}
+
+func newQueryLimit(max uint64, cancel context.CancelFunc) *queryLimit {
+	return &queryLimit{max: max, cancel: cancel}
+}
+
+func (ql *queryLimit) issue() {
+	if atomic.AddUint64(&ql.issued, 1) == ql.max {
+		ql.once.Do(func() {
+			log.Printf("Issued max-total-queries (%d) queries, stopping", ql.max)
+			ql.cancel()
+		})
+	}
+}
+
+func (ql *queryLimit) Wrap(db Database) Database {
+	return &queryLimitDatabase{db, ql}
+}
+
+type queryLimitDatabase struct {
+	Database
+	limit *queryLimit
+}
+
+func (qld *queryLimitDatabase) RunQuery(ctx context.Context, w *SafeCSVWriter, q string, args []interface{}) (int64, error) {
+	qld.limit.issue()
+	return qld.Database.RunQuery(ctx, w, q, args)
+}
+
+func (qld *queryLimitDatabase) RunQueryWithOptions(ctx context.Context, w *SafeCSVWriter, q string, args []interface{}, opts QueryOptions) (int64, error) {
+	qld.limit.issue()
+	return qld.Database.RunQueryWithOptions(ctx, w, q, args, opts)
+}
+
+func (qld *queryLimitDatabase) Begin() (Transaction, error) {
+	tx, err := qld.Database.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &queryLimitTx{tx, qld.limit}, nil
+}
+
+func (qld *queryLimitDatabase) Session() (Session, error) {
+	s, err := qld.Database.Session()
+	if err != nil {
+		return nil, err
+	}
+	return &queryLimitSession{s, qld.limit}, nil
+}
+
+func (qld *queryLimitDatabase) NewSession(ctx context.Context) (Session, error) {
+	s, err := qld.Database.NewSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &queryLimitSession{s, qld.limit}, nil
+}
+
+type queryLimitSession struct {
+	Session
+	limit *queryLimit
+}
+
+func (qls *queryLimitSession) RunQueryWithOptions(ctx context.Context, w *SafeCSVWriter, q string, args []interface{}, opts QueryOptions) (int64, error) {
+	qls.limit.issue()
+	return qls.Session.RunQueryWithOptions(ctx, w, q, args, opts)
+}
+
+func (qls *queryLimitSession) Begin() (Transaction, error) {
+	tx, err := qls.Session.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &queryLimitTx{tx, qls.limit}, nil
+}
+
+type queryLimitTx struct {
+	Transaction
+	limit *queryLimit
+}
+
+func (qlt *queryLimitTx) RunQueryWithOptions(ctx context.Context, w *SafeCSVWriter, q string, args []interface{}, opts QueryOptions) (int64, error) {
+	qlt.limit.issue()
+	return qlt.Transaction.RunQueryWithOptions(ctx, w, q, args, opts)
+}
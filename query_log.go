@@ -0,0 +1,202 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+var recordIssuedQueries = flag.String("record-issued-queries", "",
+	"Write every query issued, with its args, to this file in the "+
+		"query-log-file format so that the run can be replayed exactly.")
+
+/*
+ * Parses a record of a query log, of the form <time micros>,<query>. The time
+ * may be followed by ;<args>, where args are a base64 encoded JSON array (or
+ * object of named args) that are bound to the query. An arg that is an object
+ * of the form {"base64": <string>} is bound as the bytes it encodes.
+ */
+func parseQueryLogRecord(line string) (int64, string, []interface{}, error) {
+	parts := strings.SplitN(line, ",", 2)
+	if len(parts) != 2 {
+		return 0, "", nil, errors.New("invalid query log record")
+	}
+
+	timeField, argsField := parts[0], ""
+	if i := strings.Index(timeField, ";"); i >= 0 {
+		timeField, argsField = timeField[:i], timeField[i+1:]
+	}
+
+	timeMicros, err := strconv.ParseInt(timeField, 10, 64)
+	if err != nil || argsField == "" {
+		return timeMicros, parts[1], nil, err
+	}
+
+	argsJSON, err := base64.StdEncoding.DecodeString(argsField)
+	if err != nil {
+		return 0, "", nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(argsJSON))
+	decoder.UseNumber()
+	var record interface{}
+	if err := decoder.Decode(&record); err != nil {
+		return 0, "", nil, err
+	}
+	args, err := jsonRecordArgs(decodeBinaryArgs(record))
+	return timeMicros, parts[1], args, err
+}
+
+/*
+ * How a []byte arg that is not valid UTF-8 is recorded, since a JSON string
+ * cannot hold it. The bytes are encoded in base64.
+ */
+type binaryArg struct {
+	Base64 []byte `json:"base64"`
+}
+
+// Replaces the binaryArg objects of a decoded record with their bytes.
+func decodeBinaryArgs(record interface{}) interface{} {
+	decode := func(v interface{}) interface{} {
+		if o, ok := v.(map[string]interface{}); ok && len(o) == 1 {
+			if s, ok := o["base64"].(string); ok {
+				if b, err := base64.StdEncoding.DecodeString(s); err == nil {
+					return b
+				}
+			}
+		}
+		return v
+	}
+
+	switch r := record.(type) {
+	case []interface{}:
+		for i, v := range r {
+			r[i] = decode(v)
+		}
+	case map[string]interface{}:
+		for name, v := range r {
+			r[name] = decode(v)
+		}
+	}
+	return record
+}
+
+/*
+ * Formats a record of a query log. Newlines in the query are replaced with
+ * spaces, since each record is a single line.
+ */
+func formatQueryLogRecord(t time.Time, query string, args []interface{}) (string, error) {
+	var b strings.Builder
+	b.WriteString(strconv.FormatInt(t.UnixNano()/1000, 10))
+
+	if len(args) > 0 {
+		argsJSON, err := json.Marshal(queryLogArgs(args))
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(';')
+		b.WriteString(base64.StdEncoding.EncodeToString(argsJSON))
+	}
+
+	b.WriteByte(',')
+	b.WriteString(strings.Replace(query, "\n", " ", -1))
+	b.WriteByte('\n')
+	return b.String(), nil
+}
+
+// Converts args to the JSON array or object they are recorded as.
+func queryLogArgs(args []interface{}) interface{} {
+	jsonArg := func(arg interface{}) interface{} {
+		if b, ok := arg.([]byte); ok {
+			if !utf8.Valid(b) {
+				return binaryArg{b}
+			}
+			return string(b)
+		}
+		return arg
+	}
+
+	if _, ok := args[0].(sql.NamedArg); ok {
+		named := make(map[string]interface{}, len(args))
+		for _, arg := range args {
+			na := arg.(sql.NamedArg)
+			named[na.Name] = jsonArg(na.Value)
+		}
+		return named
+	}
+
+	positional := make([]interface{}, 0, len(args))
+	for _, arg := range args {
+		positional = append(positional, jsonArg(arg))
+	}
+	return positional
+}
+
+/*
+ * Writes the queries issued by all jobs to a single query log.
+ */
+type queryLogWriter struct {
+	m sync.Mutex
+	f *os.File
+	w *bufio.Writer
+}
+
+// Set in main if record-issued-queries is specified.
+var issuedQueries *queryLogWriter
+
+func newQueryLogWriter(path string) (*queryLogWriter, error) {
+	f, err := createOutputFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &queryLogWriter{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+func (qlw *queryLogWriter) Write(t time.Time, query string, args []interface{}) error {
+	record, err := formatQueryLogRecord(t, query, args)
+	if err != nil {
+		return err
+	}
+
+	qlw.m.Lock()
+	defer qlw.m.Unlock()
+
+	_, err = qlw.w.WriteString(record)
+	return err
+}
+
+func (qlw *queryLogWriter) Close() error {
+	qlw.m.Lock()
+	defer qlw.m.Unlock()
+
+	if err := qlw.w.Flush(); err != nil {
+		qlw.f.Close()
+		return err
+	}
+	return qlw.f.Close()
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestQueryLogRecordRoundTrip(t *testing.T) {
+	var cases = []struct {
+		query string
+		args  []interface{}
+	}{
+		{"select 1", nil},
+		{"select ?, ?,\n?", []interface{}{"a,b", nil, []byte("c")}},
+	}
+
+	for _, c := range cases {
+		record, err := formatQueryLogRecord(time.Unix(0, 5000), c.query, c.args)
+		if err != nil {
+			t.Fatalf("Error formatting %s: %v", c.query, err)
+		}
+
+		timeMicros, query, args, err := parseQueryLogRecord(record[:len(record)-1])
+		if err != nil {
+			t.Errorf("Error parsing %s: %v", record, err)
+			continue
+		}
+		if timeMicros != 5 {
+			t.Errorf("Expected time 5 for %s, got %d", record, timeMicros)
+		}
+		if len(c.args) == 0 && query != c.query {
+			t.Errorf("Expected query %s, got %s", c.query, query)
+		}
+		if len(c.args) > 0 && !reflect.DeepEqual(args, []interface{}{"a,b", nil, "c"}) {
+			t.Errorf("Unexpected args %v for %s", args, record)
+		}
+	}
+
+	binary := []byte{0xff, 0, 'a'}
+	for _, args := range [][]interface{}{
+		{binary, []byte("text")},
+		{sql.Named("a", binary), sql.Named("b", []byte("text"))},
+	} {
+		record, err := formatQueryLogRecord(time.Unix(0, 5000), "select ?, ?", args)
+		if err != nil {
+			t.Fatalf("Error formatting %v: %v", args, err)
+		}
+		_, _, parsed, err := parseQueryLogRecord(record[:len(record)-1])
+		if err != nil {
+			t.Fatalf("Error parsing %s: %v", record, err)
+		}
+		values := make([]interface{}, 0, len(parsed))
+		for _, arg := range parsed {
+			if na, ok := arg.(sql.NamedArg); ok {
+				arg = na.Value
+			}
+			values = append(values, arg)
+		}
+		if !reflect.DeepEqual(values, []interface{}{binary, "text"}) {
+			t.Errorf("Expected the binary arg to round trip, got %#v for %s", values, record)
+		}
+	}
+
+	if _, _, _, err := parseQueryLogRecord("5;!!,select 1"); err == nil {
+		t.Errorf("Expected error parsing invalid args")
+	}
+}
@@ -0,0 +1,212 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const templateStringChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// The layouts accepted by rand_time, the first of which it expands to.
+var templateTimeLayouts = []string{"2006-01-02 15:04:05", "2006-01-02", time.RFC3339}
+
+/*
+ * The functions of query templates, which generate random data from the
+ * random stream of the job so that a run can be repeated with -seed.
+ */
+func (job *Job) templateFuncs() template.FuncMap {
+	return template.FuncMap{
+		// A random integer from lo to hi inclusive.
+		"rand_int": func(lo, hi int64) (int64, error) {
+			if hi < lo {
+				return 0, fmt.Errorf("rand_int %d %d: hi is less than lo", lo, hi)
+			}
+			return lo + job.rand().Int63n(hi-lo+1), nil
+		},
+		// A random alphanumeric string of n characters.
+		"rand_string": func(n int) (string, error) {
+			if n < 0 {
+				return "", errors.New("rand_string: length is negative")
+			}
+			b := make([]byte, n)
+			for i := range b {
+				b[i] = templateStringChars[job.rand().Intn(len(templateStringChars))]
+			}
+			return string(b), nil
+		},
+		// A random (version 4) UUID.
+		"uuid": func() string {
+			var b [16]byte
+			job.rand().Read(b[:])
+			b[6] = b[6]&0x0f | 0x40
+			b[8] = b[8]&0x3f | 0x80
+			return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+		},
+		// The number of the invocation in the run, from 1.
+		"seq": func() int64 {
+			return job.invocationSeq
+		},
+		// One of the values at random.
+		"choice": func(values ...interface{}) (interface{}, error) {
+			if len(values) == 0 {
+				return nil, errors.New("choice: no values")
+			}
+			return values[job.rand().Intn(len(values))], nil
+		},
+		// One of the values at random, in proportion to the weight that
+		// follows each of them, e.g. weighted "new" 90 "paid" 9 "void" 1.
+		"weighted": func(pairs ...interface{}) (interface{}, error) {
+			return weightedChoice(job.rand(), pairs)
+		},
+		// A random integer from 1 to n, where 1 is the most likely and the
+		// likelihood of k falls off as 1/k^s (s > 1), e.g. for hot keys.
+		"zipf": func(s float64, n int64) (int64, error) {
+			if s <= 1 || n < 1 {
+				return 0, fmt.Errorf("zipf %g %d: s must be greater than 1 and n at least 1", s, n)
+			}
+			return int64(rand.NewZipf(job.rand(), s, 1, uint64(n-1)).Uint64()) + 1, nil
+		},
+		// A random number from the normal distribution with the mean and
+		// standard deviation.
+		"normal": func(mean, stddev float64) float64 {
+			return mean + stddev*job.rand().NormFloat64()
+		},
+		// A random time from from to to, as YYYY-MM-DD HH:MM:SS.
+		"rand_time": func(from, to string) (string, error) {
+			lo, err := parseTemplateTime(from)
+			if err != nil {
+				return "", err
+			}
+			hi, err := parseTemplateTime(to)
+			if err != nil {
+				return "", err
+			}
+			if hi.Before(lo) {
+				return "", fmt.Errorf("rand_time %s %s: to is before from", from, to)
+			}
+			d := time.Duration(job.rand().Int63n(int64(hi.Sub(lo)/time.Second)+1)) * time.Second
+			return lo.Add(d).Format(templateTimeLayouts[0]), nil
+		},
+		// Arithmetic for columns derived from another, e.g.
+		// {{$qty := zipf 1.5 50}}{{$qty}}, {{mul $qty 25}}.
+		"add": func(a, b int64) int64 {
+			return a + b
+		},
+		"mul": func(a, b int64) int64 {
+			return a * b
+		},
+	}
+}
+
+func parseTemplateTime(v string) (time.Time, error) {
+	for _, layout := range templateTimeLayouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, must be YYYY-MM-DD[ HH:MM:SS]", v)
+}
+
+/*
+ * Picks one of the values of pairs of value and weight, with a probability
+ * proportional to its weight.
+ */
+func weightedChoice(rng *rand.Rand, pairs []interface{}) (interface{}, error) {
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return nil, errors.New("weighted: expected pairs of a value and its weight")
+	}
+	weights := make([]float64, len(pairs)/2)
+	var total float64
+	for i := range weights {
+		switch w := pairs[2*i+1].(type) {
+		case int:
+			weights[i] = float64(w)
+		case int64:
+			weights[i] = float64(w)
+		case float64:
+			weights[i] = w
+		default:
+			return nil, fmt.Errorf("weighted: weight %v of %v is not a number", w, pairs[2*i])
+		}
+		if weights[i] < 0 {
+			return nil, fmt.Errorf("weighted: weight %v of %v is negative", weights[i], pairs[2*i])
+		}
+		total += weights[i]
+	}
+	if total == 0 {
+		return nil, errors.New("weighted: all weights are 0")
+	}
+
+	x := rng.Float64() * total
+	for i, w := range weights {
+		if x < w {
+			return pairs[2*i], nil
+		}
+		x -= w
+	}
+	// Only reached through rounding.
+	for i := len(weights) - 1; ; i-- {
+		if weights[i] > 0 {
+			return pairs[2*i], nil
+		}
+	}
+}
+
+/*
+ * Parses the queries of the job that contain {{ actions as templates, which
+ * are expanded for each invocation.
+ */
+func (job *Job) parseQueryTemplates() error {
+	queries := append([]string(nil), job.Queries...)
+	for _, set := range job.QuerySets {
+		queries = append(queries, set.Queries...)
+	}
+
+	for _, q := range queries {
+		if !strings.Contains(q, "{{") {
+			continue
+		}
+		t, err := template.New(job.Name).Funcs(job.templateFuncs()).Parse(q)
+		if err != nil {
+			return err
+		}
+		if job.templates == nil {
+			job.templates = make(map[string]*template.Template)
+		}
+		job.templates[q] = t
+	}
+	return nil
+}
+
+// Expands the query if it is a template, and otherwise returns it unchanged.
+func (job *Job) expandQueryTemplate(q string) (string, error) {
+	t, ok := job.templates[q]
+	if !ok {
+		return q, nil
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, nil); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
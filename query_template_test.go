@@ -0,0 +1,140 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestQueryTemplates(t *testing.T) {
+	job := &Job{Name: "test", Queries: []string{
+		"select 1",
+		"select * from t where id = {{rand_int 1 3}}",
+		"insert into t values ('{{rand_string 8}}', '{{uuid}}', {{seq}}, '{{choice \"a\" \"b\"}}')",
+	}}
+	if err := job.parseQueryTemplates(); err != nil {
+		t.Fatal(err)
+	}
+
+	lookup := regexp.MustCompile(`^select \* from t where id = [1-3]$`)
+	insert := regexp.MustCompile(`^insert into t values \('[a-zA-Z0-9]{8}', ` +
+		`'[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}', (\d+), '[ab]'\)$`)
+	for i := 1; i <= 10; i++ {
+		ji, err := job.getNextJobInvocation()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if q := ji.queries[0].query; q != "select 1" {
+			t.Errorf("Expected a query without actions to be unchanged but got %s", q)
+		}
+		if q := ji.queries[1].query; !lookup.MatchString(q) {
+			t.Errorf("Unexpected expansion %s", q)
+		}
+		if m := insert.FindStringSubmatch(ji.queries[2].query); m == nil {
+			t.Errorf("Unexpected expansion %s", ji.queries[2].query)
+		} else if m[1] != strconv.Itoa(i) {
+			t.Errorf("Expected seq %d but got %s", i, m[1])
+		}
+	}
+}
+
+func TestQueryTemplateErrors(t *testing.T) {
+	for _, q := range []string{
+		"select {{nap 10}}",
+		"select {{rand_int 1",
+	} {
+		job := &Job{Name: "test", Queries: []string{q}}
+		if err := job.parseQueryTemplates(); err == nil {
+			t.Errorf("Unexpected success parsing template %s", q)
+		}
+	}
+
+	job := &Job{Name: "test", Queries: []string{"select {{rand_int 3 1}}"}}
+	if err := job.parseQueryTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := job.expandQueryTemplate(job.Queries[0]); err == nil {
+		t.Errorf("Unexpected success expanding rand_int with hi less than lo")
+	}
+
+	for _, q := range []string{
+		"{{zipf 1 10}}",
+		"{{zipf 2 0}}",
+		`{{weighted "a"}}`,
+		`{{weighted "a" "b"}}`,
+		`{{weighted "a" 0 "b" 0}}`,
+		`{{weighted "a" -1 "b" 2}}`,
+		`{{rand_time "2020-02-01" "2020-01-01"}}`,
+		`{{rand_time "yesterday" "2020-01-01"}}`,
+	} {
+		job := &Job{Name: "test", Queries: []string{q}}
+		if err := job.parseQueryTemplates(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := job.expandQueryTemplate(q); err == nil {
+			t.Errorf("Unexpected success expanding %s", q)
+		}
+	}
+}
+
+func TestQueryTemplateDistributions(t *testing.T) {
+	job := &Job{Name: "test", Queries: []string{
+		"{{zipf 1.5 100}}",
+		`{{weighted "hot" 9 "cold" 1 "never" 0}}`,
+		`{{rand_time "2020-01-01" "2020-01-31 12:00:00"}}`,
+		"{{$q := rand_int 1 10}}{{$q}} {{mul $q 25}} {{add $q 1}}",
+		`{{printf "%.0f" (normal 100 0)}}`,
+	}}
+	if err := job.parseQueryTemplates(); err != nil {
+		t.Fatal(err)
+	}
+
+	zipfs := make(map[int64]int)
+	weighted := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		ji, err := job.getNextJobInvocation()
+		if err != nil {
+			t.Fatal(err)
+		}
+		z, err := strconv.ParseInt(ji.queries[0].query, 10, 64)
+		if err != nil || z < 1 || z > 100 {
+			t.Fatalf("Unexpected zipf %s", ji.queries[0].query)
+		}
+		zipfs[z]++
+		weighted[ji.queries[1].query]++
+		if q := ji.queries[2].query; q < "2020-01-01 00:00:00" || q > "2020-01-31 12:00:00" {
+			t.Fatalf("Unexpected rand_time %s", q)
+		}
+		var q, total, next int64
+		fmt.Sscanf(ji.queries[3].query, "%d %d %d", &q, &total, &next)
+		if total != 25*q || next != q+1 {
+			t.Fatalf("Unexpected derived columns %s", ji.queries[3].query)
+		}
+		if q := ji.queries[4].query; q != "100" {
+			t.Fatalf("Expected normal with no deviation to be the mean, got %s", q)
+		}
+	}
+	if zipfs[1] < zipfs[2] || zipfs[2] < zipfs[10] || zipfs[1] < 3000 {
+		t.Errorf("Expected zipf to favor low values, got %v", zipfs)
+	}
+	if weighted["never"] != 0 || weighted["hot"] < 8500 || weighted["cold"] < 700 {
+		t.Errorf("Expected weighted to follow the weights, got %v", weighted)
+	}
+}
@@ -0,0 +1,178 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ * Changes a job setting (e.g. its rate) from From to To over the first Over
+ * of the job, linearly or in Steps equal steps, then holds it at To.
+ */
+type Ramp struct {
+	From  float64
+	To    float64
+	Over  time.Duration
+	Steps int // 0 for a linear ramp.
+}
+
+// The number of parts the stats of a linear ramp are reported in.
+const linearRampSteps = 10
+
+// How often a concurrency ramp checks whether to add workers.
+const rampPollInterval = 10 * time.Millisecond
+
+// Parses <from>..<to> over <duration> [in <n> steps].
+func parseRamp(v string) (*Ramp, error) {
+	invalid := fmt.Errorf("invalid ramp %s, expected <from>..<to> over <duration> [in <n> steps]",
+		strconv.Quote(v))
+
+	fields := strings.Fields(v)
+	if (len(fields) != 3 && len(fields) != 6) || fields[1] != "over" {
+		return nil, invalid
+	}
+
+	bounds := strings.SplitN(fields[0], "..", 2)
+	if len(bounds) != 2 {
+		return nil, invalid
+	}
+	var r Ramp
+	var err error
+	if r.From, err = strconv.ParseFloat(bounds[0], 64); err != nil {
+		return nil, invalid
+	} else if r.To, err = strconv.ParseFloat(bounds[1], 64); err != nil {
+		return nil, invalid
+	} else if r.Over, err = time.ParseDuration(fields[2]); err != nil || r.Over <= 0 {
+		return nil, invalid
+	}
+
+	if len(fields) == 6 {
+		if fields[3] != "in" || fields[5] != "steps" {
+			return nil, invalid
+		} else if r.Steps, err = strconv.Atoi(fields[4]); err != nil {
+			return nil, invalid
+		} else if r.Steps < 2 {
+			return nil, fmt.Errorf("ramp %s must have at least 2 steps", strconv.Quote(v))
+		}
+	}
+	return &r, nil
+}
+
+func (r *Ramp) String() string {
+	s := fmt.Sprintf("%g..%g over %v", r.From, r.To, r.Over)
+	if r.Steps > 0 {
+		s += fmt.Sprintf(" in %d steps", r.Steps)
+	}
+	return s
+}
+
+// The number of steps the stats of the ramp are reported in.
+func (r *Ramp) NumSteps() int {
+	if r.Steps > 0 {
+		return r.Steps
+	}
+	return linearRampSteps
+}
+
+/*
+ * The step (from 1) of the ramp at the given time since it started; the last
+ * step lasts from then on.
+ */
+func (r *Ramp) Step(elapsed time.Duration) int {
+	n := r.NumSteps()
+	if elapsed <= 0 {
+		return 1
+	} else if elapsed >= r.Over {
+		return n
+	}
+	return int(int64(elapsed)*int64(n)/int64(r.Over)) + 1
+}
+
+// The value of the ramp at the given time since it started.
+func (r *Ramp) At(elapsed time.Duration) float64 {
+	if r.Steps > 0 {
+		return r.From + (r.To-r.From)*float64(r.Step(elapsed)-1)/float64(r.Steps-1)
+	} else if elapsed <= 0 {
+		return r.From
+	} else if elapsed >= r.Over {
+		return r.To
+	}
+	return r.From + (r.To-r.From)*float64(elapsed)/float64(r.Over)
+}
+
+// The ramp of the job, if it has one.
+func (job *Job) ramp() *Ramp {
+	if job.RateRamp != nil {
+		return job.RateRamp
+	}
+	return job.ConcurrencyRamp
+}
+
+// The step of the ramp of the job an invocation started at is in, or 0.
+func (job *Job) rampStep(start time.Time) int {
+	if ramp := job.ramp(); ramp != nil {
+		return ramp.Step(start.Sub(job.rampStart))
+	}
+	return 0
+}
+
+// Logs the level of the ramp of the job when it enters a new step.
+func (job *Job) logRampStep(elapsed time.Duration, name string) {
+	ramp := job.ramp()
+	if step := ramp.Step(elapsed); step != job.rampLoggedStep {
+		job.rampLoggedStep = step
+		job.logf("%s: %s step %d of %d at %.3f",
+			job.Name, name, step, ramp.NumSteps(), ramp.At(elapsed))
+	}
+}
+
+// The wait until the next tick of a job with a rate-ramp.
+func (job *Job) rampTickInterval() time.Duration {
+	elapsed := clockSince(job.rampStart)
+	job.logRampStep(elapsed, "rate-ramp")
+	return time.Duration(float64(time.Second) / job.RateRamp.At(elapsed))
+}
+
+/*
+ * Adds a worker to the queue of a job with a concurrency-ramp each time the
+ * ramp passes another whole number, until all of them (the queue depth of
+ * the job) have been added. The first workers are added by the caller.
+ */
+func (job *Job) rampConcurrency(ctx context.Context, queueSem chan<- int, workers int) {
+	ticker := clock.NewTicker(rampPollInterval)
+	defer ticker.Stop()
+
+	for uint64(workers) < job.QueueDepth {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.Chan():
+			elapsed := clockSince(job.rampStart)
+			job.logRampStep(elapsed, "concurrency-ramp")
+			for level := int(math.Floor(job.ConcurrencyRamp.At(elapsed))); workers < level; {
+				workers++
+				queueSem <- workers
+			}
+		}
+	}
+}
@@ -0,0 +1,73 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRamp(t *testing.T) {
+	r, err := parseRamp("10..1000 over 5m in 4 steps")
+	if err != nil {
+		t.Fatal(err)
+	} else if *r != (Ramp{From: 10, To: 1000, Over: 5 * time.Minute, Steps: 4}) {
+		t.Errorf("Unexpected ramp %v", r)
+	} else if r.String() != "10..1000 over 5m0s in 4 steps" {
+		t.Errorf("Unexpected string %q", r.String())
+	}
+
+	for _, v := range []string{"", "10..1000", "10 over 5m", "10..x over 5m",
+		"10..1000 over 0s", "10..1000 over 5m in 4", "10..1000 over 5m in 1 steps"} {
+		if _, err := parseRamp(v); err == nil {
+			t.Errorf("Expected an error parsing %q", v)
+		}
+	}
+}
+
+func TestRampAt(t *testing.T) {
+	linear := &Ramp{From: 10, To: 110, Over: 100 * time.Second}
+	stepped := &Ramp{From: 10, To: 40, Over: 100 * time.Second, Steps: 4}
+	for _, c := range []struct {
+		elapsed     time.Duration
+		linear      float64
+		linearStep  int
+		stepped     float64
+		steppedStep int
+	}{
+		{-time.Second, 10, 1, 10, 1},
+		{0, 10, 1, 10, 1},
+		{24 * time.Second, 34, 3, 10, 1},
+		{25 * time.Second, 35, 3, 20, 2},
+		{50 * time.Second, 60, 6, 30, 3},
+		{99 * time.Second, 109, 10, 40, 4},
+		{time.Hour, 110, 10, 40, 4},
+	} {
+		if v := linear.At(c.elapsed); v != c.linear {
+			t.Errorf("Linear ramp at %v expected %v, got %v", c.elapsed, c.linear, v)
+		}
+		if step := linear.Step(c.elapsed); step != c.linearStep {
+			t.Errorf("Linear ramp at %v expected step %d, got %d", c.elapsed, c.linearStep, step)
+		}
+		if v := stepped.At(c.elapsed); v != c.stepped {
+			t.Errorf("Stepped ramp at %v expected %v, got %v", c.elapsed, c.stepped, v)
+		}
+		if step := stepped.Step(c.elapsed); step != c.steppedStep {
+			t.Errorf("Stepped ramp at %v expected step %d, got %d", c.elapsed, c.steppedStep, step)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+var reconstructIntervals = flag.Bool("reconstruct-intervals", false,
+	"If the stats windows are not tracked during the run (with "+
+		"-intermediate-stats=false and no sink or alert that needs them), "+
+		"compute the per-interval throughput and latency of the final stats "+
+		"from the query-stats-file once the run is over.")
+
+/*
+ * Whether the stats of each window have to be tracked while the run goes on:
+ * only the query-stats-file does without them, and the final per-interval
+ * throughput and latency can then be reconstructed from it instead.
+ */
+func windowsNeeded(sinks []statsSink, alerts map[string]*latencyAlert) bool {
+	if len(alerts) > 0 {
+		return true
+	}
+	for _, sink := range sinks {
+		if _, ok := sink.(*queryStatsSink); !ok {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ * The records of the run are read back from the query-stats-file, so it has
+ * to be a regular file (and not e.g. a pipe) unless -output-dir puts it there.
+ */
+func checkReconstructFile(path string) error {
+	if path == "" {
+		if *outputDir == "" {
+			return errors.New("-reconstruct-intervals requires -query-stats-file (or -output-dir)")
+		}
+		return nil
+	}
+	if fi, err := os.Stat(path); err == nil && !fi.Mode().IsRegular() {
+		return fmt.Errorf("-reconstruct-intervals cannot read back the query-stats-file %s, "+
+			"which is not a regular file", strconv.Quote(path))
+	}
+	return nil
+}
+
+/*
+ * Adds the per-interval throughput and latency of each job to its stats, from
+ * the records written to the query-stats-file during the run. The intervals
+ * are counted from the start of each job (the origin of start_micros), and
+ * those that ended by the end of the run are added, including the empty ones
+ * after the last result of a job, as when they are tracked during the run.
+ */
+func (s *queryStatsSink) reconstructIntervals(stats map[string]*JobStats, origin time.Time, runEnd, interval time.Duration) error {
+	end, err := s.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	r := csv.NewReader(io.NewSectionReader(s.f, s.start, end-s.start))
+	r.FieldsPerRecord = len(s.schema.columns)
+
+	type window struct {
+		transactions int
+		elapsed      time.Duration
+	}
+	windows := make(map[string][]window)
+	for line := 1; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		jr, err := parseQueryStatsRecord(s.schema, record)
+		if err != nil {
+			return fmt.Errorf("record %d of the run: %v", line, err)
+		}
+
+		// The window the result arrived in, as if it ended then.
+		i := int((jr.Start + jr.Elapsed) / interval)
+		for len(windows[jr.Name]) <= i {
+			windows[jr.Name] = append(windows[jr.Name], window{})
+		}
+		if jr.Errors.TotalErrors() == 0 {
+			windows[jr.Name][i].transactions++
+			windows[jr.Name][i].elapsed += jr.Elapsed
+		}
+	}
+
+	ended := int(runEnd / interval)
+	for name, ws := range windows {
+		js, ok := stats[name]
+		if !ok {
+			// Every result of the job was during its warmup.
+			continue
+		}
+		for len(ws) < ended {
+			ws = append(ws, window{})
+		}
+		for i, w := range ws[:ended] {
+			windowEnd := origin.Add(time.Duration(i+1) * interval)
+			if w.transactions > 0 {
+				js.Latency.Add(w.elapsed/time.Duration(w.transactions), windowEnd)
+			}
+			js.Throughput.Add(w.transactions, interval, windowEnd)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReconstructIntervals(t *testing.T) {
+	f, err := ioutil.TempFile("", "query-stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	// The records of an earlier run are skipped.
+	schema := queryStatsSchemas["v2"]
+	w := csv.NewWriter(f)
+	schema.WriteHeader(w)
+	w.Write(schema.queryStatsRecord(&JobResult{Name: "test", Start: 0, Elapsed: time.Millisecond, Errors: make(ErrorCounts)}))
+	w.Flush()
+	start, _ := f.Seek(0, io.SeekCurrent)
+	s := &queryStatsSink{w, schema, f, start}
+
+	failed := make(ErrorCounts)
+	failed["error"] = errorCounts{errorsPerQuery{"": 1}, nil}
+	for _, jr := range []*JobResult{
+		// Interval 1: two transactions.
+		{Name: "test", Start: 100 * time.Millisecond, Elapsed: 10 * time.Millisecond},
+		{Name: "test", Start: 200 * time.Millisecond, Elapsed: 30 * time.Millisecond},
+		// Interval 2: none, and an error.
+		{Name: "test", Start: 1100 * time.Millisecond, Elapsed: 10 * time.Millisecond, Errors: failed},
+		// Interval 3: one.
+		{Name: "test", Start: 2100 * time.Millisecond, Elapsed: 20 * time.Millisecond},
+		// Interval 4, which had not ended by the end of the run.
+		{Name: "test", Start: 3100 * time.Millisecond, Elapsed: 20 * time.Millisecond},
+		// Only in its warmup.
+		{Name: "warmup", Start: 100 * time.Millisecond, Elapsed: 10 * time.Millisecond},
+	} {
+		if jr.Errors == nil {
+			jr.Errors = make(ErrorCounts)
+		}
+		s.Result(jr)
+	}
+	s.Close()
+
+	origin := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	js := &JobStats{}
+	if err := s.reconstructIntervals(map[string]*JobStats{"test": js}, origin, 3500*time.Millisecond, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if n := js.Throughput.Intervals.Count(); n != 3 {
+		t.Errorf("Expected 3 intervals but got %d", n)
+	}
+	if js.Throughput.Lowest != 0 || !js.Throughput.LowestEnd.Equal(origin.Add(2*time.Second)) {
+		t.Errorf("Expected the worst interval to be empty and end at 2s but got %d at %v",
+			js.Throughput.Lowest, js.Throughput.LowestEnd)
+	}
+	if js.Latency.Worst != 20*time.Millisecond || !js.Latency.WorstEnd.Equal(origin.Add(time.Second)) {
+		t.Errorf("Expected the worst interval latency to be 20ms at 1s but got %v at %v",
+			js.Latency.Worst, js.Latency.WorstEnd)
+	}
+
+	/*
+	 * In a longer run the window of the last result ended too, and the empty
+	 * windows after it are added but, as during the run, not as a stall.
+	 */
+	js = &JobStats{}
+	if err := s.reconstructIntervals(map[string]*JobStats{"test": js}, origin, 6500*time.Millisecond, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if n := js.Throughput.Intervals.Count(); n != 4 {
+		t.Errorf("Expected 4 intervals but got %d", n)
+	}
+	if js.Throughput.LongestStall != time.Second {
+		t.Errorf("Expected the longest stall to be 1s but got %v", js.Throughput.LongestStall)
+	}
+}
+
+func TestCheckReconstructFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dbbench")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "query-stats.csv")
+	if err := ioutil.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkReconstructFile(""); err == nil {
+		t.Errorf("Expected an error without a query-stats-file")
+	}
+	// Created by the run.
+	if err := checkReconstructFile(filepath.Join(dir, "new.csv")); err != nil {
+		t.Errorf("Unexpected error for a new file: %v", err)
+	}
+	if err := checkReconstructFile(path); err != nil {
+		t.Errorf("Unexpected error for a regular file: %v", err)
+	}
+	if err := checkReconstructFile(dir); err == nil {
+		t.Errorf("Expected an error for a file that is not a regular file")
+	}
+}
+
+func TestWindowsNeeded(t *testing.T) {
+	if windowsNeeded([]statsSink{&queryStatsSink{}}, nil) {
+		t.Errorf("Expected the windows not to be needed for the query-stats-file alone")
+	}
+	if !windowsNeeded([]statsSink{&queryStatsSink{}, &consoleSink{}}, nil) {
+		t.Errorf("Expected the windows to be needed for the intermediate stats")
+	}
+	if !windowsNeeded(nil, map[string]*latencyAlert{"test": nil}) {
+		t.Errorf("Expected the windows to be needed for alerts")
+	}
+}
@@ -0,0 +1,197 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ * Stats for a job recomputed from a query-stats-file.
+ */
+type reportJobStats struct {
+	jobStats
+	Transactions StreamingHistogram
+	// Transaction latencies keyed by the stats interval they started in.
+	Intervals map[int64]*StreamingStats
+}
+
+func (rjs *reportJobStats) Update(jr *JobResult) {
+	rjs.jobStats.Update(&Config{}, jr)
+	if jr.Errors.TotalErrors() > 0 {
+		return
+	}
+
+	rjs.Transactions.Add(uint64(jr.Elapsed))
+	interval := int64(jr.Start / *updateInterval)
+	if _, ok := rjs.Intervals[interval]; !ok {
+		rjs.Intervals[interval] = new(StreamingStats)
+	}
+	rjs.Intervals[interval].Add(float64(jr.Elapsed))
+}
+
+func (rjs *reportJobStats) String() string {
+	var str strings.Builder
+	str.WriteString(rjs.jobStats.String())
+
+	str.WriteString(fmt.Sprintf("\nTransactions:\n%v", transactionHistogram(&rjs.Transactions, &rjs.Latencies)))
+	if rjs.Latencies.Count() > 0 {
+		str.WriteString(fmt.Sprintf("Latency distribution:\n%v", rjs.Latencies.Distribution()))
+	}
+
+	intervals := make([]int64, 0, len(rjs.Intervals))
+	for interval := range rjs.Intervals {
+		intervals = append(intervals, interval)
+	}
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i] < intervals[j] })
+	str.WriteString("Intervals:\n")
+	for _, interval := range intervals {
+		stats := rjs.Intervals[interval]
+		str.WriteString(fmt.Sprintf("%12v: %6d transactions, latency %v\n",
+			time.Duration(interval)**updateInterval, stats.Count(),
+			time.Duration(stats.Mean())))
+	}
+	return str.String()
+}
+
+/*
+ * Parses a record written to the query-stats-file in the given schema.
+ */
+func parseQueryStatsRecord(schema *csvSchema, record []string) (*JobResult, error) {
+	if len(record) != len(schema.columns) {
+		return nil, fmt.Errorf("expected %d fields but got %d", len(schema.columns), len(record))
+	}
+
+	start, err := strconv.ParseInt(record[1], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	elapsed, err := strconv.ParseInt(record[2], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	rowsAffected, err := strconv.ParseInt(record[3], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	errors, err := strconv.ParseUint(record[4], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	queries, worker, nonRepeatable := 1, 0, 0
+	if schema.version != "v1" {
+		if queries, err = strconv.Atoi(record[5]); err != nil {
+			return nil, err
+		}
+		if worker, err = strconv.Atoi(record[6]); err != nil {
+			return nil, err
+		}
+		if nonRepeatable, err = strconv.Atoi(record[7]); err != nil {
+			return nil, err
+		}
+	}
+	var seq int64
+	if len(record) > 8 {
+		if seq, err = strconv.ParseInt(record[8], 10, 64); err != nil {
+			return nil, err
+		}
+	}
+
+	jr := &JobResult{
+		Name:          record[0],
+		Start:         time.Duration(start) * time.Microsecond,
+		Elapsed:       time.Duration(elapsed) * time.Microsecond,
+		Queries:       queries,
+		RowsAffected:  rowsAffected,
+		Errors:        make(ErrorCounts),
+		NonRepeatable: nonRepeatable,
+		Worker:        worker,
+		Seq:           seq,
+	}
+	if errors > 0 {
+		// The query-stats-file only records how many errors there were.
+		jr.Errors["error"] = errorCounts{errorsPerQuery{"": errors}, nil}
+	}
+	return jr, nil
+}
+
+func reportQueryStats(r io.Reader, w io.Writer) error {
+	stats := make(map[string]*reportJobStats)
+
+	reader := csv.NewReader(r)
+	// The schema header records have a different number of fields.
+	reader.FieldsPerRecord = -1
+	schema := queryStatsSchemas["v1"]
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		if line == 1 {
+			if s, err := parseSchemaRecord(queryStatsSchemas, record); err != nil {
+				return fmt.Errorf("line %d: %v", line, err)
+			} else if s != nil {
+				// Skip the record of column names as well.
+				schema = s
+				line++
+				if _, err := reader.Read(); err != nil {
+					return fmt.Errorf("line %d: %v", line, err)
+				}
+				continue
+			}
+		}
+
+		jr, err := parseQueryStatsRecord(schema, record)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		if _, ok := stats[jr.Name]; !ok {
+			stats[jr.Name] = &reportJobStats{Intervals: make(map[int64]*StreamingStats)}
+		}
+		stats[jr.Name].Update(jr)
+	}
+
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "%s: %v\n", name, stats[name])
+	}
+	return nil
+}
+
+func reportQueryStatsFile(path string, w io.Writer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return reportQueryStats(f, w)
+}
@@ -0,0 +1,62 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestReportQueryStatsFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "dbbench-query-stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	// Two jobs in the v2 schema; the last invocation of a failed.
+	f.WriteString("schema=v2\n" +
+		"job,start_micros,elapsed_micros,rows_affected,errors,queries,worker,non_repeatable\n" +
+		"a,0,1000,1,0,1,1,0\n" +
+		"b,500,4000,0,0,2,0,0\n" +
+		"a,1500000,3000,1,0,1,1,0\n" +
+		"a,2000000,2000,0,1,1,1,0\n")
+	f.Close()
+
+	var buf bytes.Buffer
+	if err := reportQueryStatsFile(f.Name(), &buf); err != nil {
+		t.Fatal(err)
+	}
+	report := buf.String()
+	for _, expected := range []string{
+		"a: 2 transactions",
+		"3 queries",
+		"1 aborts (33.333%)",
+		"0s:      1 transactions, latency 1ms",
+		"1s:      1 transactions, latency 3ms",
+		"b: 1 transactions",
+	} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("Expected the report to contain %q, got:\n%s", expected, report)
+		}
+	}
+	if strings.Index(report, "a: ") > strings.Index(report, "b: ") {
+		t.Errorf("Expected the jobs in order of name, got:\n%s", report)
+	}
+}
@@ -0,0 +1,74 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"flag"
+	"log"
+	"sync/atomic"
+)
+
+var resultBufferSize = flag.Int("result-buffer-size", 1024,
+	"Number of job results buffered between the jobs and the stats processing.")
+var dropOverflowResults = flag.Bool("drop-overflow-results", false,
+	"Drop job results (rather than wait) when the result buffer is full, so a slow "+
+		"query-stats-file never delays the jobs.")
+
+/*
+ * A bounded queue of job results between the jobs and the stats processing.
+ * Sends that find the queue full are counted, since waiting for space delays
+ * the jobs and pollutes their latency measurements.
+ */
+type ResultQueue struct {
+	ch         chan *JobResult
+	overflowed uint64
+	dropped    uint64
+}
+
+func NewResultQueue(size int) *ResultQueue {
+	return &ResultQueue{ch: make(chan *JobResult, size)}
+}
+
+func (rq *ResultQueue) Send(jr *JobResult) {
+	select {
+	case rq.ch <- jr:
+		return
+	default:
+	}
+
+	atomic.AddUint64(&rq.overflowed, 1)
+	if *dropOverflowResults {
+		atomic.AddUint64(&rq.dropped, 1)
+		return
+	}
+	rq.ch <- jr
+}
+
+func (rq *ResultQueue) Results() <-chan *JobResult {
+	return rq.ch
+}
+
+func (rq *ResultQueue) Close() {
+	close(rq.ch)
+}
+
+func (rq *ResultQueue) LogOverflows() {
+	if overflowed := atomic.LoadUint64(&rq.overflowed); overflowed > 0 {
+		log.Printf("%d job results found the result buffer (size %d) full; %d were dropped",
+			overflowed, cap(rq.ch), atomic.LoadUint64(&rq.dropped))
+	}
+}
@@ -0,0 +1,56 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+)
+
+func TestInsertStatement(t *testing.T) {
+	columns := []string{"run_id", "job", "queries"}
+	if s := insertStatement("t", columns, false); s != "INSERT INTO t (run_id, job, queries) VALUES (?, ?, ?)" {
+		t.Errorf("Unexpected statement %s", s)
+	}
+	if s := insertStatement("t", columns, true); s != "INSERT INTO t (run_id, job, queries) VALUES ($1, $2, $3)" {
+		t.Errorf("Unexpected statement %s", s)
+	}
+}
+
+func TestOpenResultsDatabaseFlavors(t *testing.T) {
+	for _, dsn := range []string{"sqlite:///tmp/results.db", "fake://localhost", "results"} {
+		if _, err := openResultsDatabase(dsn); err == nil {
+			t.Errorf("Unexpected success opening results database %s", dsn)
+		}
+	}
+}
+
+func TestResultsDatabaseFlavor(t *testing.T) {
+	for scheme, expected := range map[string]string{
+		"mysql":      "mysql",
+		"postgres":   "postgres",
+		"postgresql": "postgres",
+	} {
+		if sq, err := resultsDatabaseFlavor(scheme); err != nil {
+			t.Errorf("Error with results database scheme %s: %v", scheme, err)
+		} else if sq.name != expected {
+			t.Errorf("Expected scheme %s to be %s, got %s", scheme, expected, sq.name)
+		}
+	}
+	if _, err := resultsDatabaseFlavor("sqlite"); err == nil {
+		t.Errorf("Unexpected success with results database scheme sqlite")
+	}
+}
@@ -0,0 +1,55 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var seed = flag.Int64("seed", 0,
+	"Seed of the random choices of the jobs (e.g. the query-dir file and "+
+		"jitter of each invocation). Each job and worker has a stream of its "+
+		"own derived from its name, so adding a job does not change the "+
+		"choices of the others. By default a random seed is chosen and logged.")
+
+var seedOnce sync.Once
+
+func runSeed() int64 {
+	seedOnce.Do(func() {
+		if *seed == 0 {
+			*seed = time.Now().UnixNano()
+			log.Printf("Using random seed %d", *seed)
+		}
+	})
+	return *seed
+}
+
+/*
+ * Returns the random stream of a worker of a job (or of the job itself, for
+ * worker 0), which depends only on the seed and the names.
+ */
+func newJobRand(job string, worker int) *rand.Rand {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d/%s/%d", runSeed(), job, worker)
+	return rand.New(rand.NewSource(int64(h.Sum64())))
+}
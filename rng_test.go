@@ -0,0 +1,42 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+)
+
+func TestJobRandStreams(t *testing.T) {
+	defer func(s int64) { *seed = s }(*seed)
+	*seed = 42
+	runSeed()
+
+	sample := func(r interface{ Int63() int64 }) [3]int64 {
+		return [3]int64{r.Int63(), r.Int63(), r.Int63()}
+	}
+	a := sample(newJobRand("a", 0))
+	newJobRand("b", 0).Int63()
+	if again := sample(newJobRand("a", 0)); again != a {
+		t.Errorf("Expected the stream of job a to be %v but got %v", a, again)
+	}
+	if b := sample(newJobRand("b", 0)); b == a {
+		t.Errorf("Expected jobs a and b to have different streams")
+	}
+	if w := sample(newJobRand("a", 1)); w == a {
+		t.Errorf("Expected worker 1 of job a to have a different stream than the job")
+	}
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+)
+
+/*
+ * The worker (from 1) that owns the routing key of the invocation. Each range
+ * of route-range-size integer keys is owned by the next worker, round robin,
+ * so that a worker keeps to a few shards of a range partitioned table.
+ */
+func (job *Job) routeWorker(ji *jobInvocation) int {
+	if len(ji.queries) == 0 || len(ji.queries[0].args) < job.RouteArg {
+		fatalf(exitUsage, "%s: route-arg is %d, but the invocation has fewer args", job.Name, job.RouteArg)
+	}
+	key := routeKey(ji.queries[0].args[job.RouteArg-1])
+
+	var shard uint64
+	if n, err := strconv.ParseUint(key, 10, 64); err == nil {
+		shard = n / job.RouteRangeSize
+	} else {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		shard = h.Sum64()
+	}
+	return int(shard%job.QueueDepth) + 1
+}
+
+/*
+ * The routing key of an arg: the value of a named arg (rather than how the
+ * sql.NamedArg prints), and the bytes of a binary one.
+ */
+func routeKey(arg interface{}) string {
+	if na, ok := arg.(sql.NamedArg); ok {
+		arg = na.Value
+	}
+	if b, ok := arg.([]byte); ok {
+		return string(b)
+	}
+	return fmt.Sprint(arg)
+}
+
+/*
+ * The tokens of the queue-depth workers of a job with route-arg, each on a
+ * channel of its own, so that an invocation can wait for a given worker.
+ */
+type routedWorkers []chan int
+
+func newRoutedWorkers(workers int) routedWorkers {
+	rw := make(routedWorkers, workers+1)
+	for worker := 1; worker <= workers; worker++ {
+		rw[worker] = make(chan int, 1)
+		rw[worker] <- worker
+	}
+	return rw
+}
+
+// Waits until the worker is free, and returns it.
+func (rw routedWorkers) Acquire(worker int) int {
+	return <-rw[worker]
+}
+
+func (rw routedWorkers) Release(worker int) {
+	rw[worker] <- worker
+}
@@ -0,0 +1,65 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestRouteWorker(t *testing.T) {
+	job := &Job{Name: "test", QueueDepth: 4, RouteArg: 2, RouteRangeSize: 100}
+	invocation := func(key interface{}) *jobInvocation {
+		return &jobInvocation{queries: []queryInvocation{{args: []interface{}{"x", key}}}}
+	}
+
+	for _, c := range []struct {
+		key    interface{}
+		worker int
+	}{
+		{"0", 1},
+		{"99", 1},
+		{"100", 2},
+		{int64(250), 3},
+		{"399", 4},
+		{"400", 1},
+		// Named args are routed by their value.
+		{sql.Named("id", "250"), 3},
+		{sql.Named("id", int64(399)), 4},
+	} {
+		if worker := job.routeWorker(invocation(c.key)); worker != c.worker {
+			t.Errorf("For key %v\n\texpected worker %d\n\tbut got %d", c.key, c.worker, worker)
+		}
+	}
+
+	// Other keys are hashed, so each is always run by the same worker.
+	for _, key := range []string{"eu-west", "us-east", "-1"} {
+		worker := job.routeWorker(invocation(key))
+		if worker < 1 || worker > 4 {
+			t.Errorf("For key %s, expected a worker from 1 to 4 but got %d", key, worker)
+		}
+		if again := job.routeWorker(invocation(key)); again != worker {
+			t.Errorf("For key %s, expected worker %d again but got %d", key, worker, again)
+		}
+		if named := job.routeWorker(invocation(sql.Named("region", key))); named != worker {
+			t.Errorf("For named key %s, expected worker %d but got %d", key, worker, named)
+		}
+		if binary := job.routeWorker(invocation([]byte(key))); binary != worker {
+			t.Errorf("For binary key %s, expected worker %d but got %d", key, worker, binary)
+		}
+	}
+}
@@ -17,9 +17,22 @@
 package main
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"hash"
+	"hash/fnv"
 	"io"
+	"log"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
 )
 
@@ -27,19 +40,247 @@ type SafeCSVWriter struct {
 	m         sync.Mutex
 	csvWriter *csv.Writer
 	ioCloser  io.Closer
+	masks     []ColumnMask
+	encodings []ColumnEncoding
+
+	// Whether WriteResultRow prefixes the index of the result set.
+	resultSetIndex bool
+
+	// The file written to, if the writer was opened with NewSafeCSVWriter.
+	path string
+}
+
+var queryResultsMaskKey = flag.String("query-results-mask-key", "",
+	"The key that the hash rule of query-results-mask hashes values with. "+
+		"By default each run uses a random key, so that hashes can neither "+
+		"be reversed by hashing guesses nor joined across runs; set a key "+
+		"to join them.")
+
+var (
+	maskKeyOnce sync.Once
+	maskKey     []byte
+)
+
+// The key of the hash rule, random for each run unless it is configured.
+func hashMaskKey() []byte {
+	maskKeyOnce.Do(func() {
+		if *queryResultsMaskKey != "" {
+			maskKey = []byte(*queryResultsMaskKey)
+			return
+		}
+		maskKey = make([]byte, sha256.Size)
+		if _, err := rand.Read(maskKey); err != nil {
+			log.Fatalf("generating the query-results-mask key: %v", err)
+		}
+	})
+	return maskKey
+}
+
+/*
+ * A rule for masking the values of a single result column before they are
+ * written out, so sensitive values never land on disk.
+ */
+type ColumnMask struct {
+	Column int // Zero based.
+	Rule   string
+	Length int // Only used by the truncate rule.
+}
+
+/*
+ * Parses a mask of the form <column>:<rule>, where column is one based and
+ * rule is one of hash, null, or truncate:<length>.
+ */
+func ParseColumnMask(s string) (ColumnMask, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 {
+		return ColumnMask{}, fmt.Errorf("invalid mask %s, expected <column>:<rule>",
+			strconv.Quote(s))
+	}
+
+	column, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return ColumnMask{}, err
+	} else if column < 1 {
+		return ColumnMask{}, errors.New("mask column must be positive")
+	}
+
+	mask := ColumnMask{Column: column - 1, Rule: parts[1]}
+	switch mask.Rule {
+	case "hash", "null":
+		if len(parts) != 2 {
+			return ColumnMask{}, fmt.Errorf("mask rule %s takes no arguments", mask.Rule)
+		}
+	case "truncate":
+		if len(parts) != 3 {
+			return ColumnMask{}, errors.New("mask rule truncate requires a length")
+		}
+		if mask.Length, err = strconv.Atoi(parts[2]); err != nil {
+			return ColumnMask{}, err
+		} else if mask.Length < 0 {
+			return ColumnMask{}, errors.New("invalid negative truncate length")
+		}
+	default:
+		return ColumnMask{}, fmt.Errorf("invalid mask rule %s", strconv.Quote(mask.Rule))
+	}
+	return mask, nil
+}
+
+// Masks the value, leaving SQL NULL (\N) as it is.
+func (cm ColumnMask) Apply(v string) string {
+	if v == "\\N" {
+		return v
+	}
+	switch cm.Rule {
+	case "hash":
+		// Keyed, since a plain hash of a short value is easily reversed.
+		mac := hmac.New(sha256.New, hashMaskKey())
+		mac.Write([]byte(v))
+		return hex.EncodeToString(mac.Sum(nil))
+	case "null":
+		return "\\N"
+	case "truncate":
+		// The length is in characters, so that none is cut in half.
+		n := 0
+		for i := range v {
+			if n == cm.Length {
+				return v[:i]
+			}
+			n++
+		}
+	}
+	return v
+}
+
+/*
+ * An encoding for binary values of a column, so that they survive being
+ * written to or read from a text file. SQL NULL is always \N and is never
+ * encoded.
+ */
+type ColumnEncoding struct {
+	Column   int // Zero based; all columns if negative.
+	Encoding string
+}
+
+/*
+ * Parses an encoding of the form [<column>:]<encoding>, where column is one
+ * based and encoding is one of base64 or hex. Without a column the encoding
+ * applies to every column.
+ */
+func ParseColumnEncoding(s string) (ColumnEncoding, error) {
+	ce := ColumnEncoding{Column: -1, Encoding: s}
+	if i := strings.Index(s, ":"); i >= 0 {
+		column, err := strconv.Atoi(s[:i])
+		if err != nil {
+			return ColumnEncoding{}, err
+		} else if column < 1 {
+			return ColumnEncoding{}, errors.New("encoding column must be positive")
+		}
+		ce = ColumnEncoding{Column: column - 1, Encoding: s[i+1:]}
+	}
+
+	switch ce.Encoding {
+	case "base64", "hex":
+	default:
+		return ColumnEncoding{}, fmt.Errorf("invalid encoding %s",
+			strconv.Quote(ce.Encoding))
+	}
+	return ce, nil
+}
+
+func (ce ColumnEncoding) Encode(v string) string {
+	if ce.Encoding == "hex" {
+		return hex.EncodeToString([]byte(v))
+	}
+	return base64.StdEncoding.EncodeToString([]byte(v))
+}
+
+func (ce ColumnEncoding) Decode(v string) ([]byte, error) {
+	if ce.Encoding == "hex" {
+		return hex.DecodeString(v)
+	}
+	return base64.StdEncoding.DecodeString(v)
+}
+
+// Returns the encoding for the zero based column, if there is one.
+func findColumnEncoding(encodings []ColumnEncoding, column int) (ColumnEncoding, bool) {
+	for _, ce := range encodings {
+		if ce.Column == column || ce.Column < 0 {
+			return ce, true
+		}
+	}
+	return ColumnEncoding{}, false
+}
+
+func (scw *SafeCSVWriter) SetMasks(masks []ColumnMask) {
+	scw.masks = masks
+}
+
+func (scw *SafeCSVWriter) SetEncodings(encodings []ColumnEncoding) {
+	scw.encodings = encodings
+}
+
+func (scw *SafeCSVWriter) SetResultSetIndex(resultSetIndex bool) {
+	scw.resultSetIndex = resultSetIndex
+}
+
+/*
+ * Encodes the non-NULL value of the zero based column as configured by
+ * SetEncodings.
+ */
+func (scw *SafeCSVWriter) Encode(column int, v string) string {
+	if ce, ok := findColumnEncoding(scw.encodings, column); ok {
+		return ce.Encode(v)
+	}
+	return v
 }
 
 func (scw *SafeCSVWriter) Close() {
-	scw.ioCloser.Close()
+	scw.m.Lock()
+	defer scw.m.Unlock()
+
+	scw.csvWriter.Flush()
+	if err := scw.ioCloser.Close(); err != nil {
+		log.Printf("error closing results: %v", err)
+	}
 }
 
 func (scw *SafeCSVWriter) Write(record []string) error {
 	scw.m.Lock()
 	defer scw.m.Unlock()
 
+	return scw.csvWriter.Write(scw.mask(record))
+}
+
+/*
+ * Writes a row of the given (one based) result set of a query, prefixed with
+ * the index of the result set if SetResultSetIndex is set. The columns of the
+ * masks do not include the index.
+ */
+func (scw *SafeCSVWriter) WriteResultRow(resultSet int, record []string) error {
+	scw.m.Lock()
+	defer scw.m.Unlock()
+
+	record = scw.mask(record)
+	if scw.resultSetIndex {
+		record = append([]string{strconv.Itoa(resultSet)}, record...)
+	}
 	return scw.csvWriter.Write(record)
 }
 
+func (scw *SafeCSVWriter) mask(record []string) []string {
+	if len(scw.masks) > 0 {
+		masked := make([]string, len(record))
+		copy(masked, record)
+		for _, mask := range scw.masks {
+			if mask.Column < len(masked) {
+				masked[mask.Column] = mask.Apply(masked[mask.Column])
+			}
+		}
+		record = masked
+	}
+	return record
+}
+
 func (scw *SafeCSVWriter) Flush() {
 	scw.m.Lock()
 	defer scw.m.Unlock()
@@ -54,10 +295,40 @@ func (scw *SafeCSVWriter) Error() error {
 	return scw.csvWriter.Error()
 }
 
+/*
+ * Returns a SafeCSVWriter that writes to the file at path in the background,
+ * so that writes only wait for the file if query-results-memory is 0.
+ */
 func NewSafeCSVWriter(path string) (*SafeCSVWriter, error) {
 	f, err := os.Create(path)
 	if err != nil {
 		return nil, err
 	}
-	return &SafeCSVWriter{csvWriter: csv.NewWriter(f), ioCloser: f}, nil
+	if *queryResultsMemory <= 0 {
+		return &SafeCSVWriter{csvWriter: csv.NewWriter(f), ioCloser: f, path: path}, nil
+	}
+	sw := newSpillingWriter(f, *queryResultsMemory)
+	return &SafeCSVWriter{csvWriter: csv.NewWriter(sw), ioCloser: sw, path: path}, nil
+}
+
+/*
+ * Returns a SafeCSVWriter that writes to w, which is never closed.
+ */
+func NewSafeCSVWriterTo(w io.Writer) *SafeCSVWriter {
+	return &SafeCSVWriter{csvWriter: csv.NewWriter(w), ioCloser: nopCloser{}}
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+/*
+ * Returns a SafeCSVWriter that discards the records written to it, keeping
+ * only a running checksum of them.
+ */
+func NewChecksumCSVWriter() (*SafeCSVWriter, hash.Hash64) {
+	h := fnv.New64a()
+	return NewSafeCSVWriterTo(h), h
 }
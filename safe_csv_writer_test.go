@@ -0,0 +1,64 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func TestColumnMaskTruncate(t *testing.T) {
+	for _, tc := range []struct {
+		length   int
+		v        string
+		expected string
+	}{
+		{3, "abcdef", "abc"},
+		{3, "ab", "ab"},
+		{0, "abc", ""},
+		{2, "héllo", "hé"},
+		{1, "日本語", "日"},
+		{3, "日本語", "日本語"},
+	} {
+		mask := ColumnMask{Rule: "truncate", Length: tc.length}
+		if actual := mask.Apply(tc.v); actual != tc.expected {
+			t.Errorf("Truncating %q to %d expected %q, got %q", tc.v, tc.length, tc.expected, actual)
+		}
+	}
+}
+
+func TestColumnMaskHash(t *testing.T) {
+	mask := ColumnMask{Rule: "hash"}
+	hashed := mask.Apply("123-45-6789")
+	if hashed != mask.Apply("123-45-6789") {
+		t.Errorf("Expected the same value to hash the same within a run")
+	}
+	// Keyed, so not the plain hash of the value.
+	sum := sha256.Sum256([]byte("123-45-6789"))
+	if hashed == hex.EncodeToString(sum[:]) || len(hashed) != 2*sha256.Size {
+		t.Errorf("Expected a keyed hash, got %s", hashed)
+	}
+}
+
+func TestColumnMaskNull(t *testing.T) {
+	for _, mask := range []ColumnMask{{Rule: "hash"}, {Rule: "null"}, {Rule: "truncate", Length: 1}} {
+		if actual := mask.Apply("\\N"); actual != "\\N" {
+			t.Errorf("Expected %s to leave NULL as it is, got %q", mask.Rule, actual)
+		}
+	}
+}
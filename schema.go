@@ -0,0 +1,187 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ * A version of the columns of a CSV output file. Released versions never
+ * change; columns are only added in a new version, so that parsers written
+ * against an older version keep working until they opt in.
+ */
+type csvSchema struct {
+	version string
+	columns []string
+	// Whether the file starts with a schema=<version> record followed by a
+	// record of the column names. Only the original query-stats-file
+	// schema has no header.
+	header bool
+}
+
+var queryStatsSchemas = map[string]*csvSchema{
+	"v1": &csvSchema{
+		version: "v1",
+		columns: []string{"job", "start_micros", "elapsed_micros", "rows_affected", "errors"},
+	},
+	"v2": &csvSchema{
+		version: "v2",
+		columns: []string{"job", "start_micros", "elapsed_micros", "rows_affected", "errors",
+			"queries", "worker", "non_repeatable"},
+		header: true,
+	},
+	"v3": &csvSchema{
+		version: "v3",
+		columns: []string{"job", "start_micros", "elapsed_micros", "rows_affected", "errors",
+			"queries", "worker", "non_repeatable", "seq"},
+		header: true,
+	},
+}
+
+var intervalStatsSchemas = map[string]*csvSchema{
+	"v1": &csvSchema{
+		version: "v1",
+		columns: []string{"job", "end_micros", "transactions", "errors", "mean_latency_micros",
+			"rows_affected", "queries"},
+		header: true,
+	},
+	"v2": &csvSchema{
+		version: "v2",
+		columns: []string{"job", "end_micros", "transactions", "errors", "mean_latency_micros",
+			"rows_affected", "queries", "start_micros", "start_time", "end_time"},
+		header: true,
+	},
+	"v3": &csvSchema{
+		version: "v3",
+		columns: []string{"job", "end_micros", "transactions", "errors", "mean_latency_micros",
+			"rows_affected", "queries", "start_micros", "start_time", "end_time", "events"},
+		header: true,
+	},
+}
+
+var queryStatsSchema = flag.String("query-stats-schema", "v1",
+	"Schema of the query-stats-file, v1, v2 or v3. See the README for the columns of each.")
+var intervalStatsSchema = flag.String("interval-stats-schema", "v1",
+	"Schema of the interval-stats-file, v1, v2 or v3. See the README for the columns of each.")
+
+var intervalStatsFile WriteFileFlagValue
+
+func init() {
+	flag.Var(&intervalStatsFile, "interval-stats-file",
+		"Log the stats of each job for every intermediate-stats-interval to CSV file.")
+}
+
+// The schema of the query-stats-file selected by -query-stats-schema.
+func currentQueryStatsSchema() (*csvSchema, error) {
+	schema, ok := queryStatsSchemas[*queryStatsSchema]
+	if !ok {
+		return nil, fmt.Errorf("unknown query-stats-schema %s", strconv.Quote(*queryStatsSchema))
+	}
+	return schema, nil
+}
+
+// The schema of the interval-stats-file selected by -interval-stats-schema.
+func currentIntervalStatsSchema() (*csvSchema, error) {
+	schema, ok := intervalStatsSchemas[*intervalStatsSchema]
+	if !ok {
+		return nil, fmt.Errorf("unknown interval-stats-schema %s", strconv.Quote(*intervalStatsSchema))
+	}
+	return schema, nil
+}
+
+func (s *csvSchema) WriteHeader(w *csv.Writer) error {
+	if !s.header {
+		return nil
+	}
+	if err := w.Write([]string{"schema=" + s.version}); err != nil {
+		return err
+	}
+	return w.Write(s.columns)
+}
+
+/*
+ * Returns the schema declared by the first record of a file, or nil if the
+ * record is not a schema record.
+ */
+func parseSchemaRecord(schemas map[string]*csvSchema, record []string) (*csvSchema, error) {
+	if len(record) != 1 || !strings.HasPrefix(record[0], "schema=") {
+		return nil, nil
+	}
+	version := strings.TrimPrefix(record[0], "schema=")
+	if schema, ok := schemas[version]; ok {
+		return schema, nil
+	}
+	return nil, fmt.Errorf("unknown schema %s", strconv.Quote(version))
+}
+
+func micros(d time.Duration) string {
+	return strconv.FormatInt(d.Nanoseconds()/1000, 10)
+}
+
+func (s *csvSchema) queryStatsRecord(jr *JobResult) []string {
+	record := []string{
+		jr.Name,
+		micros(jr.Start),
+		micros(jr.Elapsed),
+		strconv.FormatInt(jr.RowsAffected, 10),
+		strconv.FormatUint(jr.Errors.TotalErrors(), 10),
+	}
+	if s.version == "v1" {
+		return record
+	}
+	record = append(record,
+		strconv.Itoa(jr.Queries),
+		strconv.Itoa(jr.Worker),
+		strconv.Itoa(jr.NonRepeatable))
+	if s.version == "v2" {
+		return record
+	}
+	return append(record, strconv.FormatInt(jr.Seq, 10))
+}
+
+/*
+ * The record of the stats of a job over the window, during which the events
+ * were in progress.
+ */
+func (s *csvSchema) intervalStatsRecord(name string, w statsWindow, js *jobStats, events []string) []string {
+	record := []string{
+		name,
+		micros(w.End.Sub(w.Origin)),
+		strconv.Itoa(js.Transactions.Count()),
+		strconv.FormatUint(js.TotalErrors, 10),
+		micros(time.Duration(js.Transactions.Mean())),
+		strconv.FormatInt(js.RowsAffected, 10),
+		strconv.FormatUint(js.Queries, 10),
+	}
+	if s.version == "v1" {
+		return record
+	}
+	record = append(record,
+		micros(w.Start.Sub(w.Origin)),
+		w.Start.UTC().Format(time.RFC3339Nano),
+		w.End.UTC().Format(time.RFC3339Nano))
+	if s.version == "v2" {
+		return record
+	}
+	return append(record, strings.Join(events, ","))
+}
@@ -0,0 +1,78 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueryStatsSchemas(t *testing.T) {
+	jr := &JobResult{
+		Name: "test", Start: time.Second, Elapsed: time.Millisecond,
+		Queries: 2, RowsAffected: 3, Errors: make(ErrorCounts), Worker: 4, Seq: 5,
+	}
+
+	for _, version := range []string{"v1", "v2", "v3"} {
+		schema := queryStatsSchemas[version]
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		schema.WriteHeader(w)
+		w.Write(schema.queryStatsRecord(jr))
+		w.Flush()
+
+		var out bytes.Buffer
+		if err := reportQueryStats(&buf, &out); err != nil {
+			t.Errorf("Error reporting %s query stats: %v", version, err)
+		} else if !strings.HasPrefix(out.String(), "test: 1 transactions") {
+			t.Errorf("Unexpected report of %s query stats: %s", version, out.String())
+		}
+	}
+
+	if got := strings.Join(queryStatsSchemas["v1"].queryStatsRecord(jr), ","); got != "test,1000000,1000,3,0" {
+		t.Errorf("The v1 query stats schema changed: %s", got)
+	}
+	if got := strings.Join(queryStatsSchemas["v2"].queryStatsRecord(jr), ","); got != "test,1000000,1000,3,0,2,4,0" {
+		t.Errorf("The v2 query stats schema changed: %s", got)
+	}
+	if got := strings.Join(queryStatsSchemas["v3"].queryStatsRecord(jr), ","); got != "test,1000000,1000,3,0,2,4,0,5" {
+		t.Errorf("Unexpected v3 query stats: %s", got)
+	}
+}
+
+func TestIntervalStatsSchemas(t *testing.T) {
+	origin := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	w := statsWindow{origin, origin.Add(time.Second), origin.Add(2 * time.Second)}
+	js := new(jobStats)
+	js.Transactions.Add(float64(time.Millisecond))
+	js.Queries = 1
+
+	if got := strings.Join(intervalStatsSchemas["v1"].intervalStatsRecord("test", w, js, []string{"backup"}), ","); got != "test,2000000,1,0,1000,0,1" {
+		t.Errorf("The v1 interval stats schema changed: %s", got)
+	}
+	expected := "test,2000000,1,0,1000,0,1,1000000,2020-06-01T12:00:01Z,2020-06-01T12:00:02Z"
+	if got := strings.Join(intervalStatsSchemas["v2"].intervalStatsRecord("test", w, js, []string{"backup"}), ","); got != expected {
+		t.Errorf("For the v2 interval stats\n\texpected %s\n\tbut got  %s", expected, got)
+	}
+	expected += ",backup"
+	if got := strings.Join(intervalStatsSchemas["v3"].intervalStatsRecord("test", w, js, []string{"backup"}), ","); got != expected {
+		t.Errorf("For the v3 interval stats\n\texpected %s\n\tbut got  %s", expected, got)
+	}
+}
@@ -0,0 +1,181 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const shellHelp = `Type a query to run it with the current settings, or a command:
+  \concurrency <n>   run the query with n simultaneous executions
+  \rate <r>          run the query r times per second instead
+  \duration <d>      run the query for this long (e.g. 10s)
+  \settings          show the current settings
+  \quit              exit the shell
+`
+
+type shellSettings struct {
+	concurrency uint64
+	rate        float64
+	duration    time.Duration
+}
+
+func (ss *shellSettings) String() string {
+	if ss.rate > 0 {
+		return fmt.Sprintf("rate %g for %v", ss.rate, ss.duration)
+	}
+	return fmt.Sprintf("concurrency %d for %v", ss.concurrency, ss.duration)
+}
+
+func (ss *shellSettings) command(line string) (quit bool, err error) {
+	fields := strings.Fields(line)
+	switch fields[0] {
+	case `\quit`, `\q`:
+		return true, nil
+	case `\help`, `\?`:
+		fmt.Print(shellHelp)
+		return false, nil
+	case `\settings`:
+		fmt.Println(ss)
+		return false, nil
+	}
+
+	if len(fields) != 2 {
+		return false, fmt.Errorf("%s takes exactly one argument", fields[0])
+	}
+	switch fields[0] {
+	case `\concurrency`:
+		concurrency, err := strconv.ParseUint(fields[1], 10, 0)
+		if err != nil {
+			return false, err
+		} else if concurrency == 0 {
+			return false, fmt.Errorf("concurrency must be positive")
+		}
+		ss.concurrency, ss.rate = concurrency, 0
+	case `\rate`:
+		rate, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return false, err
+		} else if rate <= 0 {
+			return false, fmt.Errorf("rate must be positive")
+		}
+		ss.concurrency, ss.rate = 0, rate
+	case `\duration`:
+		duration, err := time.ParseDuration(fields[1])
+		if err != nil {
+			return false, err
+		} else if duration <= 0 {
+			return false, fmt.Errorf("duration must be positive")
+		}
+		ss.duration = duration
+	default:
+		return false, fmt.Errorf("unknown command %s", fields[0])
+	}
+	fmt.Println(ss)
+	return false, nil
+}
+
+func (ss *shellSettings) config(df DatabaseFlavor, query string) *Config {
+	job := &Job{Name: "shell", Queries: []string{query}}
+	if ss.rate > 0 {
+		job.Rate, job.BatchSize = ss.rate, 1
+	} else {
+		job.QueueDepth = ss.concurrency
+	}
+	return &Config{
+		Flavor:     df,
+		Duration:   ss.duration,
+		Jobs:       map[string]*Job{job.Name: job},
+		ShowErrors: true,
+	}
+}
+
+/*
+ * Reads queries from in and runs each of them for a short while, printing the
+ * stats, as a quick way to explore a workload before writing a runfile.
+ */
+func runShell(db Database, df DatabaseFlavor, in io.Reader) {
+	settings := &shellSettings{concurrency: 1, duration: 5 * time.Second}
+
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+
+	fmt.Print(shellHelp)
+	scanner := bufio.NewScanner(in)
+	for fmt.Print("dbbench> "); scanner.Scan(); fmt.Print("dbbench> ") {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, `\`) {
+			if quit, err := settings.command(line); err != nil {
+				fmt.Println("error:", err)
+			} else if quit {
+				return
+			}
+			continue
+		}
+
+		var queries []string
+		for _, q := range df.SplitQueries(line) {
+			if strings.TrimSpace(q) != "" {
+				queries = append(queries, q)
+			}
+		}
+		if len(queries) != 1 {
+			fmt.Println("error: expected a single query")
+			continue
+		}
+		query := queries[0]
+		if err := df.CheckQuery(query); err != nil {
+			fmt.Println("error:", err)
+			continue
+		}
+
+		// Ignore interrupts that arrived while waiting at the prompt.
+		select {
+		case <-interrupts:
+		default:
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			select {
+			case <-interrupts:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		stats := runJobs(ctx, db, df, settings.config(df, query))
+		cancel()
+
+		for _, s := range stats {
+			fmt.Println(s)
+		}
+	}
+	fmt.Println()
+}
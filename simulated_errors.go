@@ -0,0 +1,167 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+/*
+ * An error injected by -simulate-errors in place of running a query. Its code
+ * is used directly instead of being parsed by the database flavor.
+ */
+type SimulatedError struct {
+	Code string
+}
+
+func (se *SimulatedError) Error() string {
+	return fmt.Sprintf("simulated error %s", se.Code)
+}
+
+type simulatedErrorRate struct {
+	code string
+	rate float64
+}
+
+type SimulatedErrorsFlagValue struct {
+	rates []simulatedErrorRate
+}
+
+func (sefv *SimulatedErrorsFlagValue) Set(v string) error {
+	for _, spec := range strings.Split(v, ",") {
+		parts := strings.SplitN(spec, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("invalid simulated error %s, expected code:rate",
+				strconv.Quote(spec))
+		}
+		rate, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return err
+		} else if rate < 0 || rate > 1 {
+			return errors.New("simulated error rate must be between 0 and 1")
+		}
+		sefv.rates = append(sefv.rates, simulatedErrorRate{parts[0], rate})
+	}
+	return nil
+}
+
+func (sefv *SimulatedErrorsFlagValue) String() string {
+	specs := make([]string, 0, len(sefv.rates))
+	for _, r := range sefv.rates {
+		specs = append(specs, fmt.Sprintf("%s:%g", r.code, r.rate))
+	}
+	return strings.Join(specs, ",")
+}
+
+var simulatedErrors SimulatedErrorsFlagValue
+
+func init() {
+	flag.Var(&simulatedErrors, "simulate-errors",
+		"Developer mode: fail queries with the given error code at the given "+
+			"probability instead of running them, as comma separated code:rate "+
+			"pairs (e.g. 1205:0.01).")
+}
+
+/*
+ * Wraps a database, failing queries at random with simulated errors.
+ */
+type simulatedErrorDatabase struct {
+	Database
+	rates []simulatedErrorRate
+}
+
+func (sed *simulatedErrorDatabase) RunQuery(ctx context.Context, w *SafeCSVWriter, q string, args []interface{}) (int64, error) {
+	return sed.RunQueryWithOptions(ctx, w, q, args, QueryOptions{})
+}
+
+func (sed *simulatedErrorDatabase) RunQueryWithOptions(ctx context.Context, w *SafeCSVWriter, q string, args []interface{}, opts QueryOptions) (int64, error) {
+	if err := simulateError(sed.rates); err != nil {
+		return 0, err
+	}
+	return sed.Database.RunQueryWithOptions(ctx, w, q, args, opts)
+}
+
+func (sed *simulatedErrorDatabase) Begin() (Transaction, error) {
+	tx, err := sed.Database.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &simulatedErrorTx{tx, sed.rates}, nil
+}
+
+func (sed *simulatedErrorDatabase) Session() (Session, error) {
+	s, err := sed.Database.Session()
+	if err != nil {
+		return nil, err
+	}
+	return &simulatedErrorSession{s, sed.rates}, nil
+}
+
+func (sed *simulatedErrorDatabase) NewSession(ctx context.Context) (Session, error) {
+	s, err := sed.Database.NewSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &simulatedErrorSession{s, sed.rates}, nil
+}
+
+type simulatedErrorSession struct {
+	Session
+	rates []simulatedErrorRate
+}
+
+func (ses *simulatedErrorSession) RunQueryWithOptions(ctx context.Context, w *SafeCSVWriter, q string, args []interface{}, opts QueryOptions) (int64, error) {
+	if err := simulateError(ses.rates); err != nil {
+		return 0, err
+	}
+	return ses.Session.RunQueryWithOptions(ctx, w, q, args, opts)
+}
+
+func (ses *simulatedErrorSession) Begin() (Transaction, error) {
+	tx, err := ses.Session.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &simulatedErrorTx{tx, ses.rates}, nil
+}
+
+type simulatedErrorTx struct {
+	Transaction
+	rates []simulatedErrorRate
+}
+
+func (set *simulatedErrorTx) RunQueryWithOptions(ctx context.Context, w *SafeCSVWriter, q string, args []interface{}, opts QueryOptions) (int64, error) {
+	if err := simulateError(set.rates); err != nil {
+		return 0, err
+	}
+	return set.Transaction.RunQueryWithOptions(ctx, w, q, args, opts)
+}
+
+func simulateError(rates []simulatedErrorRate) error {
+	for _, r := range rates {
+		if rand.Float64() < r.rate {
+			return &SimulatedError{r.code}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// An invocation kept by slowestInvocations.
+type slowInvocation struct {
+	Time    time.Time
+	Elapsed time.Duration
+	Queries []queryInvocation
+	// An error the invocation returned, if any: that of the lowest error
+	// code, if it returned several.
+	Error error
+}
+
+/*
+ * The n slowest invocations of a job, kept in a min-heap by latency so the
+ * fastest of them is the one replaced by a slower invocation.
+ */
+type slowestInvocations struct {
+	n           int
+	invocations []*slowInvocation
+}
+
+func newSlowestInvocations(n int) *slowestInvocations {
+	return &slowestInvocations{n: n}
+}
+
+func (si *slowestInvocations) Len() int { return len(si.invocations) }
+
+func (si *slowestInvocations) Less(i, j int) bool {
+	return si.invocations[i].Elapsed < si.invocations[j].Elapsed
+}
+
+func (si *slowestInvocations) Swap(i, j int) {
+	si.invocations[i], si.invocations[j] = si.invocations[j], si.invocations[i]
+}
+
+func (si *slowestInvocations) Push(x interface{}) {
+	si.invocations = append(si.invocations, x.(*slowInvocation))
+}
+
+func (si *slowestInvocations) Pop() interface{} {
+	last := si.invocations[len(si.invocations)-1]
+	si.invocations = si.invocations[:len(si.invocations)-1]
+	return last
+}
+
+func (si *slowestInvocations) Add(jr *JobResult) {
+	if len(si.invocations) == si.n {
+		if jr.Elapsed <= si.invocations[0].Elapsed {
+			return
+		}
+		heap.Pop(si)
+	}
+	heap.Push(si, &slowInvocation{
+		Time:    jr.startedAt,
+		Elapsed: jr.Elapsed,
+		Queries: jr.invocation,
+		Error:   jr.Errors.LowestCodeError(),
+	})
+}
+
+// The invocations kept, slowest first.
+func (si *slowestInvocations) Sorted() []*slowInvocation {
+	sorted := make([]*slowInvocation, len(si.invocations))
+	copy(sorted, si.invocations)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Elapsed > sorted[j].Elapsed
+	})
+	return sorted
+}
+
+// The queries of the invocation and their arguments, separated by semicolons.
+func (s *slowInvocation) QueryString() string {
+	queries := make([]string, len(s.Queries))
+	for i, qi := range s.Queries {
+		queries[i] = abbreviateQuery(qi.query)
+		if len(qi.args) > 0 {
+			queries[i] += fmt.Sprintf(" %v", qi.args)
+		}
+	}
+	return strings.Join(queries, "; ")
+}
+
+func (si *slowestInvocations) String() string {
+	var str strings.Builder
+	for _, s := range si.Sorted() {
+		str.WriteString(fmt.Sprintf("%12v at %s: %s\n",
+			s.Elapsed, s.Time.Format("15:04:05.000"), s.QueryString()))
+		if s.Error != nil {
+			str.WriteString(fmt.Sprintf("%12s error: %v\n", "", s.Error))
+		}
+	}
+	return str.String()
+}
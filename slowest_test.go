@@ -0,0 +1,52 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSlowestInvocations(t *testing.T) {
+	si := newSlowestInvocations(3)
+	for i, ms := range []int{5, 1, 9, 3, 7, 2} {
+		jr := &JobResult{
+			Elapsed:    time.Duration(ms) * time.Millisecond,
+			invocation: []queryInvocation{{query: "select ?", args: []interface{}{i}}},
+		}
+		if ms == 7 {
+			jr.Errors = ErrorCounts{"1205": {Error: errors.New("lock wait timeout")}}
+		}
+		si.Add(jr)
+	}
+
+	sorted := si.Sorted()
+	if len(sorted) != 3 {
+		t.Fatalf("Expected 3 invocations, got %d", len(sorted))
+	}
+	for i, ms := range []int{9, 7, 5} {
+		if sorted[i].Elapsed != time.Duration(ms)*time.Millisecond {
+			t.Errorf("Expected invocation %d to take %dms, got %v", i, ms, sorted[i].Elapsed)
+		}
+	}
+	if q := sorted[0].QueryString(); q != "select ? [2]" {
+		t.Errorf("Unexpected query %q", q)
+	}
+	if sorted[0].Error != nil || sorted[1].Error == nil {
+		t.Errorf("Unexpected errors %v, %v", sorted[0].Error, sorted[1].Error)
+	}
+}
@@ -0,0 +1,190 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"compress/gzip"
+	"flag"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+)
+
+var queryResultsMemory = flag.Int("query-results-memory", 64<<20,
+	"Bytes of query results buffered in memory for each query-results-file; "+
+		"beyond that results are spilled to compressed temporary files until "+
+		"they can be written.")
+
+// Buffered results are split into chunks of at most this fraction of the
+// memory budget, so that a spill only needs to compress a single chunk.
+const spillChunkFraction = 4
+
+type spillChunk struct {
+	data []byte
+	path string // Set once the chunk is spilled to disk.
+}
+
+/*
+ * Writes to dst from a background goroutine, so that writers never wait for
+ * dst. Pending writes are kept in memory up to a budget and then spilled to
+ * gzip compressed temporary files, preserving their order.
+ */
+type spillingWriter struct {
+	dst    io.WriteCloser
+	budget int
+
+	m        sync.Mutex
+	cond     *sync.Cond
+	chunks   []*spillChunk // Oldest first.
+	inMemory int
+	spilled  int64
+	closed   bool
+	err      error
+	done     chan struct{}
+}
+
+func newSpillingWriter(dst io.WriteCloser, budget int) *spillingWriter {
+	sw := &spillingWriter{dst: dst, budget: budget, done: make(chan struct{})}
+	sw.cond = sync.NewCond(&sw.m)
+	go sw.drain()
+	return sw
+}
+
+func (sw *spillingWriter) Write(p []byte) (int, error) {
+	sw.m.Lock()
+	defer sw.m.Unlock()
+
+	if sw.err != nil {
+		return 0, sw.err
+	}
+
+	var tail *spillChunk
+	if len(sw.chunks) > 0 {
+		tail = sw.chunks[len(sw.chunks)-1]
+	}
+	if tail == nil || tail.path != "" || len(tail.data) >= sw.budget/spillChunkFraction {
+		tail = &spillChunk{}
+		sw.chunks = append(sw.chunks, tail)
+	}
+	tail.data = append(tail.data, p...)
+	sw.inMemory += len(p)
+
+	// Spill the newest data, since it will be written last.
+	if sw.inMemory > sw.budget {
+		if err := sw.spill(tail); err != nil {
+			sw.err = err
+			return 0, err
+		}
+	}
+
+	sw.cond.Signal()
+	return len(p), nil
+}
+
+// Must be called with the lock held.
+func (sw *spillingWriter) spill(chunk *spillChunk) error {
+	f, err := ioutil.TempFile("", "dbbench-results-*.gz")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zw, _ := gzip.NewWriterLevel(f, gzip.BestSpeed)
+	if _, err := zw.Write(chunk.data); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	if sw.spilled == 0 {
+		log.Printf("query results are being written more slowly than they are produced; "+
+			"spilling to %s", f.Name())
+	}
+	sw.inMemory -= len(chunk.data)
+	sw.spilled += int64(len(chunk.data))
+	chunk.data, chunk.path = nil, f.Name()
+	return nil
+}
+
+func (sw *spillingWriter) drain() {
+	defer close(sw.done)
+
+	for {
+		sw.m.Lock()
+		for len(sw.chunks) == 0 && !sw.closed {
+			sw.cond.Wait()
+		}
+		if len(sw.chunks) == 0 {
+			sw.m.Unlock()
+			return
+		}
+		chunk := sw.chunks[0]
+		sw.chunks = sw.chunks[1:]
+		sw.inMemory -= len(chunk.data)
+		sw.m.Unlock()
+
+		if err := sw.writeChunk(chunk); err != nil {
+			sw.m.Lock()
+			if sw.err == nil {
+				sw.err = err
+			}
+			sw.m.Unlock()
+		}
+	}
+}
+
+func (sw *spillingWriter) writeChunk(chunk *spillChunk) error {
+	if chunk.path == "" {
+		_, err := sw.dst.Write(chunk.data)
+		return err
+	}
+
+	defer os.Remove(chunk.path)
+	f, err := os.Open(chunk.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(sw.dst, zr)
+	return err
+}
+
+/*
+ * Waits for all pending writes to be written to dst and closes it.
+ */
+func (sw *spillingWriter) Close() error {
+	sw.m.Lock()
+	sw.closed = true
+	sw.cond.Signal()
+	sw.m.Unlock()
+
+	<-sw.done
+	if err := sw.dst.Close(); err != nil && sw.err == nil {
+		sw.err = err
+	}
+	return sw.err
+}
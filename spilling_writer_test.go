@@ -0,0 +1,65 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+type slowBuffer struct {
+	m   sync.Mutex
+	buf bytes.Buffer
+}
+
+func (sb *slowBuffer) Write(p []byte) (int, error) {
+	time.Sleep(time.Millisecond)
+	sb.m.Lock()
+	defer sb.m.Unlock()
+	return sb.buf.Write(p)
+}
+
+func (sb *slowBuffer) Close() error {
+	return nil
+}
+
+func TestSpillingWriter(t *testing.T) {
+	dst := &slowBuffer{}
+	sw := newSpillingWriter(dst, 64)
+
+	var expected bytes.Buffer
+	for i := 0; i < 200; i++ {
+		line := fmt.Sprintf("row %d\n", i)
+		expected.WriteString(line)
+		if _, err := sw.Write([]byte(line)); err != nil {
+			t.Fatalf("Error writing: %v", err)
+		}
+	}
+	if err := sw.Close(); err != nil {
+		t.Fatalf("Error closing: %v", err)
+	}
+
+	if sw.spilled == 0 {
+		t.Errorf("Expected writes to be spilled")
+	}
+	if dst.buf.String() != expected.String() {
+		t.Errorf("Expected %q but got %q", expected.String(), dst.buf.String())
+	}
+}
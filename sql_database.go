@@ -17,12 +17,17 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/go-sql-driver/mysql"
 	"github.com/lib/pq"
@@ -30,17 +35,109 @@ import (
 
 type sqlDb struct {
 	db *sql.DB
+
+	// Used to open a separate connection for each script.
+	driverName string
+	dsn        string
+
+	// Query -> *sql.Stmt, which is prepared on each connection it runs on.
+	stmts sync.Map
+}
+
+func (s *sqlDb) RunQuery(ctx context.Context, w *SafeCSVWriter, q string, args []interface{}) (int64, error) {
+	return s.RunQueryWithOptions(ctx, w, q, args, QueryOptions{})
+}
+
+func (s *sqlDb) RunQueryWithOptions(ctx context.Context, w *SafeCSVWriter, q string, args []interface{}, opts QueryOptions) (int64, error) {
+	if opts.Prepare {
+		stmt, err := s.prepared(ctx, q)
+		if err != nil {
+			return 0, err
+		}
+		return runSQLQuery(ctx, preparedQueryer{stmt}, w, q, args, opts, false)
+	}
+	return runSQLQuery(ctx, s.db, w, q, args, opts, false)
+}
+
+func (s *sqlDb) prepared(ctx context.Context, q string) (*sql.Stmt, error) {
+	if stmt, ok := s.stmts.Load(q); ok {
+		return stmt.(*sql.Stmt), nil
+	}
+	stmt, err := s.db.PrepareContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	if other, loaded := s.stmts.LoadOrStore(q, stmt); loaded {
+		// Prepared concurrently by another worker.
+		stmt.Close()
+		return other.(*sql.Stmt), nil
+	}
+	return stmt, nil
+}
+
+// Runs a prepared statement, ignoring the query text it is given.
+type preparedQueryer struct {
+	stmt *sql.Stmt
+}
+
+func (p preparedQueryer) QueryContext(ctx context.Context, _ string, args ...interface{}) (*sql.Rows, error) {
+	return p.stmt.QueryContext(ctx, args...)
+}
+
+func (p preparedQueryer) ExecContext(ctx context.Context, _ string, args ...interface{}) (sql.Result, error) {
+	return p.stmt.ExecContext(ctx, args...)
+}
+
+// The pool of a sql.DB or the connection of a sql.Tx or a sqlSession.
+type sqlQueryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+/*
+ * Runs a query with Query or Exec, depending on whether it returns rows. Only
+ * a query on a reserved connection may change the database.
+ */
+func runSQLQuery(ctx context.Context, s sqlQueryer, w *SafeCSVWriter, q string, args []interface{}, opts QueryOptions, reserved bool) (int64, error) {
+	if opts.Timeout <= 0 {
+		return runSQLQueryContext(ctx, s, w, q, args, opts, reserved)
+	}
+
+	queryCtx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+	rows, err := runSQLQueryContext(queryCtx, s, w, q, args, opts, reserved)
+	if err != nil && ctx.Err() == nil && queryCtx.Err() == context.DeadlineExceeded {
+		// Whatever the driver made of the cancellation.
+		err = &QueryTimeoutError{opts.Timeout}
+	}
+	return rows, err
 }
 
-func (s *sqlDb) RunQuery(w *SafeCSVWriter, q string, args []interface{}) (int64, error) {
+func runSQLQueryContext(ctx context.Context, s sqlQueryer, w *SafeCSVWriter, q string, args []interface{}, opts QueryOptions, reserved bool) (int64, error) {
+	if opts.AllResultSets {
+		// Any statement may return result sets, e.g. a stored procedure.
+		return countQueryRows(ctx, s, w, q, args, opts)
+	}
 
-	switch action := strings.ToLower(strings.Fields(q)[0]); action {
+	switch action := sqlAction(q); action {
 	case "select", "show", "explain", "describe", "desc":
-		return s.countQueryRows(w, q, args)
-	case "use", "begin":
+		return countQueryRows(ctx, s, w, q, args, opts)
+	case "call", "exec", "execute":
+		// A stored procedure may return any number of result sets, whose
+		// rows are lost by Exec, so the rows of all of them are summed.
+		// The rows its statements change are only reported by Exec, and
+		// are not counted.
+		opts.AllResultSets = true
+		return countQueryRows(ctx, s, w, q, args, opts)
+	case "use":
+		if !reserved {
+			return 0, fmt.Errorf("invalid query action: %v", action)
+		}
+		return countExecRows(ctx, s, q, args)
+	case "begin":
 		return 0, fmt.Errorf("invalid query action: %v", action)
 	default:
-		return s.countExecRows(q, args)
+		return countExecRows(ctx, s, q, args)
 	}
 }
 
@@ -49,9 +146,10 @@ type rowOutputter struct {
 	outputValues []string
 	pointers     []interface{}
 	w            *SafeCSVWriter
+	resultSet    int
 }
 
-func makeRowOutputter(w *SafeCSVWriter, r *sql.Rows) (*rowOutputter, error) {
+func makeRowOutputter(w *SafeCSVWriter, r *sql.Rows, resultSet int) (*rowOutputter, error) {
 	columns, err := r.Columns()
 	if err != nil {
 		return nil, err
@@ -65,7 +163,7 @@ func makeRowOutputter(w *SafeCSVWriter, r *sql.Rows) (*rowOutputter, error) {
 		resP[i] = &res[i]
 	}
 
-	return &rowOutputter{res, resO, resP, w}, nil
+	return &rowOutputter{res, resO, resP, w, resultSet}, nil
 }
 
 func (ro *rowOutputter) outputRows(r *sql.Rows) error {
@@ -75,41 +173,52 @@ func (ro *rowOutputter) outputRows(r *sql.Rows) error {
 
 	for i, v := range ro.values {
 		if v.Valid {
-			ro.outputValues[i] = v.String
+			ro.outputValues[i] = ro.w.Encode(i, v.String)
 		} else {
 			ro.outputValues[i] = "\\N"
 		}
 	}
-	if err := ro.w.Write(ro.outputValues); err != nil {
+	if err := ro.w.WriteResultRow(ro.resultSet, ro.outputValues); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (s *sqlDb) countQueryRows(w *SafeCSVWriter, q string, args []interface{}) (int64, error) {
-	rows, err := s.db.Query(q, args...)
+func countQueryRows(ctx context.Context, s sqlQueryer, w *SafeCSVWriter, q string, args []interface{}, opts QueryOptions) (int64, error) {
+	rows, err := s.QueryContext(ctx, q, args...)
 	if err != nil {
 		return 0, err
 	}
 	defer rows.Close()
 
 	var rowsAffected int64
-	var ro *rowOutputter
-
-	if w != nil {
-		if ro, err = makeRowOutputter(w, rows); err != nil {
-			return 0, err
-		}
-	}
-
-	for rows.Next() {
+	var limitErr error
+	for resultSet := 1; ; resultSet++ {
+		var ro *rowOutputter
 		if w != nil {
-			if err = ro.outputRows(rows); err != nil {
+			if ro, err = makeRowOutputter(w, rows, resultSet); err != nil {
 				return 0, err
 			}
 		}
-		rowsAffected++
+
+		for rows.Next() {
+			if opts.MaxRows > 0 && rowsAffected == opts.MaxRows {
+				// The rest of the results are discarded when the rows are closed.
+				limitErr = &MaxRowsError{opts.MaxRows}
+				break
+			}
+			if w != nil {
+				if err = ro.outputRows(rows); err != nil {
+					return 0, err
+				}
+			}
+			rowsAffected++
+		}
+
+		if limitErr != nil || !opts.AllResultSets || !rows.NextResultSet() {
+			break
+		}
 	}
 	if err = rows.Err(); err != nil {
 		return 0, err
@@ -123,44 +232,200 @@ func (s *sqlDb) countQueryRows(w *SafeCSVWriter, q string, args []interface{}) (
 		}
 	}
 
-	return rowsAffected, nil
+	return rowsAffected, limitErr
 }
 
-func (s *sqlDb) countExecRows(q string, args []interface{}) (int64, error) {
-	res, err := s.db.Exec(q, args...)
+func countExecRows(ctx context.Context, s sqlQueryer, q string, args []interface{}) (int64, error) {
+	res, err := s.ExecContext(ctx, q, args...)
 	if err != nil {
 		return 0, err
 	}
 	return res.RowsAffected()
 }
 
+/*
+ * Scripts run on a connection of their own rather than one from the pool of
+ * the jobs, since the script may leave the connection in a different state.
+ */
+func (s *sqlDb) RunScript(statements []string) error {
+	db, err := sql.Open(s.driverName, s.dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for _, statement := range statements {
+		if _, err := conn.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("error in statement %s: %v", strconv.Quote(statement), err)
+		}
+	}
+	return nil
+}
+
+func (s *sqlDb) Begin() (Transaction, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &sqlTx{tx, false, s}, nil
+}
+
+func (s *sqlDb) Session() (Session, error) {
+	conn, err := s.db.Conn(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return &sqlSession{conn: conn, db: s, stmts: make(map[string]*sql.Stmt)}, nil
+}
+
+func (s *sqlDb) NewSession(ctx context.Context) (Session, error) {
+	db, err := sql.Open(s.driverName, s.dsn)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqlSession{conn: conn, db: s, stmts: make(map[string]*sql.Stmt), pool: db}, nil
+}
+
 func (s *sqlDb) Close() {
+	s.stmts.Range(func(_, stmt interface{}) bool {
+		stmt.(*sql.Stmt).Close()
+		return true
+	})
 	s.db.Close()
 }
 
+type sqlTx struct {
+	tx *sql.Tx
+	// Whether the transaction is on the connection of a sqlSession.
+	reserved bool
+	db       *sqlDb
+}
+
+func (t *sqlTx) RunQueryWithOptions(ctx context.Context, w *SafeCSVWriter, q string, args []interface{}, opts QueryOptions) (int64, error) {
+	if opts.Prepare {
+		stmt, err := t.db.prepared(ctx, q)
+		if err != nil {
+			return 0, err
+		}
+		// Reuses the statement if it is already prepared on the
+		// connection of the transaction.
+		txStmt := t.tx.StmtContext(ctx, stmt)
+		defer txStmt.Close()
+		return runSQLQuery(ctx, preparedQueryer{txStmt}, w, q, args, opts, t.reserved)
+	}
+	return runSQLQuery(ctx, t.tx, w, q, args, opts, t.reserved)
+}
+
+func (t *sqlTx) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *sqlTx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+type sqlSession struct {
+	conn *sql.Conn
+	db   *sqlDb
+	// Statements prepared on the connection of the session.
+	stmts map[string]*sql.Stmt
+	// The pool the connection was opened with, if it is not the pool of db.
+	pool *sql.DB
+}
+
+func (s *sqlSession) RunQueryWithOptions(ctx context.Context, w *SafeCSVWriter, q string, args []interface{}, opts QueryOptions) (int64, error) {
+	if opts.Prepare {
+		stmt, ok := s.stmts[q]
+		if !ok {
+			var err error
+			if stmt, err = s.conn.PrepareContext(ctx, q); err != nil {
+				return 0, err
+			}
+			s.stmts[q] = stmt
+		}
+		return runSQLQuery(ctx, preparedQueryer{stmt}, w, q, args, opts, true)
+	}
+	return runSQLQuery(ctx, s.conn, w, q, args, opts, true)
+}
+
+func (s *sqlSession) Begin() (Transaction, error) {
+	tx, err := s.conn.BeginTx(context.Background(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlTx{tx, true, s.db}, nil
+}
+
+func (s *sqlSession) Close() error {
+	for _, stmt := range s.stmts {
+		stmt.Close()
+	}
+	err := s.conn.Close()
+	if s.pool != nil {
+		if e := s.pool.Close(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
 type sqlDatabaseFlavor struct {
-	name      string
-	dsnFunc   func(cc *ConnectionConfig) string
-	checkFunc func(q string) error
-	errFunc   func(e error) (string, error)
+	name            string
+	dsnFunc         func(cc *ConnectionConfig) string
+	checkFunc       func(q string) error
+	splitFunc       func(contents string) []string
+	errFunc         func(e error) (string, error)
+	placeholderFunc func(q string) int
+	retryableCodes  []string
 }
 
 var maxIdleConns = flag.Int("max-idle-conns", 100, "Maximum idle database connections")
 var maxActiveConns = flag.Int("max-active-conns", 0, "Maximum active database connections")
 
-func (sq *sqlDatabaseFlavor) QuerySeparator() string {
-	return ";"
+func (sq *sqlDatabaseFlavor) SplitQueries(contents string) []string {
+	return sq.splitFunc(contents)
 }
 
 func (sq *sqlDatabaseFlavor) Connect(cc *ConnectionConfig) (Database, error) {
+	hosts, err := parseHosts(cc.Host, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := cc.TLS.Check(sq.name); err != nil {
+		return nil, err
+	}
+	if sq.name == "mysql" && cc.TLS.Enabled() {
+		if err := registerMySQLTLSConfig(&cc.TLS); err != nil {
+			return nil, err
+		}
+	}
+	driverName := sq.name
+	if len(hosts) > 1 {
+		if driverName = multiHostDrivers[sq.name]; driverName == "" {
+			return nil, fmt.Errorf("the %s driver does not support multiple hosts", sq.name)
+		}
+	}
+
 	realPassword := cc.Password
 	cc.Password = "XXX" // Mask password before printing it.
 	dsn := sq.dsnFunc(cc)
-	log.Println("Connecting to", dsn)
+	log.Println("Connecting to", redactDSN(dsn))
 	cc.Password = realPassword
 	dsn = sq.dsnFunc(cc)
 
-	db, err := sql.Open(sq.name, dsn)
+	db, err := sql.Open(driverName, dsn)
 	if err != nil {
 		return nil, err
 	}
@@ -186,7 +451,7 @@ func (sq *sqlDatabaseFlavor) Connect(cc *ConnectionConfig) (Database, error) {
 	 */
 	db.SetMaxOpenConns(*maxActiveConns)
 
-	return &sqlDb{db}, nil
+	return &sqlDb{db: db, driverName: driverName, dsn: dsn}, nil
 }
 
 func (sq *sqlDatabaseFlavor) CheckQuery(q string) error {
@@ -194,9 +459,135 @@ func (sq *sqlDatabaseFlavor) CheckQuery(q string) error {
 }
 
 func (sq *sqlDatabaseFlavor) ErrorCode(e error) (string, error) {
+	if sq.errFunc == nil {
+		return "", ErrorCodesUnsupported
+	}
 	return sq.errFunc(e)
 }
 
+func (sq *sqlDatabaseFlavor) HasErrorCodes() bool {
+	return sq.errFunc != nil
+}
+
+func (sq *sqlDatabaseFlavor) SupportsNamedArgs() bool {
+	// The mysql driver rejects them and the postgres one ignores their names.
+	return sq.name == "mssql" || sq.name == "vertica"
+}
+
+func (sq *sqlDatabaseFlavor) CostModel(rates LinearCostModel) CostModel {
+	return defaultCostModel(rates)
+}
+
+func (sq *sqlDatabaseFlavor) RetryableErrorCodes() []string {
+	return sq.retryableCodes
+}
+
+func (sq *sqlDatabaseFlavor) Describe() string {
+	return fmt.Sprintf("%s driver, default data source %s",
+		sq.name, sq.dsnFunc(&ConnectionConfig{}))
+}
+
+/*
+ * The number of args bound by the placeholders in the query, used to
+ * validate the query against its query-args-file.
+ */
+func (sq *sqlDatabaseFlavor) Placeholders(q string) int {
+	return sq.placeholderFunc(stripQuotedSQL(q))
+}
+
+/*
+ * Blanks out string literals, quoted identifiers, and comments so that
+ * placeholder characters inside them are not counted.
+ */
+func stripQuotedSQL(q string) string {
+	b := []byte(q)
+	for i := 0; i < len(b); i++ {
+		end := ""
+		switch {
+		case b[i] == '\'' || b[i] == '"' || b[i] == '`':
+			end = string(b[i])
+		case strings.HasPrefix(q[i:], "--"):
+			end = "\n"
+		case strings.HasPrefix(q[i:], "/*"):
+			end = "*/"
+		default:
+			continue
+		}
+
+		j := i + 1
+		for j < len(b) && !strings.HasPrefix(q[j:], end) {
+			if b[j] == '\\' && (end == "'" || end == `"`) {
+				j++
+			}
+			j++
+		}
+		j += len(end)
+		for ; i < j && i < len(b); i++ {
+			b[i] = ' '
+		}
+		i--
+	}
+	return string(b)
+}
+
+/*
+ * The first keyword of the statement, lower cased, skipping the comments
+ * before it (e.g. the trace comment of the invocation).
+ */
+func sqlAction(q string) string {
+	for {
+		q = strings.TrimSpace(q)
+		end := ""
+		if strings.HasPrefix(q, "/*") {
+			end = "*/"
+		} else if strings.HasPrefix(q, "--") {
+			end = "\n"
+		} else if fields := strings.Fields(q); len(fields) > 0 {
+			return strings.ToLower(fields[0])
+		} else {
+			return ""
+		}
+		i := strings.Index(q[2:], end)
+		if i < 0 {
+			return ""
+		}
+		q = q[2+i+len(end):]
+	}
+}
+
+func questionMarkPlaceholders(q string) int {
+	return strings.Count(q, "?")
+}
+
+var ordinalPlaceholderRegexp = regexp.MustCompile(`\$([0-9]+)`)
+
+// Postgres style $1, $2, ... placeholders may be repeated or out of order.
+func ordinalPlaceholders(q string) int {
+	max := 0
+	for _, m := range ordinalPlaceholderRegexp.FindAllStringSubmatch(q, -1) {
+		if n, _ := strconv.Atoi(m[1]); n > max {
+			max = n
+		}
+	}
+	return max
+}
+
+var sqlServerPlaceholderRegexp = regexp.MustCompile(`@[pP]([0-9]+)`)
+
+// SQL Server accepts both @p1, @p2, ... and ? placeholders.
+func sqlServerPlaceholders(q string) int {
+	max := 0
+	for _, m := range sqlServerPlaceholderRegexp.FindAllStringSubmatch(q, -1) {
+		if n, _ := strconv.Atoi(m[1]); n > max {
+			max = n
+		}
+	}
+	if max == 0 {
+		return questionMarkPlaceholders(q)
+	}
+	return max
+}
+
 func checkSQLQuery(q string) error {
 	query := strings.TrimSpace(q)
 	if len(query) == 0 {
@@ -210,49 +601,139 @@ func checkSQLQuery(q string) error {
 	case "begin":
 		return errors.New("cannot use transactions")
 	case "use":
-		return errors.New("cannot change database")
+		return ChangeDatabaseError
+	}
+	return nil
+}
+
+/*
+ * Vertica statements such as COPY ... DELIMITER ';' may contain quoted
+ * semicolons.
+ */
+func checkVerticaQuery(q string) error {
+	return checkSQLQuery(stripQuotedSQL(q))
+}
+
+/*
+ * A SQL Server batch may contain several statements and BEGIN ... END blocks,
+ * but may not start a transaction or change the database.
+ */
+func checkSQLServerQuery(q string) error {
+	fields := strings.Fields(strings.ToLower(stripQuotedSQL(q)))
+	if len(fields) == 0 {
+		return EmptyQueryError
+	}
+
+	for i, field := range fields {
+		field = strings.TrimSuffix(field, ";")
+		if field == "use" && (i == 0 || strings.HasSuffix(fields[i-1], ";")) {
+			return ChangeDatabaseError
+		}
+		if field == "begin" && i+1 < len(fields) &&
+			strings.HasPrefix(fields[i+1], "tran") {
+			return errors.New("cannot use transactions")
+		}
 	}
 	return nil
 }
 
+func splitOnSemicolons(contents string) []string {
+	return strings.Split(contents, ";")
+}
+
+// Like splitOnSemicolons, but ignores semicolons in quotes and comments.
+func splitOnUnquotedSemicolons(contents string) []string {
+	stripped := stripQuotedSQL(contents)
+
+	var queries []string
+	start := 0
+	for i := 0; i < len(stripped); i++ {
+		if stripped[i] == ';' {
+			queries = append(queries, contents[start:i])
+			start = i + 1
+		}
+	}
+	return append(queries, contents[start:])
+}
+
+var goBatchRegexp = regexp.MustCompile(`(?i)^\s*go(\s+([0-9]+))?\s*$`)
+
+/*
+ * Splits T-SQL into the batches separated by GO lines. As in sqlcmd, GO <n>
+ * runs the preceding batch n times.
+ */
+func splitGoBatches(contents string) []string {
+	var batches []string
+	var batch []string
+	for _, line := range strings.Split(contents, "\n") {
+		m := goBatchRegexp.FindStringSubmatch(line)
+		if m == nil {
+			batch = append(batch, line)
+			continue
+		}
+
+		count := 1
+		if m[2] != "" {
+			count, _ = strconv.Atoi(m[2])
+		}
+		for i := 0; i < count; i++ {
+			batches = append(batches, strings.Join(batch, "\n"))
+		}
+		batch = nil
+	}
+	return append(batches, strings.Join(batch, "\n"))
+}
+
 func mySQLDataSourceName(cc *ConnectionConfig) string {
-	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?%s",
+	return fmt.Sprintf("%s:%s@tcp(%s)/%s?%s",
 		firstString(cc.Username, "root"),
 		firstString(cc.Password, ""),
-		firstString(cc.Host, "localhost"),
-		firstInt(cc.Port, 3306),
+		formatHosts(cc.Host, firstInt(cc.Port, 3306)),
 		firstString(cc.Database, ""),
-		firstString(cc.Params, "allowAllFiles=true&interpolateParams=true&allowCleartextPasswords=true&tls=preferred"))
+		mySQLTLSParams(firstString(cc.Params, "allowAllFiles=true&interpolateParams=true&allowCleartextPasswords=true&tls=preferred"), &cc.TLS))
 }
 
 func postgresDataSourceName(cc *ConnectionConfig) string {
-	return fmt.Sprintf("postgres://%s:%s@%s:%d/%s?%s",
+	return fmt.Sprintf("postgres://%s:%s@%s/%s?%s",
+		firstString(cc.Username, "root"),
+		firstString(cc.Password, ""),
+		formatHosts(cc.Host, firstInt(cc.Port, 5432)),
+		firstString(cc.Database, ""),
+		withParam(postgresTLSParams(firstString(cc.Params, "sslmode=disable"), &cc.TLS),
+			"&", "application_name", url.QueryEscape(applicationName(""))))
+}
+
+func cockroachDataSourceName(cc *ConnectionConfig) string {
+	return fmt.Sprintf("postgres://%s:%s@%s/%s?%s",
 		firstString(cc.Username, "root"),
 		firstString(cc.Password, ""),
-		firstString(cc.Host, "localhost"),
-		firstInt(cc.Port, 5432),
+		formatHosts(cc.Host, firstInt(cc.Port, 26257)),
 		firstString(cc.Database, ""),
-		firstString(cc.Params, "sslmode=disable"))
+		withParam(postgresTLSParams(firstString(cc.Params, "sslmode=disable"), &cc.TLS),
+			"&", "application_name", url.QueryEscape(applicationName(""))))
 }
 
 func sqlServerDataSourceName(cc *ConnectionConfig) string {
+	hp := hostPort{firstString(cc.Host, "localhost"), firstInt(cc.Port, 1433)}
+	if hosts, err := parseHosts(cc.Host, hp.port); err == nil {
+		hp = hosts[0]
+	}
 	return fmt.Sprintf("user id=%s;password=%s;server=%s;port=%d;database=%s;%s",
 		firstString(cc.Username, "root"),
 		firstString(cc.Password, ""),
-		firstString(cc.Host, "localhost"),
-		firstInt(cc.Port, 1433),
+		hp.host,
+		hp.port,
 		firstString(cc.Database, ""),
-		firstString(cc.Params, ""))
+		withParam(sqlServerTLSParams(cc.Params, &cc.TLS), ";", "app name", applicationName("")))
 }
 
 func verticaDataSourceName(cc *ConnectionConfig) string {
-	return fmt.Sprintf("vertica://%s:%s@%s:%d/%s?%s",
+	return fmt.Sprintf("vertica://%s:%s@%s/%s?%s",
 		firstString(cc.Username, "root"),
 		firstString(cc.Password, ""),
-		firstString(cc.Host, "localhost"),
-		firstInt(cc.Port, 5433),
+		formatHosts(cc.Host, firstInt(cc.Port, 5433)),
 		firstString(cc.Database, ""),
-		firstString(cc.Params, ""))
+		verticaTLSParams(cc.Params, &cc.TLS))
 }
 
 func mySQLErrorCodeParser(e error) (string, error) {
@@ -272,7 +753,3 @@ func postgresErrorCodeParser(e error) (string, error) {
 	// https://github.com/lib/pq/blob/cb2b4276bb62435f140cb330f14dea6feeccfe71/error.go#L46
 	return string(err.Code), nil
 }
-
-func unimplementedErrorCodeParser(e error) (string, error) {
-	return "", errors.New("Database flavor currently does not support parsing errors")
-}
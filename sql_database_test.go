@@ -17,8 +17,18 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
 	"strconv"
 	"testing"
+	"time"
+
+	"github.com/lib/pq"
 )
 
 func TestSQLCheck(t *testing.T) {
@@ -50,3 +60,408 @@ func TestSQLCheck(t *testing.T) {
 		}
 	}
 }
+
+func TestSQLAction(t *testing.T) {
+	for _, c := range []struct {
+		in, out string
+	}{
+		{"SELECT 1", "select"},
+		{"  /* dbbench run=x job=y seq=1 */ select 1", "select"},
+		{"-- comment\n/* another */\tUPDATE t set a = 1", "update"},
+		{"/* unterminated select 1", ""},
+		{"", ""},
+	} {
+		if out := sqlAction(c.in); out != c.out {
+			t.Errorf("For %s expected %s but got %s", strconv.Quote(c.in), c.out, out)
+		}
+	}
+}
+
+func TestPlaceholders(t *testing.T) {
+	var cases = []struct {
+		flavor string
+		in     string
+		out    int
+	}{
+		{"mysql", "select ?, ?", 2},
+		{"mysql", "select '?', \"it\\\"s?\", ? -- why?\n", 1},
+		{"mysql", "select /* ? */ `a?` from t where a = ?", 1},
+		{"postgres", "select $1, $2, $1", 2},
+		{"postgres", "select '$3', $2", 2},
+		{"mssql", "select @p1, @p2", 2},
+		{"mssql", "select ?", 1},
+	}
+
+	for _, c := range cases {
+		sq := supportedDatabaseFlavors[c.flavor].(*sqlDatabaseFlavor)
+		if n := sq.Placeholders(c.in); n != c.out {
+			t.Errorf("Expected %d placeholders in %s query %s, got %d",
+				c.out, c.flavor, strconv.Quote(c.in), n)
+		}
+	}
+}
+
+func TestSplitQueries(t *testing.T) {
+	var cases = []struct {
+		flavor string
+		in     string
+		out    []string
+	}{
+		{"mysql", "select 1;select 2", []string{"select 1", "select 2"}},
+		{"vertica", "copy t from stdin delimiter ';';select 1",
+			[]string{"copy t from stdin delimiter ';'", "select 1"}},
+		{"mssql", "select 1; select 2\ngo\nselect 3\nGO 2\n",
+			[]string{"select 1; select 2", "select 3", "select 3", ""}},
+	}
+
+	for _, c := range cases {
+		if out := supportedDatabaseFlavors[c.flavor].SplitQueries(c.in); !reflect.DeepEqual(out, c.out) {
+			t.Errorf("Expected %s to split %s into %q, got %q",
+				c.flavor, strconv.Quote(c.in), c.out, out)
+		}
+	}
+}
+
+func TestDialectChecks(t *testing.T) {
+	var cases = []struct {
+		flavor string
+		in     string
+		ok     bool
+	}{
+		{"vertica", "copy t from stdin delimiter ';'", true},
+		{"vertica", "select 1; select 2", false},
+		{"mssql", "if 1=1 begin select 1; select 2 end", true},
+		{"mssql", "begin tran; update t set a = 1", false},
+		{"mssql", "select 1; use db", false},
+	}
+
+	for _, c := range cases {
+		err := supportedDatabaseFlavors[c.flavor].CheckQuery(c.in)
+		if c.ok && err != nil {
+			t.Errorf("Unexpected error checking %s query %s: %v",
+				c.flavor, strconv.Quote(c.in), err)
+		} else if !c.ok && err == nil {
+			t.Errorf("Unexpected success checking %s query %s",
+				c.flavor, strconv.Quote(c.in))
+		}
+	}
+}
+
+/*
+ * A database/sql driver whose queries return two result sets of two rows
+ * each, while its execs affect no rows.
+ */
+type resultSetsDriver struct{}
+
+func (resultSetsDriver) Open(string) (driver.Conn, error) { return resultSetsConn{}, nil }
+
+type resultSetsConn struct{}
+
+func (resultSetsConn) Prepare(string) (driver.Stmt, error) { return resultSetsStmt{}, nil }
+func (resultSetsConn) Close() error                        { return nil }
+func (resultSetsConn) Begin() (driver.Tx, error)           { return nil, errors.New("unsupported") }
+
+type resultSetsStmt struct{}
+
+func (resultSetsStmt) Close() error  { return nil }
+func (resultSetsStmt) NumInput() int { return -1 }
+func (resultSetsStmt) Exec([]driver.Value) (driver.Result, error) {
+	return driver.RowsAffected(0), nil
+}
+func (resultSetsStmt) Query([]driver.Value) (driver.Rows, error) {
+	return &resultSetsRows{sets: []int{2, 0, 3}, rows: 2}, nil
+}
+
+// Each of the sets has the given number of rows; rows are left in the first.
+type resultSetsRows struct {
+	sets []int
+	rows int
+}
+
+func (r *resultSetsRows) Columns() []string { return []string{"a"} }
+func (r *resultSetsRows) Close() error      { return nil }
+func (r *resultSetsRows) Next(dest []driver.Value) error {
+	if r.rows == 0 {
+		return io.EOF
+	}
+	r.rows--
+	dest[0] = int64(r.rows)
+	return nil
+}
+func (r *resultSetsRows) HasNextResultSet() bool { return len(r.sets) > 1 }
+func (r *resultSetsRows) NextResultSet() error {
+	if len(r.sets) <= 1 {
+		return io.EOF
+	}
+	r.sets = r.sets[1:]
+	r.rows = r.sets[0]
+	return nil
+}
+
+func init() {
+	sql.Register("result-sets", resultSetsDriver{})
+}
+
+func TestRunQueryResultSets(t *testing.T) {
+	db, err := sql.Open("result-sets", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &sqlDb{db: db}
+	defer s.Close()
+
+	for _, c := range []struct {
+		query string
+		rows  int64
+	}{
+		{"select a from t", 2},
+		{"insert into t values (1)", 0},
+		{"call p()", 5},
+		{"CALL p()", 5},
+		{"exec p", 5},
+	} {
+		if rows, err := s.RunQuery(context.Background(), nil, c.query, nil); err != nil {
+			t.Errorf("Error running %s: %v", strconv.Quote(c.query), err)
+		} else if rows != c.rows {
+			t.Errorf("For %s\n\texpected %d rows\n\tbut got %d",
+				strconv.Quote(c.query), c.rows, rows)
+		}
+	}
+}
+
+func TestRunQueryResultSetIndex(t *testing.T) {
+	db, err := sql.Open("result-sets", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &sqlDb{db: db}
+	defer s.Close()
+
+	var buf bytes.Buffer
+	w := NewSafeCSVWriterTo(&buf)
+	w.SetResultSetIndex(true)
+	if _, err := s.RunQuery(context.Background(), w, "call p()", nil); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "1,1\n1,0\n3,2\n3,1\n3,0\n"; buf.String() != expected {
+		t.Errorf("For results\n\texpected %q\n\tbut got %q", expected, buf.String())
+	}
+}
+
+/*
+ * A database whose queries fail with a CockroachDB serialization failure
+ * until they have been run failures times.
+ */
+type serializationFailureDb struct {
+	fakeDb
+	failures int
+}
+
+func (db *serializationFailureDb) RunQueryWithOptions(ctx context.Context, w *SafeCSVWriter, q string, args []interface{}, opts QueryOptions) (int64, error) {
+	if db.failures > 0 {
+		db.failures--
+		return 0, &pq.Error{Code: "40001"}
+	}
+	return 1, nil
+}
+
+func (db *serializationFailureDb) RunQuery(ctx context.Context, w *SafeCSVWriter, q string, args []interface{}) (int64, error) {
+	return db.RunQueryWithOptions(ctx, w, q, args, QueryOptions{})
+}
+
+func TestInvokeRetries(t *testing.T) {
+	df := supportedDatabaseFlavors["cockroachdb"]
+	ji := &jobInvocation{name: "test", queries: []queryInvocation{{query: "update t set a = 1"}}}
+	for _, c := range []struct {
+		failures   int
+		maxRetries uint64
+		retries    int
+		errors     uint64
+	}{
+		{0, 3, 0, 0},
+		{2, 3, 2, 0},
+		{3, 3, 3, 0},
+		{4, 3, 3, 1},
+		{1, 0, 0, 1},
+	} {
+		db := &serializationFailureDb{failures: c.failures}
+		jr := ji.Invoke(context.Background(), db, df, &Job{MaxRetries: c.maxRetries}, 0)
+		if jr.Retries != c.retries || jr.Errors.TotalErrors() != c.errors {
+			t.Errorf("For %d failures with max-retries %d\n\texpected %d retries and %d errors\n\tbut got %d and %d",
+				c.failures, c.maxRetries, c.retries, c.errors, jr.Retries, jr.Errors.TotalErrors())
+		}
+	}
+}
+
+func (db *serializationFailureDb) Begin() (Transaction, error) {
+	return &serializationFailureTx{db}, nil
+}
+
+type serializationFailureTx struct {
+	*serializationFailureDb
+}
+
+func (tx *serializationFailureTx) Commit() error {
+	return nil
+}
+
+func (tx *serializationFailureTx) Rollback() error {
+	return nil
+}
+
+func TestInvokeTransactionRetries(t *testing.T) {
+	df := supportedDatabaseFlavors["cockroachdb"]
+	ji := &jobInvocation{name: "test", queries: []queryInvocation{
+		{query: "update t set a = a - 1 where id = 1"},
+		{query: "update t set a = a + 1 where id = 2"},
+	}}
+	for _, c := range []struct {
+		failures     int
+		maxRetries   uint64
+		retries      int
+		errors       uint64
+		rowsAffected int64
+	}{
+		{0, 3, 0, 0, 2},
+		{2, 3, 2, 0, 2},
+		{4, 3, 3, 1, 0},
+		{1, 0, 0, 1, 0},
+	} {
+		db := &serializationFailureDb{failures: c.failures}
+		jr := ji.Invoke(context.Background(), db, df, &Job{MaxRetries: c.maxRetries, Transaction: true}, 0)
+		if jr.Retries != c.retries || jr.Errors.TotalErrors() != c.errors || jr.RowsAffected != c.rowsAffected {
+			t.Errorf("For %d failures with max-retries %d\n\texpected %d retries, %d errors and %d rows\n\tbut got %d, %d and %d",
+				c.failures, c.maxRetries, c.retries, c.errors, c.rowsAffected,
+				jr.Retries, jr.Errors.TotalErrors(), jr.RowsAffected)
+		}
+	}
+}
+
+/*
+ * A database/sql driver that counts the statements prepared on its
+ * connections.
+ */
+type preparesDriver struct {
+	prepares *int
+}
+
+func (d preparesDriver) Open(string) (driver.Conn, error) { return preparesConn{d.prepares}, nil }
+
+type preparesConn struct {
+	prepares *int
+}
+
+func (c preparesConn) Prepare(string) (driver.Stmt, error) {
+	*c.prepares++
+	return resultSetsStmt{}, nil
+}
+func (preparesConn) Close() error              { return nil }
+func (preparesConn) Begin() (driver.Tx, error) { return nil, errors.New("unsupported") }
+
+var preparesCount int
+
+func init() {
+	sql.Register("prepares", preparesDriver{&preparesCount})
+}
+
+func TestRunQueryPrepare(t *testing.T) {
+	db, err := sql.Open("prepares", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	s := &sqlDb{db: db}
+	defer s.Close()
+
+	for _, c := range []struct {
+		prepare  bool
+		prepares int
+	}{
+		{false, 3},
+		{true, 1},
+	} {
+		preparesCount = 0
+		for i := 0; i < 3; i++ {
+			opts := QueryOptions{Prepare: c.prepare}
+			if _, err := s.RunQueryWithOptions(context.Background(), nil, "insert into t values (?)", []interface{}{i}, opts); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if preparesCount != c.prepares {
+			t.Errorf("With prepare=%v\n\texpected %d prepares\n\tbut got %d",
+				c.prepare, c.prepares, preparesCount)
+		}
+	}
+}
+
+/*
+ * A database/sql driver whose queries never finish, until their context is
+ * done.
+ */
+type hangingDriver struct{}
+
+func (hangingDriver) Open(string) (driver.Conn, error) { return hangingConn{}, nil }
+
+type hangingConn struct{}
+
+func (hangingConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("unsupported") }
+func (hangingConn) Close() error                        { return nil }
+func (hangingConn) Begin() (driver.Tx, error)           { return nil, errors.New("unsupported") }
+
+func (hangingConn) QueryContext(ctx context.Context, _ string, _ []driver.NamedValue) (driver.Rows, error) {
+	<-ctx.Done()
+	return nil, errors.New("query cancelled")
+}
+func (hangingConn) ExecContext(ctx context.Context, _ string, _ []driver.NamedValue) (driver.Result, error) {
+	<-ctx.Done()
+	return nil, errors.New("query cancelled")
+}
+
+func init() {
+	sql.Register("hanging", hangingDriver{})
+}
+
+func TestRunQueryTimeout(t *testing.T) {
+	db, err := sql.Open("hanging", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &sqlDb{db: db}
+	defer s.Close()
+
+	for _, q := range []string{"select 1", "insert into t values (1)"} {
+		start := time.Now()
+		_, err := s.RunQueryWithOptions(context.Background(), nil, q, nil, QueryOptions{Timeout: 10 * time.Millisecond})
+		if _, ok := err.(*QueryTimeoutError); !ok {
+			t.Errorf("Expected %s to time out but got %v", q, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Expected %s to be cancelled after 10ms but took %v", q, elapsed)
+		}
+	}
+}
+
+func TestRunQueryCancel(t *testing.T) {
+	db, err := sql.Open("hanging", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &sqlDb{db: db}
+	defer s.Close()
+
+	for _, timeout := range []time.Duration{0, time.Hour} {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		start := time.Now()
+		_, err := s.RunQueryWithOptions(ctx, nil, "select 1", nil, QueryOptions{Timeout: timeout})
+		cancel()
+		if err == nil {
+			t.Errorf("With query-timeout %v, expected the query to be cancelled", timeout)
+		} else if _, ok := err.(*QueryTimeoutError); ok {
+			t.Errorf("With query-timeout %v, expected the cancelled query not to time out", timeout)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("With query-timeout %v, expected the query to be cancelled after 10ms but took %v",
+				timeout, elapsed)
+		}
+	}
+}
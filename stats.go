@@ -19,14 +19,109 @@ package main
 import (
 	"flag"
 	"fmt"
+	"log"
 	"math"
 	"math/bits"
-	"math/rand"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
-var maxSampleCount = flag.Int64("max-sample-count", 10000, "Samples to keep when streaming.")
+type PercentilesFlagValue []float64
+
+func (pfv *PercentilesFlagValue) Set(v string) error {
+	var ps []float64
+	if v != "" {
+		for _, s := range strings.Split(v, ",") {
+			p, err := strconv.ParseFloat(strings.TrimPrefix(strings.TrimSpace(s), "p"), 64)
+			if err != nil {
+				return fmt.Errorf("invalid percentile %s", strconv.Quote(s))
+			} else if p <= 0 || p > 100 {
+				return fmt.Errorf("percentile %s must be between 0 and 100", strconv.Quote(s))
+			}
+			ps = append(ps, p)
+		}
+	}
+	*pfv = ps
+	return nil
+}
+
+func (pfv *PercentilesFlagValue) String() string {
+	ps := make([]string, 0, len(*pfv))
+	for _, p := range *pfv {
+		ps = append(ps, strconv.FormatFloat(p, 'g', -1, 64))
+	}
+	return strings.Join(ps, ",")
+}
+
+var latencyPercentiles = PercentilesFlagValue{50, 90, 95, 99, 99.9}
+
+func init() {
+	flag.Var(&latencyPercentiles, "latency-percentiles",
+		"Comma separated latency percentiles to show in the stats of each job "+
+			"(e.g. 50,99,99.9), or empty to show none.")
+}
+
+// Durations in increasing order.
+type DurationsFlagValue []time.Duration
+
+func (dfv *DurationsFlagValue) Set(v string) error {
+	var ds []time.Duration
+	if v != "" {
+		for _, s := range strings.Split(v, ",") {
+			d, err := time.ParseDuration(strings.TrimSpace(s))
+			if err != nil {
+				return fmt.Errorf("invalid duration %s", strconv.Quote(s))
+			} else if d <= 0 || (len(ds) > 0 && d <= ds[len(ds)-1]) {
+				return fmt.Errorf("duration %s must be positive and greater than the one before",
+					strconv.Quote(s))
+			}
+			ds = append(ds, d)
+		}
+	}
+	*dfv = ds
+	return nil
+}
+
+func (dfv *DurationsFlagValue) String() string {
+	ds := make([]string, 0, len(*dfv))
+	for _, d := range *dfv {
+		ds = append(ds, d.String())
+	}
+	return strings.Join(ds, ",")
+}
+
+var latencySLA DurationsFlagValue
+
+func init() {
+	flag.Var(&latencySLA, "latency-sla",
+		"Comma separated latency thresholds (e.g. 1ms,10ms,100ms) for which "+
+			"to show the percentage of the transactions of each job that "+
+			"finished within each, and over the last, in its stats.")
+}
+
+/*
+ * The percentage of count transactions under each latency-sla threshold,
+ * from the number under each, followed by the percentage over the last.
+ */
+func slaPercentages(under []uint64, count int) []float64 {
+	percentages := make([]float64, len(under)+1)
+	for i, n := range under {
+		percentages[i] = 100 * float64(n) / float64(count)
+	}
+	percentages[len(under)] = 100 - percentages[len(under)-1]
+	return percentages
+}
+
+// The label of each of the slaPercentages, e.g. <1ms, <10ms and >=10ms.
+func slaLabels() []string {
+	labels := make([]string, 0, len(latencySLA)+1)
+	for _, d := range latencySLA {
+		labels = append(labels, "<"+d.String())
+	}
+	return append(labels, ">="+latencySLA[len(latencySLA)-1].String())
+}
 
 type StreamingHistogram struct {
 	Buckets [64]uint64
@@ -36,6 +131,11 @@ func (sh *StreamingHistogram) Add(x uint64) {
 	sh.Buckets[bits.Len64(x)] += 1
 }
 
+var histogramBuckets = flag.Int("histogram-buckets", 0,
+	"Buckets of the histogram of transaction latencies in the final stats, "+
+		"spaced evenly on a log scale between the fastest and slowest "+
+		"transaction, or 0 for a bucket per power of 2 nanoseconds.")
+
 func histogramBar(str *strings.Builder, count, maxCount uint64) {
 	width := int(50 * 8 * float64(count) / float64(maxCount))
 
@@ -47,96 +147,297 @@ func histogramBar(str *strings.Builder, count, maxCount uint64) {
 	str.WriteString([]string{"", "▏", "▎", "▍", "▌", "▋", "▊", "▉"}[width%8])
 }
 
-func (sh *StreamingHistogram) Histogram() string {
-	var str strings.Builder
-	buckets := sh.Buckets[:]
+/*
+ * Rounds a duration to the given number of significant digits so that it is
+ * readable, e.g. 1.048576ms to 1.05ms with 3 digits.
+ */
+func roundDuration(d time.Duration, digits int) time.Duration {
+	if d <= 0 {
+		return d
+	}
+	magnitude := int(math.Floor(math.Log10(float64(d)))) + 1 - digits
+	if magnitude <= 0 {
+		return d
+	}
+	return d.Round(time.Duration(math.Pow10(magnitude)))
+}
 
-	var begin = -1
-	var end = -1
-	for i, b := range buckets {
-		if b > 0 {
+type histogramRow struct {
+	bottom time.Duration
+	top    time.Duration
+	count  uint64
+}
+
+/*
+ * Renders the rows of a histogram with the count and percentage of values in
+ * each, skipping the empty rows before the first and after the last value.
+ */
+func renderHistogram(rows []histogramRow) string {
+	var begin, end = -1, -1
+	var total, maxCount uint64
+	for i, row := range rows {
+		if row.count > 0 {
 			end = i
 			if begin < 0 {
 				begin = i
 			}
 		}
+		total += row.count
+		if row.count > maxCount {
+			maxCount = row.count
+		}
+	}
+	if begin < 0 {
+		return ""
 	}
-	maxCount := maxUint64(buckets)
 
-	for bi, count := range buckets {
-		if bi < begin || bi > end {
-			continue
+	var str strings.Builder
+	for _, row := range rows[begin : end+1] {
+		str.WriteString(fmt.Sprintf("%10v - %10v [%6d] %5.1f%%: ",
+			roundDuration(row.bottom, 3), roundDuration(row.top, 3), row.count,
+			100*float64(row.count)/float64(total)))
+		histogramBar(&str, row.count, maxCount)
+		str.WriteString("\n")
+	}
+	return str.String()
+}
+
+/*
+ * Renders the histogram of transaction latencies of a job, with a bucket per
+ * power of 2 unless histogram-buckets is set.
+ */
+func transactionHistogram(sh *StreamingHistogram, lh *LatencyHistogram) string {
+	if *histogramBuckets > 0 {
+		return lh.Histogram(*histogramBuckets)
+	}
+	return sh.Histogram()
+}
+
+func (sh *StreamingHistogram) Histogram() string {
+	rows := make([]histogramRow, len(sh.Buckets))
+	for bi, count := range sh.Buckets {
+		if bi > 0 {
+			rows[bi].bottom = time.Duration(1) << uint64(bi-1)
 		}
+		rows[bi].top = time.Duration(1) << uint64(bi)
+		rows[bi].count = count
+	}
+	return renderHistogram(rows)
+}
+
+var latencyPrecision = flag.Int("latency-precision", 3,
+	"Significant decimal digits (1 to 5) to which latency percentiles are exact, "+
+		"however many transactions are recorded.")
 
-		var bucketBottom, bucketTop uint64
-		if bi == 0 {
-			bucketBottom = 0
-		} else {
-			bucketBottom = 1 << uint64(bi-1)
+func init() {
+	// Only kept so that scripts that still pass it keep working.
+	flag.Int64("max-sample-count", 0,
+		"Deprecated and ignored: latencies are no longer sampled, see -latency-precision.")
+}
+
+// Warns about the deprecated flags that were set.
+func warnDeprecatedFlags() {
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "max-sample-count" {
+			log.Printf("warning: -max-sample-count is deprecated and ignored, since latencies " +
+				"are no longer sampled; see -latency-precision")
 		}
-		bucketTop = 1 << uint64(bi)
+	})
+}
 
-		str.WriteString(fmt.Sprintf(
-			"%12v - %12v [%6d]: ",
-			time.Duration(bucketBottom), time.Duration(bucketTop), count))
-		histogramBar(&str, count, maxCount)
-		str.WriteString("\n")
+/*
+ * A histogram of latencies in the style of an HDR histogram: each power of 2
+ * range of values is split into linear sub-buckets, enough that every value
+ * is recorded to latency-precision significant digits. Unlike a sample, no
+ * value is discarded, so the tail percentiles of a long run are exact to that
+ * precision. Only the buckets with values are stored.
+ */
+type LatencyHistogram struct {
+	counts        map[int]uint64
+	count         int
+	min           time.Duration
+	max           time.Duration
+	subBucketBits int
+}
+
+func (lh *LatencyHistogram) Add(d time.Duration) {
+	if lh.counts == nil {
+		lh.counts = make(map[int]uint64)
+		// Values below 2 * 10^precision are counted exactly.
+		largestExact := 2 * math.Pow10(*latencyPrecision)
+		lh.subBucketBits = int(math.Ceil(math.Log2(largestExact)))
 	}
-	return str.String()
+	if d < 0 {
+		d = 0
+	}
+
+	lh.counts[lh.bucket(d)]++
+	if lh.count == 0 || d < lh.min {
+		lh.min = d
+	}
+	if d > lh.max {
+		lh.max = d
+	}
+	lh.count++
 }
 
-type StreamingSample struct {
-	count   int
-	samples []float64
+// Adds the latencies counted by another histogram.
+func (lh *LatencyHistogram) Merge(other *LatencyHistogram) {
+	if other.count == 0 {
+		return
+	}
+	if lh.counts == nil {
+		lh.counts = make(map[int]uint64)
+		lh.subBucketBits = other.subBucketBits
+	}
+	for bucket, count := range other.counts {
+		lh.counts[bucket] += count
+	}
+	if lh.count == 0 || other.min < lh.min {
+		lh.min = other.min
+	}
+	if other.max > lh.max {
+		lh.max = other.max
+	}
+	lh.count += other.count
 }
 
-func (ss *StreamingSample) Add(x float64) {
-	if ss.count == 0 {
-		ss.samples = make([]float64, 0, *maxSampleCount)
+func (lh *LatencyHistogram) bucket(d time.Duration) int {
+	v := uint64(d)
+	if v < 1<<lh.subBucketBits {
+		return int(v)
 	}
+	shift := bits.Len64(v) - lh.subBucketBits
+	return shift<<(lh.subBucketBits-1) + int(v>>shift)
+}
 
-	if ss.count < cap(ss.samples) {
-		ss.samples = append(ss.samples, x)
-	} else {
-		index := int(rand.Int31n(int32(ss.count + 1)))
-		if index < cap(ss.samples) {
-			ss.samples[index] = x
-		}
+// The highest value counted in the bucket.
+func (lh *LatencyHistogram) bucketValue(bucket int) time.Duration {
+	if bucket < 1<<lh.subBucketBits {
+		return time.Duration(bucket)
 	}
-	ss.count += 1
+	shift := bucket>>(lh.subBucketBits-1) - 1
+	subBucket := bucket - shift<<(lh.subBucketBits-1)
+	return time.Duration((uint64(subBucket)+1)<<shift - 1)
 }
 
-func (ss *StreamingSample) Count() int {
-	return ss.count
+func (lh *LatencyHistogram) Count() int {
+	return lh.count
 }
 
-func (ss *StreamingSample) Samples() []float64 {
-	return ss.samples
+func (lh *LatencyHistogram) Min() time.Duration {
+	return lh.min
 }
 
-func (ss *StreamingSample) Histogram(nBucketsMax int) (buckets []int, minV float64, maxV float64, extra int) {
-	if ss.count == 0 {
-		panic("Cannot compute histogram of empty sample.")
+func (lh *LatencyHistogram) Max() time.Duration {
+	return lh.max
+}
+
+/*
+ * Returns the values at the given percentiles (between 0 and 100) of the
+ * histogram, using the nearest rank method.
+ */
+func (lh *LatencyHistogram) Percentiles(ps ...float64) []time.Duration {
+	values, _ := lh.percentileRanks(ps)
+	return values
+}
+
+func (lh *LatencyHistogram) percentileRanks(ps []float64) (values []time.Duration, ranks []int) {
+	values = make([]time.Duration, len(ps))
+	ranks = make([]int, len(ps))
+	if lh.count == 0 {
+		return values, ranks
 	}
-	if nBucketsMax <= 0 {
-		panic("Cannot compute histogram with <=0 buckets.")
+
+	buckets := make([]int, 0, len(lh.counts))
+	for bucket := range lh.counts {
+		buckets = append(buckets, bucket)
 	}
+	sort.Ints(buckets)
+
+	for i, p := range ps {
+		rank := int(math.Ceil(p / 100 * float64(lh.count)))
+		if rank < 1 {
+			rank = 1
+		} else if rank > lh.count {
+			rank = lh.count
+		}
 
-	minV = minFloat64(ss.samples)
-	maxV = maxFloat64(ss.samples)
-	diff := maxV - minV
+		var seen uint64
+		for _, bucket := range buckets {
+			seen += lh.counts[bucket]
+			if seen >= uint64(rank) {
+				values[i] = lh.bucketValue(bucket)
+				break
+			}
+		}
+		if values[i] > lh.max {
+			values[i] = lh.max
+		} else if values[i] < lh.min {
+			values[i] = lh.min
+		}
+		ranks[i] = rank
+	}
+	return values, ranks
+}
+
+/*
+ * Formats the given percentiles of the histogram, e.g. "p50 1ms p99 3ms".
+ */
+func (lh *LatencyHistogram) PercentilesString(ps []float64) string {
+	strs := make([]string, 0, len(ps))
+	for i, v := range lh.Percentiles(ps...) {
+		strs = append(strs, fmt.Sprintf("p%g %v", ps[i], v))
+	}
+	return strings.Join(strs, " ")
+}
+
+/*
+ * Renders the histogram with the given number of buckets, spaced evenly on a
+ * log scale between the lowest and highest values.
+ */
+func (lh *LatencyHistogram) Histogram(nBuckets int) string {
+	if lh.count == 0 {
+		return ""
+	}
 
-	if diff > 0.0 {
-		buckets = make([]int, nBucketsMax)
-		for _, v := range ss.samples {
-			buckets[int((v-minV)/diff*float64(nBucketsMax-1))]++
+	low := math.Max(float64(lh.min), 1)
+	ratio := math.Pow((float64(lh.max)+1)/low, 1/float64(nBuckets))
+	rows := make([]histogramRow, nBuckets)
+	for i := range rows {
+		rows[i].bottom = time.Duration(low * math.Pow(ratio, float64(i)))
+		rows[i].top = time.Duration(low * math.Pow(ratio, float64(i+1)))
+	}
+	rows[0].bottom = lh.min
+
+	for bucket, count := range lh.counts {
+		v := lh.bucketValue(bucket)
+		if v > lh.max {
+			v = lh.max
 		}
-	} else {
-		buckets = make([]int, 1)
-		buckets[0] = len(ss.samples)
+		row := sort.Search(nBuckets, func(i int) bool { return v < rows[i].top })
+		if row == nBuckets {
+			row = nBuckets - 1
+		}
+		rows[row].count += count
 	}
+	return renderHistogram(rows)
+}
 
-	return buckets, minV, maxV, ss.count - len(ss.samples)
+var distributionPercentiles = []float64{0, 50, 75, 90, 95, 99, 99.9, 99.99, 99.999, 100}
+
+/*
+ * The distribution of the histogram, as the latency at each of a ladder of
+ * percentiles along with the number of values up to that latency.
+ */
+func (lh *LatencyHistogram) Distribution() string {
+	var str strings.Builder
+	values, ranks := lh.percentileRanks(distributionPercentiles)
+	for i, p := range distributionPercentiles {
+		str.WriteString(fmt.Sprintf("%12s: %12v [%6d]\n",
+			"p"+strconv.FormatFloat(p, 'g', -1, 64), values[i], ranks[i]))
+	}
+	return str.String()
 }
 
 /*
@@ -198,6 +499,91 @@ func (ss *StreamingStats) SampleVariance() float64 {
 	}
 }
 
+/*
+ * Tracks how smoothly a job completes transactions from one stats interval to
+ * the next. Idle intervals are only counted once the job completes another
+ * transaction, so the time before a job starts and after it stops is never
+ * reported as a stall.
+ */
+type IntervalThroughput struct {
+	Intervals    StreamingStats
+	LongestStall time.Duration
+
+	// The interval with the fewest transactions, identified by its end.
+	Lowest    int
+	LowestEnd time.Time
+
+	idleIntervals int
+}
+
+/*
+ * Adds the number of transactions that completed in the interval that ended
+ * at end. Idle intervals are only counted once transactions complete again,
+ * so that idle intervals before a job starts or after it stops are ignored.
+ */
+func (it *IntervalThroughput) Add(transactions int, interval time.Duration, end time.Time) {
+	if transactions == 0 {
+		if it.Intervals.Count() > 0 {
+			it.idleIntervals++
+		}
+		return
+	}
+
+	if stall := time.Duration(it.idleIntervals) * interval; stall > it.LongestStall {
+		it.LongestStall = stall
+	}
+	if it.idleIntervals > 0 && (it.Lowest > 0 || it.LowestEnd.IsZero()) {
+		// The first of the idle intervals.
+		it.Lowest, it.LowestEnd = 0, end.Add(-time.Duration(it.idleIntervals)*interval)
+	}
+	for ; it.idleIntervals > 0; it.idleIntervals-- {
+		it.Intervals.Add(0)
+	}
+	if it.LowestEnd.IsZero() || transactions < it.Lowest {
+		it.Lowest, it.LowestEnd = transactions, end
+	}
+	it.Intervals.Add(float64(transactions))
+}
+
+// The ratio of the standard deviation to the mean of per-interval throughput.
+func (it *IntervalThroughput) CoefficientOfVariation() float64 {
+	if it.Intervals.Mean() == 0 {
+		return 0
+	}
+	return it.Intervals.SampleStdDev() / it.Intervals.Mean()
+}
+
+func (it *IntervalThroughput) String() string {
+	return fmt.Sprintf("throughput CV %.3f%% over %d intervals, longest stall %v, "+
+		"worst interval %d transactions ending %s",
+		100*it.CoefficientOfVariation(), it.Intervals.Count(), it.LongestStall,
+		it.Lowest, it.LowestEnd.Format("15:04:05"))
+}
+
+/*
+ * The mean latency of each interval, to find the intervals where a job
+ * slowed down, which can be hidden by the latency of the whole run.
+ */
+type IntervalLatency struct {
+	Means LatencyHistogram
+
+	// The interval with the highest mean latency, identified by its end.
+	Worst    time.Duration
+	WorstEnd time.Time
+}
+
+func (il *IntervalLatency) Add(mean time.Duration, end time.Time) {
+	il.Means.Add(mean)
+	if mean > il.Worst {
+		il.Worst, il.WorstEnd = mean, end
+	}
+}
+
+func (il *IntervalLatency) String() string {
+	return fmt.Sprintf("p99 interval latency %v, worst interval latency %v ending %s",
+		il.Means.Percentiles(99)[0], il.Worst, il.WorstEnd.Format("15:04:05"))
+}
+
 /*
  * Modified from the author's original bc code by Alex Reece
  * (awreece@gmail.com) on Jul 2, 2015. For information about
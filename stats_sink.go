@@ -0,0 +1,215 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+/*
+ * The stats of every job over one intermediate stats window, along with the
+ * events in progress at any time during the window.
+ */
+type intervalStats struct {
+	Window     statsWindow
+	Time       time.Time // When the window was processed.
+	InProgress []string
+	Jobs       map[string]*jobStats
+}
+
+/*
+ * A destination for the stats of a run. processResults hands every job result
+ * to Result and the stats of every intermediate stats window to Interval, and
+ * calls Close once the results queue is closed. The methods are only ever
+ * called from the goroutine running processResults.
+ *
+ * This is not a library interface: dbbench is a command, so a sink is added
+ * by a file of its own in this package (see registerStatsSink), built into a
+ * custom dbbench binary.
+ */
+type statsSink interface {
+	Result(jr *JobResult)
+	Interval(is *intervalStats)
+	Close()
+}
+
+var statsSinkFactories []func(config *Config) statsSink
+
+/*
+ * Adds a sink, created for the config of every run, in addition to the sinks
+ * selected by the command line flags. Called from the init function of the
+ * file that implements the sink.
+ */
+func registerStatsSink(newSink func(config *Config) statsSink) {
+	statsSinkFactories = append(statsSinkFactories, newSink)
+}
+
+/*
+ * Returns the sinks for the stats of a run of the config: the stats files,
+ * the results database, the intermediate stats on the console (or as JSON),
+ * and any registered sinks.
+ */
+func newStatsSinks(config *Config) ([]statsSink, error) {
+	var sinks []statsSink
+
+	if f := queryStatsFile.GetFile(); f != nil {
+		schema, err := currentQueryStatsSchema()
+		if err != nil {
+			return nil, err
+		}
+		w := csv.NewWriter(f)
+		writeHeaderOnce(f, schema, w)
+		w.Flush()
+		var start int64
+		if *reconstructIntervals {
+			// Where the records of the run start, to read them back from.
+			if start, err = f.Seek(0, io.SeekCurrent); err != nil {
+				return nil, fmt.Errorf("-reconstruct-intervals: %v", err)
+			}
+		}
+		sinks = append(sinks, &queryStatsSink{w, schema, f, start})
+	}
+	if f := intervalStatsFile.GetFile(); f != nil {
+		schema, err := currentIntervalStatsSchema()
+		if err != nil {
+			return nil, err
+		}
+		w := csv.NewWriter(f)
+		writeHeaderOnce(f, schema, w)
+		sinks = append(sinks, &intervalStatsSink{w, schema})
+	}
+	if resultsDb != nil {
+		sinks = append(sinks, &resultsDbSink{resultsDb})
+	}
+	shown, err := intermediateStatsShown(config)
+	if err != nil {
+		return nil, err
+	}
+	if *intermediateUpdates {
+		if jsonOutput() {
+			sinks = append(sinks, &jsonSink{shown})
+		} else {
+			sinks = append(sinks, &consoleSink{shown})
+		}
+	}
+
+	for _, newSink := range statsSinkFactories {
+		sinks = append(sinks, newSink(config))
+	}
+	return sinks, nil
+}
+
+// Writes every result to the query-stats-file.
+type queryStatsSink struct {
+	w      *csv.Writer
+	schema *csvSchema
+	// The file, and where the records of the run start in it, to
+	// reconstruct the intervals of the run from.
+	f     *os.File
+	start int64
+}
+
+func (s *queryStatsSink) Result(jr *JobResult) {
+	if !jr.Dropped {
+		s.w.Write(s.schema.queryStatsRecord(jr))
+	}
+}
+
+func (s *queryStatsSink) Interval(is *intervalStats) {}
+
+func (s *queryStatsSink) Close() {
+	s.w.Flush()
+}
+
+// Writes the stats of every window to the interval-stats-file.
+type intervalStatsSink struct {
+	w      *csv.Writer
+	schema *csvSchema
+}
+
+func (s *intervalStatsSink) Result(jr *JobResult) {}
+
+func (s *intervalStatsSink) Interval(is *intervalStats) {
+	for name, stats := range is.Jobs {
+		s.w.Write(s.schema.intervalStatsRecord(name, is.Window, stats, is.InProgress))
+	}
+}
+
+func (s *intervalStatsSink) Close() {
+	s.w.Flush()
+}
+
+// Records the stats of every window in the results database.
+type resultsDbSink struct {
+	rdb *resultsDatabase
+}
+
+func (s *resultsDbSink) Result(jr *JobResult) {}
+
+func (s *resultsDbSink) Interval(is *intervalStats) {
+	for name, stats := range is.Jobs {
+		s.rdb.RecordInterval(name, is.Window, stats)
+	}
+}
+
+func (s *resultsDbSink) Close() {}
+
+// Logs the intermediate stats of the shown jobs.
+type consoleSink struct {
+	shown map[string]bool
+}
+
+func (s *consoleSink) Result(jr *JobResult) {}
+
+func (s *consoleSink) Interval(is *intervalStats) {
+	for name, stats := range is.Jobs {
+		if !s.shown[name] {
+			continue
+		} else if len(is.InProgress) > 0 {
+			log.Printf("%s (during %s): %v", name,
+				strings.Join(is.InProgress, ", "), stats)
+		} else {
+			log.Printf("%s: %v", name, stats)
+		}
+	}
+}
+
+func (s *consoleSink) Close() {}
+
+// Writes the intermediate stats of the shown jobs as JSON records.
+type jsonSink struct {
+	shown map[string]bool
+}
+
+func (s *jsonSink) Result(jr *JobResult) {}
+
+func (s *jsonSink) Interval(is *intervalStats) {
+	for name, stats := range is.Jobs {
+		if s.shown[name] {
+			writeJSONRecord(&intervalJSON{"interval", is.Time, is.Window.Start,
+				is.Window.End, name, is.InProgress, stats.JSON()}, false)
+		}
+	}
+}
+
+func (s *jsonSink) Close() {}
@@ -0,0 +1,107 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+type recordingSink struct {
+	results   []*JobResult
+	intervals []*intervalStats
+	closed    bool
+	// Closed once an interval has a transaction of the test job.
+	counted chan struct{}
+}
+
+func (s *recordingSink) Result(jr *JobResult) { s.results = append(s.results, jr) }
+
+func (s *recordingSink) Interval(is *intervalStats) {
+	s.intervals = append(s.intervals, is)
+	if js, ok := is.Jobs["test"]; ok && js.Transactions.Count() > 0 {
+		close(s.counted)
+	}
+}
+
+func (s *recordingSink) Close() { s.closed = true }
+
+func TestQueryStatsSinkPipe(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer func(old WriteFileFlagValue) { queryStatsFile = old }(queryStatsFile)
+	queryStatsFile = WriteFileFlagValue{f: w, path: "|"}
+	defer w.Close()
+	go io.Copy(ioutil.Discard, r)
+
+	// A pipe cannot seek, which only -reconstruct-intervals needs.
+	sinks, err := newStatsSinks(&Config{})
+	if err != nil {
+		t.Fatalf("Unexpected error for a pipe: %v", err)
+	}
+	if _, ok := sinks[0].(*queryStatsSink); !ok {
+		t.Errorf("Expected the query-stats-file sink but got %v", sinks)
+	}
+}
+
+func TestRegisteredStatsSink(t *testing.T) {
+	defer func(interval time.Duration, updates bool) {
+		*updateInterval, *intermediateUpdates = interval, updates
+		statsSinkFactories = nil
+	}(*updateInterval, *intermediateUpdates)
+	*updateInterval, *intermediateUpdates = time.Millisecond, false
+
+	sink := &recordingSink{counted: make(chan struct{})}
+	registerStatsSink(func(config *Config) statsSink { return sink })
+
+	config := &Config{Jobs: map[string]*Job{"test": {Name: "test"}}}
+	results := NewResultQueue(1)
+	done := make(chan map[string]*JobStats)
+	go func() { done <- processResults(config, results, nil) }()
+
+	results.Send(&JobResult{Name: "test", Start: time.Millisecond,
+		Elapsed: time.Millisecond, Queries: 1})
+	// The result is only counted in an interval once its window ends.
+	<-sink.counted
+	results.Close()
+	stats := <-done
+
+	if len(sink.results) != 1 || sink.results[0].Name != "test" {
+		t.Errorf("Expected the sink to receive the result, got %v", sink.results)
+	}
+	var transactions int
+	for _, is := range sink.intervals {
+		if js, ok := is.Jobs["test"]; ok {
+			transactions += js.Transactions.Count()
+		}
+	}
+	if transactions != 1 {
+		t.Errorf("Expected 1 transaction over the intervals, got %d", transactions)
+	}
+	if !sink.closed {
+		t.Errorf("Expected the sink to be closed")
+	}
+	if stats["test"].Queries != 1 {
+		t.Errorf("Expected 1 query in the final stats, got %d", stats["test"].Queries)
+	}
+}
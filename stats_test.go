@@ -18,8 +18,11 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func assertNear(t *testing.T, expected float64, actual float64, msg string) {
@@ -72,48 +75,55 @@ func TestStreamingHistogram(t *testing.T) {
 	}
 }
 
-func TestStreamingSample(t *testing.T) {
-	type testcase struct {
-		vals        []float64
-		bucketCount int
+func TestLatencyHistogram(t *testing.T) {
+	var lh LatencyHistogram
+	for i := 100; i > 0; i-- {
+		lh.Add(time.Duration(i))
+	}
 
-		min     float64
-		max     float64
-		buckets []int
+	if lh.Count() != 100 || lh.Min() != 1 || lh.Max() != 100 {
+		t.Errorf("Expected 100 values between 1 and 100 but got %d between %v and %v",
+			lh.Count(), lh.Min(), lh.Max())
 	}
 
-	for _, testCase := range []testcase{
-		{[]float64{1}, 1, 1, 1, []int{1}},
-		{[]float64{1}, 3, 1, 1, []int{1}},
-		{[]float64{1, 2}, 1, 1, 2, []int{2}},
-		{[]float64{1, 2, 2, 3}, 3, 1, 3, []int{1, 2, 1}},
-	} {
-		var ss StreamingSample
-		for _, v := range testCase.vals {
-			ss.Add(v)
-		}
-		t.Logf("Testing %f", testCase.vals)
+	expected := []time.Duration{1, 50, 95, 99, 100}
+	if actual := lh.Percentiles(0, 50, 95, 99, 100); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("For percentiles\n\texpected %v\n\tbut got %v", expected, actual)
+	}
+}
 
-		if ss.Count() != len(testCase.vals) {
-			t.Error("For count expected", len(testCase.vals),
-				"but got got", ss.Count())
-		}
+func TestLatencyHistogramPrecision(t *testing.T) {
+	var lh LatencyHistogram
+	for i := 1; i <= 100000; i++ {
+		lh.Add(time.Duration(i) * time.Microsecond)
+	}
 
-		buckets, min, max, _ := ss.Histogram(testCase.bucketCount)
-		if !reflect.DeepEqual(testCase.buckets, buckets) {
-			t.Errorf("For buckets\n\texpected %d\n\tbut got %d",
-				testCase.buckets, buckets)
+	for i, actual := range lh.Percentiles(50, 99, 99.9, 99.999) {
+		expected := []time.Duration{50 * time.Millisecond, 99 * time.Millisecond,
+			99900 * time.Microsecond, 99999 * time.Microsecond}[i]
+		if math.Abs(float64(actual-expected))/float64(expected) > 0.001 {
+			t.Errorf("Expected %v within 0.1%% but got %v", expected, actual)
 		}
+	}
 
-		if min != testCase.min {
-			t.Errorf("For min\n\texpected %f\n\tbut got %f",
-				testCase.min, min)
-		}
+	// Every value is counted, so the highest percentiles are the tail.
+	if actual := lh.Percentiles(100)[0]; actual != 100*time.Millisecond {
+		t.Errorf("Expected the max but got %v", actual)
+	}
+}
 
-		if max != testCase.max {
-			t.Errorf("For max\n\texpected %f\n\tbut got %f",
-				testCase.max, max)
+func TestLatencyHistogramBuckets(t *testing.T) {
+	lh := LatencyHistogram{subBucketBits: 11}
+	previous := -1
+	for v := time.Duration(0); v < 1<<20; v += 7 {
+		bucket := lh.bucket(v)
+		if bucket < previous {
+			t.Fatalf("Bucket of %d is %d, below the bucket %d of a smaller value", v, bucket, previous)
+		}
+		if top := lh.bucketValue(bucket); top < v || lh.bucket(top) != bucket || lh.bucket(top+1) == bucket {
+			t.Fatalf("Highest value %d of bucket %d does not bound %d", top, bucket, v)
 		}
+		previous = bucket
 	}
 }
 
@@ -145,3 +155,200 @@ func TestStreamingStats(t *testing.T) {
 			fmt.Sprint("For stddev of", testCase.vals))
 	}
 }
+
+func TestIntervalThroughput(t *testing.T) {
+	type testcase struct {
+		intervals []int
+		count     int
+		mean      float64
+		stall     time.Duration
+		lowest    int
+		lowestEnd int64
+	}
+
+	for _, testCase := range []testcase{
+		{[]int{0, 0, 10, 10}, 2, 10, 0, 10, 2},
+		{[]int{10, 0, 0, 10}, 4, 5, 2 * time.Second, 0, 1},
+		{[]int{10, 0, 10, 0, 0}, 3, 6.667, time.Second, 0, 1},
+		{[]int{10, 4, 10}, 3, 8, 0, 4, 1},
+	} {
+		var it IntervalThroughput
+		for i, v := range testCase.intervals {
+			it.Add(v, time.Second, time.Unix(int64(i), 0))
+		}
+
+		if it.Intervals.Count() != testCase.count {
+			t.Error("For interval count of", testCase.intervals,
+				"expected", testCase.count,
+				"got", it.Intervals.Count())
+		}
+		assertNear(t, testCase.mean, it.Intervals.Mean(),
+			fmt.Sprint("For mean of", testCase.intervals))
+		if it.LongestStall != testCase.stall {
+			t.Error("For longest stall of", testCase.intervals,
+				"expected", testCase.stall,
+				"got", it.LongestStall)
+		}
+		if it.Lowest != testCase.lowest || it.LowestEnd.Unix() != testCase.lowestEnd {
+			t.Error("For lowest interval of", testCase.intervals,
+				"expected", testCase.lowest, "ending", testCase.lowestEnd,
+				"got", it.Lowest, "ending", it.LowestEnd.Unix())
+		}
+	}
+}
+
+func TestIntervalLatency(t *testing.T) {
+	var il IntervalLatency
+	for i, v := range []time.Duration{time.Millisecond, 5 * time.Millisecond, 2 * time.Millisecond} {
+		il.Add(v, time.Unix(int64(i), 0))
+	}
+	if il.Worst != 5*time.Millisecond || il.WorstEnd.Unix() != 1 {
+		t.Errorf("Expected worst interval 5ms ending at 1, got %v ending at %d",
+			il.Worst, il.WorstEnd.Unix())
+	}
+}
+
+func TestPercentilesFlagValue(t *testing.T) {
+	var pfv PercentilesFlagValue
+	if err := pfv.Set("p50, 99,99.9"); err != nil {
+		t.Fatal(err)
+	}
+	if expected := (PercentilesFlagValue{50, 99, 99.9}); !reflect.DeepEqual(expected, pfv) {
+		t.Errorf("For percentiles\n\texpected %v\n\tbut got %v", expected, pfv)
+	}
+	if pfv.String() != "50,99,99.9" {
+		t.Errorf("Unexpected string %q", pfv.String())
+	}
+	if err := pfv.Set(""); err != nil || len(pfv) != 0 {
+		t.Errorf("Expected no percentiles but got %v (%v)", pfv, err)
+	}
+	for _, v := range []string{"0", "101", "p", "50,,99"} {
+		if err := pfv.Set(v); err == nil {
+			t.Errorf("Expected an error for %q", v)
+		}
+	}
+}
+
+func TestLatencySLA(t *testing.T) {
+	var dfv DurationsFlagValue
+	if err := dfv.Set("1ms, 10ms,100ms"); err != nil {
+		t.Fatal(err)
+	}
+	if dfv.String() != "1ms,10ms,100ms" {
+		t.Errorf("Unexpected string %q", dfv.String())
+	}
+	for _, v := range []string{"0s", "10ms,1ms", "fast", "1ms,,10ms"} {
+		if err := (&DurationsFlagValue{}).Set(v); err == nil {
+			t.Errorf("Expected an error for %q", v)
+		}
+	}
+
+	defer func(sla DurationsFlagValue) { latencySLA = sla }(latencySLA)
+	latencySLA = dfv
+	var js jobStats
+	for _, elapsed := range []time.Duration{
+		500 * time.Microsecond, 5 * time.Millisecond, 5 * time.Millisecond, time.Second,
+	} {
+		js.Update(&Config{}, &JobResult{Elapsed: elapsed, Queries: 1})
+	}
+	if expected := []float64{25, 75, 75, 25}; !reflect.DeepEqual(expected, slaPercentages(js.SLA, js.Transactions.Count())) {
+		t.Errorf("Expected SLA percentages %v but got %v", expected, slaPercentages(js.SLA, js.Transactions.Count()))
+	}
+	if expected := "latency SLA <1ms 25.00% <10ms 75.00% <100ms 75.00% >=100ms 25.00%"; !strings.Contains(js.String(), expected) {
+		t.Errorf("Expected the stats to contain %q but got %s", expected, js.String())
+	}
+	if expected := 75.0; js.JSON().LatencySLAPercent["<10ms"] != expected {
+		t.Errorf("Expected %v%% under 10ms but got %v", expected, js.JSON().LatencySLAPercent)
+	}
+}
+
+func TestRoundDuration(t *testing.T) {
+	for _, c := range []struct {
+		in, out time.Duration
+	}{
+		{0, 0},
+		{524, 524},
+		{524288 * time.Nanosecond, 524 * time.Microsecond},
+		{1048576 * time.Nanosecond, 1050 * time.Microsecond},
+		{90 * time.Second, 90 * time.Second},
+	} {
+		if actual := roundDuration(c.in, 3); actual != c.out {
+			t.Errorf("For %v\n\texpected %v\n\tbut got %v", c.in, c.out, actual)
+		}
+	}
+}
+
+func TestLatencyHistogramHistogram(t *testing.T) {
+	var lh LatencyHistogram
+	for i := 1; i <= 1000; i++ {
+		lh.Add(time.Duration(i) * time.Microsecond)
+	}
+
+	histogram := lh.Histogram(10)
+	if rows := strings.Count(histogram, "\n"); rows != 10 {
+		t.Errorf("Expected 10 rows but got %d:\n%s", rows, histogram)
+	}
+	if !strings.HasPrefix(histogram, "       1µs - ") {
+		t.Errorf("Expected the first row to start at the lowest value:\n%s", histogram)
+	}
+	var total int
+	for _, line := range strings.Split(strings.TrimSpace(histogram), "\n") {
+		var count int
+		fmt.Sscanf(line[strings.Index(line, "[")+1:], "%d", &count)
+		total += count
+	}
+	if total != 1000 {
+		t.Errorf("Expected 1000 values but got %d:\n%s", total, histogram)
+	}
+}
+
+func TestErrorTimeline(t *testing.T) {
+	et := ErrorTimeline{Interval: time.Second}
+	errors := func(code string, n uint64) ErrorCounts {
+		return ErrorCounts{code: errorCounts{errorsPerQuery{"select 1": n}, nil}}
+	}
+	et.Add(500*time.Millisecond, errors("1205", 1))
+	et.Add(3*time.Second, errors("1205", 8))
+	et.Add(3500*time.Millisecond, errors("1213", 2))
+	et.Add(4*time.Second, errors("1205", 2))
+
+	if codes := et.Codes(); !reflect.DeepEqual(codes, []string{"1205", "1213"}) {
+		t.Errorf("Expected codes 1205 and 1213 but got %v", codes)
+	}
+	if counts := et.Counts("1205"); !reflect.DeepEqual(counts, []uint64{1, 0, 0, 8, 2}) {
+		t.Errorf("Expected counts [1 0 0 8 2] but got %v", counts)
+	}
+	expected := "Errors by interval (1s per column, from 0s to 5s):\n" +
+		"        1205: ▁..█▂ 11 total, peak 8 at 3s\n" +
+		"        1213: ...█. 2 total, peak 2 at 3s\n"
+	if s := et.String(); s != expected {
+		t.Errorf("Expected\n%s\nbut got\n%s", expected, s)
+	}
+}
+
+func TestStatsWindows(t *testing.T) {
+	origin := time.Date(2020, 6, 1, 12, 0, 0, 300*int(time.Millisecond), time.UTC)
+	for _, c := range []struct {
+		alignToClock bool
+		now          []time.Duration
+		ends         []time.Duration
+	}{
+		// A late tick ends its window on the last boundary before it.
+		{false, []time.Duration{1001 * time.Millisecond, 3500 * time.Millisecond, 4 * time.Second},
+			[]time.Duration{time.Second, 3 * time.Second, 4 * time.Second}},
+		{true, []time.Duration{700 * time.Millisecond, 1702 * time.Millisecond},
+			[]time.Duration{700 * time.Millisecond, 1700 * time.Millisecond}},
+	} {
+		windows := newStatsWindows(origin, time.Second, c.alignToClock)
+		start := origin
+		for i, now := range c.now {
+			w := windows.Next(origin.Add(now))
+			if end := origin.Add(c.ends[i]); w.Start != start || w.End != end {
+				t.Errorf("For window %d aligned to clock %v\n\texpected %v to %v\n\tbut got %v to %v",
+					i, c.alignToClock, start, end, w.Start, w.End)
+			}
+			start = w.End
+		}
+		windows.Stop()
+	}
+}
@@ -0,0 +1,101 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+var maxRunRestarts = flag.Int("max-run-restarts", 0,
+	"Run the benchmark in a child process and, when it dies (e.g. of a crash "+
+		"or a lost connection), restart it with -resume up to this many "+
+		"times. Requires -checkpoint-file.")
+
+// Set in the environment of the child processes of -max-run-restarts.
+const supervisedEnv = "DBBENCH_SUPERVISED"
+
+/*
+ * Whether a child that exited with the code is restarted: not if it
+ * succeeded, or if restarting would fail the same way (invalid flags or
+ * config, an invalid run, an interrupt, or an unmet require-query).
+ */
+func restartable(code int) bool {
+	switch code {
+	case exitSuccess, exitUsage, exitInvalidRun, exitInterrupted, exitPreconditionFailed:
+		return false
+	}
+	return true
+}
+
+/*
+ * Runs dbbench with the same arguments in a child process, restarting it with
+ * -resume when it dies, and returns the exit code of the last child. Signals
+ * are left to the children (which share the process group, e.g. of the
+ * terminal or the service); once one arrives, the child is not restarted.
+ */
+func superviseRun(maxRestarts int) int {
+	exe, err := os.Executable()
+	if err != nil {
+		log.Printf("Error finding the dbbench executable: %v", err)
+		return exitUsage
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	args := os.Args[1:]
+	for restarts := 0; ; restarts++ {
+		cmd := exec.Command(exe, args...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		cmd.Env = append(os.Environ(), supervisedEnv+"=1")
+		if err := cmd.Start(); err != nil {
+			log.Printf("Error starting dbbench: %v", err)
+			return exitUsage
+		}
+		cmd.Wait()
+		code := cmd.ProcessState.ExitCode()
+
+		select {
+		case <-signals:
+			log.Printf("dbbench was stopped by a signal, not restarting it")
+			if code < 0 {
+				code = exitInterrupted
+			}
+			return code
+		default:
+		}
+		if code >= 0 && !restartable(code) {
+			return code
+		}
+		if restarts == maxRestarts {
+			log.Printf("dbbench exited with %s after %d restarts, giving up", cmd.ProcessState, restarts)
+			if code < 0 {
+				code = exitQueryErrors
+			}
+			return code
+		}
+		log.Printf("dbbench exited with %s, restarting it from the checkpoint (restart %d of %d)",
+			cmd.ProcessState, restarts+1, maxRestarts)
+		args = append([]string{"-resume"}, os.Args[1:]...)
+	}
+}
@@ -0,0 +1,143 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ * A synthetic statement stands in for a real query while prototyping the
+ * shape of a workload, e.g. {{sleep 10ms}} for a query that takes 10ms. It is
+ * translated into a statement of the dialect of the database flavor.
+ */
+var syntheticStatementPattern = regexp.MustCompile(`^\{\{\s*(\w+)\s+(\S+)\s*\}\}$`)
+var syntheticStatementName = regexp.MustCompile(`^\{\{\s*(\w+)`)
+
+// Synthetic statement -> dialect -> translation of the statement for a duration.
+var syntheticStatements = map[string]map[string]func(time.Duration) (string, error){
+	"sleep": {
+		"mysql": func(d time.Duration) (string, error) {
+			return fmt.Sprintf("SELECT SLEEP(%g)", d.Seconds()), nil
+		},
+		"postgres": func(d time.Duration) (string, error) {
+			return fmt.Sprintf("SELECT pg_sleep(%g)", d.Seconds()), nil
+		},
+		"mssql": func(d time.Duration) (string, error) {
+			if d >= 24*time.Hour {
+				return "", errors.New("WAITFOR DELAY must be less than 24h")
+			}
+			ms := d.Milliseconds()
+			return fmt.Sprintf("WAITFOR DELAY '%02d:%02d:%02d.%03d'",
+				ms/3600000, ms/60000%60, ms/1000%60, ms%1000), nil
+		},
+		"vertica": func(d time.Duration) (string, error) {
+			if d%time.Second != 0 {
+				return "", errors.New("vertica can only sleep for whole seconds")
+			}
+			return fmt.Sprintf("SELECT SLEEP(%d)", d/time.Second), nil
+		},
+	},
+	// Spins until the duration has elapsed, keeping a core of the server busy.
+	// MySQL cannot loop on the clock outside of a stored procedure, so it is
+	// not supported there.
+	"burn_cpu": {
+		"postgres": func(d time.Duration) (string, error) {
+			return fmt.Sprintf("DO $$DECLARE stop timestamptz := clock_timestamp() + "+
+				"interval '%d microseconds'; BEGIN WHILE clock_timestamp() < stop "+
+				"LOOP END LOOP; END$$", d.Microseconds()), nil
+		},
+		"mssql": func(d time.Duration) (string, error) {
+			return fmt.Sprintf("DECLARE @stop datetime2 = DATEADD(microsecond, %d, SYSDATETIME()); "+
+				"WHILE SYSDATETIME() < @stop SET @stop = @stop", d.Microseconds()), nil
+		},
+	},
+}
+
+// The dialect of the synthetic statements of a flavor.
+func syntheticDialect(df DatabaseFlavor) string {
+	switch f := df.(type) {
+	case *sqlDatabaseFlavor:
+		return f.name
+	case *fakeDatabaseFlavor:
+		// The fake database checks its queries as MySQL does.
+		return "mysql"
+	}
+	return ""
+}
+
+/*
+ * Translates a synthetic statement (a query of the form {{<name> <duration>}})
+ * for the flavor. Other queries, including query templates, are returned
+ * unchanged.
+ */
+func expandSyntheticStatement(df DatabaseFlavor, q string) (string, error) {
+	trimmed := strings.TrimSpace(q)
+	name := syntheticStatementName.FindStringSubmatch(trimmed)
+	if name == nil {
+		return q, nil
+	}
+	dialects, ok := syntheticStatements[name[1]]
+	if !ok {
+		return q, nil
+	}
+
+	m := syntheticStatementPattern.FindStringSubmatch(trimmed)
+	if m == nil {
+		return "", fmt.Errorf("invalid synthetic statement %s, expected e.g. {{sleep 10ms}}",
+			strconv.Quote(trimmed))
+	}
+	d, err := time.ParseDuration(m[2])
+	if err != nil {
+		return "", err
+	} else if d <= 0 {
+		return "", fmt.Errorf("duration of %s must be positive", m[1])
+	}
+	dialect := syntheticDialect(df)
+	translate, ok := dialects[dialect]
+	if !ok {
+		supported := make([]string, 0, len(dialects))
+		for d := range dialects {
+			supported = append(supported, d)
+		}
+		sort.Strings(supported)
+		name := "this database"
+		if dialect != "" {
+			name = dialect
+		}
+		return "", fmt.Errorf("%s is not supported by %s, only by %s",
+			m[1], name, strings.Join(supported, ", "))
+	}
+	return translate(d)
+}
+
+func expandSyntheticStatements(df DatabaseFlavor, queries []string) error {
+	for i, q := range queries {
+		expanded, err := expandSyntheticStatement(df, q)
+		if err != nil {
+			return err
+		}
+		queries[i] = expanded
+	}
+	return nil
+}
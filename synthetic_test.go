@@ -0,0 +1,76 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+)
+
+func TestExpandSyntheticStatement(t *testing.T) {
+	var cases = []struct {
+		flavor string
+		in     string
+		out    string
+	}{
+		{"mysql", "{{sleep 10ms}}", "SELECT SLEEP(0.01)"},
+		{"mysql", " {{ sleep 1.5s }}\n", "SELECT SLEEP(1.5)"},
+		{"fake", "{{sleep 2s}}", "SELECT SLEEP(2)"},
+		{"postgres", "{{sleep 250ms}}", "SELECT pg_sleep(0.25)"},
+		{"mssql", "{{sleep 1h2m3.004s}}", "WAITFOR DELAY '01:02:03.004'"},
+		{"vertica", "{{sleep 3s}}", "SELECT SLEEP(3)"},
+		{"postgres", "{{burn_cpu 5ms}}", "DO $$DECLARE stop timestamptz := clock_timestamp() + " +
+			"interval '5000 microseconds'; BEGIN WHILE clock_timestamp() < stop LOOP END LOOP; END$$"},
+		{"mssql", "{{burn_cpu 5ms}}", "DECLARE @stop datetime2 = DATEADD(microsecond, 5000, SYSDATETIME()); " +
+			"WHILE SYSDATETIME() < @stop SET @stop = @stop"},
+		{"mysql", "select '{{sleep 1s}}'", "select '{{sleep 1s}}'"},
+		{"mysql", "{{choice \"select 1\" \"select 2\"}}", "{{choice \"select 1\" \"select 2\"}}"},
+	}
+
+	for _, c := range cases {
+		out, err := expandSyntheticStatement(supportedDatabaseFlavors[c.flavor], c.in)
+		if err != nil {
+			t.Errorf("Unexpected error expanding %q for %s: %v", c.in, c.flavor, err)
+		} else if out != c.out {
+			t.Errorf("Expanding %q for %s\n\texpected %q\n\tbut got  %q", c.in, c.flavor, c.out, out)
+		}
+	}
+}
+
+func TestExpandSyntheticStatementErrors(t *testing.T) {
+	var cases = []struct {
+		flavor string
+		in     string
+	}{
+		{"mysql", "{{sleep}}"},
+		{"mysql", "{{sleep 10ms"},
+		{"mysql", "{{sleep ten}}"},
+		{"mysql", "{{sleep 0s}}"},
+		{"mysql", "{{burn_cpu 5ms}}"},
+		{"vertica", "{{sleep 1500ms}}"},
+		{"mssql", "{{sleep 24h}}"},
+	}
+
+	for _, c := range cases {
+		if out, err := expandSyntheticStatement(supportedDatabaseFlavors[c.flavor], c.in); err == nil {
+			t.Errorf("Unexpected success expanding %q for %s to %q", c.in, c.flavor, out)
+		}
+	}
+	_, err := expandSyntheticStatement(supportedDatabaseFlavors["mysql"], "{{burn_cpu 5ms}}")
+	if expected := "burn_cpu is not supported by mysql, only by mssql, postgres"; err == nil || err.Error() != expected {
+		t.Errorf("Expected error %q, got %v", expected, err)
+	}
+}
@@ -0,0 +1,185 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+/*
+ * The TLS settings of the connections to the database, which are translated
+ * into the params (or config) of the driver of each flavor.
+ */
+type TLSConfig struct {
+	CA         string // PEM file of the CAs to verify the server with.
+	Cert       string // PEM file of the client certificate.
+	Key        string // PEM file of the key of the client certificate.
+	SkipVerify bool
+}
+
+func init() {
+	flag.StringVar(&GlobalConfig.TLS.CA, "tls-ca", "",
+		"Connect with TLS, verifying the server with the CA certificates in "+
+			"this PEM file rather than the system ones")
+	flag.StringVar(&GlobalConfig.TLS.Cert, "tls-cert", "",
+		"Connect with TLS, with the client certificate in this PEM file "+
+			"(along with tls-key)")
+	flag.StringVar(&GlobalConfig.TLS.Key, "tls-key", "",
+		"The PEM file of the key of the tls-cert")
+	flag.BoolVar(&GlobalConfig.TLS.SkipVerify, "tls-skip-verify", false,
+		"Connect with TLS, without verifying the certificate of the server")
+}
+
+// The name the TLS config is registered with the MySQL driver under.
+const mySQLTLSConfigName = "dbbench"
+
+// Whether any of the TLS flags is set.
+func (tc *TLSConfig) Enabled() bool {
+	return tc.CA != "" || tc.Cert != "" || tc.SkipVerify
+}
+
+// Checks that the settings make sense, and that the driver supports them.
+func (tc *TLSConfig) Check(driver string) error {
+	if (tc.Cert == "") != (tc.Key == "") {
+		return errors.New("tls-cert and tls-key must be set together")
+	} else if tc.SkipVerify && tc.CA != "" {
+		return errors.New("cannot set both tls-ca and tls-skip-verify")
+	}
+	switch driver {
+	case "mssql":
+		if tc.Cert != "" {
+			return errors.New("the mssql driver does not support TLS client certificates")
+		}
+	case "vertica":
+		if tc.CA != "" || tc.Cert != "" {
+			return errors.New("the vertica driver only supports verifying the server with " +
+				"the system CAs, or tls-skip-verify")
+		}
+	}
+	return nil
+}
+
+// The TLS config of a driver that takes a crypto/tls config.
+func (tc *TLSConfig) Config() (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: tc.SkipVerify}
+	if tc.CA != "" {
+		pem, err := ioutil.ReadFile(tc.CA)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in tls-ca %s", tc.CA)
+		}
+	}
+	if tc.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(tc.Cert, tc.Key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+/*
+ * Registers the TLS config with the MySQL driver, as the one named by the
+ * tls param of mySQLDataSourceName.
+ */
+func registerMySQLTLSConfig(tc *TLSConfig) error {
+	config, err := tc.Config()
+	if err != nil {
+		return err
+	}
+	return mysql.RegisterTLSConfig(mySQLTLSConfigName, config)
+}
+
+/*
+ * Sets the param in the params separated by sep, replacing the value it has
+ * (matching its key without regard to case or spaces) if any.
+ */
+func setParam(params, sep, key, value string) string {
+	var kept []string
+	for _, param := range strings.Split(params, sep) {
+		k := strings.TrimSpace(strings.SplitN(param, "=", 2)[0])
+		if param != "" && !strings.EqualFold(k, key) {
+			kept = append(kept, param)
+		}
+	}
+	return strings.Join(append(kept, key+"="+value), sep)
+}
+
+func mySQLTLSParams(params string, tc *TLSConfig) string {
+	if !tc.Enabled() {
+		return params
+	}
+	return setParam(params, "&", "tls", mySQLTLSConfigName)
+}
+
+func postgresTLSParams(params string, tc *TLSConfig) string {
+	if !tc.Enabled() {
+		return params
+	}
+	// Without a root certificate, require does not verify the server.
+	mode := "verify-full"
+	if tc.SkipVerify {
+		mode = "require"
+	}
+	params = setParam(params, "&", "sslmode", mode)
+	if tc.CA != "" {
+		params = setParam(params, "&", "sslrootcert", url.QueryEscape(tc.CA))
+	}
+	if tc.Cert != "" {
+		params = setParam(params, "&", "sslcert", url.QueryEscape(tc.Cert))
+		params = setParam(params, "&", "sslkey", url.QueryEscape(tc.Key))
+	}
+	return params
+}
+
+func sqlServerTLSParams(params string, tc *TLSConfig) string {
+	if !tc.Enabled() {
+		return params
+	}
+	params = setParam(params, ";", "encrypt", "true")
+	if tc.SkipVerify {
+		params = setParam(params, ";", "TrustServerCertificate", "true")
+	}
+	if tc.CA != "" {
+		params = setParam(params, ";", "certificate", tc.CA)
+	}
+	return params
+}
+
+func verticaTLSParams(params string, tc *TLSConfig) string {
+	if !tc.Enabled() {
+		return params
+	}
+	mode := "server-strict"
+	if tc.SkipVerify {
+		mode = "server"
+	}
+	return setParam(params, "&", "tlsmode", mode)
+}
@@ -0,0 +1,99 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSetParam(t *testing.T) {
+	for _, c := range []struct {
+		params, sep, expected string
+	}{
+		{"", "&", "sslmode=x"},
+		{"sslmode=disable", "&", "sslmode=x"},
+		{"a=1&SSLMode=disable&b=2", "&", "a=1&b=2&sslmode=x"},
+		{"encrypt=false;", ";", "encrypt=false;sslmode=x"},
+	} {
+		if actual := setParam(c.params, c.sep, "sslmode", "x"); actual != c.expected {
+			t.Errorf("For %q expected %q, got %q", c.params, c.expected, actual)
+		}
+	}
+}
+
+func TestTLSDataSourceNames(t *testing.T) {
+	verified := TLSConfig{CA: "/etc/ca.pem", Cert: "/etc/client.pem", Key: "/etc/client.key"}
+	skipped := TLSConfig{SkipVerify: true}
+	for _, c := range []struct {
+		dsnFunc  func(cc *ConnectionConfig) string
+		tls      TLSConfig
+		expected string
+	}{
+		{mySQLDataSourceName, TLSConfig{}, "tls=preferred"},
+		{mySQLDataSourceName, skipped, "allowCleartextPasswords=true&tls=dbbench"},
+		{postgresDataSourceName, TLSConfig{}, "?sslmode=disable&"},
+		{postgresDataSourceName, skipped, "?sslmode=require&"},
+		{postgresDataSourceName, verified,
+			"?sslmode=verify-full&sslrootcert=%2Fetc%2Fca.pem&sslcert=%2Fetc%2Fclient.pem&sslkey=%2Fetc%2Fclient.key&"},
+		{cockroachDataSourceName, TLSConfig{CA: "ca.pem"}, "?sslmode=verify-full&sslrootcert=ca.pem&"},
+		{sqlServerDataSourceName, TLSConfig{CA: "/etc/ca.pem"}, ";encrypt=true;certificate=/etc/ca.pem;"},
+		{sqlServerDataSourceName, skipped, ";encrypt=true;TrustServerCertificate=true;"},
+		{verticaDataSourceName, TLSConfig{}, "/?"},
+		{verticaDataSourceName, skipped, "/?tlsmode=server"},
+	} {
+		if dsn := c.dsnFunc(&ConnectionConfig{TLS: c.tls}); !strings.Contains(dsn, c.expected) {
+			t.Errorf("With %+v, expected %s in DSN %s", c.tls, c.expected, dsn)
+		}
+	}
+}
+
+func TestTLSConfigCheck(t *testing.T) {
+	for _, c := range []struct {
+		driver string
+		tls    TLSConfig
+		ok     bool
+	}{
+		{"mysql", TLSConfig{CA: "ca.pem", Cert: "client.pem", Key: "client.key"}, true},
+		{"mysql", TLSConfig{Cert: "client.pem"}, false},
+		{"postgres", TLSConfig{CA: "ca.pem", SkipVerify: true}, false},
+		{"mssql", TLSConfig{CA: "ca.pem"}, true},
+		{"mssql", TLSConfig{Cert: "client.pem", Key: "client.key"}, false},
+		{"vertica", TLSConfig{SkipVerify: true}, true},
+		{"vertica", TLSConfig{CA: "ca.pem"}, false},
+	} {
+		if err := c.tls.Check(c.driver); (err == nil) != c.ok {
+			t.Errorf("For %s with %+v, unexpected error %v", c.driver, c.tls, err)
+		}
+	}
+
+	f, err := ioutil.TempFile("", "ca-*.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("not a certificate\n")
+	f.Close()
+	if _, err := (&TLSConfig{CA: f.Name()}).Config(); err == nil {
+		t.Errorf("Expected an error for a tls-ca without certificates")
+	}
+	if config, err := (&TLSConfig{SkipVerify: true}).Config(); err != nil || !config.InsecureSkipVerify {
+		t.Errorf("Expected a config that skips verification, got %+v, %v", config, err)
+	}
+}
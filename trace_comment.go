@@ -0,0 +1,41 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var traceComments = flag.Bool("trace-comments", false,
+	"Prepend a /* dbbench run=<id> job=<name> seq=<n> */ comment to every "+
+		"query issued by a job, to join the slow logs of the server with the "+
+		"query-stats-file (schema v3).")
+
+/*
+ * The comment identifying the invocation of a job, to be prepended to its
+ * queries. The name of the job is quoted if it has anything a log parser
+ * would split on, and the comment can not be closed early by the name.
+ */
+func traceComment(job string, seq int64) string {
+	if job == "" || strings.ContainsAny(job, " \t\r\n\"'*=") {
+		job = strings.Replace(strconv.Quote(job), "*/", `*\/`, -1)
+	}
+	return fmt.Sprintf("/* dbbench run=%s job=%s seq=%d */ ", runID, job, seq)
+}
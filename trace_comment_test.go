@@ -0,0 +1,81 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// A fake database recording the queries issued to it.
+type recordingDb struct {
+	fakeDb
+	issued []string
+}
+
+func (db *recordingDb) RunQuery(ctx context.Context, w *SafeCSVWriter, q string, args []interface{}) (int64, error) {
+	db.issued = append(db.issued, q)
+	return db.fakeDb.RunQuery(ctx, w, q, args)
+}
+
+func (db *recordingDb) RunQueryWithOptions(ctx context.Context, w *SafeCSVWriter, q string, args []interface{}, opts QueryOptions) (int64, error) {
+	db.issued = append(db.issued, q)
+	return db.fakeDb.RunQueryWithOptions(ctx, w, q, args, opts)
+}
+
+func TestTraceComment(t *testing.T) {
+	for _, c := range []struct {
+		job, comment string
+	}{
+		{"lookups", "/* dbbench run=" + runID + " job=lookups seq=3 */ "},
+		{"point lookups", "/* dbbench run=" + runID + ` job="point lookups" seq=3 */ `},
+		{"a*/b", "/* dbbench run=" + runID + ` job="a*\/b" seq=3 */ `},
+	} {
+		if comment := traceComment(c.job, 3); comment != c.comment {
+			t.Errorf("For job %s\n\texpected %s\n\tbut got  %s", c.job, c.comment, comment)
+		}
+	}
+}
+
+func TestTraceComments(t *testing.T) {
+	defer func(old bool) { *traceComments = old }(*traceComments)
+	*traceComments = true
+
+	df := supportedDatabaseFlavors["fake"]
+	db := &recordingDb{fakeDb: fakeDb{rows: 1}}
+	job := &Job{Name: "test", Queries: []string{"select 1"}, FollowQueries: []string{"delete 1"}}
+	for seq := int64(1); seq <= 2; seq++ {
+		ji, err := job.getNextJobInvocation()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if jr := ji.Invoke(context.Background(), db, df, job, 0); jr.Seq != seq {
+			t.Errorf("Expected invocation %d but got %d", seq, jr.Seq)
+		}
+	}
+
+	expected := []string{
+		traceComment("test", 1) + "select 1",
+		traceComment("test", 1) + "delete 1",
+		traceComment("test", 2) + "select 1",
+		traceComment("test", 2) + "delete 1",
+	}
+	if !reflect.DeepEqual(db.issued, expected) {
+		t.Errorf("Expected the queries\n\t%q\n\tbut got\n\t%q", expected, db.issued)
+	}
+}
@@ -25,29 +25,32 @@ import (
 )
 
 type WriteFileFlagValue struct {
-	f *os.File
+	f    *os.File
+	path string
 }
 
-func (wffv *WriteFileFlagValue) Set(v string) (err error) {
+/*
+ * Only records the path of the file, which is created by Open: -resume
+ * (which appends to the file rather than truncate it) may come after it.
+ */
+func (wffv *WriteFileFlagValue) Set(v string) error {
 	if wffv.f != nil {
 		wffv.f.Close()
 	}
+	wffv.f, wffv.path = nil, v
+	return nil
+}
 
-	if v == "" {
-		wffv.f = nil
-	} else {
-		wffv.f, err = os.Create(v)
+// Opens the file of the flag, if it has one and it is not open yet.
+func (wffv *WriteFileFlagValue) Open() (err error) {
+	if wffv.f == nil && wffv.path != "" {
+		wffv.f, err = createOutputFile(wffv.path)
 	}
 	return err
 }
 
 func (wffv *WriteFileFlagValue) String() string {
-	ret := "&fileFlagValue{"
-	if wffv.f != nil {
-		ret += wffv.f.Name()
-	}
-	ret += "}"
-	return ret
+	return "&fileFlagValue{" + wffv.path + "}"
 }
 
 func (wffv *WriteFileFlagValue) Get() interface{} {
@@ -58,6 +61,22 @@ func (wffv *WriteFileFlagValue) GetFile() *os.File {
 	return wffv.f
 }
 
+// The path of the file, set before it is opened.
+func (wffv *WriteFileFlagValue) Path() string {
+	return wffv.path
+}
+
+/*
+ * Creates an output file, or with -resume, opens it to append to what the
+ * earlier attempts of the run wrote.
+ */
+func createOutputFile(path string) (*os.File, error) {
+	if *resumeRun {
+		return os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	}
+	return os.Create(path)
+}
+
 type Set map[interface{}]struct{}
 
 func (s Set) Add(i interface{}) {
@@ -141,26 +160,6 @@ func quotedStruct(s interface{}) string {
 	return str.String()
 }
 
-func minFloat64(vals []float64) float64 {
-	m := vals[0]
-	for _, v := range vals[1:] {
-		if v < m {
-			m = v
-		}
-	}
-	return m
-}
-
-func maxFloat64(vals []float64) float64 {
-	m := vals[0]
-	for _, v := range vals[1:] {
-		if v > m {
-			m = v
-		}
-	}
-	return m
-}
-
 func maxInt(vals []int) int {
 	m := vals[0]
 	for _, v := range vals[1:] {
@@ -170,13 +169,3 @@ func maxInt(vals []int) int {
 	}
 	return m
 }
-
-func maxUint64(vals []uint64) uint64 {
-	m := vals[0]
-	for _, v := range vals[1:] {
-		if v > m {
-			m = v
-		}
-	}
-	return m
-}
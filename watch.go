@@ -0,0 +1,61 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"flag"
+	"os"
+	"reflect"
+	"time"
+)
+
+var watch = flag.Bool("watch", false,
+	"After the run, wait for the runfile or any file it references to change and run again.")
+
+const watchPollInterval = 500 * time.Millisecond
+
+// How long the files must be unchanged before rerunning, so that saving
+// several files at once only triggers a single run.
+const watchDebounce = time.Second
+
+func modTimes(files []string) map[string]time.Time {
+	times := make(map[string]time.Time, len(files))
+	for _, file := range files {
+		if fi, err := os.Stat(file); err == nil {
+			times[file] = fi.ModTime()
+		}
+	}
+	return times
+}
+
+/*
+ * Blocks until any of the files has been modified since the snapshot of
+ * their modTimes, and the files have been unchanged for the debounce period.
+ */
+func waitForChange(files []string, snapshot map[string]time.Time) {
+	for reflect.DeepEqual(snapshot, modTimes(files)) {
+		time.Sleep(watchPollInterval)
+	}
+
+	for {
+		last := modTimes(files)
+		time.Sleep(watchDebounce)
+		if reflect.DeepEqual(last, modTimes(files)) {
+			return
+		}
+	}
+}
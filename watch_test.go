@@ -0,0 +1,52 @@
+/*
+ * Copyright (c) 2020 by MemSQL. All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWaitForChangeSinceSnapshot(t *testing.T) {
+	f, err := ioutil.TempFile("", "dbbench-watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	files := []string{f.Name()}
+	snapshot := modTimes(files)
+	// Changed before waitForChange is called, e.g. while the run went on.
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(f.Name(), later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		waitForChange(files, snapshot)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * watchDebounce):
+		t.Errorf("Expected the change made before waiting to be seen")
+	}
+}